@@ -0,0 +1,145 @@
+/*
+Balance is a dialer layer that spreads dials across several targets.
+The dialer coming from the chain is always the first target; additional upstreams
+are dialed directly on the given network, with the context and DialOptions of the dial when the chain is dialed
+through a URI, scheme or chain config. A strategy decides which target is used for each dial:
+round-robin cycles through the targets, random picks one at random and failover always starts
+with the first target and moves on to the next one only when a dial fails.
+
+Since the additional targets are dialed on the bare network, the layer should be placed directly
+after the transport so every target goes through the same subsequent layers.
+*/
+
+package netx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"sync/atomic"
+)
+
+func init() {
+	Register("balance", func(params map[string]string, listener bool) (Wrapper, error) {
+		if listener {
+			return Wrapper{}, fmt.Errorf("balance: only supported for dialers")
+		}
+		var (
+			addrs    []string
+			network  = "tcp"
+			strategy = BalanceRoundRobin
+		)
+		for key, value := range params {
+			switch key {
+			case "addr", "addrs":
+				for addr := range strings.SplitSeq(value, "|") {
+					if addr = strings.TrimSpace(addr); addr != "" {
+						addrs = append(addrs, addr)
+					}
+				}
+			case "net":
+				network = value
+			case "strategy":
+				var err error
+				strategy, err = ParseBalanceStrategy(value)
+				if err != nil {
+					return Wrapper{}, err
+				}
+			default:
+				return Wrapper{}, fmt.Errorf("uri: unknown balance parameter %q", key)
+			}
+		}
+		balanceDialer := func(ctx context.Context, d Dialer, opts []DialOption) (Dialer, error) {
+			dialers := make([]Dialer, 0, len(addrs)+1)
+			dialers = append(dialers, d)
+			for _, addr := range addrs {
+				dialers = append(dialers, func() (net.Conn, error) {
+					return Dial(ctx, network, addr, opts...)
+				})
+			}
+			return NewBalanceDialer(strategy, dialers...)
+		}
+		return Wrapper{
+			Name:   "balance",
+			Params: params,
+			DialerToDialer: func(d Dialer) (Dialer, error) {
+				return balanceDialer(context.Background(), d, nil)
+			},
+			dialerToDialerCtx: balanceDialer,
+		}, nil
+	})
+}
+
+// BalanceStrategy decides which target a balanced dialer uses for each dial.
+type BalanceStrategy int
+
+const (
+	BalanceRoundRobin BalanceStrategy = iota // cycle through the targets
+	BalanceRandom                            // pick a random target
+	BalanceFailover                          // use the first target that dials successfully
+)
+
+func (s BalanceStrategy) String() string {
+	switch s {
+	case BalanceRoundRobin:
+		return "roundrobin"
+	case BalanceRandom:
+		return "random"
+	case BalanceFailover:
+		return "failover"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseBalanceStrategy parses a strategy name as used by the balance driver.
+func ParseBalanceStrategy(s string) (BalanceStrategy, error) {
+	switch strings.ToLower(s) {
+	case "roundrobin", "rr":
+		return BalanceRoundRobin, nil
+	case "random":
+		return BalanceRandom, nil
+	case "failover":
+		return BalanceFailover, nil
+	default:
+		return 0, fmt.Errorf("uri: unknown balance strategy %q", s)
+	}
+}
+
+// NewBalanceDialer returns a Dialer that picks one of the given dialers for each dial according to strategy.
+// Round-robin and random only dial the picked target, while failover tries the targets in order
+// and returns the joined errors if none of them succeeds.
+func NewBalanceDialer(strategy BalanceStrategy, dialers ...Dialer) (Dialer, error) {
+	if len(dialers) == 0 {
+		return nil, errors.New("balance: no targets")
+	}
+	switch strategy {
+	case BalanceRoundRobin:
+		var next atomic.Uint64
+		return func() (net.Conn, error) {
+			i := (next.Add(1) - 1) % uint64(len(dialers))
+			return dialers[i]()
+		}, nil
+	case BalanceRandom:
+		return func() (net.Conn, error) {
+			return dialers[rand.IntN(len(dialers))]()
+		}, nil
+	case BalanceFailover:
+		return func() (net.Conn, error) {
+			var errs error
+			for _, d := range dialers {
+				c, err := d()
+				if err == nil {
+					return c, nil
+				}
+				errs = errors.Join(errs, err)
+			}
+			return nil, errs
+		}, nil
+	default:
+		return nil, fmt.Errorf("balance: unknown strategy %d", strategy)
+	}
+}
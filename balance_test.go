@@ -0,0 +1,171 @@
+package netx_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	netx "github.com/pedramktb/go-netx"
+)
+
+// countingDialer returns a dialer that records which target was dialed and fails if fail is set.
+func countingDialer(idx int, hits []int, fail bool) netx.Dialer {
+	return func() (net.Conn, error) {
+		hits[idx]++
+		if fail {
+			return nil, errors.New("target down")
+		}
+		c, _ := net.Pipe()
+		return c, nil
+	}
+}
+
+func TestBalanceDialer_RoundRobin(t *testing.T) {
+	t.Parallel()
+	hits := make([]int, 3)
+	dial, err := netx.NewBalanceDialer(netx.BalanceRoundRobin,
+		countingDialer(0, hits, false),
+		countingDialer(1, hits, false),
+		countingDialer(2, hits, false),
+	)
+	if err != nil {
+		t.Fatalf("new balance dialer: %v", err)
+	}
+	for i := range 9 {
+		c, err := dial()
+		if err != nil {
+			t.Fatalf("dial %d: %v", i, err)
+		}
+		_ = c.Close()
+	}
+	for i, h := range hits {
+		if h != 3 {
+			t.Fatalf("target %d dialed %d times, want 3 (hits=%v)", i, h, hits)
+		}
+	}
+}
+
+func TestBalanceDialer_Failover(t *testing.T) {
+	t.Parallel()
+	hits := make([]int, 3)
+	dial, err := netx.NewBalanceDialer(netx.BalanceFailover,
+		countingDialer(0, hits, true),
+		countingDialer(1, hits, false),
+		countingDialer(2, hits, false),
+	)
+	if err != nil {
+		t.Fatalf("new balance dialer: %v", err)
+	}
+	for i := range 3 {
+		c, err := dial()
+		if err != nil {
+			t.Fatalf("dial %d: %v", i, err)
+		}
+		_ = c.Close()
+	}
+	if hits[0] != 3 || hits[1] != 3 || hits[2] != 0 {
+		t.Fatalf("unexpected failover hits: %v", hits)
+	}
+}
+
+func TestBalanceDialer_FailoverAllDown(t *testing.T) {
+	t.Parallel()
+	hits := make([]int, 2)
+	dial, err := netx.NewBalanceDialer(netx.BalanceFailover,
+		countingDialer(0, hits, true),
+		countingDialer(1, hits, true),
+	)
+	if err != nil {
+		t.Fatalf("new balance dialer: %v", err)
+	}
+	if _, err := dial(); err == nil {
+		t.Fatalf("expected error when all targets are down")
+	}
+}
+
+func TestBalanceDriver_TCPTargets(t *testing.T) {
+	t.Parallel()
+	lns := []net.Listener{tcpListener(t), tcpListener(t), tcpListener(t)}
+	accepted := make(chan int, 6)
+	for i, ln := range lns {
+		go func() {
+			for {
+				c, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				accepted <- i
+				_ = c.Close()
+			}
+		}()
+	}
+
+	var w netx.Wrapper
+	params := "balance{addrs=" + lns[1].Addr().String() + "|" + lns[2].Addr().String() + ",strategy=roundrobin}"
+	if err := w.UnmarshalText([]byte(params), false); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	base := func() (net.Conn, error) { return net.Dial("tcp", lns[0].Addr().String()) }
+	v, err := w.Apply(netx.Dialer(base))
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	dial := v.(netx.Dialer)
+
+	counts := make([]int, 3)
+	for range 6 {
+		c, err := dial()
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		counts[<-accepted]++
+		_ = c.Close()
+	}
+	for i, n := range counts {
+		if n != 2 {
+			t.Fatalf("target %d accepted %d conns, want 2 (counts=%v)", i, n, counts)
+		}
+	}
+}
+
+func TestBalanceDriverDialsTargetsWithDialContext(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = c.Close()
+		}
+	}()
+
+	var u netx.DialerURI
+	if err := u.UnmarshalText([]byte("tcp+balance{addrs=" + ln.Addr().String() + ",strategy=failover}://" + ln.Addr().String())); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// the extra target must see the canceled context as well, instead of being dialed in the background
+	c, err := u.Dial(ctx)
+	if err == nil {
+		_ = c.Close()
+		t.Fatal("dial with a canceled context succeeded")
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("dial: %v, want context.Canceled", err)
+	}
+}
+
+func TestParseBalanceStrategyUnknown(t *testing.T) {
+	t.Parallel()
+	if _, err := netx.ParseBalanceStrategy("weighted"); err == nil || err.Error() != `uri: unknown balance strategy "weighted"` {
+		t.Fatalf("got %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+/*
+Branch is a pseudo-wrapper that splits the pipeline in two. The value flowing into it is passed on
+unchanged to the rest of the chain and is also fed through a side chain, whose output is handed to a sink.
+This allows e.g. a single listener or conn to feed both the main chain and a side channel.
+
+A branch only accepts input types its side chain accepts and always outputs its input type, so
+for a chain containing a branch, OutputFor is the same as for the chain without it.
+Both chains share the same underlying value, so it is up to the caller to make sure they do not compete
+for it, e.g. by having the side chain demultiplex instead of reading directly.
+
+Branches can only be built programmatically; they have no URI representation.
+*/
+
+package netx
+
+import (
+	"fmt"
+	"net"
+)
+
+// Branch returns a wrapper that passes its input on unchanged and also applies side to it,
+// handing the result to sink. An error from the side chain or the sink fails the whole chain.
+func Branch(side Wrappers, sink func(any) error) Wrapper {
+	tee := func(v any) error {
+		out, err := side.Apply(v)
+		if err != nil {
+			return fmt.Errorf("branch: %w", err)
+		}
+		return sink(out)
+	}
+	w := Wrapper{Name: "branch"}
+	if _, ok := side.OutputFor(PipeTypeListener); ok {
+		w.ListenerToListener = func(l net.Listener) (net.Listener, error) { return l, tee(l) }
+	}
+	if _, ok := side.OutputFor(PipeTypeDialer); ok {
+		w.DialerToDialer = func(d Dialer) (Dialer, error) { return d, tee(d) }
+	}
+	if _, ok := side.OutputFor(PipeTypeConn); ok {
+		w.ConnToConn = func(c net.Conn) (net.Conn, error) { return c, tee(c) }
+	}
+	if _, ok := side.OutputFor(PipeTypeTaggedConn); ok {
+		w.TaggedToTagged = func(c TaggedConn) (TaggedConn, error) { return c, tee(c) }
+	}
+	return w
+}
@@ -0,0 +1,66 @@
+package netx_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	netx "github.com/pedramktb/go-netx"
+)
+
+func TestBranch_FeedsBothChains(t *testing.T) {
+	t.Parallel()
+	base, other := net.Pipe()
+	t.Cleanup(func() { _ = base.Close(); _ = other.Close() })
+
+	var sideIn net.Conn
+	toTagged := netx.Wrapper{Name: "totagged", ConnToTagged: func(c net.Conn) (netx.TaggedConn, error) {
+		sideIn = c
+		a, _ := netx.TaggedPipe()
+		return a, nil
+	}}
+	var sideOut any
+	branch := netx.Branch(netx.Wrappers{toTagged}, func(v any) error {
+		sideOut = v
+		return nil
+	})
+	var mainIn net.Conn
+	framed := netx.Wrapper{Name: "framed", ConnToConn: func(c net.Conn) (net.Conn, error) {
+		mainIn = c
+		return netx.NewFrameConn(c), nil
+	}}
+	chain := netx.Wrappers{branch, framed}
+
+	if out, ok := chain.OutputFor(netx.PipeTypeConn); !ok || out != netx.PipeTypeConn {
+		t.Fatalf("chain output for Conn = %v, %v; want Conn, true", out, ok)
+	}
+	if _, ok := chain.OutputFor(netx.PipeTypeListener); ok {
+		t.Fatalf("branch must reject input types its side chain does not accept")
+	}
+
+	v, err := chain.Apply(base)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if _, ok := v.(net.Conn); !ok {
+		t.Fatalf("main chain produced %T, want net.Conn", v)
+	}
+	if mainIn != base || sideIn != base {
+		t.Fatalf("both chains should receive the branched value")
+	}
+	if _, ok := sideOut.(netx.TaggedConn); !ok {
+		t.Fatalf("sink received %T, want TaggedConn", sideOut)
+	}
+}
+
+func TestBranch_SinkErrorFailsChain(t *testing.T) {
+	t.Parallel()
+	base, other := net.Pipe()
+	t.Cleanup(func() { _ = base.Close(); _ = other.Close() })
+
+	sinkErr := errors.New("sink failed")
+	branch := netx.Branch(netx.Wrappers{}, func(any) error { return sinkErr })
+	if _, err := (netx.Wrappers{branch}).Apply(base); !errors.Is(err, sinkErr) {
+		t.Fatalf("want sink error, got %v", err)
+	}
+}
@@ -114,3 +114,14 @@ func (c *bufConn) Close() error {
 }
 
 func (c *bufConn) Flush() error { return c.bw.Flush() }
+
+// CloseWrite flushes pending writes and half-closes the underlying conn if it supports it.
+func (c *bufConn) CloseWrite() error {
+	if err := c.bw.Flush(); err != nil {
+		return err
+	}
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,82 @@
+package netx
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+)
+
+// ChainConfig is the JSON form of a URI, with the transport, the address and the layers of the chain
+// as separate fields, for configs stored as JSON:
+//
+//	{"base":"tcp","addr":"127.0.0.1:9000","layers":["frame","textenc{encoding=hex}"]}
+//
+// It marshals to JSON as is; use ListenerChainConfig or DialerChainConfig to unmarshal it, since
+// the layers are validated differently for servers and clients.
+type ChainConfig struct {
+	Base   Transport
+	Addr   string
+	Layers Wrappers
+}
+
+type chainConfigJSON struct {
+	Base   string   `json:"base"`
+	Addr   string   `json:"addr"`
+	Layers []string `json:"layers,omitempty"`
+}
+
+// URI returns the URI equivalent to c.
+func (c ChainConfig) URI() URI {
+	return URI{Scheme: Scheme{Transport: c.Base, Wrappers: c.Layers}, Addr: c.Addr}
+}
+
+func (c ChainConfig) String() string {
+	return c.URI().String()
+}
+
+func (c ChainConfig) MarshalJSON() ([]byte, error) {
+	j := chainConfigJSON{Base: c.Base.String(), Addr: c.Addr}
+	for _, w := range c.Layers {
+		j.Layers = append(j.Layers, w.String())
+	}
+	return json.Marshal(j)
+}
+
+// unmarshalJSON parses data with the same rules as URI.UnmarshalText.
+func (c *ChainConfig) unmarshalJSON(data []byte, server bool) error {
+	var j chainConfigJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	text := j.Base
+	if len(j.Layers) > 0 {
+		text += "+" + strings.Join(j.Layers, "+")
+	}
+	var u URI
+	if err := u.UnmarshalText([]byte(text+"://"+j.Addr), server); err != nil {
+		return err
+	}
+	*c = ChainConfig{Base: u.Transport, Addr: u.Addr, Layers: u.Wrappers}
+	return nil
+}
+
+type ListenerChainConfig struct{ ChainConfig }
+
+func (c ListenerChainConfig) Listen(ctx context.Context, opts ...ListenOption) (net.Listener, error) {
+	return ListenerURI{c.URI()}.Listen(ctx, opts...)
+}
+
+func (c *ListenerChainConfig) UnmarshalJSON(data []byte) error {
+	return c.ChainConfig.unmarshalJSON(data, true)
+}
+
+type DialerChainConfig struct{ ChainConfig }
+
+func (c DialerChainConfig) Dial(ctx context.Context, opts ...DialOption) (net.Conn, error) {
+	return DialerURI{c.URI()}.Dial(ctx, opts...)
+}
+
+func (c *DialerChainConfig) UnmarshalJSON(data []byte) error {
+	return c.ChainConfig.unmarshalJSON(data, false)
+}
@@ -0,0 +1,88 @@
+package netx_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	netx "github.com/pedramktb/go-netx"
+)
+
+func TestChainConfigJSONRoundTrip(t *testing.T) {
+	t.Parallel()
+	in := `{"base":"tcp","addr":"127.0.0.1:9000","layers":["frame","textenc{encoding=hex}"]}`
+	var cfg netx.ListenerChainConfig
+	if err := json.Unmarshal([]byte(in), &cfg); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if cfg.Base != netx.TransportTCP || cfg.Addr != "127.0.0.1:9000" || len(cfg.Layers) != 2 {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if got := cfg.String(); got != "tcp+frame+textenc{encoding=hex}://127.0.0.1:9000" {
+		t.Fatalf("String() = %q", got)
+	}
+	out, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if string(out) != in {
+		t.Fatalf("marshal = %s, want %s", out, in)
+	}
+
+	for _, bad := range []string{
+		`{"base":"sctp","addr":"127.0.0.1:9000"}`,
+		`{"base":"tcp","addr":""}`,
+		`{"base":"tcp","addr":"127.0.0.1:9000","layers":["nosuchdriver"]}`,
+		`{"base":"tcp","addr":"127.0.0.1:9000","layers":["mux"]}`,
+	} {
+		if err := json.Unmarshal([]byte(bad), &cfg); err == nil {
+			t.Errorf("unmarshal %s: expected an error", bad)
+		}
+	}
+}
+
+func TestChainConfigBuildsWorkingConn(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	layers := `"layers":["frame","textenc{encoding=hex}"]`
+
+	var lcfg netx.ListenerChainConfig
+	if err := json.Unmarshal([]byte(`{"base":"tcp","addr":"127.0.0.1:0",`+layers+`}`), &lcfg); err != nil {
+		t.Fatalf("unmarshal listener: %v", err)
+	}
+	ln, err := lcfg.Listen(ctx)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		_, _ = io.Copy(c, c)
+	}()
+
+	var dcfg netx.DialerChainConfig
+	if err := json.Unmarshal([]byte(`{"base":"tcp","addr":"`+ln.Addr().String()+`",`+layers+`}`), &dcfg); err != nil {
+		t.Fatalf("unmarshal dialer: %v", err)
+	}
+	c, err := dcfg.Dial(ctx)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(2 * time.Second))
+	msg := "through the configured chain"
+	if _, err := c.Write([]byte(msg)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 64)
+	n, err := c.Read(buf)
+	if err != nil || string(buf[:n]) != msg {
+		t.Fatalf("read %q, %v; want %q", buf[:n], err, msg)
+	}
+}
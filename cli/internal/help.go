@@ -5,7 +5,7 @@ const uriFormat = `URI Format:
 
 	Examples:
 		tcp+tls{cert=$(cat server.crt | xxd -p),key=$(cat server.key | xxd -p)}://:9000
-		tcp+tls{cert=$(cat client.crt | xxd -p)}+buf{r=8192,w=8192}+frame{maxsize=4096}+aesgcm{key=00112233445566778899aabbccddeeff}://example.com:9443
+		tcp+tls{cert=$(cat client.crt | xxd -p)}+buf{r=8192,w=8192}+frame{headerwidth=2}+aesgcm{key=00112233445566778899aabbccddeeff}://example.com:9443
 
 	Supported transports:
 		- tcp: TCP listener or dialer
@@ -14,22 +14,37 @@ const uriFormat = `URI Format:
 
 	Supported layers:
 		- frame: length-prefixed frames for transports or layers that need packet semantics over streams.
-			params: maxsize (optional, defaults to 32768)
+			params: headerwidth (optional, 1, 2, 4 or 8 bytes of length header, defaults to 2, 1 limits frames to 255 bytes, 4 and 8 still cap them at 65535 and only exist for wire compatibility)
+		- textenc: encodes every packet as a line of printable text for channels that only pass text.
+			params: encoding (optional, base64 or hex, defaults to base64), delim (optional, escapes like \r\n are allowed, defaults to \n)
+		- h2: carries the connection in a single HTTP/2 stream of a streaming POST request and its response, put tls below it to look like HTTPS.
+			params: path (optional, defaults to /), host (optional, the request authority sent by clients and required by servers)
 		- buf: buffered read/write for better performance when using framing.
 			params: r (optional, read buffer size, defaults to 4096), w (optional, write buffer size, defaults to 4096)
-		- aesgcm: AES-GCM encryption. A passive 12-byte handshake exchanges IVs.
-			params: key, maxpacket (optional, defaults to 32768)
+		- ratelimit: caps the bandwidth of each connection at this position of the chain with a token bucket.
+			params: up (optional, write bytes/sec), down (optional, read bytes/sec), burst (optional, bucket size in bytes, defaults to one second worth of the rate); 0 means unlimited
+		- count: counts the bytes read and written at this position of the chain into a named counter.
+			params: name (counters are shared by name)
+		- metrics: records bytes, conns, errors and handshake durations at this position of the chain in the Prometheus format.
+			params: name (the name label, metrics are shared by name)
+		- balance: spreads client dials across the URI address and additional upstreams. Place it directly after the transport.
+			client params: addrs (|-separated host:port list), net (optional, defaults to tcp), strategy (optional, roundrobin, random or failover, defaults to roundrobin)
+		- aesgcm: AES-GCM encryption. A passive handshake exchanges a version byte and 12-byte IVs.
+			params: key, maxpacket (optional, defaults to 32768), nonce (optional, derived or explicit, explicit skips the handshake and prefixes a random nonce to every packet), compress (optional, none, flate or zstd, packet sizes then leak information about the plaintext), roled (optional, true derives separate keys per direction from key), aead (optional, gcm or gcmsiv, gcmsiv is nonce-misuse resistant but slower), legacy (optional, true omits the version byte of the handshake for peers that predate it), reclen (optional, true authenticates the length of every packet to detect truncated ones)
+		- fallback: accepts aesgcm and legacy plaintext clients on the same port. A first packet of 12 to 14 bytes is taken for the aesgcm handshake.
+			server params: those of aesgcm except nonce, wait (optional, defaults to 5s, how long to wait for the first packet before assuming plaintext)
 		- ssh: SSH tunneling via "direct-tcpip" channels.
 			server params: key, pass (optional), pubkey (optional, required if no pass)
-			client options: pubkey, pass (optional), key (optional, required if no pass)
+			client options: pubkey, pass (optional), key (optional), agent (optional, true also offers the keys of the agent at SSH_AUTH_SOCK), otp (optional, answers keyboard-interactive challenges with the given value), interactive (optional, true answers keyboard-interactive challenges with the prompt registered under prompt with ssh.RegisterPrompt, not with otp), prompt (the name of the registered prompt, required with interactive=true), at least one of pass, key, agent=true, otp and interactive=true
 		- tls: Transport Layer Security
 			server params: key, cert
-			client params: cert (optional, for SPKI pinning), servername (required if cert not provided)
+			client params: cert (optional, for SPKI pinning), servername (required if cert not provided), handshaketimeout (optional, e.g. 10s)
+			both sides: minversion, maxversion (optional, 1.2 or 1.3, defaults to 1.3), ciphers (optional, |-separated TLS 1.2 cipher suite names, requires minversion=1.2)
 		- utls: TLS with client fingerprint camouflage via uTLS (github.com/refraction-networking/utls)
-			client params: cert (optional, for SPKI pinning), servername (required if cert not provided), hello (optional, e.g. chrome, firefox, ios, android, safari, edge, randomized)
+			client params: cert (optional, for SPKI pinning), servername (required if cert not provided), hello (optional, e.g. chrome, firefox, ios, android, safari, edge, randomized), handshaketimeout (optional, e.g. 10s)
 		- dtls: Datagram Transport Layer Security
 			server params: key, cert
-			client params: cert (optional, for SPKI pinning), servername (required if cert not provided)
+			client params: cert (optional, for SPKI pinning), servername (required if cert not provided), handshaketimeout (optional, e.g. 10s)
 		- tlspsk: TLS with pre-shared key. Cipher is TLS_DHE_PSK_WITH_AES_256_CBC_SHA.
 			params: key
 		- dtlspsk: DTLS with pre-shared key. Cipher is TLS_PSK_WITH_AES_128_GCM_SHA256.
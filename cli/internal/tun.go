@@ -72,15 +72,15 @@ func runTun(ctx context.Context, cancel context.CancelFunc, from, to string) err
 
 	tm := netx.TunMaster[struct{}]{}
 
-	tm.SetRoute(struct{}{}, func(ctx context.Context, conn net.Conn) (bool, context.Context, netx.Tun) {
+	tm.SetRoute(struct{}{}, func(ctx context.Context, conn net.Conn) (bool, context.Context, *netx.Tun) {
 		pconn, err := toURI.Dial(ctx)
 		if err != nil {
 			slog.Error("dial tun", "err", err)
 			_ = conn.Close()
-			return false, ctx, netx.Tun{}
+			return false, ctx, nil
 		}
 
-		return true, ctx, netx.Tun{Conn: conn, Peer: pconn}
+		return true, ctx, &netx.Tun{Conn: conn, Peer: pconn}
 	})
 
 	go func() {
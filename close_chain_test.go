@@ -0,0 +1,127 @@
+package netx_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	netx "github.com/pedramktb/go-netx"
+)
+
+// closeSpy is an instrumented conn that records whether it was closed.
+// It reports a MaxWrite so that layers requiring one can be stacked on it.
+type closeSpy struct {
+	net.Conn
+	closed atomic.Bool
+}
+
+func newCloseSpy(t *testing.T) *closeSpy {
+	t.Helper()
+	c, peer := net.Pipe()
+	t.Cleanup(func() { _ = c.Close(); _ = peer.Close() })
+	go func() { _, _ = io.Copy(io.Discard, peer) }()
+	return &closeSpy{Conn: c}
+}
+
+func (c *closeSpy) MaxWrite() uint16 { return 1024 }
+
+func (c *closeSpy) Close() error {
+	c.closed.Store(true)
+	return c.Conn.Close()
+}
+
+// assertCloseChain closes top and fails unless every spy below it was closed as well.
+func assertCloseChain(t *testing.T, top io.Closer, spies ...*closeSpy) {
+	t.Helper()
+	_ = top.Close()
+	for i, spy := range spies {
+		if !spy.closed.Load() {
+			t.Fatalf("closing the top of the chain did not close layer %d", i)
+		}
+	}
+}
+
+func TestCloseChainCoreDrivers(t *testing.T) {
+	t.Parallel()
+	for _, chain := range []string{
+		"buf",
+		"frame",
+		"split",
+		"textenc",
+		"ratelimit{up=1000}",
+		"count{name=TestCloseChainCoreDrivers}",
+		"poll",
+		"frame+count{name=TestCloseChainCoreDrivers}+ratelimit{down=1000}+textenc+buf+poll",
+	} {
+		t.Run(chain, func(t *testing.T) {
+			t.Parallel()
+			var ws netx.Wrappers
+			if err := ws.UnmarshalText([]byte(chain), false); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			spy := newCloseSpy(t)
+			top, err := ws.Apply(net.Conn(spy))
+			if err != nil {
+				t.Fatalf("apply: %v", err)
+			}
+			assertCloseChain(t, top.(io.Closer), spy)
+		})
+	}
+}
+
+func TestCloseChainDemuxListenerClosesConns(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	dl := netx.NewDemuxListener(ln, 1)
+	defer dl.Close()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte{0x01, 'h', 'i'}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	sess, err := dl.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer sess.Close()
+
+	_ = dl.Close()
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected closing the listener to close accepted conns, got %v", err)
+	}
+	if _, err := dl.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected accept after close to fail with net.ErrClosed, got %v", err)
+	}
+}
+
+func TestListenClosesListenerOnUpgradeError(t *testing.T) {
+	t.Parallel()
+	var base net.Listener
+	sinkErr := errors.New("sink failed")
+	var s netx.ListenerScheme
+	if err := s.UnmarshalText([]byte("tcp")); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	s.Wrappers = netx.Wrappers{netx.Branch(nil, func(v any) error {
+		base = v.(net.Listener)
+		return sinkErr
+	})}
+	if _, err := s.Listen(context.Background(), "127.0.0.1:0"); !errors.Is(err, sinkErr) {
+		t.Fatalf("expected upgrade error, got %v", err)
+	}
+	if _, err := base.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected base listener to be closed, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+/*
+CountConn is a network layer that counts the bytes read and written through it, e.g. to measure
+throughput at a specific position of a chain. Counters are registered globally by name, so the
+count driver and the code reading the totals only need to agree on the name:
+
+	tcp+count{name=outer}+aesgcm{key=...}+count{name=inner}://example.com:9000
+
+	inner := netx.GetByteCounter("inner")
+	fmt.Println(inner.Read(), inner.Written())
+
+All conns wrapped with the same name add to the same counter. Reads and writes are passed through
+unchanged, so I/O boundaries are preserved.
+*/
+
+package netx
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+func init() {
+	Register("count", func(params map[string]string, listener bool) (Wrapper, error) {
+		var name string
+		for key, value := range params {
+			switch key {
+			case "name":
+				name = value
+			default:
+				return Wrapper{}, fmt.Errorf("uri: unknown count parameter %q", key)
+			}
+		}
+		counter := GetByteCounter(name)
+		connToConn := func(c net.Conn) (net.Conn, error) {
+			return NewCountConn(c, counter), nil
+		}
+		return Wrapper{
+			Name:   "count",
+			Params: params,
+			ListenerToListener: func(l net.Listener) (net.Listener, error) {
+				return ConnWrapListener(l, connToConn)
+			},
+			DialerToDialer: func(f Dialer) (Dialer, error) {
+				return ConnWrapDialer(f, connToConn)
+			},
+			ConnToConn: connToConn,
+		}, nil
+	}, RequireParams("name"))
+}
+
+// ByteCounter holds byte totals of the conns counting into it. It is safe for concurrent use.
+type ByteCounter struct {
+	read, written atomic.Uint64
+}
+
+// Read returns the number of bytes read so far.
+func (c *ByteCounter) Read() uint64 { return c.read.Load() }
+
+// Written returns the number of bytes written so far.
+func (c *ByteCounter) Written() uint64 { return c.written.Load() }
+
+var byteCounters sync.Map // name to *ByteCounter
+
+// GetByteCounter returns the counter registered under name, creating it if needed,
+// so it can be looked up before or after the chain using it is built.
+func GetByteCounter(name string) *ByteCounter {
+	if c, ok := byteCounters.Load(name); ok {
+		return c.(*ByteCounter)
+	}
+	c, _ := byteCounters.LoadOrStore(name, new(ByteCounter))
+	return c.(*ByteCounter)
+}
+
+type countConn struct {
+	net.Conn
+	counter *ByteCounter
+}
+
+// NewCountConn wraps c so the bytes read and written through it are added to counter.
+func NewCountConn(c net.Conn, counter *ByteCounter) net.Conn {
+	return &countConn{Conn: c, counter: counter}
+}
+
+// MaxWrite forwards the underlying connection's MaxWrite limit, if any.
+func (c *countConn) MaxWrite() uint16 {
+	if mw, ok := c.Conn.(interface{ MaxWrite() uint16 }); ok {
+		return mw.MaxWrite()
+	}
+	return 0
+}
+
+func (c *countConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.counter.read.Add(uint64(n))
+	return n, err
+}
+
+func (c *countConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.counter.written.Add(uint64(n))
+	return n, err
+}
@@ -0,0 +1,60 @@
+package netx_test
+
+import (
+	"net"
+	"testing"
+
+	netx "github.com/pedramktb/go-netx"
+)
+
+func TestCountConnCountsTransferredBytes(t *testing.T) {
+	t.Parallel()
+	var w netx.Wrapper
+	if err := w.UnmarshalText([]byte("count{name=TestCountConnCountsTransferredBytes}"), false); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	clientRaw, serverRaw := net.Pipe()
+	t.Cleanup(func() { _ = clientRaw.Close(); _ = serverRaw.Close() })
+	v, err := w.Apply(net.Conn(clientRaw))
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	client := v.(net.Conn)
+	// counters are global, so only the bytes of this run are checked, e.g. with -count
+	counter := netx.GetByteCounter("TestCountConnCountsTransferredBytes")
+	written, read := counter.Written(), counter.Read()
+
+	// echo every write back, keeping the write boundaries
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := serverRaw.Read(buf)
+			if err != nil {
+				return
+			}
+			if _, err := serverRaw.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+
+	var total uint64
+	buf := make([]byte, 1024)
+	for _, size := range []int{1, 100, 1000} {
+		if _, err := client.Write(make([]byte, size)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if n != size {
+			t.Fatalf("read %d bytes, want the %d bytes written in one piece", n, size)
+		}
+		total += uint64(size)
+	}
+
+	if counter.Written()-written != total || counter.Read()-read != total {
+		t.Fatalf("counted %d written and %d read, want %d each", counter.Written()-written, counter.Read()-read, total)
+	}
+}
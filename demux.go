@@ -34,18 +34,27 @@ func init() {
 					return Wrapper{}, fmt.Errorf("uri: invalid demux id hex parameter %q: %w", value, err)
 				}
 			case "accq":
-				if !listener {
-					return Wrapper{}, fmt.Errorf("uri: demux accept queue parameter is only valid for listeners")
-				}
 				size, err := strconv.ParseUint(value, 10, 16)
 				if err != nil {
 					return Wrapper{}, fmt.Errorf("uri: invalid demux accept queue parameter %q: %w", value, err)
 				}
 				opts = append(opts, WithDemuxAccQueue(uint16(size)))
-			case "rq":
-				if !listener {
-					return Wrapper{}, fmt.Errorf("uri: demux session read queue parameter is only valid for listeners")
+			case "accblock":
+				timeout, err := time.ParseDuration(value)
+				if err != nil {
+					return Wrapper{}, fmt.Errorf("uri: invalid demux accept block parameter %q: %w", value, err)
+				}
+				opts = append(opts, WithDemuxAccBlock(timeout))
+			case "tags":
+				switch value {
+				case "drop":
+					opts = append(opts, WithDemuxTagPolicy(DemuxTagDropOldest))
+				case "block":
+					opts = append(opts, WithDemuxTagPolicy(DemuxTagBlock))
+				default:
+					return Wrapper{}, fmt.Errorf("uri: invalid demux tags parameter %q", value)
 				}
+			case "rq":
 				size, err := strconv.ParseUint(value, 10, 16)
 				if err != nil {
 					return Wrapper{}, fmt.Errorf("uri: invalid demux session queue parameter %q: %w", value, err)
@@ -56,7 +65,7 @@ func init() {
 			}
 		}
 		if len(id) == 0 {
-			return Wrapper{}, fmt.Errorf("uri: demux requires an id parameter to determine session ID length")
+			return Wrapper{}, fmt.Errorf("uri: demux id parameter must not be empty")
 		}
 		if listener {
 			return Wrapper{
@@ -85,7 +94,7 @@ func init() {
 				return NewDemuxDialer(d, id), nil
 			},
 		}, nil
-	})
+	}, RequireParams("id"), DialerRules(ForbidParams("accq", "accblock", "tags", "rq")))
 }
 
 type demux struct {
@@ -100,7 +109,11 @@ type demuxCore struct {
 	logger            Logger
 	idMask            int // length of ID prefix in bytes
 	accQueue          chan net.Conn
+	accBlock          time.Duration // how long to wait for accept queue space, 0 drops immediately
+	accDone           chan struct{} // closed on Close to release a read loop blocked on the accept queue
+	accMu             sync.RWMutex  // held for reading while blocked on the accept queue, so Close does not close it under a sender
 	sessReadQueueSize int
+	tagPolicy         DemuxTagPolicy
 	maxWrite          uint16
 }
 
@@ -114,6 +127,16 @@ func WithDemuxAccQueue(size uint16) DemuxOption {
 	}
 }
 
+// WithDemuxAccBlock makes the demux wait up to timeout for space in a full accept queue instead of
+// dropping the new session right away. This applies backpressure to the underlying conn: while waiting,
+// no packets are read, so all existing sessions stall as well. Use it only when Accept is known to keep up
+// eventually. Default is 0, which drops new sessions when the queue is full.
+func WithDemuxAccBlock(timeout time.Duration) DemuxOption {
+	return func(m *demuxCore) {
+		m.accBlock = timeout
+	}
+}
+
 // WithReadQueueSize sets the size of the read queues of the sessions.
 // Default is 128.
 func WithDemuxReadQueue(size uint16) DemuxOption {
@@ -122,6 +145,25 @@ func WithDemuxReadQueue(size uint16) DemuxOption {
 	}
 }
 
+// DemuxTagPolicy decides what a TaggedDemux session does when its queue of tags awaiting a Write is full,
+// which happens when it reads much more than it writes.
+type DemuxTagPolicy int
+
+const (
+	DemuxTagDropOldest DemuxTagPolicy = iota // drop the oldest tag to make room for the new one
+	DemuxTagBlock                            // block Read until a Write consumes a tag
+)
+
+// WithDemuxTagPolicy sets the policy for full tag queues of TaggedDemux sessions, which hold twice the read
+// queue size. DemuxTagBlock keeps every tag, but a session that rarely writes stalls its reads, and thereby
+// a reader waiting for data before writing deadlocks. Default is DemuxTagDropOldest, which suits stateless
+// transports like DNS, where old tags are stale anyway. It has no effect on a plain Demux.
+func WithDemuxTagPolicy(policy DemuxTagPolicy) DemuxOption {
+	return func(m *demuxCore) {
+		m.tagPolicy = policy
+	}
+}
+
 // WithLogger sets the logger for the demux and its sessions.
 func WithDemuxLogger(logger Logger) DemuxOption {
 	return func(m *demuxCore) {
@@ -141,6 +183,7 @@ func NewDemux(c net.Conn, idMask uint8, opts ...DemuxOption) (net.Listener, erro
 			logger:            slog.Default(),
 			idMask:            int(idMask),
 			accQueue:          make(chan net.Conn, 1),
+			accDone:           make(chan struct{}),
 			sessReadQueueSize: 128,
 		},
 	}
@@ -169,6 +212,7 @@ func (m *demux) Close() error {
 	if !m.closing.CompareAndSwap(false, true) {
 		return nil
 	}
+	m.stopAccept()
 	m.mu.Lock()
 	close(m.accQueue)
 	for _, s := range m.sessions {
@@ -224,9 +268,22 @@ func (m *demux) processPacket(id, payload []byte) {
 		select {
 		case m.accQueue <- sess:
 		default:
-			// If the accept queue is full, drop the new session to avoid blocking the read loop.
-			m.logger.WarnContext(context.Background(), "demux: accept queue full, dropping new session", "id", hex.EncodeToString(id))
-			delete(m.sessions, string(id))
+			accepted := false
+			if m.accBlock > 0 {
+				// wait without holding the lock so Close and the other sessions are not blocked
+				m.mu.Unlock()
+				accepted = m.waitAccept(sess)
+				m.mu.Lock()
+				if m.sessions == nil {
+					m.mu.Unlock()
+					return
+				}
+			}
+			if !accepted {
+				// If the accept queue is full, drop the new session to avoid blocking the read loop.
+				m.logger.WarnContext(context.Background(), "demux: accept queue full, dropping new session", "id", hex.EncodeToString(id))
+				delete(m.sessions, string(id))
+			}
 		}
 	}
 	select {
@@ -240,6 +297,35 @@ func (m *demux) processPacket(id, payload []byte) {
 
 func (m *demux) Addr() net.Addr { return m.bc.LocalAddr() }
 
+// waitAccept waits up to accBlock for space in the accept queue and reports whether c was queued.
+func (m *demuxCore) waitAccept(c net.Conn) bool {
+	m.accMu.RLock()
+	defer m.accMu.RUnlock()
+	select {
+	case <-m.accDone:
+		return false
+	default:
+	}
+	timer := time.NewTimer(m.accBlock)
+	defer timer.Stop()
+	select {
+	case m.accQueue <- c:
+		return true
+	case <-timer.C:
+		return false
+	case <-m.accDone:
+		return false
+	}
+}
+
+// stopAccept releases a read loop blocked in waitAccept and waits for it to let go of the accept queue,
+// after which the queue can be closed.
+func (m *demuxCore) stopAccept() {
+	close(m.accDone)
+	m.accMu.Lock()
+	defer m.accMu.Unlock()
+}
+
 // demuxSess represents a persistent virtual connection
 type demuxSess struct {
 	demux         *demux
@@ -348,8 +434,9 @@ func (s *demuxSess) Close() error {
 		return nil
 	}
 	s.demux.mu.Lock()
-	close(s.rQueue)
+	// a closed demux has already closed the queues of all its sessions
 	if s.demux.sessions != nil {
+		close(s.rQueue)
 		delete(s.demux.sessions, string(s.id))
 	}
 	s.demux.mu.Unlock()
@@ -394,3 +481,5 @@ type demuxVirtualAddr struct {
 func (a *demuxVirtualAddr) String() string {
 	return a.Addr.String() + ":" + hex.EncodeToString(a.id)
 }
+
+func (a *demuxVirtualAddr) Virtual() bool { return true }
@@ -12,6 +12,8 @@ type demuxClient struct {
 	id       []byte
 	buf      sync.Pool
 	writeMax uint16
+	rMu      sync.Mutex
+	unread   []byte // rest of a packet larger than the caller's buffer
 }
 
 func NewDemuxClient(c net.Conn, id []byte) Dialer {
@@ -38,7 +40,16 @@ func NewDemuxClient(c net.Conn, id []byte) Dialer {
 
 func (m *demuxClient) MaxWrite() uint16 { return m.writeMax }
 
+// Read returns the payload of the next packet. A payload larger than b is delivered across multiple Reads.
 func (m *demuxClient) Read(b []byte) (n int, err error) {
+	m.rMu.Lock()
+	defer m.rMu.Unlock()
+	if len(m.unread) > 0 {
+		n = copy(b, m.unread)
+		m.unread = m.unread[n:]
+		return n, nil
+	}
+
 	bp := m.buf.Get().(*[]byte)
 	buf := *bp
 	defer m.buf.Put(bp)
@@ -58,8 +69,13 @@ func (m *demuxClient) Read(b []byte) (n int, err error) {
 	if string(buf[:len(m.id)]) != string(m.id) {
 		return 0, errors.New("demuxClient: received packet with mismatched ID")
 	}
-	copy(b, buf[len(m.id):n])
-	return n - len(m.id), nil
+	payload := buf[len(m.id):n]
+	c := copy(b, payload)
+	if c < len(payload) {
+		// the pooled buffer is reused, so keep a copy of the rest
+		m.unread = append(m.unread[:0], payload[c:]...)
+	}
+	return c, nil
 }
 
 func (m *demuxClient) Write(b []byte) (n int, err error) {
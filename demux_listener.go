@@ -15,6 +15,12 @@ type demuxListener struct {
 	demux    func(net.Conn) (net.Listener, error)
 	accQueue chan demuxAccept
 	once     sync.Once
+
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+	demuxes   map[net.Listener]struct{} // nil once closed
+	wg        sync.WaitGroup
 }
 
 // NewDemuxListener creates a new Demux that listens on an underlying net.Listener.
@@ -22,6 +28,7 @@ type demuxListener struct {
 // it creates a new Demux for each accepted connection from the underlying listener,
 // which allows an addition of an ID to the current connection routing logic based on the remote address of the accepted connection,
 // instead of ignoring it and relying solely on the session ID in the packet for routing.
+// Closing the listener also closes the Demux of every accepted connection.
 func NewDemuxListener(l net.Listener, idMask uint8, opts ...DemuxOption) net.Listener {
 	return &demuxListener{
 		Listener: l,
@@ -29,39 +36,91 @@ func NewDemuxListener(l net.Listener, idMask uint8, opts ...DemuxOption) net.Lis
 			return NewDemux(c, idMask, opts...)
 		},
 		accQueue: make(chan demuxAccept, 1),
+		done:     make(chan struct{}),
+		demuxes:  make(map[net.Listener]struct{}),
 	}
 }
 
 func (dl *demuxListener) Accept() (net.Conn, error) {
 	dl.once.Do(func() {
+		dl.wg.Add(1)
 		go func() {
-			defer close(dl.accQueue)
+			defer func() {
+				dl.wg.Done()
+				dl.wg.Wait()
+				close(dl.accQueue)
+			}()
 			for {
 				c, err := dl.Listener.Accept()
 				if err != nil {
 					return
 				}
-				go func(c net.Conn) {
-					l, err := dl.demux(c)
-					if err != nil {
-						c.Close()
-						return
-					}
-					defer l.Close()
-					for {
-						conn, err := l.Accept()
-						dl.accQueue <- demuxAccept{conn, err}
-						if err != nil {
-							return
-						}
-					}
-				}(c)
+				dl.wg.Add(1)
+				go dl.serve(c)
 			}
 		}()
 	})
-	r, ok := <-dl.accQueue
-	if !ok {
+	select {
+	case r, ok := <-dl.accQueue:
+		if !ok {
+			return nil, net.ErrClosed
+		}
+		return r.conn, r.err
+	case <-dl.done:
 		return nil, net.ErrClosed
 	}
-	return r.conn, r.err
+}
+
+// serve demultiplexes c and queues its sessions until it fails or the listener is closed.
+func (dl *demuxListener) serve(c net.Conn) {
+	defer dl.wg.Done()
+	l, err := dl.demux(c)
+	if err != nil {
+		c.Close()
+		return
+	}
+	dl.mu.Lock()
+	if dl.demuxes == nil {
+		dl.mu.Unlock()
+		l.Close()
+		return
+	}
+	dl.demuxes[l] = struct{}{}
+	dl.mu.Unlock()
+	defer func() {
+		dl.mu.Lock()
+		delete(dl.demuxes, l)
+		dl.mu.Unlock()
+		l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		select {
+		case dl.accQueue <- demuxAccept{conn, err}:
+		case <-dl.done:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (dl *demuxListener) Close() error {
+	err := net.ErrClosed
+	dl.closeOnce.Do(func() {
+		close(dl.done)
+		err = dl.Listener.Close()
+		dl.mu.Lock()
+		demuxes := dl.demuxes
+		dl.demuxes = nil
+		dl.mu.Unlock()
+		for l := range demuxes {
+			l.Close()
+		}
+	})
+	return err
 }
@@ -38,6 +38,7 @@ func NewTaggedDemux(c TaggedConn, idMask uint8, opts ...DemuxOption) (net.Listen
 			logger:            slog.Default(),
 			idMask:            int(idMask),
 			accQueue:          make(chan net.Conn, 1),
+			accDone:           make(chan struct{}),
 			sessReadQueueSize: 128,
 		},
 	}
@@ -66,6 +67,7 @@ func (m *taggedDemux) Close() error {
 	if !m.closing.CompareAndSwap(false, true) {
 		return nil
 	}
+	m.stopAccept()
 	m.mu.Lock()
 	close(m.accQueue)
 	for _, s := range m.sessions {
@@ -124,9 +126,22 @@ func (m *taggedDemux) processPacket(id, payload []byte, tag any) {
 		select {
 		case m.accQueue <- sess:
 		default:
-			// If the accept queue is full, drop the new session to avoid blocking the read loop.
-			m.logger.WarnContext(context.Background(), "demux: accept queue full, dropping new session", "id", hex.EncodeToString(id))
-			delete(m.sessions, string(id))
+			accepted := false
+			if m.accBlock > 0 {
+				// wait without holding the lock so Close and the other sessions are not blocked
+				m.mu.Unlock()
+				accepted = m.waitAccept(sess)
+				m.mu.Lock()
+				if m.sessions == nil {
+					m.mu.Unlock()
+					return
+				}
+			}
+			if !accepted {
+				// If the accept queue is full, drop the new session to avoid blocking the read loop.
+				m.logger.WarnContext(context.Background(), "demux: accept queue full, dropping new session", "id", hex.EncodeToString(id))
+				delete(m.sessions, string(id))
+			}
 		}
 	}
 	select {
@@ -197,9 +212,7 @@ func (s *taggedDemuxSess) Read(b []byte) (n int, err error) {
 			if !ok {
 				return 0, io.EOF
 			}
-			select {
-			case s.tagQueue <- td.tag:
-			case <-s.closed:
+			if !s.pushTag(td.tag) {
 				return 0, net.ErrClosed
 			}
 
@@ -221,6 +234,31 @@ func (s *taggedDemuxSess) Read(b []byte) (n int, err error) {
 	}
 }
 
+// pushTag queues the tag of a read packet for a later Write according to the tag policy.
+// It returns false if the session was closed while blocked.
+func (s *taggedDemuxSess) pushTag(tag any) bool {
+	if s.demux.tagPolicy == DemuxTagBlock {
+		select {
+		case s.tagQueue <- tag:
+			return true
+		case <-s.closed:
+			return false
+		}
+	}
+	for {
+		select {
+		case s.tagQueue <- tag:
+			return true
+		default:
+		}
+		// make room; a concurrent Write may have taken the oldest tag already
+		select {
+		case <-s.tagQueue:
+		default:
+		}
+	}
+}
+
 func (s *taggedDemuxSess) Write(b []byte) (n int, err error) {
 	s.mu.Lock()
 	deadline := s.writeDeadline
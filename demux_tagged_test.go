@@ -2,6 +2,7 @@ package netx_test
 
 import (
 	"bytes"
+	"fmt"
 	"net"
 	"sync"
 	"testing"
@@ -234,3 +235,54 @@ func TestTaggedDemux_Close(t *testing.T) {
 		t.Error("Expected error accepting on closed listener")
 	}
 }
+
+func TestTaggedDemux_ReadHeavySessionDropsOldestTags(t *testing.T) {
+	clientConn, serverConn := netx.TaggedPipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	// a read queue of 2 gives a tag queue of 4
+	l, err := netx.NewTaggedDemux(serverConn, 4, netx.WithDemuxReadQueue(2))
+	if err != nil {
+		t.Fatalf("Failed to create TaggedDemux: %v", err)
+	}
+	defer l.Close()
+
+	sessID := []byte("1001")
+	next := make(chan int)
+	go func() {
+		for i := range next {
+			_, _ = clientConn.WriteTagged(append(bytes.Clone(sessID), 'x'), fmt.Sprintf("tag-%d", i))
+		}
+	}()
+	defer close(next)
+
+	next <- 0
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	defer conn.Close()
+
+	// read far more packets than the tag queue holds without writing
+	buf := make([]byte, 16)
+	for i := range 10 {
+		if i > 0 {
+			next <- i
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Read(buf); err != nil {
+			t.Fatalf("Read %d stalled: %v", i, err)
+		}
+	}
+
+	// the oldest tags were dropped, so the first write answers the oldest remaining one
+	go func() { _, _ = conn.Write([]byte("resp")) }()
+	var tag any
+	if _, err := clientConn.ReadTagged(buf, &tag); err != nil {
+		t.Fatalf("Client Read failed: %v", err)
+	}
+	if tag != "tag-6" {
+		t.Fatalf("Expected response tag %q, got %v", "tag-6", tag)
+	}
+}
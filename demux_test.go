@@ -342,3 +342,99 @@ func TestDemuxSess_Deadline(t *testing.T) {
 		t.Errorf("Returned too early: %v", elapsed)
 	}
 }
+
+func TestDemux_AccBlockDeliversSession(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	// Accept queue of 1: the second session does not fit until the first is accepted
+	l, err := netx.NewDemux(serverConn, 4, netx.WithDemuxAccQueue(1), netx.WithDemuxAccBlock(2*time.Second))
+	if err != nil {
+		t.Fatalf("Failed to create Demux: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		for _, id := range []string{"0001", "0002"} {
+			mc, _ := netx.NewDemuxClient(clientConn, []byte(id))()
+			_, _ = mc.Write([]byte("hello " + id))
+		}
+	}()
+
+	// Accept late, after the read loop had to wait for queue space
+	time.Sleep(100 * time.Millisecond)
+	buf := make([]byte, 32)
+	for _, id := range []string{"0001", "0002"} {
+		sess, err := l.Accept()
+		if err != nil {
+			t.Fatalf("Accept failed: %v", err)
+		}
+		_ = sess.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := sess.Read(buf)
+		if err != nil {
+			t.Fatalf("session %s read: %v", id, err)
+		}
+		if string(buf[:n]) != "hello "+id {
+			t.Fatalf("got %q, want %q", buf[:n], "hello "+id)
+		}
+	}
+}
+
+func TestDemux_AccBlockCloseReleasesReadLoop(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+
+	l, err := netx.NewDemux(serverConn, 4, netx.WithDemuxAccQueue(1), netx.WithDemuxAccBlock(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create Demux: %v", err)
+	}
+	go func() {
+		for _, id := range []string{"0001", "0002"} {
+			mc, _ := netx.NewDemuxClient(clientConn, []byte(id))()
+			_, _ = mc.Write([]byte("x"))
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// the read loop is blocked on the full accept queue; Close must not hang or panic
+	done := make(chan struct{})
+	go func() {
+		_ = l.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close blocked by waiting read loop")
+	}
+}
+
+func TestDemuxClient_LargeResponseAcrossReads(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	mc, err := netx.NewDemuxClient(clientConn, []byte("ID01"))()
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	payload := make([]byte, 40000)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	go func() { _, _ = serverConn.Write(append([]byte("ID01"), payload...)) }()
+
+	got := make([]byte, 0, len(payload))
+	buf := make([]byte, 1000)
+	for len(got) < len(payload) {
+		n, err := mc.Read(buf)
+		if err != nil {
+			t.Fatalf("read after %d bytes: %v", len(got), err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatal("reassembled response does not match")
+	}
+}
@@ -1,7 +1,11 @@
 package netx
 
 import (
+	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
 	"sync"
 )
 
@@ -12,7 +16,10 @@ var (
 	drivers   = make(map[string]Driver)
 )
 
-func Register(name string, d Driver) {
+// Register makes a driver available by name.
+// The given rules are checked against the params before the driver is invoked,
+// so drivers can declare required and conflicting params instead of validating them by hand.
+func Register(name string, d Driver, rules ...ParamRule) {
 	driversMu.Lock()
 	defer driversMu.Unlock()
 	if d == nil {
@@ -21,6 +28,17 @@ func Register(name string, d Driver) {
 	if _, dup := drivers[name]; dup {
 		panic("uri: Register called twice for driver " + name)
 	}
+	if len(rules) > 0 {
+		raw := d
+		d = func(params map[string]string, listener bool) (Wrapper, error) {
+			for _, rule := range rules {
+				if err := rule(params, listener); err != nil {
+					return Wrapper{}, err
+				}
+			}
+			return raw(params, listener)
+		}
+	}
 	drivers[name] = d
 }
 
@@ -33,3 +51,148 @@ func GetDriver(name string) (Driver, error) {
 	}
 	return d, nil
 }
+
+// bridgingDrivers returns the sorted names of the registered drivers that turn from into to.
+// Drivers are set up without params, or with a placeholder for the ones RequireParams asks for,
+// and drivers that still fail are skipped.
+func bridgingDrivers(from, to PipeType, listener bool) []string {
+	driversMu.RLock()
+	registered := maps.Clone(drivers)
+	driversMu.RUnlock()
+
+	var names []string
+	for name, d := range registered {
+		w, ok := probeDriver(d, listener)
+		if !ok {
+			continue
+		}
+		if out, ok := w.OutputFor(from); ok && out == to {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// probeDriver sets up d with as few params as it accepts, to find out which types it converts.
+func probeDriver(d Driver, listener bool) (Wrapper, bool) {
+	params := map[string]string{}
+	for {
+		w, err := d(params, listener)
+		var missing missingParamError
+		if err == nil {
+			return w, true
+		}
+		if !errors.As(err, &missing) || params[missing.key] != "" {
+			return Wrapper{}, false
+		}
+		params[missing.key] = "00"
+	}
+}
+
+// Errors returned by param rules.
+var (
+	ErrMissingParam      = errors.New("missing required parameter")
+	ErrConflictingParams = errors.New("mutually exclusive parameters")
+	ErrUnsupportedParam  = errors.New("unsupported parameter")
+)
+
+// missingParamError is returned by RequireParams and names the missing param.
+type missingParamError struct{ key string }
+
+func (e missingParamError) Error() string { return fmt.Sprintf("%s %q", ErrMissingParam, e.key) }
+func (e missingParamError) Unwrap() error { return ErrMissingParam }
+
+// ParamRule validates driver params before the driver is invoked. See Register.
+type ParamRule func(params map[string]string, listener bool) error
+
+// RequireParams requires all of the given params to be set.
+// Params with an empty value are treated as not set by all rules.
+func RequireParams(keys ...string) ParamRule {
+	return func(params map[string]string, _ bool) error {
+		for _, key := range keys {
+			if params[key] == "" {
+				return missingParamError{key: key}
+			}
+		}
+		return nil
+	}
+}
+
+// RequireOneOf requires at least one of the given params to be set.
+func RequireOneOf(keys ...string) ParamRule {
+	return func(params map[string]string, _ bool) error {
+		for _, key := range keys {
+			if params[key] != "" {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: one of %s", ErrMissingParam, quoteParams(keys))
+	}
+}
+
+// ExclusiveParams allows at most one of the given params to be set.
+func ExclusiveParams(keys ...string) ParamRule {
+	return func(params map[string]string, _ bool) error {
+		var set []string
+		for _, key := range keys {
+			if params[key] != "" {
+				set = append(set, key)
+			}
+		}
+		if len(set) > 1 {
+			return fmt.Errorf("%w %s", ErrConflictingParams, quoteParams(set))
+		}
+		return nil
+	}
+}
+
+// ForbidParams rejects the given params. It is meant to be scoped with ListenerRules or DialerRules.
+func ForbidParams(keys ...string) ParamRule {
+	return func(params map[string]string, _ bool) error {
+		for _, key := range keys {
+			if params[key] != "" {
+				return fmt.Errorf("%w %q", ErrUnsupportedParam, key)
+			}
+		}
+		return nil
+	}
+}
+
+// ListenerRules applies the given rules to listener (server) chains only.
+func ListenerRules(rules ...ParamRule) ParamRule {
+	return func(params map[string]string, listener bool) error {
+		if !listener {
+			return nil
+		}
+		for _, rule := range rules {
+			if err := rule(params, listener); err != nil {
+				return fmt.Errorf("listener: %w", err)
+			}
+		}
+		return nil
+	}
+}
+
+// DialerRules applies the given rules to dialer (client) chains only.
+func DialerRules(rules ...ParamRule) ParamRule {
+	return func(params map[string]string, listener bool) error {
+		if listener {
+			return nil
+		}
+		for _, rule := range rules {
+			if err := rule(params, listener); err != nil {
+				return fmt.Errorf("dialer: %w", err)
+			}
+		}
+		return nil
+	}
+}
+
+func quoteParams(keys []string) string {
+	quoted := make([]string, len(keys))
+	for i, key := range keys {
+		quoted[i] = fmt.Sprintf("%q", key)
+	}
+	return strings.Join(quoted, ", ")
+}
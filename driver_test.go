@@ -0,0 +1,86 @@
+package netx_test
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	netx "github.com/pedramktb/go-netx"
+)
+
+func init() {
+	netx.Register("paramtest", func(params map[string]string, listener bool) (netx.Wrapper, error) {
+		return netx.Wrapper{Name: "paramtest", Params: params}, nil
+	},
+		netx.RequireParams("key"),
+		netx.ExclusiveParams("pass", "token"),
+		netx.ListenerRules(netx.RequireOneOf("cert", "psk")),
+		netx.DialerRules(netx.ForbidParams("cert")),
+	)
+}
+
+func TestDriverParamRules(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		layer    string
+		listener bool
+		wantErr  error
+		wantMsg  string
+	}{
+		{layer: "paramtest{key=1,psk=2}", listener: true},
+		{layer: "paramtest{key=1}", listener: false},
+		{layer: "paramtest{psk=2}", listener: true, wantErr: netx.ErrMissingParam, wantMsg: `missing required parameter "key"`},
+		{layer: "paramtest{key=}", listener: false, wantErr: netx.ErrMissingParam, wantMsg: `missing required parameter "key"`},
+		{layer: "paramtest{key=1,pass=a,token=b}", listener: false, wantErr: netx.ErrConflictingParams, wantMsg: `mutually exclusive parameters "pass", "token"`},
+		{layer: "paramtest{key=1}", listener: true, wantErr: netx.ErrMissingParam, wantMsg: `listener: missing required parameter: one of "cert", "psk"`},
+		{layer: "paramtest{key=1,cert=3}", listener: false, wantErr: netx.ErrUnsupportedParam, wantMsg: `dialer: unsupported parameter "cert"`},
+		{layer: "demux{rq=4}", listener: true, wantErr: netx.ErrMissingParam, wantMsg: `missing required parameter "id"`},
+		{layer: "demux{id=00,accq=4}", listener: false, wantErr: netx.ErrUnsupportedParam, wantMsg: `dialer: unsupported parameter "accq"`},
+		{layer: "poll{interval=1s}", listener: true, wantErr: netx.ErrUnsupportedParam, wantMsg: `listener: unsupported parameter "interval"`},
+	}
+	for _, tt := range tests {
+		var w netx.Wrapper
+		err := w.UnmarshalText([]byte(tt.layer), tt.listener)
+		if tt.wantErr == nil {
+			if err != nil {
+				t.Errorf("%s (listener=%v): unexpected error: %v", tt.layer, tt.listener, err)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s (listener=%v): got %v, want %v", tt.layer, tt.listener, err, tt.wantErr)
+			continue
+		}
+		if !strings.HasSuffix(err.Error(), tt.wantMsg) {
+			t.Errorf("%s (listener=%v): got message %q, want suffix %q", tt.layer, tt.listener, err.Error(), tt.wantMsg)
+		}
+	}
+}
+
+func TestChainTypeErrorSuggestsBridge(t *testing.T) {
+	t.Parallel()
+	var ws netx.Wrappers
+	err := ws.UnmarshalText([]byte("mux"), true)
+	var cte *netx.ChainTypeError
+	if !errors.As(err, &cte) {
+		t.Fatalf("got %v, want a ChainTypeError", err)
+	}
+	if !cte.Server || cte.Got != netx.PipeTypeTaggedConn || cte.Want != netx.PipeTypeListener {
+		t.Fatalf("unexpected error fields %+v", cte)
+	}
+	if !slices.Contains(cte.Bridges, "demux") {
+		t.Fatalf("bridges %v do not suggest demux", cte.Bridges)
+	}
+	if !strings.Contains(err.Error(), `append one of "demux"`) {
+		t.Fatalf("error %q does not suggest a fix", err)
+	}
+
+	err = ws.UnmarshalText([]byte("mux"), false)
+	if !errors.As(err, &cte) || cte.Got != netx.PipeTypeConn || cte.Want != netx.PipeTypeDialer {
+		t.Fatalf("got %v, want a Conn to Dialer ChainTypeError", err)
+	}
+	if !slices.Contains(cte.Bridges, "demux") {
+		t.Fatalf("bridges %v do not suggest demux", cte.Bridges)
+	}
+}
@@ -2,48 +2,152 @@ package aesgcm
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"time"
 
 	"github.com/pedramktb/go-netx"
 	aesgcmproto "github.com/pedramktb/go-netx/proto/aesgcm"
 )
 
 func init() {
-	netx.Register("aesgcm", func(params map[string]string, listener bool) (netx.Wrapper, error) {
-		aeskey := []byte{}
+	netx.Register("aesgcm", newWrapper, netx.RequireParams("key"))
+	netx.Register("fallback", func(params map[string]string, listener bool) (netx.Wrapper, error) {
+		if !listener {
+			return netx.Wrapper{}, errors.New("uri: fallback is exclusive to servers, use aesgcm for clients instead")
+		}
+		wait := 5 * time.Second
+		aesgcmParams := make(map[string]string, len(params))
 		for key, value := range params {
-			switch key {
-			case "key":
-				var err error
-				aeskey, err = hex.DecodeString(value)
-				if err != nil {
-					return netx.Wrapper{}, fmt.Errorf("uri: invalid aesgcm key parameter: %w", err)
-				}
-				if len(aeskey) != 16 && len(aeskey) != 24 && len(aeskey) != 32 {
-					return netx.Wrapper{}, fmt.Errorf("uri: invalid aesgcm key size %d", len(aeskey))
-				}
-			default:
-				return netx.Wrapper{}, fmt.Errorf("uri: unknown aesgcm parameter %q", key)
+			if key != "wait" {
+				aesgcmParams[key] = value
+				continue
+			}
+			var err error
+			if wait, err = time.ParseDuration(value); err != nil {
+				return netx.Wrapper{}, fmt.Errorf("uri: invalid fallback wait parameter %q: %w", value, err)
 			}
 		}
-		if len(aeskey) == 0 {
-			return netx.Wrapper{}, fmt.Errorf("uri: missing aesgcm key parameter")
+		w, err := newWrapper(aesgcmParams, listener)
+		if err != nil {
+			return netx.Wrapper{}, err
 		}
+		// the first packet is awaited on the first I/O, so silent clients do not hold up Accept
 		connToConn := func(c net.Conn) (net.Conn, error) {
-			return aesgcmproto.NewAESGCMConn(c, aeskey)
+			return aesgcmproto.NewFallbackConnDeferred(c, wait, w.ConnToConn), nil
 		}
 		return netx.Wrapper{
-			Name:     "aesgcm",
+			Name:     "fallback",
 			Params:   params,
 			Listener: listener,
 			ListenerToListener: func(l net.Listener) (net.Listener, error) {
 				return netx.ConnWrapListener(l, connToConn)
 			},
-			DialerToDialer: func(f netx.Dialer) (netx.Dialer, error) {
-				return netx.ConnWrapDialer(f, connToConn)
-			},
 			ConnToConn: connToConn,
 		}, nil
-	})
+	}, netx.RequireParams("key"), netx.ForbidParams("nonce"))
+}
+
+// newWrapper is the driver of the aesgcm layer.
+func newWrapper(params map[string]string, listener bool) (netx.Wrapper, error) {
+	aeskey := []byte{}
+	opts := []aesgcmproto.Option{}
+	roled := false
+	negotiate := false
+	compression := aesgcmproto.CompressNone
+	aead := aesgcmproto.AEADAESGCM
+	for key, value := range params {
+		switch key {
+		case "key":
+			var err error
+			aeskey, err = hex.DecodeString(value)
+			if err != nil {
+				return netx.Wrapper{}, fmt.Errorf("uri: invalid aesgcm key parameter: %w", err)
+			}
+			if len(aeskey) != 16 && len(aeskey) != 24 && len(aeskey) != 32 {
+				return netx.Wrapper{}, fmt.Errorf("uri: invalid aesgcm key size %d", len(aeskey))
+			}
+		case "nonce":
+			switch value {
+			case "explicit":
+				opts = append(opts, aesgcmproto.WithExplicitNonce(true))
+			case "derived":
+			default:
+				return netx.Wrapper{}, fmt.Errorf("uri: invalid aesgcm nonce parameter %q", value)
+			}
+		case "roled":
+			var err error
+			roled, err = strconv.ParseBool(value)
+			if err != nil {
+				return netx.Wrapper{}, fmt.Errorf("uri: invalid aesgcm roled parameter: %w", err)
+			}
+		case "aead":
+			var err error
+			aead, err = aesgcmproto.ParseAEAD(value)
+			if err != nil {
+				return netx.Wrapper{}, fmt.Errorf("uri: invalid aesgcm aead parameter: %w", err)
+			}
+			opts = append(opts, aesgcmproto.WithAEAD(aead))
+		case "replay":
+			size, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return netx.Wrapper{}, fmt.Errorf("uri: invalid aesgcm replay parameter: %w", err)
+			}
+			opts = append(opts, aesgcmproto.WithReplayWindow(uint32(size)))
+		case "compress":
+			var err error
+			compression, err = aesgcmproto.ParseCompression(value)
+			if err != nil {
+				return netx.Wrapper{}, fmt.Errorf("uri: invalid aesgcm compress parameter: %w", err)
+			}
+		case "legacy":
+			legacy, err := strconv.ParseBool(value)
+			if err != nil {
+				return netx.Wrapper{}, fmt.Errorf("uri: invalid aesgcm legacy parameter: %w", err)
+			}
+			opts = append(opts, aesgcmproto.WithLegacyHandshake(legacy))
+		case "reclen":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return netx.Wrapper{}, fmt.Errorf("uri: invalid aesgcm reclen parameter: %w", err)
+			}
+			opts = append(opts, aesgcmproto.WithRecordLength(enabled))
+		case "negotiate":
+			var err error
+			negotiate, err = strconv.ParseBool(value)
+			if err != nil {
+				return netx.Wrapper{}, fmt.Errorf("uri: invalid aesgcm negotiate parameter: %w", err)
+			}
+		default:
+			return netx.Wrapper{}, fmt.Errorf("uri: unknown aesgcm parameter %q", key)
+		}
+	}
+	if negotiate {
+		opts = append(opts, aesgcmproto.WithNegotiatedCompression(compression))
+	} else {
+		opts = append(opts, aesgcmproto.WithCompression(compression))
+	}
+	if aead == aesgcmproto.AEADAESGCMSIV && len(aeskey) == 24 {
+		return netx.Wrapper{}, fmt.Errorf("uri: aesgcm gcmsiv requires a 16 or 32 byte key")
+	}
+	connToConn := func(c net.Conn) (net.Conn, error) {
+		if roled {
+			return aesgcmproto.NewAESGCMConnRoled(c, aeskey, !listener, opts...)
+		}
+		return aesgcmproto.NewAESGCMConn(c, aeskey, opts...)
+	}
+	return netx.Wrapper{
+		Name:     "aesgcm",
+		Params:   params,
+		Listener: listener,
+		ListenerToListener: func(l net.Listener) (net.Listener, error) {
+			return netx.ConnWrapListener(l, connToConn)
+		},
+		DialerToDialer: func(f netx.Dialer) (netx.Dialer, error) {
+			return netx.ConnWrapDialer(f, connToConn)
+		},
+		ConnToConn: connToConn,
+	}, nil
 }
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pedramktb/go-netx"
 	dnstproto "github.com/pedramktb/go-netx/proto/dnst"
@@ -12,27 +14,99 @@ import (
 func init() {
 	netx.Register("dnst", func(params map[string]string, listener bool) (netx.Wrapper, error) {
 		var domain string
-		opts := []dnstproto.ServerOption{}
+		opts := []dnstproto.Option{}
 		for key, value := range params {
 			switch key {
 			case "domain":
-				domain = value
-			case "maxw":
-				if !listener {
-					return netx.Wrapper{}, fmt.Errorf("dnst: max write parameter is only valid for listeners")
+				domains := strings.Split(value, "|")
+				for _, d := range domains {
+					if err := dnstproto.ValidateDomain(d); err != nil {
+						return netx.Wrapper{}, err
+					}
 				}
+				if len(domains) > 1 {
+					if !listener {
+						return netx.Wrapper{}, fmt.Errorf("dnst: multiple domains are only valid for listeners")
+					}
+					opts = append(opts, dnstproto.WithDomains(domains[1:]...))
+				}
+				domain = domains[0]
+			case "maxw":
 				size, err := strconv.ParseUint(value, 10, 16)
 				if err != nil {
 					return netx.Wrapper{}, fmt.Errorf("dnst: invalid max write parameter %q: %w", value, err)
 				}
 				opts = append(opts, dnstproto.WithMaxWrite(uint16(size)))
+			case "maxr":
+				size, err := strconv.ParseUint(value, 10, 16)
+				if err != nil {
+					return netx.Wrapper{}, fmt.Errorf("dnst: invalid max read parameter %q: %w", value, err)
+				}
+				opts = append(opts, dnstproto.WithMaxRead(uint16(size)))
+			case "alphabet":
+				if err := dnstproto.ValidateAlphabet(value); err != nil {
+					return netx.Wrapper{}, err
+				}
+				opts = append(opts, dnstproto.WithAlphabet(value))
+			case "dedup":
+				window, err := time.ParseDuration(value)
+				if err != nil {
+					return netx.Wrapper{}, fmt.Errorf("dnst: invalid dedup parameter %q: %w", value, err)
+				}
+				opts = append(opts, dnstproto.WithDedup(window))
+			case "keepalive":
+				interval, err := time.ParseDuration(value)
+				if err != nil {
+					return netx.Wrapper{}, fmt.Errorf("dnst: invalid keepalive parameter %q: %w", value, err)
+				}
+				opts = append(opts, dnstproto.WithKeepalive(interval))
+			case "ecs":
+				_, subnet, err := net.ParseCIDR(value)
+				if err != nil {
+					return netx.Wrapper{}, fmt.Errorf("dnst: invalid ecs parameter %q: %w", value, err)
+				}
+				opts = append(opts, dnstproto.WithClientSubnet(*subnet))
+			case "session":
+				if !listener {
+					if err := dnstproto.ValidateSessionToken(value); err != nil {
+						return netx.Wrapper{}, err
+					}
+					opts = append(opts, dnstproto.WithSessionToken(value))
+					break
+				}
+				enabled, err := strconv.ParseBool(value)
+				if err != nil {
+					return netx.Wrapper{}, fmt.Errorf("dnst: invalid session parameter %q: %w", value, err)
+				}
+				opts = append(opts, dnstproto.WithSessionLabel(enabled))
+			case "pushq":
+				size, err := strconv.ParseUint(value, 10, 16)
+				if err != nil {
+					return netx.Wrapper{}, fmt.Errorf("dnst: invalid pushq parameter %q: %w", value, err)
+				}
+				opts = append(opts, dnstproto.WithPushQueue(int(size)))
+			case "msgheader":
+				enabled, err := strconv.ParseBool(value)
+				if err != nil {
+					return netx.Wrapper{}, fmt.Errorf("dnst: invalid msgheader parameter %q: %w", value, err)
+				}
+				opts = append(opts, dnstproto.WithMessageHeader(enabled))
+			case "answerindex":
+				enabled, err := strconv.ParseBool(value)
+				if err != nil {
+					return netx.Wrapper{}, fmt.Errorf("dnst: invalid answerindex parameter %q: %w", value, err)
+				}
+				opts = append(opts, dnstproto.WithAnswerIndexing(enabled))
+			case "duplex":
+				enabled, err := strconv.ParseBool(value)
+				if err != nil {
+					return netx.Wrapper{}, fmt.Errorf("dnst: invalid duplex parameter %q: %w", value, err)
+				}
+				opts = append(opts, dnstproto.WithFullDuplex(enabled))
 			default:
 				return netx.Wrapper{}, fmt.Errorf("dnst: unknown parameter %q", key)
 			}
 		}
-		if domain == "" {
-			return netx.Wrapper{}, fmt.Errorf("dnst: missing domain parameter")
-		}
 		if listener {
 			return netx.Wrapper{
 				Name:     "dnst",
@@ -50,7 +124,8 @@ func init() {
 			Params:   params,
 			Listener: listener,
 			ConnToConn: func(c net.Conn) (net.Conn, error) {
-				return dnstproto.NewClientConn(c, domain), nil
+				return dnstproto.NewClientConn(c, domain, opts...), nil
 			}}, nil
-	})
+	}, netx.RequireParams("domain"), netx.DialerRules(netx.ForbidParams("maxw", "duplex", "pushq", "dedup")),
+		netx.ListenerRules(netx.ForbidParams("keepalive", "ecs")))
 }
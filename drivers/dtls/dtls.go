@@ -9,6 +9,7 @@ import (
 	"encoding/pem"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/pedramktb/go-netx"
 	"github.com/pion/dtls/v3"
@@ -18,6 +19,7 @@ import (
 func init() {
 	netx.Register("dtls", func(params map[string]string, listener bool) (netx.Wrapper, error) {
 		var certKey, cert []byte
+		var handshakeTimeout time.Duration
 		cfg := &dtls.Config{}
 		for key, value := range params {
 			switch key {
@@ -35,14 +37,17 @@ func init() {
 				}
 			case "servername":
 				cfg.ServerName = value
+			case "handshaketimeout":
+				var err error
+				handshakeTimeout, err = time.ParseDuration(value)
+				if err != nil {
+					return netx.Wrapper{}, fmt.Errorf("uri: invalid dtls handshaketimeout parameter %q: %w", value, err)
+				}
 			default:
 				return netx.Wrapper{}, fmt.Errorf("uri: unknown dtls parameter %q", key)
 			}
 		}
 		if listener {
-			if cert == nil || certKey == nil {
-				return netx.Wrapper{}, fmt.Errorf("uri: dtls server requires cert and key parameters")
-			}
 			certificate, err := tls.X509KeyPair(cert, certKey)
 			if err != nil {
 				return netx.Wrapper{}, fmt.Errorf("uri: invalid dtls certificate: %w", err)
@@ -56,12 +61,13 @@ func init() {
 					return dtls.NewListener(dtlsnet.PacketListenerFromListener(l), cfg)
 				},
 				ConnToConn: func(c net.Conn) (net.Conn, error) {
-					return dtls.Server(dtlsnet.PacketConnFromConn(c), c.RemoteAddr(), cfg)
+					raddr, err := netx.PeerAddr("dtls", c)
+					if err != nil {
+						return nil, err
+					}
+					return dtls.Server(dtlsnet.PacketConnFromConn(c), raddr, cfg)
 				}}, nil
 		} else {
-			if certKey != nil {
-				return netx.Wrapper{}, fmt.Errorf("uri: dtls client does not support key parameter")
-			}
 			if cert != nil {
 				var err error
 				cfg.InsecureSkipVerify = true
@@ -70,23 +76,31 @@ func init() {
 					return netx.Wrapper{}, fmt.Errorf("uri: invalid dtls cert parameter: %w", err)
 				}
 			}
-			if cfg.ServerName == "" && cert == nil {
-				return netx.Wrapper{}, fmt.Errorf("uri: dtls client requires servername or cert parameter")
+			// without a timeout, the handshake runs lazily on first use
+			connToConn := func(c net.Conn) (net.Conn, error) {
+				raddr, err := netx.PeerAddr("dtls", c)
+				if err != nil {
+					return nil, err
+				}
+				dc, err := dtls.Client(dtlsnet.PacketConnFromConn(c), raddr, cfg)
+				if err != nil || handshakeTimeout == 0 {
+					return dc, err
+				}
+				return dc, netx.Handshake(c, handshakeTimeout, dc.HandshakeContext)
 			}
 			return netx.Wrapper{
 				Name:     "dtls",
 				Params:   params,
 				Listener: listener,
 				DialerToDialer: func(f netx.Dialer) (netx.Dialer, error) {
-					return netx.ConnWrapDialer(f, func(c net.Conn) (net.Conn, error) {
-						return dtls.Client(dtlsnet.PacketConnFromConn(c), c.RemoteAddr(), cfg)
-					})
+					return netx.ConnWrapDialer(f, connToConn)
 				},
-				ConnToConn: func(c net.Conn) (net.Conn, error) {
-					return dtls.Client(dtlsnet.PacketConnFromConn(c), c.RemoteAddr(), cfg)
-				}}, nil
+				ConnToConn: connToConn}, nil
 		}
-	})
+	},
+		netx.ListenerRules(netx.RequireParams("cert", "key"), netx.ForbidParams("handshaketimeout")),
+		netx.DialerRules(netx.ForbidParams("key"), netx.RequireOneOf("servername", "cert")),
+	)
 }
 
 func spkiVerifier(certPEM []byte) (func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error, error) {
@@ -0,0 +1,41 @@
+package dtls_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/pedramktb/go-netx"
+	_ "github.com/pedramktb/go-netx/drivers/dtls"
+)
+
+func TestDTLSRejectsSyntheticRemoteAddr(t *testing.T) {
+	cr, sr := net.Pipe()
+	t.Cleanup(func() { _ = cr.Close(); _ = sr.Close() })
+	ln, err := netx.NewDemux(sr, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		c, _ := netx.NewDemuxClient(cr, []byte("0001"))()
+		_, _ = c.Write([]byte("hello"))
+	}()
+	sess, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ws netx.ClientWrappers
+	if err := ws.UnmarshalText([]byte("dtls{servername=example.com}")); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ws.Wrappers[0].ConnToConn(sess)
+	var addrErr *netx.RemoteAddrError
+	if !errors.As(err, &addrErr) {
+		t.Fatalf("dtls over a demux session: got %v, want a RemoteAddrError", err)
+	}
+	if addrErr.Layer != "dtls" || addrErr.Addr == nil {
+		t.Fatalf("RemoteAddrError = %+v", addrErr)
+	}
+}
@@ -28,12 +28,6 @@ func init() {
 				return netx.Wrapper{}, fmt.Errorf("uri: unknown dtlspsk parameter %q", key)
 			}
 		}
-		if len(psk) == 0 {
-			return netx.Wrapper{}, fmt.Errorf("uri: missing dtlspsk key parameter")
-		}
-		if !listener && identity == "" {
-			return netx.Wrapper{}, fmt.Errorf("uri: dtlspsk client requires identity parameter")
-		}
 		cfg := &dtls.Config{
 			PSK: func(hint []byte) ([]byte, error) {
 				return psk, nil
@@ -51,21 +45,28 @@ func init() {
 					return dtls.NewListener(dtlsnet.PacketListenerFromListener(l), cfg)
 				},
 				ConnToConn: func(c net.Conn) (net.Conn, error) {
-					return dtls.Server(dtlsnet.PacketConnFromConn(c), c.RemoteAddr(), cfg)
+					raddr, err := netx.PeerAddr("dtlspsk", c)
+					if err != nil {
+						return nil, err
+					}
+					return dtls.Server(dtlsnet.PacketConnFromConn(c), raddr, cfg)
 				}}, nil
 		} else {
+			connToConn := func(c net.Conn) (net.Conn, error) {
+				raddr, err := netx.PeerAddr("dtlspsk", c)
+				if err != nil {
+					return nil, err
+				}
+				return dtls.Client(dtlsnet.PacketConnFromConn(c), raddr, cfg)
+			}
 			return netx.Wrapper{
 				Name:     "dtlspsk",
 				Params:   params,
 				Listener: listener,
 				DialerToDialer: func(f netx.Dialer) (netx.Dialer, error) {
-					return netx.ConnWrapDialer(f, func(c net.Conn) (net.Conn, error) {
-						return dtls.Client(dtlsnet.PacketConnFromConn(c), c.RemoteAddr(), cfg)
-					})
+					return netx.ConnWrapDialer(f, connToConn)
 				},
-				ConnToConn: func(c net.Conn) (net.Conn, error) {
-					return dtls.Client(dtlsnet.PacketConnFromConn(c), c.RemoteAddr(), cfg)
-				}}, nil
+				ConnToConn: connToConn}, nil
 		}
-	})
+	}, netx.RequireParams("key"), netx.DialerRules(netx.RequireParams("identity")))
 }
@@ -3,23 +3,68 @@ package ssh
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
+	"os"
+	"slices"
+	"sync"
 
 	"github.com/pedramktb/go-netx"
 	sshproto "github.com/pedramktb/go-netx/proto/ssh"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
+var (
+	promptsMu sync.RWMutex
+	prompts   = make(map[string]ssh.KeyboardInteractiveChallenge)
+)
+
+// RegisterPrompt makes prompt available by name to ssh clients with interactive=true, which select it with
+// prompt=<name>, e.g. after
+//
+//	ssh.RegisterPrompt("otp", func(_, _ string, questions []string, _ []bool) ([]string, error) { ... })
+//
+// the layer ssh{pub=...,interactive=true,prompt=otp} answers the keyboard-interactive challenges of servers
+// with it, e.g. by asking the user for a one-time password. Prompts are looked up when the layer is parsed,
+// so they must be registered before.
+func RegisterPrompt(name string, prompt ssh.KeyboardInteractiveChallenge) {
+	promptsMu.Lock()
+	defer promptsMu.Unlock()
+	if name == "" || prompt == nil {
+		panic("uri: RegisterPrompt name or prompt are empty")
+	}
+	if _, dup := prompts[name]; dup {
+		panic("uri: RegisterPrompt called twice for prompt " + name)
+	}
+	prompts[name] = prompt
+}
+
 func init() {
 	netx.Register("ssh", func(params map[string]string, listener bool) (netx.Wrapper, error) {
-		var pass string
+		var pass, otp, promptName string
+		var useAgent, interactive bool
 		var sshkey ssh.Signer // Host key for server, private key for client
 		var pubkey ssh.PublicKey
 		for key, value := range params {
 			switch key {
 			case "pass":
 				pass = value
+			case "otp":
+				otp = value
+			case "prompt":
+				promptName = value
+			case "agent":
+				if value != "true" && value != "false" {
+					return netx.Wrapper{}, fmt.Errorf("uri: invalid ssh agent parameter %q", value)
+				}
+				useAgent = value == "true"
+			case "interactive":
+				if value != "true" && value != "false" {
+					return netx.Wrapper{}, fmt.Errorf("uri: invalid ssh interactive parameter %q", value)
+				}
+				interactive = value == "true"
 			case "key":
 				pemkey, err := hex.DecodeString(value)
 				if err != nil {
@@ -42,11 +87,39 @@ func init() {
 				return netx.Wrapper{}, fmt.Errorf("uri: unknown ssh parameter %q", key)
 			}
 		}
+		// agent and interactive are booleans, so they are checked here rather than by the param rules, which
+		// take any value as set
 		if listener {
-			cfg := &ssh.ServerConfig{}
-			if sshkey == nil {
-				return netx.Wrapper{}, fmt.Errorf("uri: ssh server requires key parameter")
+			switch {
+			case useAgent:
+				return netx.Wrapper{}, fmt.Errorf("%w %q", netx.ErrUnsupportedParam, "agent")
+			case interactive:
+				return netx.Wrapper{}, fmt.Errorf("%w %q", netx.ErrUnsupportedParam, "interactive")
+			case otp != "":
+				return netx.Wrapper{}, fmt.Errorf("%w %q", netx.ErrUnsupportedParam, "otp")
+			}
+		} else {
+			if sshkey == nil && pass == "" && otp == "" && !useAgent && !interactive {
+				return netx.Wrapper{}, fmt.Errorf("%w: one of \"key\", \"pass\", \"agent=true\", \"otp\", \"interactive=true\"", netx.ErrMissingParam)
 			}
+			if otp != "" && interactive {
+				return netx.Wrapper{}, fmt.Errorf("%w \"otp\", \"interactive\"", netx.ErrConflictingParams)
+			}
+			if interactive != (promptName != "") {
+				return netx.Wrapper{}, errors.New("uri: ssh interactive=true and prompt must be given together")
+			}
+		}
+		var prompt ssh.KeyboardInteractiveChallenge
+		if interactive {
+			promptsMu.RLock()
+			prompt = prompts[promptName]
+			promptsMu.RUnlock()
+			if prompt == nil {
+				return netx.Wrapper{}, fmt.Errorf("uri: unknown ssh prompt %q", promptName)
+			}
+		}
+		if listener {
+			cfg := &ssh.ServerConfig{}
 			cfg.AddHostKey(sshkey)
 			if pubkey != nil {
 				cfg.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
@@ -64,9 +137,6 @@ func init() {
 					return nil, fmt.Errorf("uri: ssh password mismatch")
 				}
 			}
-			if cfg.PublicKeyCallback == nil && cfg.PasswordCallback == nil {
-				return netx.Wrapper{}, fmt.Errorf("uri: ssh server requires pubkey or pass parameter")
-			}
 			return netx.Wrapper{
 				Name:     "ssh",
 				Params:   params,
@@ -81,9 +151,6 @@ func init() {
 				}}, nil
 		} else {
 			cfg := &ssh.ClientConfig{}
-			if pubkey == nil {
-				return netx.Wrapper{}, fmt.Errorf("uri: ssh client requires pubkey parameter")
-			}
 			cfg.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
 				if bytes.Equal(key.Marshal(), pubkey.Marshal()) {
 					return nil
@@ -96,21 +163,66 @@ func init() {
 			if pass != "" {
 				cfg.Auth = append(cfg.Auth, ssh.Password(pass))
 			}
-			if len(cfg.Auth) == 0 {
-				return netx.Wrapper{}, fmt.Errorf("uri: ssh client requires key or pass parameter")
+			if otp != "" {
+				cfg.Auth = append(cfg.Auth, ssh.KeyboardInteractive(func(_, _ string, questions []string, _ []bool) ([]string, error) {
+					answers := make([]string, len(questions))
+					for i := range answers {
+						answers[i] = otp
+					}
+					return answers, nil
+				}))
+			} else if interactive {
+				cfg.Auth = append(cfg.Auth, ssh.KeyboardInteractive(prompt))
+			}
+			newClientConn := func(c net.Conn) (net.Conn, error) {
+				if !useAgent {
+					return sshproto.NewClientConn(c, cfg)
+				}
+				// the agent signs during the handshake, so it is connected for every handshake
+				ac, err := dialAgent()
+				if err != nil {
+					return nil, err
+				}
+				defer ac.Close()
+				signers, err := agent.NewClient(ac).Signers()
+				if err != nil {
+					return nil, fmt.Errorf("uri: ssh agent: %w", err)
+				}
+				// public keys are only tried once per handshake, so the agent's keys join the one of key
+				agentCfg := *cfg
+				agentCfg.Auth = slices.Clone(cfg.Auth)
+				if sshkey != nil {
+					agentCfg.Auth[0] = ssh.PublicKeys(append([]ssh.Signer{sshkey}, signers...)...)
+				} else {
+					agentCfg.Auth = slices.Insert(agentCfg.Auth, 0, ssh.PublicKeys(signers...))
+				}
+				return sshproto.NewClientConn(c, &agentCfg)
 			}
 			return netx.Wrapper{
 				Name:     "ssh",
 				Params:   params,
 				Listener: listener,
 				DialerToDialer: func(f netx.Dialer) (netx.Dialer, error) {
-					return netx.ConnWrapDialer(f, func(c net.Conn) (net.Conn, error) {
-						return sshproto.NewClientConn(c, cfg)
-					})
+					return netx.ConnWrapDialer(f, newClientConn)
 				},
-				ConnToConn: func(c net.Conn) (net.Conn, error) {
-					return sshproto.NewClientConn(c, cfg)
-				}}, nil
+				ConnToConn: newClientConn,
+			}, nil
 		}
-	})
+	},
+		netx.ListenerRules(netx.RequireParams("key"), netx.RequireOneOf("pub", "pass"), netx.ForbidParams("prompt")),
+		netx.DialerRules(netx.RequireParams("pub")),
+	)
+}
+
+// dialAgent connects to the SSH agent listening on SSH_AUTH_SOCK.
+func dialAgent() (net.Conn, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, errors.New("uri: ssh agent auth requires SSH_AUTH_SOCK to be set")
+	}
+	c, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("uri: ssh agent: %w", err)
+	}
+	return c, nil
 }
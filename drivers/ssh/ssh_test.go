@@ -0,0 +1,201 @@
+package ssh_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pedramktb/go-netx"
+	netxssh "github.com/pedramktb/go-netx/drivers/ssh"
+	sshproto "github.com/pedramktb/go-netx/proto/ssh"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func init() {
+	// prompts are registered once per process, so tests running more than once share it
+	netxssh.RegisterPrompt("TestSSHKeyboardInteractive", func(_, _ string, questions []string, _ []bool) ([]string, error) {
+		if len(questions) != 1 || !strings.Contains(questions[0], "password") {
+			return nil, errors.New("unexpected questions")
+		}
+		return []string{"123456"}, nil
+	})
+}
+
+// newKey returns a fresh key, hex-encoded as PEM and as an authorized key, as taken by the ssh driver.
+func newKey(t *testing.T) (priv ed25519.PrivateKey, signer ssh.Signer, pemHex, pubHex string) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer, err = ssh.NewSignerFromKey(priv); err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, signer, hex.EncodeToString(pem.EncodeToMemory(block)), hex.EncodeToString(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+}
+
+// connect runs an in-process server with cfg against a client layer over a TCP loopback conn.
+func connect(t *testing.T, cfg *ssh.ServerConfig, client string) error {
+	t.Helper()
+	var cw netx.ClientWrappers
+	if err := cw.UnmarshalText([]byte(client)); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		sc, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer sc.Close()
+		if c, err := sshproto.NewServerConn(sc, cfg); err == nil {
+			_ = c.Close()
+		}
+	}()
+	cc, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	c, err := cw.Wrappers[0].ConnToConn(cc)
+	if err != nil {
+		return err
+	}
+	_ = c.Close()
+	return nil
+}
+
+// otpServer returns a server config that accepts the keyboard-interactive answer code, and public keys only if
+// they match pub.
+func otpServer(host ssh.Signer, code string, pub ssh.PublicKey) *ssh.ServerConfig {
+	cfg := &ssh.ServerConfig{
+		KeyboardInteractiveCallback: func(_ ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := challenge("", "", []string{"One-time password: "}, []bool{false})
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) != 1 || answers[0] != code {
+				return nil, errors.New("wrong one-time password")
+			}
+			return nil, nil
+		},
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if pub != nil && string(key.Marshal()) == string(pub.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	cfg.AddHostKey(host)
+	return cfg
+}
+
+func TestSSHKeyboardInteractive(t *testing.T) {
+	_, host, _, hostPub := newKey(t)
+	_, _, otherKey, _ := newKey(t)
+	cfg := otpServer(host, "123456", nil)
+
+	if err := connect(t, cfg, "ssh{pub="+hostPub+",otp=123456}"); err != nil {
+		t.Fatalf("otp: %v", err)
+	}
+	if err := connect(t, cfg, "ssh{pub="+hostPub+",otp=654321}"); err == nil {
+		t.Fatal("expected a wrong one-time password to fail")
+	}
+	// a key the server does not know falls through to keyboard-interactive
+	if err := connect(t, cfg, "ssh{pub="+hostPub+",key="+otherKey+",otp=123456}"); err != nil {
+		t.Fatalf("key and otp: %v", err)
+	}
+
+	if err := connect(t, cfg, "ssh{pub="+hostPub+",interactive=true,prompt=TestSSHKeyboardInteractive}"); err != nil {
+		t.Fatalf("prompt: %v", err)
+	}
+	var w netx.Wrapper
+	if err := w.UnmarshalText([]byte("ssh{pub="+hostPub+",interactive=true,prompt=TestSSHKeyboardInteractive-missing}"), false); err == nil {
+		t.Fatal("expected an unregistered prompt to be rejected")
+	}
+}
+
+func TestSSHAuthParams(t *testing.T) {
+	_, _, hostKey, hostPub := newKey(t)
+	for _, tc := range []struct {
+		layer    string
+		listener bool
+		want     error
+	}{
+		// false booleans ask for the default, so they neither count as an auth method nor conflict
+		{"ssh{pub=" + hostPub + ",agent=false}", false, netx.ErrMissingParam},
+		{"ssh{pub=" + hostPub + ",interactive=false}", false, netx.ErrMissingParam},
+		{"ssh{pub=" + hostPub + ",otp=123456,interactive=false}", false, nil},
+		{"ssh{pub=" + hostPub + ",otp=123456,agent=false}", false, nil},
+		{"ssh{pub=" + hostPub + ",otp=123456,interactive=true}", false, netx.ErrConflictingParams},
+		{"ssh{key=" + hostKey + ",pass=secret,agent=false,interactive=false}", true, nil},
+		{"ssh{key=" + hostKey + ",pass=secret,agent=true}", true, netx.ErrUnsupportedParam},
+		{"ssh{key=" + hostKey + ",pass=secret,interactive=true}", true, netx.ErrUnsupportedParam},
+		{"ssh{key=" + hostKey + ",pass=secret,otp=123456}", true, netx.ErrUnsupportedParam},
+	} {
+		var w netx.Wrapper
+		err := w.UnmarshalText([]byte(tc.layer), tc.listener)
+		if tc.want == nil && err != nil || tc.want != nil && !errors.Is(err, tc.want) {
+			t.Errorf("%s (listener %v): got %v, want %v", tc.layer, tc.listener, err, tc.want)
+		}
+	}
+}
+
+func TestSSHAgent(t *testing.T) {
+	_, host, _, hostPub := newKey(t)
+	priv, signer, _, _ := newKey(t)
+	_, _, otherKey, _ := newKey(t)
+
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				_ = agent.ServeAgent(keyring, c)
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+
+	cfg := otpServer(host, "123456", signer.PublicKey())
+	if err := connect(t, cfg, "ssh{pub="+hostPub+",agent=true}"); err != nil {
+		t.Fatalf("agent: %v", err)
+	}
+	// the agent's key is offered along with one given by key
+	if err := connect(t, cfg, "ssh{pub="+hostPub+",key="+otherKey+",agent=true}"); err != nil {
+		t.Fatalf("key and agent: %v", err)
+	}
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if err := connect(t, cfg, "ssh{pub="+hostPub+",agent=true}"); err == nil || !strings.Contains(err.Error(), "SSH_AUTH_SOCK") {
+		t.Fatalf("got %v, want an error about SSH_AUTH_SOCK", err)
+	}
+}
@@ -9,6 +9,9 @@ import (
 	"encoding/pem"
 	"fmt"
 	"net"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/pedramktb/go-netx"
 )
@@ -16,6 +19,7 @@ import (
 func init() {
 	netx.Register("tls", func(params map[string]string, listener bool) (netx.Wrapper, error) {
 		var certKey, cert []byte
+		var handshakeTimeout time.Duration
 		cfg := &tls.Config{
 			MinVersion: tls.VersionTLS13,
 			MaxVersion: tls.VersionTLS13,
@@ -36,14 +40,42 @@ func init() {
 				}
 			case "servername":
 				cfg.ServerName = value
+			case "handshaketimeout":
+				var err error
+				handshakeTimeout, err = time.ParseDuration(value)
+				if err != nil {
+					return netx.Wrapper{}, fmt.Errorf("uri: invalid tls handshaketimeout parameter %q: %w", value, err)
+				}
+			case "minversion":
+				var err error
+				cfg.MinVersion, err = parseVersion(value)
+				if err != nil {
+					return netx.Wrapper{}, fmt.Errorf("uri: invalid tls minversion parameter: %w", err)
+				}
+			case "maxversion":
+				var err error
+				cfg.MaxVersion, err = parseVersion(value)
+				if err != nil {
+					return netx.Wrapper{}, fmt.Errorf("uri: invalid tls maxversion parameter: %w", err)
+				}
+			case "ciphers":
+				var err error
+				cfg.CipherSuites, err = parseCiphers(value)
+				if err != nil {
+					return netx.Wrapper{}, fmt.Errorf("uri: invalid tls ciphers parameter: %w", err)
+				}
 			default:
 				return netx.Wrapper{}, fmt.Errorf("uri: unknown tls parameter %q", key)
 			}
 		}
+		if cfg.MinVersion > cfg.MaxVersion {
+			return netx.Wrapper{}, fmt.Errorf("uri: tls minversion %s is above maxversion %s",
+				tls.VersionName(cfg.MinVersion), tls.VersionName(cfg.MaxVersion))
+		}
+		if cfg.CipherSuites != nil && cfg.MinVersion > tls.VersionTLS12 {
+			return netx.Wrapper{}, fmt.Errorf("uri: tls ciphers only apply to TLS 1.2, set minversion=1.2")
+		}
 		if listener {
-			if cert == nil || certKey == nil {
-				return netx.Wrapper{}, fmt.Errorf("uri: tls server requires cert and key parameters")
-			}
 			certificate, err := tls.X509KeyPair(cert, certKey)
 			if err != nil {
 				return netx.Wrapper{}, fmt.Errorf("uri: invalid tls certificate: %w", err)
@@ -60,9 +92,6 @@ func init() {
 					return tls.Server(c, cfg), nil
 				}}, nil
 		} else {
-			if certKey != nil {
-				return netx.Wrapper{}, fmt.Errorf("uri: tls client does not support key parameter")
-			}
 			if cert != nil {
 				var err error
 				cfg.InsecureSkipVerify = true
@@ -71,23 +100,62 @@ func init() {
 					return netx.Wrapper{}, fmt.Errorf("uri: invalid tls cert parameter: %w", err)
 				}
 			}
-			if cfg.ServerName == "" && cert == nil {
-				return netx.Wrapper{}, fmt.Errorf("uri: tls client requires servername or cert parameter")
+			// without a timeout, the handshake runs lazily on first use
+			connToConn := func(c net.Conn) (net.Conn, error) {
+				tc := tls.Client(c, cfg)
+				if handshakeTimeout == 0 {
+					return tc, nil
+				}
+				return tc, netx.Handshake(c, handshakeTimeout, tc.HandshakeContext)
 			}
 			return netx.Wrapper{
 				Name:     "tls",
 				Params:   params,
 				Listener: listener,
 				DialerToDialer: func(f netx.Dialer) (netx.Dialer, error) {
-					return netx.ConnWrapDialer(f, func(c net.Conn) (net.Conn, error) {
-						return tls.Client(c, cfg), nil
-					})
+					return netx.ConnWrapDialer(f, connToConn)
 				},
-				ConnToConn: func(c net.Conn) (net.Conn, error) {
-					return tls.Client(c, cfg), nil
-				}}, nil
+				ConnToConn: connToConn}, nil
+		}
+	},
+		netx.ListenerRules(netx.RequireParams("cert", "key"), netx.ForbidParams("handshaketimeout")),
+		netx.DialerRules(netx.ForbidParams("key"), netx.RequireOneOf("servername", "cert")),
+	)
+}
+
+// parseVersion parses a TLS version of the form 1.2, rejecting versions below the TLS 1.2 floor.
+func parseVersion(value string) (uint16, error) {
+	switch value {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	case "1.0", "1.1":
+		return 0, fmt.Errorf("TLS %s is below the minimum of TLS 1.2", value)
+	default:
+		return 0, fmt.Errorf("unknown TLS version %q", value)
+	}
+}
+
+// parseCiphers parses a |-separated list of TLS 1.2 cipher suite names, as returned by tls.CipherSuiteName.
+// Insecure suites are rejected. TLS 1.3 suites are not configurable and are always enabled for TLS 1.3.
+func parseCiphers(value string) ([]uint16, error) {
+	var ids []uint16
+	for name := range strings.SplitSeq(value, "|") {
+		i := slices.IndexFunc(tls.CipherSuites(), func(s *tls.CipherSuite) bool { return s.Name == name })
+		if i < 0 {
+			if slices.ContainsFunc(tls.InsecureCipherSuites(), func(s *tls.CipherSuite) bool { return s.Name == name }) {
+				return nil, fmt.Errorf("insecure cipher suite %s", name)
+			}
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		suite := tls.CipherSuites()[i]
+		if !slices.Contains(suite.SupportedVersions, tls.VersionTLS12) {
+			return nil, fmt.Errorf("cipher suite %s is not configurable, TLS 1.3 suites are always enabled", name)
 		}
-	})
+		ids = append(ids, suite.ID)
+	}
+	return ids, nil
 }
 
 func spkiVerifier(certPEM []byte) (func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error, error) {
@@ -0,0 +1,129 @@
+package tls_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedramktb/go-netx"
+	_ "github.com/pedramktb/go-netx/drivers/tls"
+)
+
+// selfSigned returns a hex-encoded PEM certificate and key, as taken by the tls driver.
+func selfSigned(t *testing.T) (cert, key string) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		hex.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+// handshake runs the handshake of a server and a client layer over a TCP loopback conn.
+func handshake(t *testing.T, server, client string) (serverErr, clientErr error) {
+	t.Helper()
+	var sw netx.ServerWrappers
+	if err := sw.UnmarshalText([]byte(server)); err != nil {
+		t.Fatal(err)
+	}
+	var cw netx.ClientWrappers
+	if err := cw.UnmarshalText([]byte(client)); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	done := make(chan error, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer c.Close()
+		sc, err := sw.Wrappers[0].ConnToConn(c)
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- sc.(*tls.Conn).Handshake()
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	cc, err := cw.Wrappers[0].ConnToConn(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientErr = cc.(*tls.Conn).Handshake()
+	_ = c.Close()
+	return <-done, clientErr
+}
+
+func TestTLSVersionPolicy(t *testing.T) {
+	cert, key := selfSigned(t)
+	server := "tls{cert=" + cert + ",key=" + key + "}"
+
+	serverErr, clientErr := handshake(t, server, "tls{cert="+cert+",minversion=1.2,maxversion=1.2}")
+	if serverErr == nil || clientErr == nil {
+		t.Fatalf("TLS 1.2 client against the default TLS 1.3 server: server %v, client %v, want both to fail", serverErr, clientErr)
+	}
+
+	server = "tls{cert=" + cert + ",key=" + key + ",minversion=1.2,ciphers=TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}"
+	serverErr, clientErr = handshake(t, server, "tls{cert="+cert+",minversion=1.2,maxversion=1.2}")
+	if serverErr != nil || clientErr != nil {
+		t.Fatalf("TLS 1.2 with an allowed cipher: server %v, client %v", serverErr, clientErr)
+	}
+}
+
+func TestTLSPolicyParams(t *testing.T) {
+	cert, _ := selfSigned(t)
+	for _, tc := range []struct {
+		params string
+		want   string
+	}{
+		{"minversion=1.1", "below the minimum"},
+		{"maxversion=1.2", "above maxversion"},
+		{"minversion=1.4", "unknown TLS version"},
+		{"ciphers=TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "only apply to TLS 1.2"},
+		{"minversion=1.2,ciphers=TLS_RSA_WITH_RC4_128_SHA", "insecure cipher suite"},
+		{"minversion=1.2,ciphers=TLS_AES_128_GCM_SHA256", "not configurable"},
+		{"minversion=1.2,ciphers=TLS_NOPE", "unknown cipher suite"},
+	} {
+		var cw netx.ClientWrappers
+		err := cw.UnmarshalText([]byte("tls{cert=" + cert + "," + tc.params + "}"))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.params, err, tc.want)
+		}
+	}
+}
@@ -29,12 +29,6 @@ func init() {
 				return netx.Wrapper{}, fmt.Errorf("uri: unknown tlspsk parameter %q", key)
 			}
 		}
-		if len(psk) == 0 {
-			return netx.Wrapper{}, fmt.Errorf("uri: missing tlspsk key parameter")
-		}
-		if !listener && identity == "" {
-			return netx.Wrapper{}, fmt.Errorf("uri: tlspsk client requires identity parameter")
-		}
 		cfg := &tlswithpks.Config{
 			MinVersion: tls.VersionTLS12,
 			MaxVersion: tls.VersionTLS12,
@@ -74,7 +68,7 @@ func init() {
 					return tlswithpks.Client(c, cfg), nil
 				}}, nil
 		}
-	})
+	}, netx.RequireParams("key"), netx.DialerRules(netx.RequireParams("identity")))
 }
 
 // dummyCert returns a self-signed certificate for use in tls-psk server mode. (ed25519)
@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/pedramktb/go-netx"
 	utls "github.com/refraction-networking/utls"
@@ -22,6 +23,7 @@ func init() {
 			return netx.Wrapper{}, errors.New("uri: utls is exclusive to clients, use tls for servers instead")
 		}
 		var cert []byte
+		var handshakeTimeout time.Duration
 		cfg := &utls.Config{
 			MinVersion: tls.VersionTLS13,
 			MaxVersion: tls.VersionTLS13,
@@ -37,6 +39,12 @@ func init() {
 				}
 			case "servername":
 				cfg.ServerName = value
+			case "handshaketimeout":
+				var err error
+				handshakeTimeout, err = time.ParseDuration(value)
+				if err != nil {
+					return netx.Wrapper{}, fmt.Errorf("uri: invalid utls handshaketimeout parameter %q: %w", value, err)
+				}
 			case "hello":
 				switch strings.ToLower(value) {
 				case "chrome":
@@ -70,24 +78,19 @@ func init() {
 				return netx.Wrapper{}, fmt.Errorf("uri: invalid utls cert parameter: %w", err)
 			}
 		}
-		if cfg.ServerName == "" && cert == nil {
-			return netx.Wrapper{}, fmt.Errorf("uri: utls client requires servername or cert parameter")
+		connToConn := func(c net.Conn) (net.Conn, error) {
+			uc := utls.UClient(c, cfg, id)
+			return uc, netx.Handshake(c, handshakeTimeout, uc.HandshakeContext)
 		}
 		return netx.Wrapper{
 			Name:     "utls",
 			Params:   params,
 			Listener: listener,
 			DialerToDialer: func(f netx.Dialer) (netx.Dialer, error) {
-				return netx.ConnWrapDialer(f, func(c net.Conn) (net.Conn, error) {
-					uc := utls.UClient(c, cfg, id)
-					return uc, uc.Handshake()
-				})
+				return netx.ConnWrapDialer(f, connToConn)
 			},
-			ConnToConn: func(c net.Conn) (net.Conn, error) {
-				uc := utls.UClient(c, cfg, id)
-				return uc, uc.Handshake()
-			}}, nil
-	})
+			ConnToConn: connToConn}, nil
+	}, netx.DialerRules(netx.RequireOneOf("servername", "cert")))
 }
 
 func spkiVerifier(certPEM []byte) (func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error, error) {
@@ -1,8 +1,16 @@
 /*
 FrameConn is a network layer that adds a length-prefixed framing protocol inside a stream-oriented
 connection (like TCP). This allows wrapping packet-based connections inside stream ones (e.g.
-UDP over TCP+TLS), preserving message boundaries. Each frame consists of a 4-byte big-endian
-length header followed by the payload.
+UDP over TCP+TLS), preserving message boundaries. Each frame consists of a big-endian length
+header followed by the payload. The header is 2 bytes by default, the width FrameConn has always used on
+the wire, so peers that predate WithFrameHeaderWidth keep working; WithFrameHeaderWidth sets it to 1, 2, 4
+or 8 bytes, e.g. 1 byte for tight transports with small packets:
+
+	tcp+frame{headerwidth=1}://example.com:9000
+
+Both ends must use the same width. Frames are at most MaxPacketSize bytes, or 255 with a 1-byte
+header, and larger writes fail. Widths 4 and 8 do not allow larger frames; they only exist for wire
+compatibility with peers using such headers.
 
 Since FrameConn performs two writes per frame (one for the header and one for the payload),
 it is highly recommended to wrap the underlying connection in a BufferedConn. This coalesces
@@ -16,16 +24,27 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"sync"
 )
 
 func init() {
 	Register("frame", func(params map[string]string, listener bool) (Wrapper, error) {
-		for key := range params {
-			return Wrapper{}, fmt.Errorf("uri: unknown frame parameter %q", key)
+		opts := []FrameConnOption{}
+		for key, value := range params {
+			switch key {
+			case "headerwidth":
+				width, err := strconv.ParseUint(value, 10, 8)
+				if err != nil || !validFrameHeaderWidth(uint8(width)) {
+					return Wrapper{}, fmt.Errorf("uri: invalid frame headerwidth parameter %q, must be 1, 2, 4 or 8", value)
+				}
+				opts = append(opts, WithFrameHeaderWidth(uint8(width)))
+			default:
+				return Wrapper{}, fmt.Errorf("uri: unknown frame parameter %q", key)
+			}
 		}
 		connToConn := func(c net.Conn) (net.Conn, error) {
-			return NewFrameConn(c), nil
+			return NewFrameConn(c, opts...), nil
 		}
 		return Wrapper{
 			Name:   "frame",
@@ -41,20 +60,66 @@ func init() {
 	})
 }
 
+type FrameConnOption func(*frameConn)
+
+// WithFrameHeaderWidth sets the width of the length header in bytes, which must be 1, 2, 4 or 8 and
+// the same on both ends. It panics on other widths. A width of 1 limits frames to 255 bytes, while
+// widths 4 and 8 still cap them at MaxPacketSize, so they only cost bytes and exist for wire
+// compatibility with peers using such headers.
+// Default is 2, the width of the existing wire format, not 4.
+func WithFrameHeaderWidth(width uint8) FrameConnOption {
+	if !validFrameHeaderWidth(width) {
+		panic("netx: invalid frame header width " + strconv.Itoa(int(width)))
+	}
+	return func(c *frameConn) {
+		c.width = int(width)
+	}
+}
+
+func validFrameHeaderWidth(width uint8) bool {
+	return width == 1 || width == 2 || width == 4 || width == 8
+}
+
 type frameConn struct {
 	net.Conn
+	width    int // of the length header in bytes
+	maxFrame int
 	pending  []byte
 	buf      []byte
 	rmu, wmu sync.Mutex
 }
 
-// NewFrameConn wraps a net.Conn with a simple length-prefixed framing protocol.
-// Each frame is prefixed with a 4-byte big-endian unsigned integer indicating the length of the frame.
-func NewFrameConn(c net.Conn) net.Conn {
-	return &frameConn{
-		Conn: c,
-		buf:  make([]byte, MaxPacketSize),
+// NewFrameConn wraps a net.Conn with a simple length-prefixed framing protocol, see FrameConn.
+// Each frame is prefixed with a big-endian unsigned integer indicating the length of the frame.
+func NewFrameConn(c net.Conn, opts ...FrameConnOption) net.Conn {
+	fc := &frameConn{
+		Conn:  c,
+		width: 2,
+		buf:   make([]byte, MaxPacketSize),
+	}
+	for _, o := range opts {
+		o(fc)
+	}
+	fc.maxFrame = MaxPacketSize
+	if fc.width == 1 {
+		fc.maxFrame = 0xff
+	}
+	return fc
+}
+
+// MaxWrite forwards the underlying connection's MaxWrite limit, if any, capped by the largest frame.
+// The header and the payload are written separately, so the payload limit is the same as the underlying one.
+func (c *frameConn) MaxWrite() uint16 {
+	limit := 0
+	if c.maxFrame < MaxPacketSize {
+		limit = c.maxFrame
 	}
+	if mw, ok := c.Conn.(interface{ MaxWrite() uint16 }); ok && mw.MaxWrite() > 0 {
+		if limit == 0 || int(mw.MaxWrite()) < limit {
+			limit = int(mw.MaxWrite())
+		}
+	}
+	return uint16(limit)
 }
 
 // Read returns at most one frame's bytes; large frames are delivered across multiple Reads.
@@ -68,11 +133,15 @@ func (c *frameConn) Read(p []byte) (int, error) {
 		return n, nil
 	}
 
-	var hdr [2]byte
-	if _, err := io.ReadFull(c.Conn, hdr[:]); err != nil {
+	var hdr [8]byte
+	if _, err := io.ReadFull(c.Conn, hdr[8-c.width:]); err != nil {
 		return 0, err
 	}
-	n := int(binary.BigEndian.Uint16(hdr[:]))
+	size := binary.BigEndian.Uint64(hdr[:])
+	if size > uint64(c.maxFrame) {
+		return 0, fmt.Errorf("frame: frame of %d bytes exceeds the maximum of %d", size, c.maxFrame)
+	}
+	n := int(size)
 	if len(p) >= n {
 		_, err := io.ReadFull(c.Conn, p[:n])
 		return n, err
@@ -91,17 +160,19 @@ func (c *frameConn) Write(p []byte) (int, error) {
 	c.wmu.Lock()
 	defer c.wmu.Unlock()
 
-	var hdr [2]byte
-	binary.BigEndian.PutUint16(hdr[:], uint16(len(p)))
-	if _, err := c.Conn.Write(hdr[:]); err != nil {
-		return 0, err
-	}
-	if len(p) == 0 {
-		return 0, nil
+	if len(p) > c.maxFrame {
+		return 0, fmt.Errorf("frame: write of %d bytes exceeds the maximum frame of %d", len(p), c.maxFrame)
 	}
-	if _, err := c.Conn.Write(p); err != nil {
+	var hdr [8]byte
+	binary.BigEndian.PutUint64(hdr[:], uint64(len(p)))
+	if _, err := c.Conn.Write(hdr[8-c.width:]); err != nil {
 		return 0, err
 	}
+	if len(p) > 0 {
+		if _, err := c.Conn.Write(p); err != nil {
+			return 0, err
+		}
+	}
 	// If the underlying layer is buffered and implements Flush, flush now to coalesce header+payload.
 	if fw, ok := c.Conn.(BufConn); ok {
 		if err := fw.Flush(); err != nil {
@@ -3,8 +3,10 @@ package netx_test
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"net"
+	"strings"
 	"testing"
 	"time"
 
@@ -142,3 +144,108 @@ func TestFrameConnDeliversEmptyFrames(t *testing.T) {
 		t.Fatalf("writer blocked")
 	}
 }
+
+func TestFrameConnHeaderWidths(t *testing.T) {
+	for _, width := range []uint8{1, 2, 4, 8} {
+		t.Run(fmt.Sprint(width), func(t *testing.T) {
+			clientRaw, serverRaw := net.Pipe()
+			t.Cleanup(func() { _ = clientRaw.Close(); _ = serverRaw.Close() })
+			fcClient := netx.NewFrameConn(clientRaw, netx.WithFrameHeaderWidth(width))
+
+			maxFrame := netx.MaxPacketSize
+			if width == 1 {
+				maxFrame = 0xff
+			}
+			frames := [][]byte{nil, []byte("data"), {}, bytes.Repeat([]byte("x"), maxFrame)}
+			done := make(chan error, 1)
+			go func() {
+				for _, f := range frames {
+					if _, err := fcClient.Write(f); err != nil {
+						done <- err
+						return
+					}
+				}
+				done <- nil
+			}()
+
+			// the raw side sees headers of the chosen width
+			for _, f := range frames {
+				hdr := make([]byte, 8)
+				if _, err := io.ReadFull(serverRaw, hdr[8-width:]); err != nil {
+					t.Fatalf("read hdr: %v", err)
+				}
+				if n := binary.BigEndian.Uint64(hdr); n != uint64(len(f)) {
+					t.Fatalf("header says %d bytes, want %d", n, len(f))
+				}
+				got := make([]byte, len(f))
+				if _, err := io.ReadFull(serverRaw, got); err != nil {
+					t.Fatalf("read body: %v", err)
+				}
+				if !bytes.Equal(got, f) {
+					t.Fatalf("body mismatch")
+				}
+			}
+			if err := <-done; err != nil {
+				t.Fatalf("write: %v", err)
+			}
+
+			// and a framed reader of the same width gets the frames back, empty ones included
+			fcServer := netx.NewFrameConn(serverRaw, netx.WithFrameHeaderWidth(width))
+			go func() {
+				for _, f := range frames {
+					_, _ = fcClient.Write(f)
+				}
+			}()
+			buf := make([]byte, netx.MaxPacketSize)
+			for _, f := range frames {
+				n, err := fcServer.Read(buf)
+				if err != nil || !bytes.Equal(buf[:n], f) {
+					t.Fatalf("read n=%d err=%v, want %d bytes", n, err, len(f))
+				}
+			}
+
+			if _, err := fcClient.Write(make([]byte, maxFrame+1)); err == nil {
+				t.Fatalf("want an error for a frame of %d bytes", maxFrame+1)
+			}
+		})
+	}
+}
+
+// The default header width is the 2 bytes FrameConn has always used, so older peers keep working.
+func TestFrameConnDefaultHeaderWidth(t *testing.T) {
+	clientRaw, serverRaw := net.Pipe()
+	t.Cleanup(func() { _ = clientRaw.Close(); _ = serverRaw.Close() })
+	go func() { _, _ = netx.NewFrameConn(clientRaw).Write([]byte("data")) }()
+	got := make([]byte, 6)
+	if _, err := io.ReadFull(serverRaw, got); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if want := []byte("\x00\x04data"); !bytes.Equal(got, want) {
+		t.Fatalf("wire = %q, want %q", got, want)
+	}
+}
+
+func TestFrameConnRejectsInvalidHeaderWidth(t *testing.T) {
+	var w netx.Wrapper
+	err := w.UnmarshalText([]byte("frame{headerwidth=3}"), false)
+	if err == nil || !strings.Contains(err.Error(), "uri: invalid frame headerwidth parameter") {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestFrameConnHeaderWidthLimitsMaxWrite(t *testing.T) {
+	clientRaw, serverRaw := net.Pipe()
+	t.Cleanup(func() { _ = clientRaw.Close(); _ = serverRaw.Close() })
+	fc := netx.NewFrameConn(clientRaw, netx.WithFrameHeaderWidth(1))
+	if mw := fc.(interface{ MaxWrite() uint16 }).MaxWrite(); mw != 0xff {
+		t.Fatalf("MaxWrite = %d, want 255", mw)
+	}
+
+	var w netx.Wrapper
+	if err := w.UnmarshalText([]byte("frame{headerwidth=3}"), false); err == nil {
+		t.Fatal("want an error for headerwidth=3")
+	}
+	if err := w.UnmarshalText([]byte("frame{headerwidth=4}"), false); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+}
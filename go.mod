@@ -7,4 +7,7 @@ require (
 	golang.org/x/net v0.52.0
 )
 
-require golang.org/x/sys v0.42.0 // indirect
+require (
+	golang.org/x/sys v0.42.0 // indirect
+	golang.org/x/text v0.35.0 // indirect
+)
@@ -0,0 +1,309 @@
+/*
+H2Conn is a network layer that carries a connection in a single HTTP/2 stream, so a tunnel looks like a long-lived
+upload to a web server. The client sends a POST request whose streaming body carries its writes, and the server
+answers with a 200 response whose streaming body carries the reverse direction. HTTP/2 is spoken with prior
+knowledge, without ALPN, so it composes with a TLS layer below it, e.g.:
+
+	tcp+tls{servername=example.com}+h2{path=/upload}://example.com:443
+
+Only the first request for the path is taken as the connection; other requests get a 404 response.
+
+Both directions are subject to HTTP/2 flow control: a peer that stops reading stops the other side's writes once
+the window of the stream is used up, so writes may block where the conn below would still buffer. Intermediaries like reverse proxies and CDNs may buffer request bodies or responses instead of
+streaming them, which only works with those that support full-duplex HTTP/2 streams.
+*/
+
+package netx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func init() {
+	Register("h2", func(params map[string]string, listener bool) (Wrapper, error) {
+		opts := []H2ConnOption{}
+		for key, value := range params {
+			switch key {
+			case "path":
+				if len(value) == 0 || value[0] != '/' {
+					return Wrapper{}, fmt.Errorf("h2: invalid path parameter %q, must start with /", value)
+				}
+				opts = append(opts, WithH2Path(value))
+			case "host":
+				opts = append(opts, WithH2Host(value))
+			default:
+				return Wrapper{}, fmt.Errorf("uri: unknown h2 parameter %q", key)
+			}
+		}
+		connToConn := func(c net.Conn) (net.Conn, error) {
+			if listener {
+				return NewH2ServerConn(c, opts...)
+			}
+			return NewH2ClientConn(c, opts...)
+		}
+		return Wrapper{
+			Name:     "h2",
+			Params:   params,
+			Listener: listener,
+			ListenerToListener: func(l net.Listener) (net.Listener, error) {
+				return ConnWrapListener(l, connToConn)
+			},
+			DialerToDialer: func(f Dialer) (Dialer, error) {
+				return ConnWrapDialer(f, connToConn)
+			},
+			ConnToConn: connToConn,
+		}, nil
+	})
+}
+
+// h2Timeout bounds how long the server waits for the request and the client for the response headers.
+const h2Timeout = 10 * time.Second
+
+type H2ConnOption func(*h2Config)
+
+type h2Config struct {
+	path string
+	host string
+}
+
+// WithH2Path sets the path of the request. Default is "/".
+func WithH2Path(path string) H2ConnOption {
+	return func(c *h2Config) {
+		c.path = path
+	}
+}
+
+// WithH2Host sets the host the client sends as the request authority. Servers with a host only accept
+// requests for it. Default is the remote address of the conn for clients and any host for servers.
+func WithH2Host(host string) H2ConnOption {
+	return func(c *h2Config) {
+		c.host = host
+	}
+}
+
+func newH2Config(opts []H2ConnOption) h2Config {
+	cfg := h2Config{path: "/"}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	return cfg
+}
+
+// h2Conn is one end of the HTTP/2 stream carrying the connection.
+type h2Conn struct {
+	conn net.Conn // the conn HTTP/2 runs on
+	body io.ReadCloser
+	wmu  sync.Mutex
+	w    io.Writer
+	// closeWrite ends the written direction, nil if it cannot be ended on its own
+	closeWrite func() error
+	closeOnce  sync.Once
+	onClose    func()
+}
+
+// NewH2ClientConn speaks HTTP/2 over conn and returns a conn over the bodies of a POST request and its
+// response, see H2Conn. It returns once the server answered, within 10 seconds.
+func NewH2ClientConn(conn net.Conn, opts ...H2ConnOption) (net.Conn, error) {
+	cfg := newH2Config(opts)
+	if cfg.host == "" {
+		cfg.host = conn.RemoteAddr().String()
+	}
+	if err := h2Handshake(conn); err != nil {
+		return nil, err
+	}
+	cc, err := (&http2.Transport{}).NewClientConn(conn)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	// the request lives as long as the conn, so only the wait for the response is bounded
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+cfg.host+cfg.path, pr)
+	if err != nil {
+		cancel()
+		_ = cc.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	timer := time.AfterFunc(h2Timeout, cancel)
+	resp, err := cc.RoundTrip(req)
+	if !timer.Stop() && err == nil {
+		_ = resp.Body.Close()
+		err = context.DeadlineExceeded
+	}
+	if err != nil {
+		cancel()
+		_ = cc.Close()
+		return nil, fmt.Errorf("h2: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		cancel()
+		_ = resp.Body.Close()
+		_ = cc.Close()
+		return nil, fmt.Errorf("h2: server answered %s", resp.Status)
+	}
+	return &h2Conn{
+		conn:       conn,
+		body:       resp.Body,
+		w:          pw,
+		closeWrite: pw.Close,
+		onClose: func() {
+			cancel()
+			_ = pw.Close()
+			_ = cc.Close()
+		},
+	}, nil
+}
+
+// NewH2ServerConn serves HTTP/2 over conn and returns a conn over the body of the first POST request for the
+// path and that of its response, see H2Conn. It fails if no such request arrives within 10 seconds.
+func NewH2ServerConn(conn net.Conn, opts ...H2ConnOption) (net.Conn, error) {
+	cfg := newH2Config(opts)
+	if err := h2Handshake(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	streams := make(chan *h2Conn, 1)
+	done := make(chan struct{})
+	var taken sync.Once
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != cfg.path || cfg.host != "" && r.Host != cfg.host {
+			http.NotFound(w, r)
+			return
+		}
+		first := false
+		taken.Do(func() { first = true })
+		if !first {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		rc := http.NewResponseController(w)
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		// the stream ends when the handler returns, so it waits for the conn to be closed
+		finished := make(chan struct{})
+		fw := &flushWriter{w: w, rc: rc}
+		streams <- &h2Conn{
+			conn: conn,
+			body: r.Body,
+			w:    fw,
+			onClose: func() {
+				close(finished)
+			},
+		}
+		select {
+		case <-finished:
+		case <-r.Context().Done():
+		}
+		// the response writer must not be used once the handler returned
+		fw.finish()
+	})
+	go func() {
+		defer close(done)
+		(&http2.Server{}).ServeConn(conn, &http2.ServeConnOpts{Handler: handler})
+	}()
+
+	timer := time.NewTimer(h2Timeout)
+	defer timer.Stop()
+	select {
+	case c := <-streams:
+		return c, nil
+	case <-done:
+		_ = conn.Close()
+		return nil, errors.New("h2: connection closed before a request arrived")
+	case <-timer.C:
+		_ = conn.Close()
+		<-done
+		return nil, errors.New("h2: no request within the timeout")
+	}
+}
+
+// h2Handshake completes the handshake of a TLS layer below, within 10 seconds, since HTTP/2 checks the
+// negotiated TLS version and cipher suite when it starts.
+func h2Handshake(conn net.Conn) error {
+	hs, ok := conn.(interface{ HandshakeContext(context.Context) error })
+	if !ok {
+		return nil
+	}
+	if err := Handshake(conn, h2Timeout, hs.HandshakeContext); err != nil {
+		return fmt.Errorf("h2: %w", err)
+	}
+	return nil
+}
+
+// flushWriter flushes every write of the response body, so it is sent right away. Writes after the handler
+// finished fail with net.ErrClosed, as the response writer must not be used anymore.
+type flushWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	rc       *http.ResponseController
+	finished bool
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.finished {
+		return 0, net.ErrClosed
+	}
+	n, err := f.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, f.rc.Flush()
+}
+
+// finish marks the handler as finished, waiting for a write in progress.
+func (f *flushWriter) finish() {
+	f.mu.Lock()
+	f.finished = true
+	f.mu.Unlock()
+}
+
+func (c *h2Conn) Read(p []byte) (int, error) { return c.body.Read(p) }
+
+func (c *h2Conn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.w.Write(p)
+}
+
+// CloseWrite ends the request body on clients. Servers cannot end the response on its own, as that ends
+// the stream, so it returns errors.ErrUnsupported there.
+func (c *h2Conn) CloseWrite() error {
+	if c.closeWrite == nil {
+		return errors.ErrUnsupported
+	}
+	return c.closeWrite()
+}
+
+// Close ends the stream and closes the underlying conn.
+func (c *h2Conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.onClose()
+		_ = c.body.Close()
+		err = c.conn.Close()
+	})
+	return err
+}
+
+func (c *h2Conn) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *h2Conn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// SetDeadline and the other deadline methods apply to the underlying conn, so an expired read deadline
+// also fails writes and ends the HTTP/2 connection.
+func (c *h2Conn) SetDeadline(t time.Time) error      { return c.conn.SetDeadline(t) }
+func (c *h2Conn) SetReadDeadline(t time.Time) error  { return c.conn.SetReadDeadline(t) }
+func (c *h2Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
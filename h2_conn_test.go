@@ -0,0 +1,146 @@
+package netx_test
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedramktb/go-netx"
+)
+
+// h2Pair returns the ends of an h2 layer over a TCP loopback conn, with TLS below it if tlsBelow is set.
+func h2Pair(t *testing.T, server, client string, tlsBelow bool) (net.Conn, net.Conn, error) {
+	t.Helper()
+	var sw netx.ServerWrappers
+	if err := sw.UnmarshalText([]byte(server)); err != nil {
+		t.Fatal(err)
+	}
+	var cw netx.ClientWrappers
+	if err := cw.UnmarshalText([]byte(client)); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	cert := mustSelfSignedCert(t)
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			accepted <- result{nil, err}
+			return
+		}
+		if tlsBelow {
+			c = tls.Server(c, &tls.Config{Certificates: []tls.Certificate{cert}})
+		}
+		sc, err := sw.Wrappers[0].ConnToConn(c)
+		if err != nil {
+			_ = c.Close()
+		}
+		accepted <- result{sc, err}
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsBelow {
+		c = tls.Client(c, &tls.Config{InsecureSkipVerify: true})
+	}
+	cc, cerr := cw.Wrappers[0].ConnToConn(c)
+	if cerr != nil {
+		_ = c.Close()
+	}
+	r := <-accepted
+	if r.err == nil {
+		t.Cleanup(func() { _ = r.conn.Close() })
+	}
+	if cerr == nil {
+		t.Cleanup(func() { _ = cc.Close() })
+	}
+	if cerr != nil {
+		return nil, nil, cerr
+	}
+	return r.conn, cc, r.err
+}
+
+func TestH2Conn(t *testing.T) {
+	t.Parallel()
+	for _, tlsBelow := range []bool{false, true} {
+		sc, cc, err := h2Pair(t, "h2{path=/upload,host=example.com}", "h2{path=/upload,host=example.com}", tlsBelow)
+		if err != nil {
+			t.Fatalf("tls=%v: %v", tlsBelow, err)
+		}
+		_ = sc.SetDeadline(time.Now().Add(5 * time.Second))
+		_ = cc.SetDeadline(time.Now().Add(5 * time.Second))
+
+		// both directions carry data before either is closed
+		for i, dir := range [][2]net.Conn{{cc, sc}, {sc, cc}, {cc, sc}} {
+			msg := strings.Repeat("x", 100_000+i)
+			go func() { _, _ = dir[0].Write([]byte(msg)) }()
+			buf := make([]byte, len(msg))
+			if _, err := io.ReadFull(dir[1], buf); err != nil || string(buf) != msg {
+				t.Fatalf("tls=%v message %d: %v", tlsBelow, i, err)
+			}
+		}
+
+		// closing the request body ends the server's reads
+		if err := cc.(interface{ CloseWrite() error }).CloseWrite(); err != nil {
+			t.Fatalf("tls=%v close write: %v", tlsBelow, err)
+		}
+		if _, err := sc.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("tls=%v server read after CloseWrite: got %v, want io.EOF", tlsBelow, err)
+		}
+		if _, err := sc.Write([]byte("bye")); err != nil {
+			t.Fatalf("tls=%v server write after CloseWrite: %v", tlsBelow, err)
+		}
+		buf := make([]byte, 3)
+		if _, err := io.ReadFull(cc, buf); err != nil || string(buf) != "bye" {
+			t.Fatalf("tls=%v client read: %q, %v", tlsBelow, buf, err)
+		}
+	}
+}
+
+func TestH2ConnRejectsOtherPaths(t *testing.T) {
+	t.Parallel()
+	if _, _, err := h2Pair(t, "h2{path=/upload}", "h2{path=/other}", false); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("got %v, want a 404 error", err)
+	}
+	if _, _, err := h2Pair(t, "h2{host=example.com}", "h2{host=example.org}", false); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("got %v, want a 404 error", err)
+	}
+	var cw netx.ClientWrappers
+	if err := cw.UnmarshalText([]byte("h2{path=upload}")); err == nil {
+		t.Fatal("expected a path without a leading slash to be rejected")
+	}
+}
+
+func TestH2ConnServerWriteAfterClientClose(t *testing.T) {
+	t.Parallel()
+	sc, cc, err := h2Pair(t, "h2", "h2", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = cc.Close()
+	// the handler returns once the client is gone, after which writes fail instead of panicking
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := sc.Write([]byte("late")); errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server writes did not fail with net.ErrClosed after the client closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,50 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// HandshakeTimeoutError is returned by Handshake when the handshake did not complete in time.
+// It implements net.Error with Timeout reporting true.
+type HandshakeTimeoutError struct {
+	Limit time.Duration
+	Err   error
+}
+
+func (e *HandshakeTimeoutError) Error() string {
+	return "handshake timed out after " + e.Limit.String() + ": " + e.Err.Error()
+}
+
+func (e *HandshakeTimeoutError) Unwrap() error   { return e.Err }
+func (e *HandshakeTimeoutError) Timeout() bool   { return true }
+func (e *HandshakeTimeoutError) Temporary() bool { return false }
+
+// Handshake runs handshake bounded by timeout, for layers like TLS whose handshake may otherwise block forever
+// on a stalled peer. The deadline of c, the conn the handshake runs over, is set for the duration and cleared
+// afterwards, and the context passed to handshake expires at the same time. A zero timeout runs handshake
+// without a deadline.
+func Handshake(c net.Conn, timeout time.Duration, handshake func(context.Context) error) error {
+	if timeout <= 0 {
+		return handshake(context.Background())
+	}
+	deadline := time.Now().Add(timeout)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if err := c.SetDeadline(deadline); err != nil {
+		return err
+	}
+	err := handshake(ctx)
+	if err != nil {
+		var ne net.Error
+		if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) ||
+			(errors.As(err, &ne) && ne.Timeout()) || !time.Now().Before(deadline) {
+			return &HandshakeTimeoutError{Limit: timeout, Err: err}
+		}
+		return err
+	}
+	return c.SetDeadline(time.Time{})
+}
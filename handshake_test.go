@@ -0,0 +1,35 @@
+package netx_test
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	netx "github.com/pedramktb/go-netx"
+)
+
+func TestHandshakeTimesOutOnStalledServer(t *testing.T) {
+	t.Parallel()
+	c, s := net.Pipe()
+	t.Cleanup(func() { _ = c.Close(); _ = s.Close() })
+	// the server reads the client hello but never answers
+	go func() { _, _ = io.Copy(io.Discard, s) }()
+
+	tc := tls.Client(c, &tls.Config{ServerName: "example.com"})
+	start := time.Now()
+	err := netx.Handshake(c, 100*time.Millisecond, tc.HandshakeContext)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("handshake took %v despite the timeout", elapsed)
+	}
+	var hsErr *netx.HandshakeTimeoutError
+	if !errors.As(err, &hsErr) || hsErr.Limit != 100*time.Millisecond {
+		t.Fatalf("expected a HandshakeTimeoutError, got %v", err)
+	}
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("expected a net.Error timeout, got %v", err)
+	}
+}
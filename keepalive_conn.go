@@ -0,0 +1,252 @@
+/*
+KeepaliveConn is a network layer that keeps a message-preserving connection, like a frame layer, alive with
+application-level pings. Every message is prefixed with a 1-byte type: data messages carry the payload, while
+ping and pong control messages have no payload and are never returned by Read.
+
+Each side sends a ping every interval and closes the connection if no pong arrives within the timeout, so a
+peer that stopped responding is detected even if the transport below stays open. Pings are answered and pongs
+are picked up by Read, so the connection must be read continuously, as a Tun does, over a transport that buffers writes,
+like a socket. Both peers must use the layer, e.g.:
+
+	tcp+frame+keepalive{interval=10s,timeout=5s}://example.com:9000
+*/
+
+package netx
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("keepalive", func(params map[string]string, listener bool) (Wrapper, error) {
+		opts := []KeepaliveConnOption{}
+		for key, value := range params {
+			switch key {
+			case "interval":
+				dur, err := time.ParseDuration(value)
+				if err != nil {
+					return Wrapper{}, fmt.Errorf("keepalive: invalid interval parameter %q: %w", value, err)
+				}
+				opts = append(opts, WithKeepaliveInterval(dur))
+			case "timeout":
+				dur, err := time.ParseDuration(value)
+				if err != nil {
+					return Wrapper{}, fmt.Errorf("keepalive: invalid timeout parameter %q: %w", value, err)
+				}
+				opts = append(opts, WithKeepaliveTimeout(dur))
+			default:
+				return Wrapper{}, fmt.Errorf("uri: unknown keepalive parameter %q", key)
+			}
+		}
+		connToConn := func(c net.Conn) (net.Conn, error) {
+			return NewKeepaliveConn(c, opts...), nil
+		}
+		return Wrapper{
+			Name:   "keepalive",
+			Params: params,
+			ListenerToListener: func(l net.Listener) (net.Listener, error) {
+				return ConnWrapListener(l, connToConn)
+			},
+			DialerToDialer: func(f Dialer) (Dialer, error) {
+				return ConnWrapDialer(f, connToConn)
+			},
+			ConnToConn: connToConn,
+		}, nil
+	})
+}
+
+// ErrKeepaliveTimeout is returned by Read and Write after a KeepaliveConn was closed because the peer did not
+// answer a ping in time.
+var ErrKeepaliveTimeout = errors.New("keepalive: no pong within timeout")
+
+// Message types of KeepaliveConn.
+const (
+	keepaliveData byte = iota
+	keepalivePing
+	keepalivePong
+)
+
+type KeepaliveConnOption func(*keepaliveConn)
+
+// WithKeepaliveInterval sets how often a ping is sent. 0 disables sending pings, while pings of the
+// peer are still answered.
+// Default is 30s.
+func WithKeepaliveInterval(d time.Duration) KeepaliveConnOption {
+	return func(c *keepaliveConn) {
+		c.interval = d
+	}
+}
+
+// WithKeepaliveTimeout sets how long to wait for the pong to a ping before closing the connection.
+// Default is the interval.
+func WithKeepaliveTimeout(d time.Duration) KeepaliveConnOption {
+	return func(c *keepaliveConn) {
+		c.timeout = d
+	}
+}
+
+type keepaliveConn struct {
+	net.Conn
+	interval time.Duration
+	timeout  time.Duration
+
+	rmu     sync.Mutex
+	rbuf    []byte
+	pending []byte
+
+	wmu  sync.Mutex
+	wbuf []byte
+
+	pong      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+	timedOut  bool
+}
+
+// NewKeepaliveConn wraps a message-preserving net.Conn with keepalive pings, see KeepaliveConn.
+// The returned conn starts pinging right away and stops when it is closed.
+func NewKeepaliveConn(c net.Conn, opts ...KeepaliveConnOption) net.Conn {
+	kc := &keepaliveConn{
+		Conn:     c,
+		interval: 30 * time.Second,
+		rbuf:     make([]byte, MaxPacketSize),
+		pong:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(kc)
+	}
+	if kc.timeout <= 0 {
+		kc.timeout = kc.interval
+	}
+	if kc.interval > 0 {
+		go kc.loop()
+	}
+	return kc
+}
+
+// loop sends a ping every interval and closes the conn if its pong does not arrive within the timeout.
+func (c *keepaliveConn) loop() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.done:
+			return
+		}
+		// drop a pong that arrived late for the previous ping
+		select {
+		case <-c.pong:
+		default:
+		}
+		if err := c.writeMessage(keepalivePing, nil); err != nil {
+			return
+		}
+		timer := time.NewTimer(c.timeout)
+		select {
+		case <-c.pong:
+			timer.Stop()
+		case <-timer.C:
+			c.mu.Lock()
+			c.timedOut = true
+			c.mu.Unlock()
+			_ = c.Close()
+			return
+		case <-c.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// err returns ErrKeepaliveTimeout if the conn was closed for a missing pong, err otherwise.
+func (c *keepaliveConn) err(err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timedOut {
+		return ErrKeepaliveTimeout
+	}
+	return err
+}
+
+// MaxWrite forwards the underlying connection's MaxWrite limit, if any, less the type byte.
+func (c *keepaliveConn) MaxWrite() uint16 {
+	if mw, ok := c.Conn.(interface{ MaxWrite() uint16 }); ok && mw.MaxWrite() > 0 {
+		return mw.MaxWrite() - 1
+	}
+	return 0
+}
+
+// Read returns the payload of the next data message; large messages are delivered across multiple Reads.
+// Control messages are handled on the way.
+func (c *keepaliveConn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+
+	for {
+		n, err := c.Conn.Read(c.rbuf)
+		if err != nil {
+			return 0, c.err(err)
+		}
+		if n == 0 {
+			continue
+		}
+		switch c.rbuf[0] {
+		case keepaliveData:
+			w := copy(p, c.rbuf[1:n])
+			c.pending = c.rbuf[1+w : n]
+			return w, nil
+		case keepalivePing:
+			if err := c.writeMessage(keepalivePong, nil); err != nil {
+				return 0, c.err(err)
+			}
+		case keepalivePong:
+			select {
+			case c.pong <- struct{}{}:
+			default:
+			}
+		default:
+			return 0, fmt.Errorf("keepalive: unknown message type %d", c.rbuf[0])
+		}
+	}
+}
+
+// Write sends p as a single data message.
+func (c *keepaliveConn) Write(p []byte) (int, error) {
+	if len(p) >= MaxPacketSize {
+		return 0, errors.New("keepalive: packet too large")
+	}
+	if err := c.writeMessage(keepaliveData, p); err != nil {
+		return 0, c.err(err)
+	}
+	return len(p), nil
+}
+
+func (c *keepaliveConn) writeMessage(typ byte, p []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.wbuf = append(append(c.wbuf[:0], typ), p...)
+	_, err := c.Conn.Write(c.wbuf)
+	return err
+}
+
+func (c *keepaliveConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		err = c.Conn.Close()
+	})
+	return err
+}
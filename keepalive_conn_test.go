@@ -0,0 +1,92 @@
+package netx_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	netx "github.com/pedramktb/go-netx"
+)
+
+func TestKeepaliveConnFiltersControlMessages(t *testing.T) {
+	t.Parallel()
+
+	// a real socket, since both sides ping at once and a synchronous pipe would deadlock
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	clientRaw, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	serverRaw, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	opts := []netx.KeepaliveConnOption{
+		netx.WithKeepaliveInterval(10 * time.Millisecond),
+		netx.WithKeepaliveTimeout(200 * time.Millisecond),
+	}
+	client := netx.NewKeepaliveConn(netx.NewFrameConn(clientRaw), opts...)
+	server := netx.NewKeepaliveConn(netx.NewFrameConn(serverRaw), opts...)
+	t.Cleanup(func() { _ = client.Close(); _ = server.Close() })
+
+	// echo on the server until the client goes away
+	go func() { _, _ = io.Copy(server, server) }()
+
+	// read continuously, as a Tun would, so that pongs are picked up
+	reads := make(chan string)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := client.Read(buf)
+			if err != nil {
+				close(reads)
+				return
+			}
+			reads <- string(buf[:n])
+		}
+	}()
+
+	// several ping intervals pass between the messages, none of the pings show up as data
+	for _, msg := range []string{"one", "two", "three"} {
+		time.Sleep(50 * time.Millisecond)
+		if _, err := client.Write([]byte(msg)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if got, ok := <-reads; !ok || got != msg {
+			t.Fatalf("got %q, want %q", got, msg)
+		}
+	}
+}
+
+func TestKeepaliveConnClosesWithoutPong(t *testing.T) {
+	t.Parallel()
+
+	clientRaw, serverRaw := net.Pipe()
+	t.Cleanup(func() { _ = serverRaw.Close() })
+	client := netx.NewKeepaliveConn(clientRaw,
+		netx.WithKeepaliveInterval(20*time.Millisecond),
+		netx.WithKeepaliveTimeout(50*time.Millisecond),
+	)
+	t.Cleanup(func() { _ = client.Close() })
+
+	// the peer swallows everything, including pings, and never answers
+	go func() { _, _ = io.Copy(io.Discard, serverRaw) }()
+
+	start := time.Now()
+	_, err := client.Read(make([]byte, 64))
+	if !errors.Is(err, netx.ErrKeepaliveTimeout) {
+		t.Fatalf("read err = %v, want ErrKeepaliveTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed < 70*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("torn down after %v, want interval+timeout", elapsed)
+	}
+	if _, err := client.Write([]byte("late")); !errors.Is(err, netx.ErrKeepaliveTimeout) {
+		t.Fatalf("write err = %v, want ErrKeepaliveTimeout", err)
+	}
+}
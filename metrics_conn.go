@@ -0,0 +1,236 @@
+/*
+MetricsConn is a network layer that records metrics of the conns passing through it, exported in the Prometheus
+text format by MetricsHandler. Metrics are registered globally by name, which becomes the name label, so the
+metrics driver and the scraper only need to agree on it:
+
+	tcp+metrics{name=outer}+tls{...}+metrics{name=inner}://example.com:443
+
+	http.Handle("/metrics", netx.MetricsHandler())
+
+Per name, the following metrics are kept:
+
+	netx_conn_bytes_read_total               bytes read
+	netx_conn_bytes_written_total            bytes written
+	netx_conn_opened_total                   conns wrapped
+	netx_conn_active                         conns wrapped and not closed yet
+	netx_conn_errors_total                   failed handshakes, reads and writes, other than EOF and closed conns
+	netx_conn_handshake_duration_seconds     histogram of the handshakes of the layer below
+
+Handshakes are only timed for layers below that have a HandshakeContext method, like TLS: the first read or
+write runs the handshake, as the layer would itself, and records how long it took. Reads and writes are passed
+through unchanged and only update atomic counters, so the layer adds little overhead and is safe for concurrent
+use.
+*/
+
+package netx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	Register("metrics", func(params map[string]string, listener bool) (Wrapper, error) {
+		var name string
+		for key, value := range params {
+			switch key {
+			case "name":
+				name = value
+			default:
+				return Wrapper{}, fmt.Errorf("uri: unknown metrics parameter %q", key)
+			}
+		}
+		connToConn := func(c net.Conn) (net.Conn, error) {
+			return NewMetricsConn(c, name), nil
+		}
+		return Wrapper{
+			Name:   "metrics",
+			Params: params,
+			ListenerToListener: func(l net.Listener) (net.Listener, error) {
+				return ConnWrapListener(l, connToConn)
+			},
+			DialerToDialer: func(f Dialer) (Dialer, error) {
+				return ConnWrapDialer(f, connToConn)
+			},
+			ConnToConn: connToConn,
+		}, nil
+	}, RequireParams("name"))
+}
+
+// handshakeBuckets are the upper bounds, in seconds, of the buckets of the handshake duration histogram.
+var handshakeBuckets = [...]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// connMetrics holds the metrics of the conns wrapped under one name.
+type connMetrics struct {
+	read, written  atomic.Uint64
+	opened, errors atomic.Uint64
+	active         atomic.Int64
+	// handshakes counts handshakes by bucket, the last one for those slower than all bounds
+	handshakes   [len(handshakeBuckets) + 1]atomic.Uint64
+	handshakeSum atomic.Uint64 // in nanoseconds
+}
+
+func (m *connMetrics) observeHandshake(d time.Duration) {
+	i, _ := slices.BinarySearch(handshakeBuckets[:], d.Seconds())
+	m.handshakes[i].Add(1)
+	m.handshakeSum.Add(uint64(d))
+}
+
+// countError counts err unless it is nil or the regular end of a conn.
+func (m *connMetrics) countError(err error) {
+	if err != nil && err != io.EOF && !errors.Is(err, net.ErrClosed) {
+		m.errors.Add(1)
+	}
+}
+
+var connMetricsByName sync.Map // name to *connMetrics
+
+func getConnMetrics(name string) *connMetrics {
+	if m, ok := connMetricsByName.Load(name); ok {
+		return m.(*connMetrics)
+	}
+	m, _ := connMetricsByName.LoadOrStore(name, new(connMetrics))
+	return m.(*connMetrics)
+}
+
+// MetricsHandler returns a handler serving the metrics of all names used by metrics layers in the Prometheus
+// text exposition format, see MetricsConn.
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = io.WriteString(w, formatMetrics())
+	})
+}
+
+// formatMetrics renders all metrics, sorted by name so scrapes are stable.
+func formatMetrics() string {
+	type named struct {
+		label string
+		m     *connMetrics
+	}
+	var all []named
+	connMetricsByName.Range(func(k, v any) bool {
+		all = append(all, named{label: `name="` + escapeLabel(k.(string)) + `"`, m: v.(*connMetrics)})
+		return true
+	})
+	slices.SortFunc(all, func(a, b named) int { return strings.Compare(a.label, b.label) })
+
+	var b strings.Builder
+	counter := func(metric, kind, help string, value func(*connMetrics) string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", metric, help, metric, kind)
+		for _, n := range all {
+			fmt.Fprintf(&b, "%s{%s} %s\n", metric, n.label, value(n.m))
+		}
+	}
+	counter("netx_conn_bytes_read_total", "counter", "Bytes read through the metrics layer.",
+		func(m *connMetrics) string { return strconv.FormatUint(m.read.Load(), 10) })
+	counter("netx_conn_bytes_written_total", "counter", "Bytes written through the metrics layer.",
+		func(m *connMetrics) string { return strconv.FormatUint(m.written.Load(), 10) })
+	counter("netx_conn_opened_total", "counter", "Connections wrapped by the metrics layer.",
+		func(m *connMetrics) string { return strconv.FormatUint(m.opened.Load(), 10) })
+	counter("netx_conn_active", "gauge", "Connections wrapped by the metrics layer and not closed yet.",
+		func(m *connMetrics) string { return strconv.FormatInt(m.active.Load(), 10) })
+	counter("netx_conn_errors_total", "counter", "Failed handshakes, reads and writes, other than EOF and closed connections.",
+		func(m *connMetrics) string { return strconv.FormatUint(m.errors.Load(), 10) })
+
+	const hist = "netx_conn_handshake_duration_seconds"
+	fmt.Fprintf(&b, "# HELP %s Duration of the handshakes of the layer below the metrics layer.\n# TYPE %s histogram\n", hist, hist)
+	for _, n := range all {
+		var cumulative uint64
+		for i, le := range handshakeBuckets {
+			cumulative += n.m.handshakes[i].Load()
+			fmt.Fprintf(&b, "%s_bucket{%s,le=\"%s\"} %d\n", hist, n.label, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		cumulative += n.m.handshakes[len(handshakeBuckets)].Load()
+		fmt.Fprintf(&b, "%s_bucket{%s,le=\"+Inf\"} %d\n", hist, n.label, cumulative)
+		fmt.Fprintf(&b, "%s_sum{%s} %s\n", hist, n.label, strconv.FormatFloat(time.Duration(n.m.handshakeSum.Load()).Seconds(), 'g', -1, 64))
+		fmt.Fprintf(&b, "%s_count{%s} %d\n", hist, n.label, cumulative)
+	}
+	return b.String()
+}
+
+// escapeLabel escapes a label value as required by the text exposition format.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+type metricsConn struct {
+	net.Conn
+	metrics   *connMetrics
+	handshake func(context.Context) error // nil if the conn below has no handshake
+	hsOnce    sync.Once
+	hsErr     error
+	closeOnce sync.Once
+}
+
+// NewMetricsConn wraps c so its traffic is recorded in the metrics of name, see MetricsConn.
+func NewMetricsConn(c net.Conn, name string) net.Conn {
+	metrics := getConnMetrics(name)
+	metrics.opened.Add(1)
+	metrics.active.Add(1)
+	mc := &metricsConn{Conn: c, metrics: metrics}
+	if hs, ok := c.(interface{ HandshakeContext(context.Context) error }); ok {
+		mc.handshake = hs.HandshakeContext
+	}
+	return mc
+}
+
+// MaxWrite forwards the underlying connection's MaxWrite limit, if any.
+func (c *metricsConn) MaxWrite() uint16 {
+	if mw, ok := c.Conn.(interface{ MaxWrite() uint16 }); ok {
+		return mw.MaxWrite()
+	}
+	return 0
+}
+
+// runHandshake runs and times the handshake of the conn below on the first read or write.
+func (c *metricsConn) runHandshake() error {
+	if c.handshake == nil {
+		return nil
+	}
+	c.hsOnce.Do(func() {
+		start := time.Now()
+		c.hsErr = c.handshake(context.Background())
+		if c.hsErr != nil {
+			c.metrics.countError(c.hsErr)
+			return
+		}
+		c.metrics.observeHandshake(time.Since(start))
+	})
+	return c.hsErr
+}
+
+func (c *metricsConn) Read(p []byte) (int, error) {
+	if err := c.runHandshake(); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Read(p)
+	c.metrics.read.Add(uint64(n))
+	c.metrics.countError(err)
+	return n, err
+}
+
+func (c *metricsConn) Write(p []byte) (int, error) {
+	if err := c.runHandshake(); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Write(p)
+	c.metrics.written.Add(uint64(n))
+	c.metrics.countError(err)
+	return n, err
+}
+
+func (c *metricsConn) Close() error {
+	c.closeOnce.Do(func() { c.metrics.active.Add(-1) })
+	return c.Conn.Close()
+}
@@ -0,0 +1,74 @@
+package netx_test
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	netx "github.com/pedramktb/go-netx"
+)
+
+func scrapeMetrics(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	netx.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	return rec.Body.String()
+}
+
+func TestMetricsConnRecordsTraffic(t *testing.T) {
+	t.Parallel()
+	// metrics are global, so every run uses a name of its own, e.g. with -count
+	name := fmt.Sprintf("TestMetricsConnRecordsTraffic-%d", time.Now().UnixNano())
+	var w netx.Wrapper
+	if err := w.UnmarshalText([]byte("metrics{name="+name+"}"), false); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	cert := mustSelfSignedCert(t)
+	clientRaw, serverRaw := net.Pipe()
+	t.Cleanup(func() { _ = clientRaw.Close(); _ = serverRaw.Close() })
+	// the TLS client below gets its handshake timed
+	v, err := w.Apply(net.Conn(tls.Client(clientRaw, &tls.Config{InsecureSkipVerify: true})))
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	client := v.(net.Conn)
+
+	server := tls.Server(serverRaw, &tls.Config{Certificates: []tls.Certificate{cert}})
+	go func() { _, _ = io.Copy(server, server) }()
+
+	buf := make([]byte, 1000)
+	for range 3 {
+		if _, err := client.Write(buf); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if _, err := io.ReadFull(client, buf); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+	}
+
+	label := `{name="` + name + `"}`
+	got := scrapeMetrics(t)
+	for _, want := range []string{
+		"netx_conn_bytes_read_total" + label + " 3000\n",
+		"netx_conn_bytes_written_total" + label + " 3000\n",
+		"netx_conn_opened_total" + label + " 1\n",
+		"netx_conn_active" + label + " 1\n",
+		"netx_conn_errors_total" + label + " 0\n",
+		"netx_conn_handshake_duration_seconds_count" + label + " 1\n",
+		`netx_conn_handshake_duration_seconds_bucket{name="` + name + `",le="+Inf"} 1` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("scrape lacks %q:\n%s", want, got)
+		}
+	}
+
+	_ = client.Close()
+	if got := scrapeMetrics(t); !strings.Contains(got, "netx_conn_active"+label+" 0\n") {
+		t.Fatalf("want no active conns after close:\n%s", got)
+	}
+}
@@ -71,6 +71,15 @@ type muxPacket struct {
 	conn net.Conn
 }
 
+// Mux is the TaggedConn returned by NewMux.
+type Mux interface {
+	TaggedConn
+	// CloseIdle closes the accepted connections nothing has been read from for at least idleFor,
+	// and returns how many it closed. Mux keeps no state for a connection once it is closed, so this
+	// is how stale connections, e.g. of clients that roamed to a new connection, are reclaimed.
+	CloseIdle(idleFor time.Duration) int
+}
+
 type mux struct {
 	logger   Logger
 	listener net.Listener
@@ -84,7 +93,7 @@ type mux struct {
 	pendingConn net.Conn
 
 	connMu sync.Mutex
-	conns  map[net.Conn]struct{}
+	conns  map[net.Conn]*atomic.Int64 // last read activity in unix nanoseconds
 
 	deadlineMu    sync.Mutex
 	readDeadline  time.Time
@@ -119,13 +128,13 @@ func WithMuxLogger(logger Logger) MuxOption {
 //
 // Closing the returned TaggedConn closes all active underlying connections and
 // the listener.
-func NewMux(ln net.Listener, opts ...MuxOption) TaggedConn {
+func NewMux(ln net.Listener, opts ...MuxOption) Mux {
 	m := &mux{
 		logger:   slog.Default(),
 		listener: ln,
 		doneCh:   make(chan struct{}),
 		rQueue:   make(chan muxPacket, 64),
-		conns:    make(map[net.Conn]struct{}),
+		conns:    make(map[net.Conn]*atomic.Int64),
 	}
 	for _, o := range opts {
 		o(m)
@@ -176,16 +185,18 @@ func (c *mux) acceptLoop() {
 			_ = conn.Close()
 			return
 		}
-		c.conns[conn] = struct{}{}
+		active := new(atomic.Int64)
+		active.Store(time.Now().UnixNano())
+		c.conns[conn] = active
 		c.connMu.Unlock()
 
-		go c.readConn(conn)
+		go c.readConn(conn, active)
 	}
 }
 
 // readConn reads from a single underlying connection and forwards packets to
 // the shared readQueue. It exits on EOF, any error, or mux close.
-func (c *mux) readConn(conn net.Conn) {
+func (c *mux) readConn(conn net.Conn, active *atomic.Int64) {
 	c.logger.DebugContext(context.Background(), "mux: new connection accepted", "remoteAddr", conn.RemoteAddr().Network()+"://"+conn.RemoteAddr().String())
 	defer func() {
 		_ = conn.Close()
@@ -200,6 +211,7 @@ func (c *mux) readConn(conn net.Conn) {
 	for {
 		n, err := conn.Read(buf)
 		if n > 0 {
+			active.Store(time.Now().UnixNano())
 			data := make([]byte, n)
 			copy(data, buf[:n])
 			select {
@@ -283,6 +295,22 @@ func (c *mux) WriteTagged(b []byte, tag any) (int, error) {
 	return conn.Write(b)
 }
 
+func (c *mux) CloseIdle(idleFor time.Duration) int {
+	cutoff := time.Now().Add(-idleFor).UnixNano()
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	closed := 0
+	for conn, active := range c.conns {
+		if active.Load() <= cutoff {
+			// readConn stops once its read fails
+			_ = conn.Close()
+			delete(c.conns, conn)
+			closed++
+		}
+	}
+	return closed
+}
+
 func (c *mux) Close() error {
 	if !c.closed.CompareAndSwap(false, true) {
 		return nil
@@ -342,3 +370,5 @@ func (a *muxVirtualAddr) Network() string {
 func (a *muxVirtualAddr) String() string {
 	return "mux"
 }
+
+func (a *muxVirtualAddr) Virtual() bool { return true }
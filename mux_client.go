@@ -27,6 +27,16 @@ import (
 // It should return a new net.Conn each time it is called.
 type Dialer = func() (net.Conn, error)
 
+// MuxClient is the net.Conn returned by NewMuxClient.
+type MuxClient interface {
+	net.Conn
+	// CurrentConn returns the underlying connection in use, or nil if none is dialled.
+	CurrentConn() net.Conn
+	// Reset closes the current underlying connection (if any) so the next Read/Write
+	// dials a new one, e.g. after a network change.
+	Reset() error
+}
+
 type muxClient struct {
 	logger Logger
 	dial   Dialer
@@ -57,7 +67,7 @@ func WithMuxClientLogger(logger Logger) MuxClientOption {
 // the current connection reaches EOF or encounters an error.
 // Closing the returned conn closes the current underlying connection (if any) and
 // prevents further dialling.
-func NewMuxClient(dial Dialer, opts ...MuxClientOption) net.Conn {
+func NewMuxClient(dial Dialer, opts ...MuxClientOption) MuxClient {
 	dc := &muxClient{
 		logger: slog.Default(),
 		dial:   dial,
@@ -119,6 +129,28 @@ func (c *muxClient) replaceCurrent(old net.Conn) {
 	c.connMu.Unlock()
 }
 
+func (c *muxClient) CurrentConn() net.Conn {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.current
+}
+
+func (c *muxClient) Reset() error {
+	if c.closed.Load() {
+		return net.ErrClosed
+	}
+
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.current == nil {
+		return nil
+	}
+	c.logger.DebugContext(context.Background(), "muxClient: resetting current connection", "localAddr", c.current.LocalAddr().Network()+"://"+c.current.LocalAddr().String())
+	err := c.current.Close()
+	c.current = nil
+	return err
+}
+
 func (c *muxClient) Read(b []byte) (int, error) {
 	c.rMu.Lock()
 	defer c.rMu.Unlock()
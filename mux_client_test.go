@@ -6,6 +6,7 @@ import (
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -151,6 +152,97 @@ func TestMuxClient_RequestResponse(t *testing.T) {
 	wg.Wait()
 }
 
+// Write only sends and Read consumes responses independently, so a single
+// write may be followed by several reads for a multi-part response.
+func TestMuxClient_MultipleReadsAfterWrite(t *testing.T) {
+	ln := tcpListener(t)
+	dc := netx.NewMuxClient(tcpDialer(t, ln.Addr().String()))
+	defer dc.Close()
+
+	parts := []string{"part-1", "part-2", "part-3"}
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		c, err := ln.Accept()
+		if err != nil {
+			t.Errorf("accept: %v", err)
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, 256)
+		if _, err := c.Read(buf); err != nil {
+			t.Errorf("read: %v", err)
+			return
+		}
+		for _, p := range parts {
+			if _, err := c.Write([]byte(p)); err != nil {
+				t.Errorf("write %q: %v", p, err)
+				return
+			}
+			time.Sleep(10 * time.Millisecond) // keep parts in separate segments
+		}
+	})
+
+	if _, err := dc.Write([]byte("request")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = dc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var got []byte
+	buf := make([]byte, 256)
+	for len(got) < len("part-1part-2part-3") {
+		n, err := dc.Read(buf)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if string(got) != "part-1part-2part-3" {
+		t.Fatalf("got %q", got)
+	}
+
+	wg.Wait()
+}
+
+// A response larger than the caller's buffer is delivered across several reads
+// without losing any bytes.
+func TestMuxClient_LargeResponse(t *testing.T) {
+	ln := tcpListener(t)
+	dc := netx.NewMuxClient(tcpDialer(t, ln.Addr().String()))
+	defer dc.Close()
+
+	large := bytes.Repeat([]byte("0123456789abcdef"), 16<<10) // 256 KiB
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		c, err := ln.Accept()
+		if err != nil {
+			t.Errorf("accept: %v", err)
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, 256)
+		if _, err := c.Read(buf); err != nil {
+			t.Errorf("read: %v", err)
+			return
+		}
+		if _, err := c.Write(large); err != nil {
+			t.Errorf("write: %v", err)
+		}
+	})
+
+	if _, err := dc.Write([]byte("request")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = dc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got := make([]byte, len(large))
+	if _, err := io.ReadFull(dc, got); err != nil {
+		t.Fatalf("readfull: %v", err)
+	}
+	if !bytes.Equal(got, large) {
+		t.Fatalf("large response mismatch")
+	}
+
+	wg.Wait()
+}
+
 func TestMuxClient_Close(t *testing.T) {
 	ln := tcpListener(t)
 	dc := netx.NewMuxClient(tcpDialer(t, ln.Addr().String()))
@@ -253,3 +345,45 @@ func TestMuxClient_DialError(t *testing.T) {
 		t.Fatalf("expected dial error, got %v", err)
 	}
 }
+
+func TestMuxClient_ResetRedials(t *testing.T) {
+	var dials atomic.Int32
+	dc := netx.NewMuxClient(func() (net.Conn, error) {
+		dials.Add(1)
+		c, s := net.Pipe()
+		go func() { _, _ = io.Copy(io.Discard, s) }()
+		return c, nil
+	})
+	defer dc.Close()
+
+	if dc.CurrentConn() != nil {
+		t.Fatal("expected no current conn before first use")
+	}
+	if _, err := dc.Write([]byte("first")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	first := dc.CurrentConn()
+	if first == nil || dials.Load() != 1 {
+		t.Fatalf("expected one dialled conn, got %v after %d dials", first, dials.Load())
+	}
+
+	if err := dc.Reset(); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if dc.CurrentConn() != nil {
+		t.Fatal("expected no current conn after reset")
+	}
+	if _, err := first.Write([]byte("x")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("expected reset to close the old conn, got %v", err)
+	}
+
+	if _, err := dc.Write([]byte("second")); err != nil {
+		t.Fatalf("write after reset: %v", err)
+	}
+	if dials.Load() != 2 {
+		t.Fatalf("expected write after reset to dial again, got %d dials", dials.Load())
+	}
+	if cur := dc.CurrentConn(); cur == nil || cur == first {
+		t.Fatal("expected a fresh current conn after reset")
+	}
+}
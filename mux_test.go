@@ -338,3 +338,56 @@ func TestMux_Deadlines(t *testing.T) {
 		t.Fatalf("expected timeout, got %v", err)
 	}
 }
+
+func TestMux_CloseIdle(t *testing.T) {
+	ln := tcpListener(t)
+	lc := netx.NewMux(ln)
+	defer lc.Close()
+
+	idle, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer idle.Close()
+	active, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer active.Close()
+
+	buf := make([]byte, 256)
+	var tag any
+	for _, c := range []net.Conn{idle, active} {
+		if _, err := c.Write([]byte("hello")); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if _, err := lc.ReadTagged(buf, &tag); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, err := active.Write([]byte("still here")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := lc.ReadTagged(buf, &tag); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	if n := lc.CloseIdle(100 * time.Millisecond); n != 1 {
+		t.Fatalf("CloseIdle closed %d conns, want 1", n)
+	}
+	_ = idle.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := idle.Read(buf); err == nil {
+		t.Fatal("expected the idle conn to be closed")
+	}
+	if _, err := active.Write([]byte("ping")); err != nil {
+		t.Fatalf("write on active conn: %v", err)
+	}
+	if _, err := lc.ReadTagged(buf, &tag); err != nil {
+		t.Fatalf("read from active conn: %v", err)
+	}
+	if n := lc.CloseIdle(time.Hour); n != 0 {
+		t.Fatalf("CloseIdle closed %d conns, want 0", n)
+	}
+}
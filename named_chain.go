@@ -0,0 +1,73 @@
+package netx
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// chainLayer is the name of the layer referencing a chain registered with RegisterChain.
+const chainLayer = "chain"
+
+// maxChainDepth bounds how deeply registered chains may reference each other, which also catches cycles.
+const maxChainDepth = 8
+
+var (
+	chainsMu sync.RWMutex
+	chains   = make(map[string]string)
+)
+
+// RegisterChain makes a +-separated chain of layers available by name, so that chains can reference it as
+// chain{name=<name>} instead of repeating its layers, e.g. after
+//
+//	netx.RegisterChain("secure", "tls{servername=example.com}+frame+aesgcm{key=...}")
+//
+// the chain tcp+chain{name=secure}://example.com:443 is parsed exactly like the inline form. The layers are
+// expanded when a chain is parsed, before its drivers are set up, so they are set up for the server or client
+// role of the chain and typed in their position, and registered chains may reference each other. Layers are
+// not checked on registration, since their params may only be valid for one role.
+func RegisterChain(name, layers string) {
+	chainsMu.Lock()
+	defer chainsMu.Unlock()
+	if name == "" || strings.TrimSpace(layers) == "" {
+		panic("uri: RegisterChain name or layers are empty")
+	}
+	if _, dup := chains[name]; dup {
+		panic("uri: RegisterChain called twice for chain " + name)
+	}
+	chains[name] = layers
+}
+
+// expandChains replaces the chain layers in parts with the layers of the chains they reference.
+func expandChains(parts []string, depth int) ([]string, error) {
+	var expanded []string
+	for _, part := range parts {
+		name, params, err := parseLayer(part)
+		if err != nil {
+			return nil, err
+		}
+		if name != chainLayer {
+			expanded = append(expanded, part)
+			continue
+		}
+		ref := params["name"]
+		if ref == "" || len(params) != 1 {
+			return nil, fmt.Errorf("uri: %s layer %q takes exactly a name parameter", chainLayer, part)
+		}
+		if depth >= maxChainDepth {
+			return nil, fmt.Errorf("uri: chain %q is nested more than %d levels deep", ref, maxChainDepth)
+		}
+		chainsMu.RLock()
+		layers, ok := chains[ref]
+		chainsMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("uri: unknown chain %q", ref)
+		}
+		sub, err := expandChains(strings.Split(layers, "+"), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, sub...)
+	}
+	return expanded, nil
+}
@@ -0,0 +1,68 @@
+package netx_test
+
+import (
+	"strings"
+	"testing"
+
+	netx "github.com/pedramktb/go-netx"
+)
+
+func init() {
+	netx.RegisterChain("test-encoded", "frame+textenc{encoding=hex}")
+	netx.RegisterChain("test-nested", "buf+chain{name=test-encoded}")
+	netx.RegisterChain("test-server", "paramtest{key=k,cert=c}")
+	netx.RegisterChain("test-loop", "frame+chain{name=test-loop}")
+}
+
+func TestNamedChainExpandsInline(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		chain, inline string
+	}{
+		{"chain{name=test-encoded}", "frame+textenc{encoding=hex}"},
+		{"buf+chain{name=test-encoded}+frame", "buf+frame+textenc{encoding=hex}+frame"},
+		{"chain{name=test-nested}", "buf+frame+textenc{encoding=hex}"},
+	} {
+		for _, server := range []bool{true, false} {
+			var got, want netx.Wrappers
+			if err := got.UnmarshalText([]byte(tc.chain), server); err != nil {
+				t.Fatalf("%s (server %v): %v", tc.chain, server, err)
+			}
+			if err := want.UnmarshalText([]byte(tc.inline), server); err != nil {
+				t.Fatalf("%s (server %v): %v", tc.inline, server, err)
+			}
+			if got.String() != want.String() {
+				t.Fatalf("%s (server %v) = %s, want %s", tc.chain, server, got, want)
+			}
+		}
+	}
+}
+
+func TestNamedChainErrors(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		chain   string
+		server  bool
+		wantMsg string
+	}{
+		// the layers are set up for the role of the chain they are used in; paramtest accepts the
+		// server params, but converts no type
+		{"chain{name=test-server}", true, "incompatible input type"},
+		{"chain{name=test-server}", false, `unsupported parameter "cert"`},
+		{"chain{name=missing}", true, `unknown chain "missing"`},
+		{"chain", true, "takes exactly a name parameter"},
+		{"chain{name=test-encoded,extra=1}", true, "takes exactly a name parameter"},
+		{"chain{name=test-loop}", true, "nested more than"},
+	} {
+		var ws netx.Wrappers
+		err := ws.UnmarshalText([]byte(tc.chain), tc.server)
+		if err == nil || !strings.Contains(err.Error(), tc.wantMsg) {
+			t.Errorf("%s (server %v): got %v, want an error containing %q", tc.chain, tc.server, err, tc.wantMsg)
+		}
+	}
+
+	var w netx.Wrapper
+	if err := w.UnmarshalText([]byte("chain{name=test-encoded}"), true); err == nil {
+		t.Error("a chain layer parsed as a single wrapper")
+	}
+}
@@ -0,0 +1,248 @@
+/*
+NegotiateConn is a network layer that lets client and server pick the rest of the chain at connect time, from
+profiles both ends allow, so traffic does not follow a single fixed profile. Profiles are chains of conn layers
+registered with RegisterChain and listed by name, e.g. with two write sizes:
+
+	netx.RegisterChain("small", "buf{w=512}+frame")
+	netx.RegisterChain("large", "buf{w=16384}+frame")
+
+	tcp+negotiate{profiles=small|large}://example.com:9000
+
+The client offers the profiles it allows in random order and the server picks one of those it allows as well,
+at random, so both ends constrain the choice. The messages are:
+
+	client: [version][count][count x 8-byte profile ID]
+	server: [version][index of the chosen profile in the offer, or 0xff if none is allowed]
+
+Profile IDs are the first 8 bytes of the SHA-256 of the profile name, so the names are not sent. Both ends then
+apply the layers of the chosen profile, as set up for their role. If no profile is allowed by both, both ends
+fail with ErrNoCommonProfile. Each message is sent in a single write, so the layer works over streams and
+packets alike, and the negotiation has to complete within 10 seconds.
+*/
+
+package netx
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("negotiate", func(params map[string]string, listener bool) (Wrapper, error) {
+		var profiles []NegotiateProfile
+		for key, value := range params {
+			switch key {
+			case "profiles":
+				for name := range strings.SplitSeq(value, "|") {
+					layers, err := registeredConnLayers(name, listener)
+					if err != nil {
+						return Wrapper{}, fmt.Errorf("negotiate: profile %q: %w", name, err)
+					}
+					profiles = append(profiles, NegotiateProfile{Name: name, Layers: layers})
+				}
+			default:
+				return Wrapper{}, fmt.Errorf("uri: unknown negotiate parameter %q", key)
+			}
+		}
+		connToConn := func(c net.Conn) (net.Conn, error) {
+			if listener {
+				return NewNegotiateServerConn(c, profiles...)
+			}
+			return NewNegotiateClientConn(c, profiles...)
+		}
+		return Wrapper{
+			Name:     "negotiate",
+			Params:   params,
+			Listener: listener,
+			ListenerToListener: func(l net.Listener) (net.Listener, error) {
+				return ConnWrapListener(l, connToConn)
+			},
+			DialerToDialer: func(f Dialer) (Dialer, error) {
+				return ConnWrapDialer(f, connToConn)
+			},
+			ConnToConn: connToConn,
+		}, nil
+	}, RequireParams("profiles"))
+}
+
+// registeredConnLayers sets up the layers of the chain registered as name for the given role, which must turn a
+// conn into a conn.
+func registeredConnLayers(name string, listener bool) (Wrappers, error) {
+	parts, err := expandChains([]string{chainLayer + "{name=" + name + "}"}, 0)
+	if err != nil {
+		return nil, err
+	}
+	layers := make(Wrappers, len(parts))
+	for i := range parts {
+		if err := layers[i].UnmarshalText([]byte(parts[i]), listener); err != nil {
+			return nil, err
+		}
+	}
+	if out, ok := layers.OutputFor(PipeTypeConn); !ok || out != PipeTypeConn {
+		return nil, fmt.Errorf("chain %q does not turn a conn into a conn", layers.String())
+	}
+	return layers, nil
+}
+
+const (
+	negotiateVersion  byte = 1
+	negotiateIDLen         = 8
+	negotiateRejected byte = 0xff
+	// negotiateMaxProfiles leaves the index 0xff for rejections.
+	negotiateMaxProfiles = 255
+	negotiateTimeout     = 10 * time.Second
+)
+
+// ErrNoCommonProfile is returned by negotiate conns when client and server allow no profile in common.
+var ErrNoCommonProfile = errors.New("negotiate: no profile allowed by both ends")
+
+// NegotiateProfile is a choice of a negotiate layer: the layers applied on top of the conn if it is chosen, set
+// up for the role of the conn, and the name the peers know it by.
+type NegotiateProfile struct {
+	Name   string
+	Layers Wrappers
+}
+
+func (p NegotiateProfile) id() [negotiateIDLen]byte {
+	sum := sha256.Sum256([]byte(p.Name))
+	return [negotiateIDLen]byte(sum[:negotiateIDLen])
+}
+
+// NewNegotiateClientConn offers profiles to the server in random order over conn and returns conn wrapped in
+// the layers of the profile the server picked, see NegotiateConn.
+func NewNegotiateClientConn(conn net.Conn, profiles ...NegotiateProfile) (net.Conn, error) {
+	if len(profiles) == 0 || len(profiles) > negotiateMaxProfiles {
+		return nil, fmt.Errorf("negotiate: %d profiles, want 1 to %d", len(profiles), negotiateMaxProfiles)
+	}
+	offer := make([]NegotiateProfile, len(profiles))
+	copy(offer, profiles)
+	if err := shuffle(offer); err != nil {
+		return nil, err
+	}
+	var chosen byte
+	err := Handshake(conn, negotiateTimeout, func(_ context.Context) error {
+		msg := []byte{negotiateVersion, byte(len(offer))}
+		for _, p := range offer {
+			id := p.id()
+			msg = append(msg, id[:]...)
+		}
+		if err := writeFull(conn, msg); err != nil {
+			return err
+		}
+		var reply [2]byte
+		if _, err := io.ReadFull(conn, reply[:]); err != nil {
+			return err
+		}
+		if reply[0] != negotiateVersion {
+			return fmt.Errorf("unsupported version %d", reply[0])
+		}
+		chosen = reply[1]
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("negotiate: %w", err)
+	}
+	if chosen == negotiateRejected {
+		return nil, ErrNoCommonProfile
+	}
+	if int(chosen) >= len(offer) {
+		return nil, fmt.Errorf("negotiate: server chose profile %d of %d", chosen, len(offer))
+	}
+	return offer[chosen].apply(conn)
+}
+
+// NewNegotiateServerConn reads the profiles offered by the client over conn, picks one of them that is also in
+// profiles at random and returns conn wrapped in its layers, see NegotiateConn.
+func NewNegotiateServerConn(conn net.Conn, profiles ...NegotiateProfile) (net.Conn, error) {
+	allowed := make(map[[negotiateIDLen]byte]NegotiateProfile, len(profiles))
+	for _, p := range profiles {
+		allowed[p.id()] = p
+	}
+	var chosen *NegotiateProfile
+	err := Handshake(conn, negotiateTimeout, func(_ context.Context) error {
+		// read the offer in one go, as it may be a single datagram
+		buf := make([]byte, 2+negotiateMaxProfiles*negotiateIDLen)
+		n := 0
+		for n < 2 || n < 2+int(buf[1])*negotiateIDLen {
+			m, err := conn.Read(buf[n:])
+			if err != nil {
+				return err
+			}
+			n += m
+		}
+		if buf[0] != negotiateVersion {
+			return fmt.Errorf("unsupported version %d", buf[0])
+		}
+		var candidates []int
+		for i := range int(buf[1]) {
+			if _, ok := allowed[[negotiateIDLen]byte(buf[2+i*negotiateIDLen:])]; ok {
+				candidates = append(candidates, i)
+			}
+		}
+		reply := []byte{negotiateVersion, negotiateRejected}
+		if len(candidates) > 0 {
+			pick, err := rand.Int(rand.Reader, big.NewInt(int64(len(candidates))))
+			if err != nil {
+				return err
+			}
+			i := candidates[pick.Int64()]
+			p := allowed[[negotiateIDLen]byte(buf[2+i*negotiateIDLen:])]
+			chosen, reply[1] = &p, byte(i)
+		}
+		return writeFull(conn, reply)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("negotiate: %w", err)
+	}
+	if chosen == nil {
+		return nil, ErrNoCommonProfile
+	}
+	return chosen.apply(conn)
+}
+
+func (p NegotiateProfile) apply(conn net.Conn) (net.Conn, error) {
+	out, err := p.Layers.Apply(conn)
+	if err != nil {
+		return nil, fmt.Errorf("negotiate: profile %q: %w", p.Name, err)
+	}
+	c, ok := out.(net.Conn)
+	if !ok {
+		return nil, fmt.Errorf("negotiate: profile %q does not result in a conn", p.Name)
+	}
+	return c, nil
+}
+
+// shuffle puts profiles in a random order.
+func shuffle(profiles []NegotiateProfile) error {
+	for i := len(profiles) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return err
+		}
+		profiles[i], profiles[j.Int64()] = profiles[j.Int64()], profiles[i]
+	}
+	return nil
+}
+
+// writeFull writes all of b to conn, writing the rest again after a short write.
+func writeFull(conn net.Conn, b []byte) error {
+	for len(b) > 0 {
+		n, err := conn.Write(b)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrShortWrite
+		}
+		b = b[n:]
+	}
+	return nil
+}
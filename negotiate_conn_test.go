@@ -0,0 +1,94 @@
+package netx_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	netx "github.com/pedramktb/go-netx"
+)
+
+func init() {
+	// the profiles differ in the byte counter they feed, which tells which one was chosen
+	for _, name := range []string{"a", "b", "c"} {
+		netx.RegisterChain("TestNegotiate-"+name, "count{name=TestNegotiate-"+name+"}+frame")
+	}
+}
+
+func negotiateLayer(t *testing.T, profiles string, listener bool) netx.Wrapper {
+	t.Helper()
+	var w netx.Wrapper
+	if err := w.UnmarshalText([]byte("negotiate{profiles="+profiles+"}"), listener); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	return w
+}
+
+// negotiatePair negotiates over a pipe and returns the resulting client and server conns or errors.
+func negotiatePair(t *testing.T, clientProfiles, serverProfiles string) (net.Conn, net.Conn, error, error) {
+	t.Helper()
+	client, server := negotiateLayer(t, clientProfiles, false), negotiateLayer(t, serverProfiles, true)
+	clientRaw, serverRaw := net.Pipe()
+	t.Cleanup(func() { _ = clientRaw.Close(); _ = serverRaw.Close() })
+
+	type result struct {
+		c   net.Conn
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		c, err := server.ConnToConn(serverRaw)
+		done <- result{c, err}
+	}()
+	cc, cerr := client.ConnToConn(clientRaw)
+	s := <-done
+	return cc, s.c, cerr, s.err
+}
+
+func TestNegotiateConnPicksCommonProfile(t *testing.T) {
+	t.Parallel()
+	client, server, cerr, serr := negotiatePair(t, "TestNegotiate-a|TestNegotiate-b", "TestNegotiate-b|TestNegotiate-c")
+	if cerr != nil || serr != nil {
+		t.Fatalf("negotiate: client %v, server %v", cerr, serr)
+	}
+
+	go func() { _, _ = io.Copy(server, server) }()
+	msg := []byte("hello over the negotiated profile")
+	if _, err := client.Write(msg); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(client, got); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(got) != string(msg) {
+		t.Fatalf("got %q, want %q", got, msg)
+	}
+
+	// both ends read through profile b, the count of the echo may still be pending
+	if b := netx.GetByteCounter("TestNegotiate-b"); b.Read() < 2*uint64(len(msg)) || b.Written() < uint64(len(msg)) {
+		t.Fatalf("profile b counted %d bytes read and %d written", b.Read(), b.Written())
+	}
+	for _, name := range []string{"TestNegotiate-a", "TestNegotiate-c"} {
+		if c := netx.GetByteCounter(name); c.Read() != 0 || c.Written() != 0 {
+			t.Fatalf("profile %s counted %d bytes read and %d written, want none", name, c.Read(), c.Written())
+		}
+	}
+}
+
+func TestNegotiateConnNoCommonProfile(t *testing.T) {
+	t.Parallel()
+	_, _, cerr, serr := negotiatePair(t, "TestNegotiate-a", "TestNegotiate-c")
+	if !errors.Is(cerr, netx.ErrNoCommonProfile) || !errors.Is(serr, netx.ErrNoCommonProfile) {
+		t.Fatalf("want ErrNoCommonProfile on both ends, got client %v, server %v", cerr, serr)
+	}
+}
+
+func TestNegotiateConnRejectsUnknownProfile(t *testing.T) {
+	t.Parallel()
+	var w netx.Wrapper
+	if err := w.UnmarshalText([]byte("negotiate{profiles=TestNegotiate-missing}"), false); err == nil {
+		t.Fatal("want an error for an unregistered profile")
+	}
+}
@@ -0,0 +1,61 @@
+/*
+ConnFromPacketConn turns an unconnected net.PacketConn into a net.Conn bound to a single remote address,
+so packet-based sockets can be used as the starting Conn of a wrapper pipeline (e.g. under dtls or aesgcm).
+Writes go to the remote address and reads only return packets received from it; packets from any other
+address are silently dropped. Packet boundaries are preserved.
+*/
+
+package netx
+
+import (
+	"net"
+	"time"
+)
+
+type packetConnConn struct {
+	pc    net.PacketConn
+	raddr net.Addr
+}
+
+// ConnFromPacketConn returns a net.Conn that exchanges packets with raddr over pc.
+// Closing the returned conn closes pc.
+func ConnFromPacketConn(pc net.PacketConn, raddr net.Addr) net.Conn {
+	return &packetConnConn{pc: pc, raddr: raddr}
+}
+
+// Read reads the next packet from the remote address, skipping packets from other addresses.
+func (c *packetConnConn) Read(b []byte) (int, error) {
+	for {
+		n, addr, err := c.pc.ReadFrom(b)
+		if err != nil {
+			return n, err
+		}
+		if sameAddr(addr, c.raddr) {
+			return n, nil
+		}
+	}
+}
+
+func (c *packetConnConn) Write(b []byte) (int, error) { return c.pc.WriteTo(b, c.raddr) }
+
+func (c *packetConnConn) Close() error                       { return c.pc.Close() }
+func (c *packetConnConn) LocalAddr() net.Addr                { return c.pc.LocalAddr() }
+func (c *packetConnConn) RemoteAddr() net.Addr               { return c.raddr }
+func (c *packetConnConn) SetDeadline(t time.Time) error      { return c.pc.SetDeadline(t) }
+func (c *packetConnConn) SetReadDeadline(t time.Time) error  { return c.pc.SetReadDeadline(t) }
+func (c *packetConnConn) SetWriteDeadline(t time.Time) error { return c.pc.SetWriteDeadline(t) }
+
+// sameAddr reports whether a and b refer to the same address, treating IPv4 and IPv4-mapped IPv6 addresses as equal.
+func sameAddr(a, b net.Addr) bool {
+	switch a := a.(type) {
+	case *net.UDPAddr:
+		if b, ok := b.(*net.UDPAddr); ok {
+			return a.Port == b.Port && a.IP.Equal(b.IP) && a.Zone == b.Zone
+		}
+	case *net.IPAddr:
+		if b, ok := b.(*net.IPAddr); ok {
+			return a.IP.Equal(b.IP) && a.Zone == b.Zone
+		}
+	}
+	return a.Network() == b.Network() && a.String() == b.String()
+}
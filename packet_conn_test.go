@@ -0,0 +1,70 @@
+package netx_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	netx "github.com/pedramktb/go-netx"
+)
+
+func udpSocket(t *testing.T) net.PacketConn {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen packet: %v", err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+	return pc
+}
+
+func TestConnFromPacketConn(t *testing.T) {
+	t.Parallel()
+	a, b, stranger := udpSocket(t), udpSocket(t), udpSocket(t)
+
+	ca := netx.ConnFromPacketConn(a, b.LocalAddr())
+	cb := netx.ConnFromPacketConn(b, a.LocalAddr())
+	if ca.RemoteAddr() != b.LocalAddr() || ca.LocalAddr() != a.LocalAddr() {
+		t.Fatalf("unexpected addrs: local %v remote %v", ca.LocalAddr(), ca.RemoteAddr())
+	}
+
+	// a packet from an unrelated address must be dropped
+	if _, err := stranger.WriteTo([]byte("spoof"), a.LocalAddr()); err != nil {
+		t.Fatalf("stranger write: %v", err)
+	}
+	if _, err := cb.Write([]byte("hello")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = ca.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 64)
+	n, err := ca.Read(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Fatalf("got %q, want %q", buf[:n], "hello")
+	}
+
+	// packet boundaries are preserved in the other direction too
+	for _, m := range []string{"one", "two"} {
+		if _, err := ca.Write([]byte(m)); err != nil {
+			t.Fatalf("write %s: %v", m, err)
+		}
+	}
+	_ = cb.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for _, want := range []string{"one", "two"} {
+		n, err := cb.Read(buf)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if string(buf[:n]) != want {
+			t.Fatalf("got %q, want %q", buf[:n], want)
+		}
+	}
+
+	// deadlines pass through
+	_ = ca.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := ca.Read(buf); err == nil {
+		t.Fatalf("expected timeout")
+	}
+}
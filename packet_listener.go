@@ -0,0 +1,257 @@
+/*
+PacketListener turns a connectionless net.PacketConn, like a UDP socket, into a net.Listener, so that a Server
+can route datagram protocols the same way as streams. Datagrams are dispatched by source address: the first
+datagram from a new address creates a conn that is queued for Accept, and later ones are delivered to its Read.
+Writes to the conn are sent to that address. Every Read returns a single datagram.
+
+Since there is no connection teardown in datagram protocols, conns that receive nothing for the idle timeout are
+closed and their peer gets a new conn with its next datagram. The PacketConn is shared by all conns and closed
+once the listener and all conns are closed. The dispatch follows the ICMP listener, without its batching.
+*/
+
+package netx
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/transport/v3/deadline"
+	"github.com/pion/transport/v3/packetio"
+)
+
+// ErrPacketListenerClosed is returned by Accept once a PacketListener is closed. It wraps net.ErrClosed.
+var ErrPacketListenerClosed = fmt.Errorf("packet listener: %w", net.ErrClosed)
+
+type PacketListenerOption func(*packetListener)
+
+// WithPacketListenerBacklog sets the number of new conns that may wait for Accept. Datagrams from new
+// addresses are dropped while the backlog is full. Default is 128.
+func WithPacketListenerBacklog(n int) PacketListenerOption {
+	return func(l *packetListener) {
+		if n > 0 {
+			l.acceptCh = make(chan *packetListenerConn, n)
+		}
+	}
+}
+
+// WithPacketListenerIdleTimeout sets how long a conn may go without receiving a datagram before it is closed.
+// 0 disables eviction, so conns are only released by closing them.
+// Default is 30s.
+func WithPacketListenerIdleTimeout(d time.Duration) PacketListenerOption {
+	return func(l *packetListener) {
+		l.idleTimeout = d
+	}
+}
+
+type packetListener struct {
+	pc          net.PacketConn
+	idleTimeout time.Duration
+
+	accepting atomic.Bool
+	acceptCh  chan *packetListenerConn
+	doneCh    chan struct{}
+	doneOnce  sync.Once
+
+	connLock sync.Mutex
+	conns    map[string]*packetListenerConn
+	connWG   sync.WaitGroup // the listener and every conn, the PacketConn is closed when it is done
+
+	readDoneCh chan struct{}
+	errRead    atomic.Value // error
+}
+
+// NewPacketListener returns a listener accepting a conn per remote address of the datagrams read from pc,
+// see PacketListener. It takes ownership of pc.
+func NewPacketListener(pc net.PacketConn, opts ...PacketListenerOption) net.Listener {
+	l := &packetListener{
+		pc:          pc,
+		idleTimeout: 30 * time.Second,
+		acceptCh:    make(chan *packetListenerConn, defaultListenBacklog),
+		doneCh:      make(chan struct{}),
+		conns:       make(map[string]*packetListenerConn),
+		readDoneCh:  make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(l)
+	}
+	l.accepting.Store(true)
+	l.connWG.Add(1)
+	go l.readLoop()
+	go func() {
+		l.connWG.Wait()
+		_ = l.pc.Close()
+	}()
+	if l.idleTimeout > 0 {
+		go l.evictLoop()
+	}
+	return l
+}
+
+// Accept waits for and returns the conn of the next new remote address.
+func (l *packetListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.acceptCh:
+		return c, nil
+	case <-l.readDoneCh:
+		err, _ := l.errRead.Load().(error)
+		return nil, err
+	case <-l.doneCh:
+		return nil, ErrPacketListenerClosed
+	}
+}
+
+// Close stops accepting and closes the conns that were not accepted yet.
+// Accepted conns keep working until they are closed.
+func (l *packetListener) Close() error {
+	l.doneOnce.Do(func() {
+		// under the lock, so that no conn is added once the listener is done
+		l.connLock.Lock()
+		l.accepting.Store(false)
+		l.connLock.Unlock()
+		close(l.doneCh)
+	drain:
+		for {
+			select {
+			case c := <-l.acceptCh:
+				_ = c.Close()
+			default:
+				break drain
+			}
+		}
+		l.connWG.Done()
+	})
+	return nil
+}
+
+func (l *packetListener) Addr() net.Addr { return l.pc.LocalAddr() }
+
+// readLoop dispatches datagrams to their conns until the PacketConn is closed.
+func (l *packetListener) readLoop() {
+	defer close(l.readDoneCh)
+	buf := make([]byte, MaxPacketSize)
+	for {
+		n, raddr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			l.errRead.Store(err)
+			return
+		}
+		if c := l.getConn(raddr); c != nil {
+			_, _ = c.buffer.Write(buf[:n])
+		}
+	}
+}
+
+// getConn returns the conn of raddr, creating and queueing it for Accept if needed.
+// It returns nil if the datagram should be dropped.
+func (l *packetListener) getConn(raddr net.Addr) *packetListenerConn {
+	l.connLock.Lock()
+	defer l.connLock.Unlock()
+	if c, ok := l.conns[raddr.String()]; ok {
+		c.lastRead.Store(time.Now().UnixNano())
+		return c
+	}
+	if !l.accepting.Load() {
+		return nil
+	}
+	c := &packetListenerConn{
+		listener:      l,
+		rAddr:         raddr,
+		buffer:        packetio.NewBuffer(),
+		writeDeadline: deadline.New(),
+	}
+	c.lastRead.Store(time.Now().UnixNano())
+	select {
+	case l.acceptCh <- c:
+		l.conns[raddr.String()] = c
+		l.connWG.Add(1)
+		return c
+	default:
+		return nil // backlog full
+	}
+}
+
+// evictLoop closes conns that have not received a datagram for the idle timeout.
+func (l *packetListener) evictLoop() {
+	ticker := time.NewTicker(l.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-l.readDoneCh:
+			return
+		}
+		cutoff := time.Now().Add(-l.idleTimeout).UnixNano()
+		var idle []*packetListenerConn
+		l.connLock.Lock()
+		for _, c := range l.conns {
+			if c.lastRead.Load() < cutoff {
+				idle = append(idle, c)
+			}
+		}
+		l.connLock.Unlock()
+		for _, c := range idle {
+			_ = c.Close()
+		}
+	}
+}
+
+// packetListenerConn is the conn of a remote address of a packetListener.
+type packetListenerConn struct {
+	listener      *packetListener
+	rAddr         net.Addr
+	buffer        *packetio.Buffer
+	lastRead      atomic.Int64 // unix nanos of the last datagram received
+	closeOnce     sync.Once
+	writeDeadline *deadline.Deadline
+}
+
+// Read returns the next datagram of the remote address.
+func (c *packetListenerConn) Read(p []byte) (int, error) {
+	return c.buffer.Read(p)
+}
+
+// Write sends p as a single datagram to the remote address.
+func (c *packetListenerConn) Write(p []byte) (int, error) {
+	select {
+	case <-c.writeDeadline.Done():
+		return 0, context.DeadlineExceeded
+	default:
+	}
+	return c.listener.pc.WriteTo(p, c.rAddr)
+}
+
+// Close releases the conn; a later datagram from the remote address creates a new one.
+func (c *packetListenerConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.listener.connLock.Lock()
+		if c.listener.conns[c.rAddr.String()] == c {
+			delete(c.listener.conns, c.rAddr.String())
+		}
+		c.listener.connLock.Unlock()
+		_ = c.buffer.Close()
+		c.listener.connWG.Done()
+	})
+	return nil
+}
+
+func (c *packetListenerConn) LocalAddr() net.Addr  { return c.listener.pc.LocalAddr() }
+func (c *packetListenerConn) RemoteAddr() net.Addr { return c.rAddr }
+
+func (c *packetListenerConn) SetDeadline(t time.Time) error {
+	c.writeDeadline.Set(t)
+	return c.SetReadDeadline(t)
+}
+
+func (c *packetListenerConn) SetReadDeadline(t time.Time) error {
+	return c.buffer.SetReadDeadline(t)
+}
+
+// SetWriteDeadline only applies to Write, the deadline of the shared PacketConn is not changed.
+func (c *packetListenerConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.Set(t)
+	return nil
+}
@@ -0,0 +1,94 @@
+package netx_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pedramktb/go-netx"
+)
+
+func TestPacketListenerServesUDPPeers(t *testing.T) {
+	t.Parallel()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ln := netx.NewPacketListener(pc, netx.WithPacketListenerIdleTimeout(200*time.Millisecond))
+
+	var s netx.Server[string]
+	s.Logger = &memLogger{}
+	defer s.Close()
+	go func() { _ = s.Serve(context.Background(), ln) }()
+
+	// every accepted conn echoes its datagrams prefixed with its number
+	var accepted atomic.Int32
+	s.SetRoute("echo", func(_ context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+		id := byte('0' + accepted.Add(1))
+		go func() {
+			defer closed()
+			defer conn.Close()
+			buf := make([]byte, 1500)
+			for {
+				n, err := conn.Read(buf)
+				if err != nil {
+					return
+				}
+				if _, err := conn.Write(append([]byte{id}, buf[:n]...)); err != nil {
+					return
+				}
+			}
+		}()
+		return true, conn
+	})
+
+	exchange := func(c net.Conn, msg string) string {
+		t.Helper()
+		_ = c.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := c.Write([]byte(msg)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		buf := make([]byte, 1500)
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if got := string(buf[1:n]); got != msg {
+			t.Fatalf("echo %q, want %q", got, msg)
+		}
+		return string(buf[:1])
+	}
+
+	var peers []net.Conn
+	for range 2 {
+		c, err := net.Dial("udp", pc.LocalAddr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer c.Close()
+		peers = append(peers, c)
+	}
+	first := []string{exchange(peers[0], "a1"), exchange(peers[1], "b1")}
+	if first[0] == first[1] {
+		t.Fatalf("both peers were served by conn %s", first[0])
+	}
+	// later datagrams reach the same conns
+	if got := []string{exchange(peers[0], "a2"), exchange(peers[1], "b2")}; got[0] != first[0] || got[1] != first[1] {
+		t.Fatalf("peers moved from conns %v to %v", first, got)
+	}
+	if n := accepted.Load(); n != 2 {
+		t.Fatalf("accepted %d conns, want 2", n)
+	}
+
+	// an idle peer's conn is evicted, and its next datagram gets a new one
+	time.Sleep(500 * time.Millisecond)
+	if got := exchange(peers[0], "a3"); got == first[0] {
+		t.Fatalf("idle peer still served by conn %s", got)
+	}
+	if n := accepted.Load(); n != 3 {
+		t.Fatalf("accepted %d conns, want 3", n)
+	}
+}
@@ -0,0 +1,41 @@
+package netx
+
+import (
+	"fmt"
+	"net"
+)
+
+// VirtualAddr is implemented by the synthetic remote addresses of conns that share a transport with other
+// conns, like mux and demux sessions, and do not correspond to a peer that packets can be addressed to.
+type VirtualAddr interface {
+	net.Addr
+	Virtual() bool
+}
+
+// RemoteAddrError is returned by PeerAddr when the conn below a layer has no real remote address.
+type RemoteAddrError struct {
+	Layer string
+	Addr  net.Addr // the address reported by the conn, nil if none
+}
+
+func (e *RemoteAddrError) Error() string {
+	if e.Addr == nil {
+		return e.Layer + ": underlying conn has no remote address"
+	}
+	return fmt.Sprintf("%s: underlying conn has the synthetic remote address %q (%s); place %s directly over "+
+		"a conn to its peer", e.Layer, e.Addr.String(), e.Addr.Network(), e.Layer)
+}
+
+// PeerAddr returns the remote address of c, for layers like dtls that address their packets to the peer and
+// would otherwise target the wrong one. If c has no remote address, or only a VirtualAddr, it returns a
+// *RemoteAddrError naming layer.
+func PeerAddr(layer string, c net.Conn) (net.Addr, error) {
+	addr := c.RemoteAddr()
+	if addr == nil {
+		return nil, &RemoteAddrError{Layer: layer}
+	}
+	if va, ok := addr.(VirtualAddr); ok && va.Virtual() {
+		return nil, &RemoteAddrError{Layer: layer, Addr: addr}
+	}
+	return addr, nil
+}
@@ -38,18 +38,12 @@ func init() {
 		for key, value := range params {
 			switch key {
 			case "interval":
-				if listener {
-					return Wrapper{}, fmt.Errorf("poll: interval parameter is only valid for clients")
-				}
 				dur, err := time.ParseDuration(value)
 				if err != nil {
 					return Wrapper{}, fmt.Errorf("poll: invalid interval parameter %q: %w", value, err)
 				}
 				opts = append(opts, WithPollInterval(dur))
 			case "timeout":
-				if !listener {
-					return Wrapper{}, fmt.Errorf("poll: timeout parameter is only valid for servers")
-				}
 				dur, err := time.ParseDuration(value)
 				if err != nil {
 					return Wrapper{}, fmt.Errorf("poll: invalid timeout parameter %q: %w", value, err)
@@ -93,7 +87,7 @@ func init() {
 				return clientConnToConn(c)
 			},
 		}, nil
-	})
+	}, ListenerRules(ForbidParams("interval")), DialerRules(ForbidParams("timeout")))
 }
 
 type pollConnCore struct {
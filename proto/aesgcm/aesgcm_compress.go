@@ -0,0 +1,184 @@
+package aesgcmproto
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pedramktb/go-netx"
+)
+
+// Compression selects the algorithm used to compress plaintext before encryption. See WithCompression.
+type Compression uint8
+
+const (
+	CompressNone  Compression = iota // no compression, no flag byte
+	CompressFlate                    // DEFLATE (RFC 1951)
+	CompressZstd                     // Zstandard
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressNone:
+		return "none"
+	case CompressFlate:
+		return "flate"
+	case CompressZstd:
+		return "zstd"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseCompression parses a compression name as used by the aesgcm driver.
+func ParseCompression(s string) (Compression, error) {
+	switch strings.ToLower(s) {
+	case "none", "":
+		return CompressNone, nil
+	case "flate", "deflate":
+		return CompressFlate, nil
+	case "zstd":
+		return CompressZstd, nil
+	default:
+		return 0, fmt.Errorf("unknown compression %q", s)
+	}
+}
+
+// WithCompression compresses the plaintext of every packet before it is sealed and inflates it after opening.
+// A 1-byte flag is prepended to the plaintext telling whether the packet was compressed;
+// packets that do not shrink are sent uncompressed. Both peers must use the same setting.
+//
+// Compressing before encrypting makes the packet size depend on the content. If an attacker can inject
+// data that is compressed together with secrets, packet sizes leak information about those secrets
+// (CRIME/BREACH style attacks). Only enable this when that is not a concern for the tunneled traffic.
+func WithCompression(kind Compression) Option {
+	return func(c *aesgcmConn) {
+		c.compression = kind
+		c.offer = CompressNone
+	}
+}
+
+// WithNegotiatedCompression offers compression with kind to the peer instead of requiring it as WithCompression
+// does. The offer is sent in a capability byte after the IV in the handshake, and kind is only used if the
+// peer offers the same kind; otherwise packets are sent without compression and without the flag byte.
+// Use the Compression method of the conn to see the outcome.
+//
+// A peer that does not negotiate sends a plain IV and is treated as not supporting compression. Peers from
+// before the capability byte was introduced read it as a stray packet, so both ends need a version that
+// knows it, even if only one of them offers compression. It requires the handshake and cannot be combined
+// with WithExplicitNonce. The traffic-analysis caveat of WithCompression applies.
+func WithNegotiatedCompression(kind Compression) Option {
+	return func(c *aesgcmConn) {
+		c.compression = CompressNone
+		c.offer = kind
+	}
+}
+
+// Compression returns the compression used by the conn, as negotiated with WithNegotiatedCompression.
+func (c *aesgcmConn) Compression() Compression {
+	return c.compression
+}
+
+const (
+	flagRaw        byte = 0
+	flagCompressed byte = 1
+)
+
+var (
+	flateWriters = sync.Pool{New: func() any {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	}}
+	flateReaders = sync.Pool{New: func() any {
+		return flate.NewReader(bytes.NewReader(nil))
+	}}
+	zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+		e, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+		return e
+	})
+	zstdDecoder = sync.OnceValue(func() *zstd.Decoder {
+		d, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(netx.MaxPacketSize))
+		return d
+	})
+)
+
+// compress writes the flag byte followed by the compressed or, if compression does not help, the raw p to dst.
+func (c *aesgcmConn) compress(dst []byte, p []byte) ([]byte, error) {
+	dst = append(dst[:0], flagCompressed)
+	switch c.compression {
+	case CompressFlate:
+		w := flateWriters.Get().(*flate.Writer)
+		defer flateWriters.Put(w)
+		out := bytes.NewBuffer(dst)
+		w.Reset(out)
+		if _, err := w.Write(p); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		dst = out.Bytes()
+	case CompressZstd:
+		dst = zstdEncoder().EncodeAll(p, dst)
+	default:
+		return nil, fmt.Errorf("aesgcmConn: unknown compression %d", c.compression)
+	}
+	if len(dst)-1 >= len(p) {
+		dst = append(dst[:1], p...)
+		dst[0] = flagRaw
+	}
+	return dst, nil
+}
+
+// decompress interprets the flag byte of pt and returns the payload, inflating it into dst if needed.
+// dst must have a length of netx.MaxPacketSize, which bounds the inflated size.
+func (c *aesgcmConn) decompress(dst []byte, pt []byte) ([]byte, error) {
+	if len(pt) < 1 {
+		return nil, errors.New("aesgcmConn: missing compression flag")
+	}
+	flag, data := pt[0], pt[1:]
+	switch flag {
+	case flagRaw:
+		return data, nil
+	case flagCompressed:
+	default:
+		return nil, fmt.Errorf("aesgcmConn: invalid compression flag %d", flag)
+	}
+	switch c.compression {
+	case CompressFlate:
+		r := flateReaders.Get().(io.ReadCloser)
+		defer flateReaders.Put(r)
+		if err := r.(flate.Resetter).Reset(bytes.NewReader(data), nil); err != nil {
+			return nil, err
+		}
+		n, err := io.ReadFull(r, dst)
+		switch err {
+		case io.EOF, io.ErrUnexpectedEOF:
+			return dst[:n], nil
+		case nil:
+			var one [1]byte
+			if m, _ := r.Read(one[:]); m > 0 {
+				return nil, errors.New("aesgcmConn: decompressed packet too large")
+			}
+			return dst[:n], nil
+		default:
+			return nil, err
+		}
+	case CompressZstd:
+		out, err := zstdDecoder().DecodeAll(data, dst[:0])
+		if err != nil {
+			return nil, err
+		}
+		if len(out) > netx.MaxPacketSize {
+			return nil, errors.New("aesgcmConn: decompressed packet too large")
+		}
+		return out, nil
+	default:
+		return nil, errors.New("aesgcmConn: received compressed packet but compression is disabled")
+	}
+}
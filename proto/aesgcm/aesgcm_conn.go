@@ -2,6 +2,8 @@
 AESGCMConn is a network layer that provides authenticated encryption using AES-GCM.
 Key must be 16, 24, or 32 bytes (AES-128/192/256).
 It assumes the underlying conn preserves packet boundaries; it does not perform additional framing.
+Short writes of the underlying conn are completed by writing the rest of the packet, so conns that take a packet
+in several writes, like a stream below a frame layer that does not loop itself, work as well.
 Packet layout (single datagram):
 
 	[8-byte seq big-endian][GCM(ciphertext||tag)]
@@ -10,17 +12,55 @@ Nonce derivation: 12-byte IV is required. For a packet with sequence S,
 nonce = IV with its last 8 bytes XORed with S (big-endian). This ensures
 per-packet unique nonces without transmitting the full nonce.
 Write IV is randomly generated on creation and sent to the peer in the
-passive handshake that is performed on creation to exchange random IVs.
+passive handshake that is performed on creation to exchange random IVs, or with NewAESGCMConnDeferred on
+HandshakeContext or the first I/O, so many conns can be created first and handshaken concurrently.
+The handshake message starts with a version byte, so future format changes are detected
+instead of silently misinterpreted:
+
+	[version][12-byte IV][optional capability byte]
+
+WithLegacyHandshake omits the version byte, for peers that predate it.
+
+NewAESGCMConnRoled derives a separate key per direction from a master key, so a packet reflected back
+to its sender does not decrypt.
+
+With WithExplicitNonce, no handshake takes place and every packet carries its own random nonce instead:
+
+	[12-byte nonce][GCM(ciphertext||tag)]
+
+This costs 4 more bytes per packet but needs no state shared with the peer, which suits strictly
+one-shot request-response transports. Random 96-bit nonces should not be used for more than 2^32
+packets under the same key.
+
+With WithRecordLength, the header ends with the 2-byte big-endian length of the whole packet, which is
+authenticated with the rest of the header, so a packet cut short on the way is reported as truncated:
+
+	[8-byte seq big-endian][2-byte length][GCM(ciphertext||tag)]
+
+With WithCompression, the plaintext is prefixed with a 1-byte flag and compressed before sealing:
+
+	[flag (0 = raw, 1 = compressed)][payload]
+
+See WithCompression for the traffic-analysis caveat of compressing before encrypting.
+With WithNegotiatedCompression, compression is offered in a capability byte appended to the IV in the
+handshake and only used if the peer offers the same algorithm.
+
+WithAEAD(AEADAESGCMSIV) seals packets with AES-GCM-SIV instead, keeping the packet layout and nonce
+derivation, for deployments that cannot guarantee unique nonces.
 */
 
 package aesgcmproto
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hkdf"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"sync"
@@ -32,28 +72,171 @@ import (
 
 type aesgcmConn struct {
 	net.Conn
-	aead cipher.AEAD
-	wiv  [12]byte
-	riv  [12]byte
+	// io is held for reading by Read, Write and Flush, and for writing by Reset, which swaps Conn
+	io sync.RWMutex
+	// connMu guards Conn and maxWrite for the methods that do not hold io
+	connMu sync.Mutex
+	raead  cipher.AEAD // opens received packets
+	waead  cipher.AEAD // seals written packets
+	wiv    [12]byte
+	riv    [12]byte
 	// sequence number for nonce derivation, incremented atomically
-	seq      atomic.Uint64
-	buf      sync.Pool
-	maxWrite uint16
+	seq           atomic.Uint64
+	keepSeq       bool // see WithInitialSeq
+	legacy        bool // see WithLegacyHandshake
+	buf           sync.Pool
+	maxWrite      uint16
+	explicitNonce bool
+	recordLength  bool // see WithRecordLength
+	compression   Compression
+	offer         Compression // see WithNegotiatedCompression
+	aead          AEAD
+	replay        *replayWindow // nil unless WithReplayWindow is set
+	maxPeers      int           // see WithMaxPeers
+
+	// message state, see MessageConn
+	msgID        atomic.Uint32
+	msgMu        sync.Mutex
+	pending      map[uint32]*partialMessage
+	pendingOrder []uint32 // IDs of pending, oldest first
+
+	// handshake state, see NewAESGCMConnDeferred
+	handshaken atomic.Bool
+	hsMu       sync.Mutex
+	hsErr      error // the result of the deferred handshake once it ran
+}
+
+type Option func(*aesgcmConn)
+
+// HandshakeVersion is the version of the handshake sent in its first byte.
+const HandshakeVersion byte = 1
+
+// HandshakeVersionError is returned by the constructors when the peer's handshake has an unknown version,
+// or none at all because it uses the legacy handshake. Peer is 0 for a legacy peer.
+type HandshakeVersionError struct {
+	Peer byte
+}
+
+func (e *HandshakeVersionError) Error() string {
+	if e.Peer == 0 {
+		return "aesgcm: peer sent a legacy handshake without version, both ends must use WithLegacyHandshake"
+	}
+	return fmt.Sprintf("aesgcm: peer handshake version %d is not supported, want %d", e.Peer, HandshakeVersion)
+}
+
+// WithLegacyHandshake omits the version byte from the handshake, to interoperate with peers that predate it.
+// Both peers must use the same setting. It has no effect with WithExplicitNonce, which has no handshake.
+func WithLegacyHandshake(enabled bool) Option {
+	return func(c *aesgcmConn) {
+		c.legacy = enabled
+	}
+}
+
+// WithExplicitNonce makes every packet carry a fresh random 12-byte nonce in the clear
+// instead of deriving nonces from IVs exchanged in a handshake. The handshake is skipped entirely.
+// Both peers must use the same setting.
+func WithExplicitNonce(enabled bool) Option {
+	return func(c *aesgcmConn) {
+		c.explicitNonce = enabled
+	}
+}
+
+// ErrTruncatedRecord is returned by reads with WithRecordLength for a packet shorter than the length in its
+// header, or one the underlying conn could only read in part, e.g. a frame cut off by a closed connection.
+var ErrTruncatedRecord = errors.New("aesgcm: truncated record")
+
+// WithRecordLength adds the length of every packet to its header, which is authenticated as associated data,
+// so reads tell a packet cut short on the way, e.g. by an attacker truncating the stream below a frame layer,
+// apart from a corrupted one and fail with ErrTruncatedRecord. A packet that is longer than its length fails
+// as well. Packets are never returned in part either way, since the tag covers the whole ciphertext; this
+// only makes the error precise. It costs 2 bytes per packet, and both peers must use the same setting.
+func WithRecordLength(enabled bool) Option {
+	return func(c *aesgcmConn) {
+		c.recordLength = enabled
+	}
+}
+
+// WithInitialSeq sets the sequence number of the first packet written, 0 by default, e.g. to continue the
+// sequence of a previous conn under the same key, as read with CurrentSeq, when resuming a tunnel.
+// It also makes Reset continue the sequence instead of starting over at 0.
+// Every conn exchanges fresh random IVs in its handshake, so the nonces of a new conn do not repeat those of
+// an earlier one even if both start at 0. Without the handshake, that is with WithExplicitNonce, packets
+// carry random nonces and the sequence is unused. The peer reads the sequence from each packet, so it needs
+// no coordination, but both ends of a resumed tunnel should carry their sequence over or use fresh keys.
+func WithInitialSeq(seq uint64) Option {
+	return func(c *aesgcmConn) {
+		c.seq.Store(seq)
+		c.keepSeq = true
+	}
 }
 
 // NewAESGCMConn creates a new AESGCMConn wrapping the provided net.Conn with the given key.
-func NewAESGCMConn(conn net.Conn, key []byte) (net.Conn, error) {
+func NewAESGCMConn(conn net.Conn, key []byte, opts ...Option) (net.Conn, error) {
+	return newAESGCMConn(conn, key, key, opts...)
+}
+
+// NewAESGCMConnRoled creates a new AESGCMConn that uses a separate key per direction.
+// Both keys are derived from masterKey with HKDF-SHA256 and have its length; the client writes with the
+// "c2s" key and reads with the "s2c" key, and the server the other way around. Since a packet only opens
+// with the key of the opposite direction, packets reflected back to their sender are rejected.
+// Peers must use opposite roles.
+func NewAESGCMConnRoled(conn net.Conn, masterKey []byte, isClient bool, opts ...Option) (net.Conn, error) {
+	c2s, err := hkdf.Key(sha256.New, masterKey, nil, "netx aesgcm c2s", len(masterKey))
+	if err != nil {
+		return nil, err
+	}
+	s2c, err := hkdf.Key(sha256.New, masterKey, nil, "netx aesgcm s2c", len(masterKey))
+	if err != nil {
+		return nil, err
+	}
+	if isClient {
+		return newAESGCMConn(conn, s2c, c2s, opts...)
+	}
+	return newAESGCMConn(conn, c2s, s2c, opts...)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
-	a, err := cipher.NewGCM(block)
+	return cipher.NewGCM(block)
+}
+
+// NewAEADConnWith creates a conn like NewAESGCMConn that seals and opens packets with aead instead of an AES
+// cipher selected by WithAEAD, e.g. to try a custom or hardware-backed cipher. The packet layout, handshake and
+// nonce derivation are the same, so aead must take 12-byte nonces. Both peers must use the same AEAD and key.
+// The AEAD is used for both directions; WithAEAD has no effect.
+func NewAEADConnWith(conn net.Conn, aead cipher.AEAD, opts ...Option) (net.Conn, error) {
+	if aead.NonceSize() != 12 {
+		return nil, fmt.Errorf("aesgcm: AEAD nonce size is %d bytes, want 12", aead.NonceSize())
+	}
+	agc, err := newState(opts...)
+	if err != nil {
+		return nil, err
+	}
+	agc.raead, agc.waead = aead, aead
+	return agc.start(conn)
+}
+
+// newAESGCMState sets up the ciphers and options of a conn, without its underlying conn.
+func newAESGCMState(rkey, wkey []byte, opts ...Option) (*aesgcmConn, error) {
+	agc, err := newState(opts...)
 	if err != nil {
 		return nil, err
 	}
+	if agc.raead, err = agc.aead.new(rkey); err != nil {
+		return nil, err
+	}
+	if agc.waead, err = agc.aead.new(wkey); err != nil {
+		return nil, err
+	}
+	return agc, nil
+}
+
+// newState applies and validates the options of a conn, without its ciphers and underlying conn.
+func newState(opts ...Option) (*aesgcmConn, error) {
 	agc := &aesgcmConn{
-		Conn: conn,
-		aead: a,
 		buf: sync.Pool{
 			New: func() any {
 				b := make([]byte, netx.MaxPacketSize)
@@ -61,120 +244,433 @@ func NewAESGCMConn(conn net.Conn, key []byte) (net.Conn, error) {
 			},
 		},
 	}
-	if mw, ok := conn.(interface{ MaxWrite() uint16 }); ok && mw.MaxWrite() != 0 {
-		if mw.MaxWrite() <= uint16(8+a.Overhead()) {
-			return nil, errors.New("aesgcm: underlying connection's MaxWrite is too small")
+	for _, o := range opts {
+		o(agc)
+	}
+	if agc.replay != nil && agc.explicitNonce {
+		return nil, errors.New("aesgcm: replay window requires derived nonces")
+	}
+	if agc.offer != CompressNone && agc.explicitNonce {
+		return nil, errors.New("aesgcm: compression negotiation requires derived nonces")
+	}
+	return agc, nil
+}
+
+// newAESGCMConn creates the conn with the keys for reading and writing, which may be the same.
+func newAESGCMConn(conn net.Conn, rkey, wkey []byte, opts ...Option) (net.Conn, error) {
+	agc, err := newAESGCMState(rkey, wkey, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return agc.start(conn)
+}
+
+// start attaches the conn to its underlying conn and performs the handshake.
+func (c *aesgcmConn) start(conn net.Conn) (net.Conn, error) {
+	c.Conn = conn
+	if !c.explicitNonce {
+		if err := c.handshake(context.Background()); err != nil {
+			return nil, err
 		}
-		agc.maxWrite = mw.MaxWrite() - uint16(8+a.Overhead())
 	}
-	if _, err := io.ReadFull(rand.Reader, agc.wiv[:]); err != nil {
+	c.handshaken.Store(true)
+	// after the handshake, which may have settled the compression
+	var err error
+	if c.maxWrite, err = c.maxWriteOf(conn); err != nil {
 		return nil, err
 	}
+	return c, nil
+}
+
+// maxWriteOf returns the MaxWrite for the underlying conn, 0 if it has no limit.
+func (c *aesgcmConn) maxWriteOf(conn net.Conn) (uint16, error) {
+	mw, ok := conn.(interface{ MaxWrite() uint16 })
+	if !ok || mw.MaxWrite() == 0 {
+		return 0, nil
+	}
+	if mw.MaxWrite() <= uint16(c.overhead()) {
+		return 0, errors.New("aesgcm: underlying connection's MaxWrite is too small")
+	}
+	return mw.MaxWrite() - uint16(c.overhead()), nil
+}
+
+// Reset makes the conn continue over newConn, e.g. after the transport below was reconnected, and repeats
+// the handshake on it, so both ends must reset at the same time. The write sequence starts over at 0, as
+// fresh IVs are exchanged, unless WithInitialSeq was set, in which case it continues. The replay window is
+// cleared along with partially received messages, and the compression is negotiated again. The old conn is not closed.
+//
+// Reset waits for in-flight Read, Write and Flush calls to return, so the old conn should be closed or
+// its deadline expired first. If the handshake fails, the conn is unusable until a Reset succeeds.
+// It can be reached through interface{ Reset(net.Conn) error }.
+func (c *aesgcmConn) Reset(newConn net.Conn) error {
+	c.io.Lock()
+	defer c.io.Unlock()
+	c.connMu.Lock()
+	c.Conn = newConn
+	c.maxWrite = 0
+	c.connMu.Unlock()
+
+	if !c.keepSeq {
+		c.seq.Store(0)
+	}
+	if c.replay != nil {
+		c.replay = newReplayWindow(uint32(c.replay.size))
+	}
+	c.msgMu.Lock()
+	c.pending, c.pendingOrder = nil, nil
+	c.msgMu.Unlock()
+	if !c.explicitNonce {
+		if c.offer != CompressNone {
+			c.compression = CompressNone
+		}
+		err := c.handshake(context.Background())
+		c.hsMu.Lock()
+		c.hsErr = err
+		c.hsMu.Unlock()
+		c.handshaken.Store(true)
+		if err != nil {
+			return err
+		}
+	}
+	maxWrite, err := c.maxWriteOf(newConn)
+	if err != nil {
+		return err
+	}
+	c.connMu.Lock()
+	c.maxWrite = maxWrite
+	c.connMu.Unlock()
+	return nil
+}
+
+// conn returns the current underlying conn, see Reset.
+func (c *aesgcmConn) conn() net.Conn {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	return c.Conn
+}
+
+func (c *aesgcmConn) Close() error                       { return c.conn().Close() }
+func (c *aesgcmConn) LocalAddr() net.Addr                { return c.conn().LocalAddr() }
+func (c *aesgcmConn) RemoteAddr() net.Addr               { return c.conn().RemoteAddr() }
+func (c *aesgcmConn) SetDeadline(t time.Time) error      { return c.conn().SetDeadline(t) }
+func (c *aesgcmConn) SetReadDeadline(t time.Time) error  { return c.conn().SetReadDeadline(t) }
+func (c *aesgcmConn) SetWriteDeadline(t time.Time) error { return c.conn().SetWriteDeadline(t) }
+
+// handshake exchanges the version and IVs with the peer, followed by the compression offer if there is one.
+// Canceling ctx aborts it through the deadline of the underlying conn.
+func (c *aesgcmConn) handshake(ctx context.Context) (err error) {
+	conn := c.Conn
+	if _, err := io.ReadFull(rand.Reader, c.wiv[:]); err != nil {
+		return err
+	}
+	msg := make([]byte, 0, 2+len(c.wiv))
+	if !c.legacy {
+		msg = append(msg, HandshakeVersion)
+	}
+	msg = append(msg, c.wiv[:]...)
+	if c.offer != CompressNone {
+		msg = append(msg, byte(c.offer))
+	}
 
 	// Passive handshake (duplex): concurrently read peer IV while writing ours
 	handshakeDeadline := time.Now().Add(5 * time.Second)
 	_ = conn.SetDeadline(handshakeDeadline)
 	defer func() { _ = conn.SetDeadline(time.Time{}) }() // clear deadline after handshake
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer func() {
+		if !stop() && err != nil {
+			err = ctx.Err()
+		}
+	}()
 
-	// Start read of peer's 12-byte IV
+	// Start read of peer's version, 12-byte IV and optional capability byte, which arrive in one packet
+	var peerOffer Compression
 	readErrCh := make(chan error, 1)
 	go func() {
-		// io.ReadFull returns err if not enough bytes
-		_, err := io.ReadFull(conn, agc.riv[:])
-		readErrCh <- err
+		var in [len(c.riv) + 2]byte
+		n := 0
+		for n < len(c.riv) {
+			m, err := conn.Read(in[n:])
+			if err != nil {
+				readErrCh <- err
+				return
+			}
+			n += m
+		}
+		msg := in[:n]
+		if !c.legacy {
+			if n == len(c.riv) {
+				readErrCh <- &HandshakeVersionError{}
+				return
+			}
+			if msg[0] != HandshakeVersion {
+				readErrCh <- &HandshakeVersionError{Peer: msg[0]}
+				return
+			}
+			msg = msg[1:]
+		}
+		copy(c.riv[:], msg)
+		if len(msg) > len(c.riv) {
+			peerOffer = Compression(msg[len(c.riv)])
+		}
+		readErrCh <- nil
 	}()
 
-	// Write our 12-byte IV
-	o := 0
-	for o < len(agc.wiv) {
-		n, err := conn.Write(agc.wiv[o:])
-		if err != nil {
-			return nil, err
-		}
-		o += n
-	}
-	if o != len(agc.wiv) {
-		return nil, io.ErrShortWrite
+	// Write our handshake message
+	if err := writeFull(conn, msg); err != nil {
+		return err
 	}
 
 	// Wait for read to complete
 	if err := <-readErrCh; err != nil {
-		return nil, err
+		return err
 	}
-
-	return agc, nil
+	if c.offer != CompressNone && peerOffer == c.offer {
+		c.compression = c.offer
+	}
+	return nil
 }
 
 func (c *aesgcmConn) MaxWrite() uint16 {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
 	return c.maxWrite
 }
 
+// CurrentSeq returns the sequence number of the next packet written, to be persisted and passed to
+// WithInitialSeq when resuming. It can be reached through interface{ CurrentSeq() uint64 }.
+func (c *aesgcmConn) CurrentSeq() uint64 {
+	return c.seq.Load()
+}
+
+// headerLen returns the length of the clear header preceding the ciphertext, which is also used as AAD.
+func (c *aesgcmConn) headerLen() int {
+	n := 8
+	if c.explicitNonce {
+		n = 12
+	}
+	if c.recordLength {
+		n += 2
+	}
+	return n
+}
+
+// sealOverhead returns the number of bytes sealing adds on top of the plaintext, including the header.
+func (c *aesgcmConn) sealOverhead() int {
+	return c.headerLen() + c.waead.Overhead()
+}
+
+// overhead returns the number of bytes a packet adds on top of the payload in the worst case. Before a deferred
+// handshake, that includes the compression flag if compression may be negotiated.
+func (c *aesgcmConn) overhead() int {
+	if c.compression != CompressNone || c.offer != CompressNone && !c.handshaken.Load() {
+		return c.sealOverhead() + 1
+	}
+	return c.sealOverhead()
+}
+
+// nonce returns the nonce for a packet with the given header.
+func (c *aesgcmConn) nonce(iv *[12]byte, header []byte) [12]byte {
+	nonce := [12]byte{}
+	if c.explicitNonce {
+		copy(nonce[:], header)
+		return nonce
+	}
+	copy(nonce[:], iv[:])
+	for i := range 8 {
+		nonce[4+i] ^= header[i]
+	}
+	return nonce
+}
+
 // Read reads and decrypts a single datagram from the underlying conn.
 // If p is too small for the decrypted payload, io.ErrShortBuffer is returned.
 func (c *aesgcmConn) Read(p []byte) (int, error) {
+	n, _, _, err := c.ReadSeq(p)
+	return n, err
+}
+
+// ReadSeq is like Read, but also returns the sequence number of the packet and whether it was reordered.
+// See SeqReader.
+func (c *aesgcmConn) ReadSeq(p []byte) (n int, seq uint64, reordered bool, err error) {
+	if err := c.ensureHandshake(); err != nil {
+		return 0, 0, false, err
+	}
+	c.io.RLock()
+	defer c.io.RUnlock()
 	bp := c.buf.Get().(*[]byte)
 	buf := *bp
 	defer c.buf.Put(bp)
 
+	if n, err = c.readPacket(buf); err != nil {
+		return 0, 0, false, err
+	}
+	return c.open(p, buf[:n])
+}
+
+// readPacket reads a single packet from the underlying conn into buf, which must hold netx.MaxPacketSize bytes.
+// Caller must hold io for reading.
+func (c *aesgcmConn) readPacket(buf []byte) (int, error) {
 	n, err := c.Conn.Read(buf)
 	if err != nil {
+		if c.recordLength && errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, fmt.Errorf("%w: %w", ErrTruncatedRecord, err)
+		}
 		return 0, err
 	}
 	if n == netx.MaxPacketSize {
 		return 0, errors.New("aesgcmConn: packet too large")
 	}
-	if n < 8+c.aead.Overhead() {
-		return 0, errors.New("aesgcmConn: packet too small")
+	return n, nil
+}
+
+// open authenticates and decrypts the packet pkt in place and copies its payload to p.
+func (c *aesgcmConn) open(p, pkt []byte) (n int, seq uint64, reordered bool, err error) {
+	return c.openExtra(p, pkt, 0)
+}
+
+// openExtra is open for a packet with extra clear bytes after the header, which are authenticated along with it.
+func (c *aesgcmConn) openExtra(p, pkt []byte, extra int) (n int, seq uint64, reordered bool, err error) {
+	hdr := c.headerLen()
+	if c.recordLength && len(pkt) >= hdr {
+		switch length := int(binary.BigEndian.Uint16(pkt[hdr-2 : hdr])); {
+		case len(pkt) < length:
+			return 0, 0, false, ErrTruncatedRecord
+		case len(pkt) > length:
+			return 0, 0, false, errors.New("aesgcmConn: packet longer than its record length")
+		}
+	}
+	// A packet of exactly the overhead carries an empty payload and yields a zero-length read.
+	if len(pkt) < c.overhead()+extra {
+		return 0, 0, false, errors.New("aesgcmConn: packet too small")
 	}
 
-	nonce := [12]byte{}
-	copy(nonce[:], c.riv[:])
-	for i := range 8 {
-		nonce[4+i] ^= buf[i]
+	nonce := c.nonce(&c.riv, pkt[:hdr])
+	if !c.explicitNonce {
+		seq = binary.BigEndian.Uint64(pkt[:hdr])
 	}
 
-	buf, err = c.aead.Open(buf[8:8], nonce[:], buf[8:n], buf[:8])
+	aad := hdr + extra
+	buf, err := c.raead.Open(pkt[aad:aad], nonce[:], pkt[aad:], pkt[:aad])
 	if err != nil {
-		return 0, err
+		return 0, 0, false, err
+	}
+	if c.replay != nil {
+		if reordered, err = c.replay.check(seq); err != nil {
+			return 0, seq, false, err
+		}
+	}
+	if c.compression != CompressNone {
+		sp := c.buf.Get().(*[]byte)
+		defer c.buf.Put(sp)
+		if buf, err = c.decompress(*sp, buf); err != nil {
+			return 0, seq, reordered, err
+		}
 	}
 
 	if len(buf) > len(p) {
-		return 0, io.ErrShortBuffer
+		return 0, seq, reordered, io.ErrShortBuffer
 	}
 
 	copy(p, buf)
-	return len(buf), nil
+	return len(buf), seq, reordered, nil
 }
 
-// Write encrypts p as a single datagram and writes it to the underlying conn.
-// It prepends an 8-byte sequence number used for nonce derivation.
+// Write encrypts p as a single datagram and writes it to the underlying conn, completing short writes.
+// It prepends an 8-byte sequence number used for nonce derivation, or the random nonce in explicit nonce mode.
+// With compression, the size limit applies to the compressed payload.
 func (c *aesgcmConn) Write(p []byte) (int, error) {
-	if len(p)+8+c.aead.Overhead() > netx.MaxPacketSize {
-		return 0, errors.New("aesgcmConn: packet too large")
+	if err := c.ensureHandshake(); err != nil {
+		return 0, err
 	}
+	c.io.RLock()
+	defer c.io.RUnlock()
 	bp := c.buf.Get().(*[]byte)
-	buf := *bp
 	defer c.buf.Put(bp)
+	buf, err := c.seal(*bp, p)
+	if err != nil {
+		return 0, err
+	}
 
-	seq := c.seq.Add(1) - 1
-	binary.BigEndian.PutUint64(buf[:8], seq)
+	if err := writeFull(c.Conn, buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
 
-	nonce := [12]byte{}
-	copy(nonce[:], c.wiv[:])
-	for i := range 8 {
-		nonce[4+i] ^= buf[i]
+// writeFull writes all of b to conn, writing the rest again after a short write. It fails with
+// io.ErrShortWrite if conn makes no progress.
+func writeFull(conn net.Conn, b []byte) error {
+	for len(b) > 0 {
+		n, err := conn.Write(b)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrShortWrite
+		}
+		b = b[n:]
 	}
+	return nil
+}
 
-	ct := c.aead.Seal(buf[8:8], nonce[:], p, buf[:8])
-	buf = buf[:8+len(ct)]
+// seal encrypts p into a packet in buf, which must hold netx.MaxPacketSize bytes, and returns the packet.
+func (c *aesgcmConn) seal(buf, p []byte) ([]byte, error) {
+	return c.sealExtra(buf, nil, p)
+}
 
-	n, err := c.Conn.Write(buf)
-	if err != nil {
-		return 0, err
+// sealExtra is seal with extra clear bytes after the header, which are authenticated along with it.
+func (c *aesgcmConn) sealExtra(buf, extra, p []byte) ([]byte, error) {
+	pt := p
+	if c.compression != CompressNone {
+		if len(p) > netx.MaxPacketSize {
+			return nil, errors.New("aesgcmConn: packet too large")
+		}
+		sp := c.buf.Get().(*[]byte)
+		defer c.buf.Put(sp)
+		var err error
+		if pt, err = c.compress(*sp, p); err != nil {
+			return nil, err
+		}
 	}
-	if n != len(buf) {
-		return 0, io.ErrShortWrite
+	if len(pt)+c.sealOverhead()+len(extra) > netx.MaxPacketSize {
+		return nil, errors.New("aesgcmConn: packet too large")
 	}
 
-	return len(p), nil
+	hdr := c.headerLen()
+	if c.explicitNonce {
+		if _, err := io.ReadFull(rand.Reader, buf[:hdr]); err != nil {
+			return nil, err
+		}
+	} else {
+		seq := c.seq.Add(1) - 1
+		binary.BigEndian.PutUint64(buf[:hdr], seq)
+	}
+	if c.recordLength {
+		binary.BigEndian.PutUint16(buf[hdr-2:hdr], uint16(hdr+len(extra)+len(pt)+c.waead.Overhead()))
+	}
+	nonce := c.nonce(&c.wiv, buf[:hdr])
+
+	aad := hdr + copy(buf[hdr:], extra)
+	ct := c.waead.Seal(buf[aad:aad], nonce[:], pt, buf[:aad])
+	return buf[:aad+len(ct)], nil
+}
+
+// Flush flushes the underlying conn if it buffers writes (e.g. a netx.BufConn), so sealed packets are sent.
+// It is a no-op otherwise.
+func (c *aesgcmConn) Flush() error {
+	c.io.RLock()
+	defer c.io.RUnlock()
+	if f, ok := c.Conn.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// CloseWrite half-closes the underlying conn if it supports it, and returns errors.ErrUnsupported otherwise.
+func (c *aesgcmConn) CloseWrite() error {
+	if cw, ok := c.conn().(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.ErrUnsupported
 }
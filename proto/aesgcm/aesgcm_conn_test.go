@@ -2,8 +2,16 @@ package aesgcmproto_test
 
 import (
 	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"io"
 	"net"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -108,6 +116,62 @@ func TestAESGCM_EmptyPayload(t *testing.T) {
 	}
 }
 
+func TestAESGCM_EmptyPayloadSizeBoundary(t *testing.T) {
+	// relay frames from client to server so packets can be measured and injected
+	cr, mr := net.Pipe()
+	ms, sr := net.Pipe()
+	t.Cleanup(func() { _ = cr.Close(); _ = mr.Close(); _ = ms.Close(); _ = sr.Close() })
+	fromClient, toServer := netx.NewFrameConn(mr), netx.NewFrameConn(ms)
+	packets := make(chan []byte, 16)
+	relay := func(src, dst net.Conn, tap chan<- []byte) {
+		buf := make([]byte, 2048)
+		for {
+			n, err := src.Read(buf)
+			if err != nil {
+				return
+			}
+			if tap != nil {
+				tap <- append([]byte(nil), buf[:n]...)
+			}
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+	}
+	go relay(fromClient, toServer, packets)
+	go relay(toServer, fromClient, nil)
+
+	key := bytes.Repeat([]byte{0x42}, 32)
+	var c, s net.Conn
+	var ec, es error
+	done := make(chan struct{}, 2)
+	go func() { c, ec = aesgcmproto.NewAESGCMConn(netx.NewFrameConn(cr), key); done <- struct{}{} }()
+	go func() { s, es = aesgcmproto.NewAESGCMConn(netx.NewFrameConn(sr), key); done <- struct{}{} }()
+	<-done
+	<-done
+	if ec != nil || es != nil {
+		t.Fatalf("aesgcm: %v, %v", ec, es)
+	}
+	<-packets // client IV
+
+	// an empty payload is exactly the 8-byte sequence number plus the GCM tag
+	const minPacket = 8 + 16
+	go func() { _, _ = c.Write(nil) }()
+	buf := make([]byte, 8)
+	if n, err := s.Read(buf); err != nil || n != 0 {
+		t.Fatalf("want zero-length read, got n=%d err=%v", n, err)
+	}
+	if pkt := <-packets; len(pkt) != minPacket {
+		t.Fatalf("empty payload packet is %d bytes, want %d", len(pkt), minPacket)
+	}
+
+	// one byte less cannot hold a tag and must be rejected
+	go func() { _, _ = toServer.Write(make([]byte, minPacket-1)) }()
+	if _, err := s.Read(buf); err == nil || !strings.Contains(err.Error(), "packet too small") {
+		t.Fatalf("want packet too small error, got %v", err)
+	}
+}
+
 func TestAESGCM_ShortBufferDropsPacket(t *testing.T) {
 	c, s := newAESPair(t)
 
@@ -185,3 +249,941 @@ func TestAESGCM_DecryptErrorWrongKey(t *testing.T) {
 	}
 	<-writeDone
 }
+
+// msgConn is a one-way, stateless message pipe: writes are queued as whole datagrams and reads pop them.
+// Nothing written by the reading side ever reaches the writer, so no handshake can complete over it.
+type msgConn struct {
+	net.Conn
+	msgs chan []byte
+}
+
+func (c *msgConn) Write(p []byte) (int, error) {
+	c.msgs <- bytes.Clone(p)
+	return len(p), nil
+}
+
+func (c *msgConn) Read(p []byte) (int, error) {
+	m := <-c.msgs
+	if len(m) > len(p) {
+		return 0, io.ErrShortBuffer
+	}
+	return copy(p, m), nil
+}
+
+func TestAESGCM_ExplicitNonceStateless(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	pipe := &msgConn{msgs: make(chan []byte, 4)}
+
+	// the writer is created and used before the reader even exists
+	w, err := aesgcmproto.NewAESGCMConn(pipe, key, aesgcmproto.WithExplicitNonce(true))
+	if err != nil {
+		t.Fatalf("writer: %v", err)
+	}
+	msgs := [][]byte{[]byte("first"), []byte("second"), {}}
+	for _, m := range msgs {
+		if _, err := w.Write(m); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	r, err := aesgcmproto.NewAESGCMConn(pipe, key, aesgcmproto.WithExplicitNonce(true))
+	if err != nil {
+		t.Fatalf("reader: %v", err)
+	}
+	buf := make([]byte, 64)
+	for _, m := range msgs {
+		n, err := r.Read(buf)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if !bytes.Equal(buf[:n], m) {
+			t.Fatalf("got %q, want %q", buf[:n], m)
+		}
+	}
+
+	// identical plaintexts must not produce identical packets
+	_, _ = w.Write([]byte("same"))
+	_, _ = w.Write([]byte("same"))
+	if a, b := <-pipe.msgs, <-pipe.msgs; bytes.Equal(a, b) || len(a) != 12+4+16 {
+		t.Fatalf("unexpected explicit nonce packets: %x %x", a, b)
+	}
+
+	// tampering with the nonce must fail authentication
+	_, _ = w.Write([]byte("tamper"))
+	m := <-pipe.msgs
+	m[0] ^= 0xff
+	pipe.msgs <- m
+	if _, err := r.Read(buf); err == nil {
+		t.Fatalf("expected decrypt error for tampered nonce")
+	}
+}
+
+// openExplicit decrypts an explicit nonce packet with key and returns its plaintext, including the compression flag.
+func openExplicit(t *testing.T, key, pkt []byte) []byte {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("cipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("gcm: %v", err)
+	}
+	pt, err := aead.Open(nil, pkt[:12], pkt[12:], pkt[:12])
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	return pt
+}
+
+func TestAESGCM_Compression(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	random := make([]byte, 4096)
+	_, _ = rand.Read(random)
+	compressible := bytes.Repeat([]byte("compress me "), 512)
+
+	for _, kind := range []aesgcmproto.Compression{aesgcmproto.CompressFlate, aesgcmproto.CompressZstd} {
+		t.Run(kind.String(), func(t *testing.T) {
+			pipe := &msgConn{msgs: make(chan []byte, 1)}
+			opts := []aesgcmproto.Option{aesgcmproto.WithExplicitNonce(true), aesgcmproto.WithCompression(kind)}
+			w, err := aesgcmproto.NewAESGCMConn(pipe, key, opts...)
+			if err != nil {
+				t.Fatalf("writer: %v", err)
+			}
+			r, err := aesgcmproto.NewAESGCMConn(pipe, key, opts...)
+			if err != nil {
+				t.Fatalf("reader: %v", err)
+			}
+
+			for _, tc := range []struct {
+				name     string
+				data     []byte
+				wantFlag byte
+			}{
+				{"compressible", compressible, 1},
+				{"incompressible", random, 0},
+				{"empty", []byte{}, 0},
+			} {
+				if _, err := w.Write(tc.data); err != nil {
+					t.Fatalf("%s: write: %v", tc.name, err)
+				}
+				pkt := <-pipe.msgs
+				pt := openExplicit(t, key, pkt)
+				if pt[0] != tc.wantFlag {
+					t.Fatalf("%s: flag %d, want %d", tc.name, pt[0], tc.wantFlag)
+				}
+				if tc.wantFlag == 1 && len(pkt) >= len(tc.data) {
+					t.Fatalf("%s: packet of %d bytes not smaller than payload of %d", tc.name, len(pkt), len(tc.data))
+				}
+
+				pipe.msgs <- pkt
+				buf := make([]byte, 8192)
+				n, err := r.Read(buf)
+				if err != nil {
+					t.Fatalf("%s: read: %v", tc.name, err)
+				}
+				if !bytes.Equal(buf[:n], tc.data) {
+					t.Fatalf("%s: roundtrip mismatch", tc.name)
+				}
+			}
+
+			// the size limit applies to the compressed payload, so a compressible packet
+			// larger than the uncompressed limit still fits
+			big := bytes.Repeat([]byte{'z'}, 65520)
+			if _, err := w.Write(big); err != nil {
+				t.Fatalf("write big compressible: %v", err)
+			}
+			<-pipe.msgs
+			bigRandom := make([]byte, 65520)
+			_, _ = rand.Read(bigRandom)
+			if _, err := w.Write(bigRandom); err == nil {
+				t.Fatalf("expected write error for oversized incompressible packet")
+			}
+		})
+	}
+}
+
+func TestAESGCM_RoledKeys(t *testing.T) {
+	cr, sr := net.Pipe()
+	t.Cleanup(func() { _ = cr.Close(); _ = sr.Close() })
+	fc := netx.NewFrameConn(cr)
+	fs := netx.NewFrameConn(sr)
+	master := bytes.Repeat([]byte{0x42}, 32)
+
+	var (
+		c, s   net.Conn
+		ec, es error
+		done   = make(chan struct{}, 2)
+	)
+	go func() { c, ec = aesgcmproto.NewAESGCMConnRoled(fc, master, true); done <- struct{}{} }()
+	go func() { s, es = aesgcmproto.NewAESGCMConnRoled(fs, master, false); done <- struct{}{} }()
+	<-done
+	<-done
+	if ec != nil || es != nil {
+		t.Fatalf("roled conns: client %v, server %v", ec, es)
+	}
+
+	for _, dir := range []struct {
+		name string
+		w, r net.Conn
+	}{{"c2s", c, s}, {"s2c", s, c}} {
+		msg := []byte("hello " + dir.name)
+		go func() { _, _ = dir.w.Write(msg) }()
+		buf := make([]byte, 64)
+		n, err := dir.r.Read(buf)
+		if err != nil {
+			t.Fatalf("%s read: %v", dir.name, err)
+		}
+		if !bytes.Equal(buf[:n], msg) {
+			t.Fatalf("%s: got %q, want %q", dir.name, buf[:n], msg)
+		}
+	}
+}
+
+func TestAESGCM_RoledKeysRejectReflection(t *testing.T) {
+	master := bytes.Repeat([]byte{0x42}, 32)
+	pipe := &msgConn{msgs: make(chan []byte, 1)}
+	opt := aesgcmproto.WithExplicitNonce(true)
+	client, err := aesgcmproto.NewAESGCMConnRoled(pipe, master, true, opt)
+	if err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	server, err := aesgcmproto.NewAESGCMConnRoled(pipe, master, false, opt)
+	if err != nil {
+		t.Fatalf("server: %v", err)
+	}
+	buf := make([]byte, 64)
+
+	// a packet bounced back to its sender must not decrypt
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := client.Read(buf); err == nil {
+		t.Fatalf("expected reflected packet to be rejected")
+	}
+
+	// while the peer with the opposite role reads it fine
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	n, err := server.Read(buf)
+	if err != nil {
+		t.Fatalf("server read: %v", err)
+	}
+	if string(buf[:n]) != "ping" {
+		t.Fatalf("got %q, want %q", buf[:n], "ping")
+	}
+}
+
+func TestAESGCM_FlushAndCloseWriteThroughBufConn(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- c
+	}()
+	raw, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer raw.Close()
+	srvRaw := <-accepted
+	if srvRaw == nil {
+		t.Fatal("accept failed")
+	}
+	defer srvRaw.Close()
+
+	// explicit nonces skip the handshake, which would otherwise need flushing itself
+	key := bytes.Repeat([]byte{0x42}, 32)
+	client, err := aesgcmproto.NewAESGCMConn(netx.NewBufConn(raw), key, aesgcmproto.WithExplicitNonce(true))
+	if err != nil {
+		t.Fatalf("client aesgcm: %v", err)
+	}
+	server, err := aesgcmproto.NewAESGCMConn(srvRaw, key, aesgcmproto.WithExplicitNonce(true))
+	if err != nil {
+		t.Fatalf("server aesgcm: %v", err)
+	}
+
+	msg := []byte("buffered secret")
+	if _, err := client.Write(msg); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = server.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	buf := make([]byte, 64)
+	if _, err := server.Read(buf); err == nil {
+		t.Fatal("data arrived before flush")
+	}
+
+	if err := client.(netx.BufConn).Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	_ = server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := server.Read(buf)
+	if err != nil {
+		t.Fatalf("read after flush: %v", err)
+	}
+	if !bytes.Equal(buf[:n], msg) {
+		t.Fatalf("got %q, want %q", buf[:n], msg)
+	}
+
+	if err := client.(interface{ CloseWrite() error }).CloseWrite(); err != nil {
+		t.Fatalf("close write: %v", err)
+	}
+	if _, err := server.Read(buf); err != io.EOF {
+		t.Fatalf("read after close write = %v, want EOF", err)
+	}
+}
+
+func TestAESGCM_GCMSIVRoundtrip(t *testing.T) {
+	cr, sr := net.Pipe()
+	t.Cleanup(func() { _ = cr.Close(); _ = sr.Close() })
+	key := bytes.Repeat([]byte{0x42}, 16)
+
+	var (
+		c, s   net.Conn
+		ec, es error
+		done   = make(chan struct{}, 2)
+	)
+	siv := aesgcmproto.WithAEAD(aesgcmproto.AEADAESGCMSIV)
+	go func() { c, ec = aesgcmproto.NewAESGCMConn(netx.NewFrameConn(cr), key, siv); done <- struct{}{} }()
+	go func() { s, es = aesgcmproto.NewAESGCMConn(netx.NewFrameConn(sr), key, siv); done <- struct{}{} }()
+	<-done
+	<-done
+	if ec != nil || es != nil {
+		t.Fatalf("gcm-siv conns: client %v, server %v", ec, es)
+	}
+
+	msg := bytes.Repeat([]byte("siv payload "), 100)
+	go func() { _, _ = c.Write(msg) }()
+	buf := make([]byte, 2048)
+	n, err := s.Read(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(buf[:n], msg) {
+		t.Fatalf("got %q, want %q", buf[:n], msg)
+	}
+}
+
+func TestAESGCM_InitialSeq(t *testing.T) {
+	// relay frames from client to server so the sealed packets can be inspected
+	cr, mr := net.Pipe()
+	ms, sr := net.Pipe()
+	t.Cleanup(func() { _ = cr.Close(); _ = mr.Close(); _ = ms.Close(); _ = sr.Close() })
+	fromClient, toServer := netx.NewFrameConn(mr), netx.NewFrameConn(ms)
+	packets := make(chan []byte, 16)
+	relay := func(src, dst net.Conn, tap chan<- []byte) {
+		buf := make([]byte, 2048)
+		for {
+			n, err := src.Read(buf)
+			if err != nil {
+				return
+			}
+			if tap != nil {
+				tap <- append([]byte(nil), buf[:n]...)
+			}
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+	}
+	go relay(fromClient, toServer, packets)
+	go relay(toServer, fromClient, nil)
+
+	const start = 1<<40 + 7
+	key := bytes.Repeat([]byte{0x42}, 32)
+	var c, s net.Conn
+	var ec, es error
+	done := make(chan struct{}, 2)
+	go func() {
+		c, ec = aesgcmproto.NewAESGCMConn(netx.NewFrameConn(cr), key, aesgcmproto.WithInitialSeq(start))
+		done <- struct{}{}
+	}()
+	go func() { s, es = aesgcmproto.NewAESGCMConn(netx.NewFrameConn(sr), key); done <- struct{}{} }()
+	<-done
+	<-done
+	if ec != nil || es != nil {
+		t.Fatalf("aesgcm: %v, %v", ec, es)
+	}
+	iv := (<-packets)[1:] // client IV, after the version
+
+	seqConn, ok := c.(interface{ CurrentSeq() uint64 })
+	if !ok {
+		t.Fatal("conn does not expose CurrentSeq")
+	}
+	if got := seqConn.CurrentSeq(); got != start {
+		t.Fatalf("CurrentSeq before writing = %d, want %d", got, start)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	for i, msg := range []string{"first", "second"} {
+		go func() { _, _ = c.Write([]byte(msg)) }()
+		n, err := s.Read(buf)
+		if err != nil || string(buf[:n]) != msg {
+			t.Fatalf("peer read %q, %v; want %q", buf[:n], err, msg)
+		}
+
+		pkt := <-packets
+		seq := binary.BigEndian.Uint64(pkt[:8])
+		if seq != start+uint64(i) {
+			t.Fatalf("packet %d has sequence %d, want %d", i, seq, start+uint64(i))
+		}
+		// nonce = IV with its last 8 bytes XORed with the sequence
+		nonce := append([]byte(nil), iv...)
+		for j := range 8 {
+			nonce[4+j] ^= pkt[j]
+		}
+		pt, err := gcm.Open(nil, nonce, pkt[8:], pkt[:8])
+		if err != nil || string(pt) != msg {
+			t.Fatalf("open with derived nonce: %q, %v", pt, err)
+		}
+	}
+	if got := seqConn.CurrentSeq(); got != start+2 {
+		t.Fatalf("CurrentSeq after two writes = %d, want %d", got, start+2)
+	}
+}
+
+// queueConn is one end of a duplex message pipe made of two queues, so packets can be taken out of
+// a queue and put back in any order.
+type queueConn struct {
+	net.Conn
+	in, out chan []byte
+}
+
+func (c *queueConn) Write(p []byte) (int, error) {
+	c.out <- bytes.Clone(p)
+	return len(p), nil
+}
+
+func (c *queueConn) Read(p []byte) (int, error) { return copy(p, <-c.in), nil }
+
+func (c *queueConn) SetDeadline(time.Time) error { return nil }
+
+func TestAESGCM_ReplayWindow(t *testing.T) {
+	c2s, s2c := make(chan []byte, 256), make(chan []byte, 256)
+	key := bytes.Repeat([]byte{0x42}, 32)
+	var c, s net.Conn
+	var ec, es error
+	done := make(chan struct{}, 2)
+	go func() {
+		c, ec = aesgcmproto.NewAESGCMConn(&queueConn{in: s2c, out: c2s}, key)
+		done <- struct{}{}
+	}()
+	go func() {
+		s, es = aesgcmproto.NewAESGCMConn(&queueConn{in: c2s, out: s2c}, key, aesgcmproto.WithReplayWindow(64))
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+	if ec != nil || es != nil {
+		t.Fatalf("aesgcm: %v, %v", ec, es)
+	}
+
+	// take the sealed packets out of the queue to deliver them in a chosen order
+	pkts := make([][]byte, 200)
+	for i := range pkts {
+		if _, err := c.Write([]byte{byte(i)}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		pkts[i] = <-c2s
+	}
+
+	sr := s.(aesgcmproto.SeqReader)
+	buf := make([]byte, 16)
+	deliver := func(seq int, wantReordered bool, wantErr error) {
+		t.Helper()
+		c2s <- pkts[seq]
+		n, got, reordered, err := sr.ReadSeq(buf)
+		if wantErr != nil {
+			if !errors.Is(err, wantErr) {
+				t.Fatalf("seq %d: got %v, want %v", seq, err, wantErr)
+			}
+			return
+		}
+		if err != nil || n != 1 || buf[0] != byte(seq) || got != uint64(seq) || reordered != wantReordered {
+			t.Fatalf("seq %d: got n=%d payload=%d seq=%d reordered=%v err=%v", seq, n, buf[0], got, reordered, err)
+		}
+	}
+
+	// in order
+	deliver(0, false, nil)
+	deliver(1, false, nil)
+	deliver(2, false, nil)
+	// reordered within the window, then duplicates
+	deliver(5, false, nil)
+	deliver(4, true, nil)
+	deliver(4, false, aesgcmproto.ErrReplayDuplicate)
+	deliver(5, false, aesgcmproto.ErrReplayDuplicate)
+	// a jump moves the window, leaving older packets behind
+	deliver(150, false, nil)
+	deliver(90, true, nil)
+	deliver(80, false, aesgcmproto.ErrReplayTooOld)
+	deliver(150, false, aesgcmproto.ErrReplayDuplicate)
+
+	// a forged packet does not update the window
+	forged := bytes.Clone(pkts[199])
+	forged[len(forged)-1] ^= 0xff
+	c2s <- forged
+	if _, err := s.Read(buf); err == nil {
+		t.Fatal("expected decrypt error for a forged packet")
+	}
+	deliver(199, false, nil)
+
+	if _, err := aesgcmproto.NewAESGCMConn(&queueConn{}, key, aesgcmproto.WithExplicitNonce(true), aesgcmproto.WithReplayWindow(64)); err == nil {
+		t.Fatal("expected an error combining the replay window with explicit nonces")
+	}
+}
+
+func TestAESGCM_NegotiatedCompression(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	offer := aesgcmproto.WithNegotiatedCompression(aesgcmproto.CompressZstd)
+	compressible := bytes.Repeat([]byte("compress me "), 256)
+
+	for _, tc := range []struct {
+		name         string
+		client, peer []aesgcmproto.Option
+		want         aesgcmproto.Compression
+	}{
+		{"both offer", []aesgcmproto.Option{offer}, []aesgcmproto.Option{offer}, aesgcmproto.CompressZstd},
+		{"peer does not negotiate", []aesgcmproto.Option{offer}, nil, aesgcmproto.CompressNone},
+		{"peer offers another kind", []aesgcmproto.Option{offer},
+			[]aesgcmproto.Option{aesgcmproto.WithNegotiatedCompression(aesgcmproto.CompressFlate)}, aesgcmproto.CompressNone},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cr, sr := net.Pipe()
+			t.Cleanup(func() { _ = cr.Close(); _ = sr.Close() })
+			var c, s net.Conn
+			var ec, es error
+			done := make(chan struct{}, 2)
+			go func() {
+				c, ec = aesgcmproto.NewAESGCMConn(netx.NewFrameConn(cr), key, tc.client...)
+				done <- struct{}{}
+			}()
+			go func() { s, es = aesgcmproto.NewAESGCMConn(netx.NewFrameConn(sr), key, tc.peer...); done <- struct{}{} }()
+			<-done
+			<-done
+			if ec != nil || es != nil {
+				t.Fatalf("aesgcm: %v, %v", ec, es)
+			}
+
+			for _, conn := range []net.Conn{c, s} {
+				if got := conn.(interface {
+					Compression() aesgcmproto.Compression
+				}).Compression(); got != tc.want {
+					t.Fatalf("negotiated %v, want %v", got, tc.want)
+				}
+			}
+			// both directions carry data after the handshake, compressed or not
+			buf := make([]byte, 8192)
+			for _, dir := range [][2]net.Conn{{c, s}, {s, c}} {
+				go func() { _, _ = dir[0].Write(compressible) }()
+				n, err := dir[1].Read(buf)
+				if err != nil || !bytes.Equal(buf[:n], compressible) {
+					t.Fatalf("read %d bytes, %v", n, err)
+				}
+			}
+		})
+	}
+}
+
+func TestAESGCM_Reset(t *testing.T) {
+	c, s := newAESPair(t)
+	buf := make([]byte, 64)
+	exchange := func(msg string) {
+		t.Helper()
+		for _, dir := range [][2]net.Conn{{c, s}, {s, c}} {
+			go func() { _, _ = dir[0].Write([]byte(msg)) }()
+			n, err := dir[1].Read(buf)
+			if err != nil || string(buf[:n]) != msg {
+				t.Fatalf("read %q, %v; want %q", buf[:n], err, msg)
+			}
+		}
+	}
+	exchange("before")
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Read(buf); err == nil {
+		t.Fatal("read from closed pipe succeeded")
+	}
+
+	cr, sr := net.Pipe()
+	t.Cleanup(func() { _ = cr.Close(); _ = sr.Close() })
+	type resetter interface{ Reset(net.Conn) error }
+	errs := make(chan error, 2)
+	go func() { errs <- c.(resetter).Reset(netx.NewFrameConn(cr)) }()
+	go func() { errs <- s.(resetter).Reset(netx.NewFrameConn(sr)) }()
+	for range 2 {
+		if err := <-errs; err != nil {
+			t.Fatalf("reset: %v", err)
+		}
+	}
+
+	exchange("after")
+	if got := c.(interface{ CurrentSeq() uint64 }).CurrentSeq(); got != 1 {
+		t.Fatalf("CurrentSeq after reset and one write = %d, want 1", got)
+	}
+}
+
+func TestAESGCM_NewAEADConnWith(t *testing.T) {
+	block, err := aes.NewCipher(bytes.Repeat([]byte{0x42}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr, sr := net.Pipe()
+	t.Cleanup(func() { _ = cr.Close(); _ = sr.Close() })
+	var c, s net.Conn
+	var ec, es error
+	done := make(chan struct{}, 2)
+	go func() { c, ec = aesgcmproto.NewAEADConnWith(netx.NewFrameConn(cr), gcm); done <- struct{}{} }()
+	go func() {
+		// the peer derives the same cipher from the key
+		s, es = aesgcmproto.NewAESGCMConn(netx.NewFrameConn(sr), bytes.Repeat([]byte{0x42}, 32))
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+	if ec != nil || es != nil {
+		t.Fatalf("aesgcm: %v, %v", ec, es)
+	}
+	buf := make([]byte, 64)
+	for _, dir := range [][2]net.Conn{{c, s}, {s, c}} {
+		go func() { _, _ = dir[0].Write([]byte("hello")) }()
+		n, err := dir[1].Read(buf)
+		if err != nil || string(buf[:n]) != "hello" {
+			t.Fatalf("read %q, %v", buf[:n], err)
+		}
+	}
+
+	gcm16, err := cipher.NewGCMWithNonceSize(block, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := aesgcmproto.NewAEADConnWith(&queueConn{}, gcm16); err == nil {
+		t.Fatal("AEAD with 16-byte nonces accepted")
+	}
+}
+
+func TestAESGCM_HandshakeVersion(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	legacy := aesgcmproto.WithLegacyHandshake(true)
+	pair := func(t *testing.T, client, server []aesgcmproto.Option) (c, s net.Conn, ec, es error) {
+		cr, sr := net.Pipe()
+		t.Cleanup(func() { _ = cr.Close(); _ = sr.Close() })
+		done := make(chan struct{}, 2)
+		go func() { c, ec = aesgcmproto.NewAESGCMConn(netx.NewFrameConn(cr), key, client...); done <- struct{}{} }()
+		go func() { s, es = aesgcmproto.NewAESGCMConn(netx.NewFrameConn(sr), key, server...); done <- struct{}{} }()
+		<-done
+		<-done
+		return c, s, ec, es
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts []aesgcmproto.Option
+	}{
+		{"matching versions", nil},
+		{"legacy", []aesgcmproto.Option{legacy}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, s, ec, es := pair(t, tc.opts, tc.opts)
+			if ec != nil || es != nil {
+				t.Fatalf("aesgcm: %v, %v", ec, es)
+			}
+			go func() { _, _ = c.Write([]byte("hello")) }()
+			buf := make([]byte, 64)
+			if n, err := s.Read(buf); err != nil || string(buf[:n]) != "hello" {
+				t.Fatalf("read %q, %v", buf[:n], err)
+			}
+		})
+	}
+
+	t.Run("legacy peer", func(t *testing.T) {
+		_, _, _, es := pair(t, []aesgcmproto.Option{legacy}, nil)
+		var ve *aesgcmproto.HandshakeVersionError
+		if !errors.As(es, &ve) || ve.Peer != 0 {
+			t.Fatalf("versioned side got %v, want a HandshakeVersionError for a legacy peer", es)
+		}
+	})
+
+	t.Run("unknown version", func(t *testing.T) {
+		cr, sr := net.Pipe()
+		t.Cleanup(func() { _ = cr.Close(); _ = sr.Close() })
+		peer := netx.NewFrameConn(cr)
+		go func() {
+			_, _ = peer.Write(append([]byte{9}, make([]byte, 12)...))
+			_, _ = peer.Read(make([]byte, 64))
+		}()
+		_, err := aesgcmproto.NewAESGCMConn(netx.NewFrameConn(sr), key)
+		var ve *aesgcmproto.HandshakeVersionError
+		if !errors.As(err, &ve) || ve.Peer != 9 {
+			t.Fatalf("got %v, want a HandshakeVersionError for version 9", err)
+		}
+	})
+}
+
+func TestAESGCM_RecordLength(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	opt := aesgcmproto.WithRecordLength(true)
+
+	t.Run("truncated packet", func(t *testing.T) {
+		c2s, s2c := make(chan []byte, 4), make(chan []byte, 4)
+		var c, s net.Conn
+		var ec, es error
+		done := make(chan struct{}, 2)
+		go func() { c, ec = aesgcmproto.NewAESGCMConn(&queueConn{in: s2c, out: c2s}, key, opt); done <- struct{}{} }()
+		go func() { s, es = aesgcmproto.NewAESGCMConn(&queueConn{in: c2s, out: s2c}, key, opt); done <- struct{}{} }()
+		<-done
+		<-done
+		if ec != nil || es != nil {
+			t.Fatalf("aesgcm: %v, %v", ec, es)
+		}
+		// the server reads from the queue the client writes to, so packets can be tampered with on the way
+		tamper := func(f func([]byte) []byte) error {
+			if _, err := c.Write([]byte("a record that gets cut short")); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			c2s <- f(<-c2s)
+			_, err := s.Read(make([]byte, 64))
+			return err
+		}
+
+		if err := tamper(func(p []byte) []byte { return p }); err != nil {
+			t.Fatalf("intact packet: %v", err)
+		}
+		if err := tamper(func(p []byte) []byte { return p[:len(p)-5] }); !errors.Is(err, aesgcmproto.ErrTruncatedRecord) {
+			t.Fatalf("truncated packet: got %v, want ErrTruncatedRecord", err)
+		}
+		if err := tamper(func(p []byte) []byte { return append(p, 0) }); err == nil || errors.Is(err, aesgcmproto.ErrTruncatedRecord) {
+			t.Fatalf("extended packet: got %v, want a length error", err)
+		}
+	})
+
+	t.Run("frame cut off", func(t *testing.T) {
+		cr, sr := net.Pipe()
+		t.Cleanup(func() { _ = cr.Close(); _ = sr.Close() })
+		var s net.Conn
+		var ec, es error
+		done := make(chan struct{}, 2)
+		go func() { _, ec = aesgcmproto.NewAESGCMConn(netx.NewFrameConn(cr), key, opt); done <- struct{}{} }()
+		go func() { s, es = aesgcmproto.NewAESGCMConn(netx.NewFrameConn(sr), key, opt); done <- struct{}{} }()
+		<-done
+		<-done
+		if ec != nil || es != nil {
+			t.Fatalf("aesgcm: %v, %v", ec, es)
+		}
+		// a frame announcing 64 bytes, of which the connection closes after 10
+		go func() {
+			_, _ = cr.Write([]byte{0, 64})
+			_, _ = cr.Write(make([]byte, 10))
+			_ = cr.Close()
+		}()
+		if _, err := s.Read(make([]byte, 128)); !errors.Is(err, aesgcmproto.ErrTruncatedRecord) {
+			t.Fatalf("got %v, want ErrTruncatedRecord", err)
+		}
+	})
+}
+
+func TestAESGCM_Message(t *testing.T) {
+	c2s, s2c := make(chan []byte, 16), make(chan []byte, 16)
+	key := bytes.Repeat([]byte{0x42}, 32)
+	var c, s net.Conn
+	var ec, es error
+	done := make(chan struct{}, 2)
+	go func() { c, ec = aesgcmproto.NewAESGCMConn(&queueConn{in: s2c, out: c2s}, key); done <- struct{}{} }()
+	go func() { s, es = aesgcmproto.NewAESGCMConn(&queueConn{in: c2s, out: s2c}, key); done <- struct{}{} }()
+	<-done
+	<-done
+	if ec != nil || es != nil {
+		t.Fatalf("aesgcm: %v, %v", ec, es)
+	}
+	cm, sm := c.(aesgcmproto.MessageConn), s.(aesgcmproto.MessageConn)
+
+	large := make([]byte, 200_000)
+	for i := range large {
+		large[i] = byte(i * 7)
+	}
+	if err := cm.WriteMessage(large); err != nil {
+		t.Fatalf("write large: %v", err)
+	}
+	var pkts [][]byte
+	for len(c2s) > 0 {
+		pkts = append(pkts, <-c2s)
+	}
+	if len(pkts) < 3 {
+		t.Fatalf("large message took %d packets, want several", len(pkts))
+	}
+	if err := cm.WriteMessage([]byte("small")); err != nil {
+		t.Fatalf("write small: %v", err)
+	}
+	small := <-c2s
+
+	// deliver the fragments of the large message in reverse, with a duplicate and the small message in between
+	slices.Reverse(pkts)
+	for i, pkt := range pkts {
+		c2s <- pkt
+		if i == 0 {
+			c2s <- pkt
+			c2s <- small
+		}
+	}
+	got, err := sm.ReadMessage()
+	if err != nil || string(got) != "small" {
+		t.Fatalf("first message: got %q, %v, want the small one", got, err)
+	}
+	if got, err = sm.ReadMessage(); err != nil || !bytes.Equal(got, large) {
+		t.Fatalf("second message: got %d bytes, %v, want the large one of %d bytes", len(got), err, len(large))
+	}
+
+	// an empty message is a single fragment
+	if err := cm.WriteMessage(nil); err != nil {
+		t.Fatalf("write empty: %v", err)
+	}
+	if got, err = sm.ReadMessage(); err != nil || len(got) != 0 {
+		t.Fatalf("empty message: got %q, %v", got, err)
+	}
+
+	// plain packets do not authenticate as message fragments
+	if _, err := c.Write([]byte("plain")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := sm.ReadMessage(); err == nil {
+		t.Fatal("expected an error reading a plain packet as a message")
+	}
+}
+
+// trickleConn accepts at most limit bytes per Write, as a stream may, and collects them.
+type trickleConn struct {
+	net.Conn
+	limit  int
+	writes int
+	buf    []byte
+}
+
+func (c *trickleConn) Write(p []byte) (int, error) {
+	c.writes++
+	n := min(len(p), c.limit)
+	c.buf = append(c.buf, p[:n]...)
+	return n, nil
+}
+
+func TestAESGCM_ShortWrites(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	tc := &trickleConn{limit: 3}
+	c, err := aesgcmproto.NewAESGCMConn(tc, key, aesgcmproto.WithExplicitNonce(true))
+	if err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	msg := []byte("written a few bytes at a time")
+	if n, err := c.Write(msg); err != nil || n != len(msg) {
+		t.Fatalf("write: n=%d, %v", n, err)
+	}
+	if tc.writes < 2 {
+		t.Fatalf("packet written in %d calls, want several", tc.writes)
+	}
+
+	pipe := &msgConn{msgs: make(chan []byte, 1)}
+	s, err := aesgcmproto.NewAESGCMConn(pipe, key, aesgcmproto.WithExplicitNonce(true))
+	if err != nil {
+		t.Fatalf("server: %v", err)
+	}
+	pipe.msgs <- tc.buf
+	buf := make([]byte, 64)
+	n, err := s.Read(buf)
+	if err != nil || !bytes.Equal(buf[:n], msg) {
+		t.Fatalf("read: got %q, %v, want %q", buf[:n], err, msg)
+	}
+
+	// a conn that takes nothing fails instead of looping forever
+	tc.limit = 0
+	if _, err := c.Write(msg); !errors.Is(err, io.ErrShortWrite) {
+		t.Fatalf("write without progress: got %v, want io.ErrShortWrite", err)
+	}
+}
+
+func TestAESGCM_Deferred(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	const pairs = 8
+	clients := make([]aesgcmproto.HandshakeConn, pairs)
+	servers := make([]aesgcmproto.HandshakeConn, pairs)
+	// creating does not block, even though nobody reads the synchronous pipes yet
+	for i := range pairs {
+		cr, sr := net.Pipe()
+		t.Cleanup(func() { _ = cr.Close(); _ = sr.Close() })
+		var err error
+		if clients[i], err = aesgcmproto.NewAESGCMConnDeferred(netx.NewFrameConn(cr), key, aesgcmproto.WithNegotiatedCompression(aesgcmproto.CompressFlate)); err != nil {
+			t.Fatalf("client %d: %v", i, err)
+		}
+		if servers[i], err = aesgcmproto.NewAESGCMConnDeferred(netx.NewFrameConn(sr), key, aesgcmproto.WithNegotiatedCompression(aesgcmproto.CompressFlate)); err != nil {
+			t.Fatalf("server %d: %v", i, err)
+		}
+	}
+
+	// all handshakes run at once, except for the last pair, which is handshaken by its first I/O
+	errs := make(chan error, 2*pairs)
+	for i := range pairs - 1 {
+		for _, c := range []aesgcmproto.HandshakeConn{clients[i], servers[i]} {
+			go func() { errs <- c.HandshakeContext(context.Background()) }()
+		}
+	}
+	for range 2 * (pairs - 1) {
+		if err := <-errs; err != nil {
+			t.Fatalf("handshake: %v", err)
+		}
+	}
+
+	for i := range pairs {
+		msg := []byte(strings.Repeat("deferred ", i+1))
+		go func() {
+			_, err := clients[i].Write(msg)
+			errs <- err
+		}()
+		buf := make([]byte, 1024)
+		n, err := servers[i].Read(buf)
+		if err != nil {
+			t.Fatalf("pair %d: read: %v", i, err)
+		}
+		if err := <-errs; err != nil {
+			t.Fatalf("pair %d: write: %v", i, err)
+		}
+		if !bytes.Equal(buf[:n], msg) {
+			t.Fatalf("pair %d: got %q, want %q", i, buf[:n], msg)
+		}
+	}
+
+	// a canceled handshake fails every later use of the conn
+	cr, sr := net.Pipe()
+	t.Cleanup(func() { _ = cr.Close(); _ = sr.Close() })
+	c, err := aesgcmproto.NewAESGCMConnDeferred(cr, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.HandshakeContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if _, err := c.Write([]byte("x")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("write got %v, want the handshake error", err)
+	}
+}
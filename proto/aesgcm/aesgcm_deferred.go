@@ -0,0 +1,68 @@
+package aesgcmproto
+
+import (
+	"context"
+	"net"
+)
+
+// HandshakeConn is a conn whose handshake can be run as a step of its own, see NewAESGCMConnDeferred.
+type HandshakeConn interface {
+	net.Conn
+	// HandshakeContext runs the handshake unless it already ran, and returns its result. Canceling ctx aborts
+	// it, which leaves the conn unusable. The handshake is bounded by 5 seconds regardless of ctx.
+	HandshakeContext(ctx context.Context) error
+}
+
+// NewAESGCMConnDeferred creates a conn like NewAESGCMConn without performing the handshake, so many conns can be
+// set up first and handshaken concurrently. The handshake runs on HandshakeContext, or on the first Read, Write,
+// ReadMessage or WriteMessage, as with crypto/tls, and its error is returned by all of them from then on. Until it
+// ran, MaxWrite accounts for compression if it is offered, since it may be negotiated. Conns with
+// WithExplicitNonce have no handshake, so HandshakeContext returns nil right away.
+func NewAESGCMConnDeferred(conn net.Conn, key []byte, opts ...Option) (HandshakeConn, error) {
+	agc, err := newAESGCMState(key, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	agc.Conn = conn
+	agc.handshaken.Store(agc.explicitNonce)
+	if agc.maxWrite, err = agc.maxWriteOf(conn); err != nil {
+		return nil, err
+	}
+	return agc, nil
+}
+
+// HandshakeContext runs the handshake of a conn created by NewAESGCMConnDeferred, see HandshakeConn.
+// It returns nil for conns whose handshake was done on creation.
+func (c *aesgcmConn) HandshakeContext(ctx context.Context) error {
+	if c.handshaken.Load() {
+		c.hsMu.Lock()
+		defer c.hsMu.Unlock()
+		return c.hsErr
+	}
+	c.io.RLock()
+	defer c.io.RUnlock()
+	c.hsMu.Lock()
+	defer c.hsMu.Unlock()
+	if c.handshaken.Load() {
+		return c.hsErr
+	}
+	defer c.handshaken.Store(true)
+	if c.hsErr = c.handshake(ctx); c.hsErr != nil {
+		return c.hsErr
+	}
+	// the handshake may have settled the compression
+	maxWrite, err := c.maxWriteOf(c.Conn)
+	if err != nil {
+		c.hsErr = err
+		return err
+	}
+	c.connMu.Lock()
+	c.maxWrite = maxWrite
+	c.connMu.Unlock()
+	return nil
+}
+
+// ensureHandshake runs a deferred handshake on the first I/O, and returns its error on later ones.
+func (c *aesgcmConn) ensureHandshake() error {
+	return c.HandshakeContext(context.Background())
+}
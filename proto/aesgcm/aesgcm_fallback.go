@@ -0,0 +1,108 @@
+package aesgcmproto
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pedramktb/go-netx"
+)
+
+// NewFallbackConn lets a server accept encrypted and legacy plaintext clients on the same port, e.g. during
+// a migration to AESGCMConn. It reads the first packet of conn, waiting up to wait for it, and tells the two
+// apart by its length: the handshake of an AESGCMConn starts with a packet of the version and the 12-byte IV,
+// one byte more with WithNegotiatedCompression and one less with WithLegacyHandshake, so 12 to 14 bytes.
+// If the packet has that length, conn is passed to encrypted, e.g. a closure
+// over NewAESGCMConn, which then finds the packet as the first thing it reads. Otherwise, or if no packet
+// arrives in time as with protocols where the server speaks first, conn is returned as plaintext with the
+// packet still to be read, so no data is lost. It reports whether the returned conn is encrypted.
+//
+// Since it speculates on reads, it only suits servers, and it cannot be used with WithExplicitNonce, which has
+// no handshake. Plaintext clients whose first packet happens to be 12 to 14 bytes long are mistaken for
+// encrypted ones and fail the handshake. Like AESGCMConn, conn must preserve packet boundaries.
+func NewFallbackConn(conn net.Conn, wait time.Duration, encrypted func(net.Conn) (net.Conn, error)) (net.Conn, bool, error) {
+	buf := make([]byte, netx.MaxPacketSize)
+	_ = conn.SetReadDeadline(time.Now().Add(wait))
+	n, err := conn.Read(buf)
+	_ = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return conn, false, nil
+		}
+		return nil, false, err
+	}
+	rc := &replayConn{Conn: conn, first: buf[:n]}
+	if n < 12 || n > 14 {
+		return rc, false, nil
+	}
+	ec, err := encrypted(rc)
+	if err != nil {
+		return nil, false, err
+	}
+	return ec, true, nil
+}
+
+// replayConn returns the packet read ahead of time on its first Read.
+type replayConn struct {
+	net.Conn
+	mu    sync.Mutex
+	first []byte
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	if c.first != nil {
+		n := copy(p, c.first)
+		if c.first = c.first[n:]; len(c.first) == 0 {
+			c.first = nil
+		}
+		c.mu.Unlock()
+		return n, nil
+	}
+	c.mu.Unlock()
+	return c.Conn.Read(p)
+}
+
+// NewFallbackConnDeferred is like NewFallbackConn, but returns right away and tells encrypted and plaintext
+// clients apart on the first Read or Write of the returned conn, whose error is returned by all of them from
+// then on. Listeners use it so that a client that stays silent does not hold up accepting the others; only
+// the first I/O of its own conn waits for its first packet.
+func NewFallbackConnDeferred(conn net.Conn, wait time.Duration, encrypted func(net.Conn) (net.Conn, error)) net.Conn {
+	return &deferredFallbackConn{Conn: conn, wait: wait, encrypted: encrypted}
+}
+
+// deferredFallbackConn runs NewFallbackConn on its first Read or Write. Close and the deadlines go to the
+// underlying conn, so closing it also aborts a pending wait for the first packet.
+type deferredFallbackConn struct {
+	net.Conn
+	wait      time.Duration
+	encrypted func(net.Conn) (net.Conn, error)
+	once      sync.Once
+	conn      net.Conn
+	err       error
+}
+
+func (c *deferredFallbackConn) resolve() (net.Conn, error) {
+	c.once.Do(func() {
+		c.conn, _, c.err = NewFallbackConn(c.Conn, c.wait, c.encrypted)
+	})
+	return c.conn, c.err
+}
+
+func (c *deferredFallbackConn) Read(p []byte) (int, error) {
+	conn, err := c.resolve()
+	if err != nil {
+		return 0, err
+	}
+	return conn.Read(p)
+}
+
+func (c *deferredFallbackConn) Write(p []byte) (int, error) {
+	conn, err := c.resolve()
+	if err != nil {
+		return 0, err
+	}
+	return conn.Write(p)
+}
@@ -0,0 +1,106 @@
+package aesgcmproto_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pedramktb/go-netx"
+	aesgcmproto "github.com/pedramktb/go-netx/proto/aesgcm"
+)
+
+func TestFallbackConn(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	// the same server side for every client
+	accept := func(conn net.Conn) (net.Conn, bool, error) {
+		return aesgcmproto.NewFallbackConn(netx.NewFrameConn(conn), time.Second, func(c net.Conn) (net.Conn, error) {
+			return aesgcmproto.NewAESGCMConn(c, key)
+		})
+	}
+
+	t.Run("encrypted", func(t *testing.T) {
+		cr, sr := net.Pipe()
+		t.Cleanup(func() { _ = cr.Close(); _ = sr.Close() })
+		var c, s net.Conn
+		var ec, es error
+		var encrypted bool
+		done := make(chan struct{}, 2)
+		go func() { c, ec = aesgcmproto.NewAESGCMConn(netx.NewFrameConn(cr), key); done <- struct{}{} }()
+		go func() { s, encrypted, es = accept(sr); done <- struct{}{} }()
+		<-done
+		<-done
+		if ec != nil || es != nil {
+			t.Fatalf("aesgcm: %v, %v", ec, es)
+		}
+		if !encrypted {
+			t.Fatal("encrypted client taken for plaintext")
+		}
+		buf := make([]byte, 64)
+		for _, dir := range [][2]net.Conn{{c, s}, {s, c}} {
+			go func() { _, _ = dir[0].Write([]byte("sealed")) }()
+			n, err := dir[1].Read(buf)
+			if err != nil || string(buf[:n]) != "sealed" {
+				t.Fatalf("read %q, %v", buf[:n], err)
+			}
+		}
+	})
+
+	t.Run("plaintext", func(t *testing.T) {
+		cr, sr := net.Pipe()
+		t.Cleanup(func() { _ = cr.Close(); _ = sr.Close() })
+		c := netx.NewFrameConn(cr)
+		go func() {
+			_, _ = c.Write([]byte("hello from a legacy client"))
+			_, _ = c.Write([]byte("second"))
+		}()
+		s, encrypted, err := accept(sr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encrypted {
+			t.Fatal("plaintext client taken for encrypted")
+		}
+		buf := make([]byte, 64)
+		for _, want := range []string{"hello from a legacy client", "second"} {
+			n, err := s.Read(buf)
+			if err != nil || string(buf[:n]) != want {
+				t.Fatalf("read %q, %v; want %q", buf[:n], err, want)
+			}
+		}
+	})
+
+	t.Run("server speaks first", func(t *testing.T) {
+		cr, sr := net.Pipe()
+		t.Cleanup(func() { _ = cr.Close(); _ = sr.Close() })
+		s, encrypted, err := accept(sr)
+		if err != nil || encrypted {
+			t.Fatalf("silent client: encrypted %v, %v", encrypted, err)
+		}
+		go func() { _, _ = s.Write([]byte("banner")) }()
+		buf := make([]byte, 64)
+		n, err := netx.NewFrameConn(cr).Read(buf)
+		if err != nil || string(buf[:n]) != "banner" {
+			t.Fatalf("read %q, %v", buf[:n], err)
+		}
+	})
+
+	t.Run("deferred", func(t *testing.T) {
+		cr, sr := net.Pipe()
+		t.Cleanup(func() { _ = cr.Close(); _ = sr.Close() })
+		start := time.Now()
+		s := aesgcmproto.NewFallbackConnDeferred(netx.NewFrameConn(sr), time.Second, func(c net.Conn) (net.Conn, error) {
+			return aesgcmproto.NewAESGCMConn(c, key)
+		})
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Fatalf("deferred fallback conn waited %v for the first packet", elapsed)
+		}
+		// the client only writes now, after the conn was set up
+		go func() { _, _ = netx.NewFrameConn(cr).Write([]byte("late legacy client")) }()
+		buf := make([]byte, 64)
+		n, err := s.Read(buf)
+		if err != nil || string(buf[:n]) != "late legacy client" {
+			t.Fatalf("read %q, %v", buf[:n], err)
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package aesgcmproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/pedramktb/go-netx"
+)
+
+// messageHeaderLen is the length of the fragment header that follows the packet header in message packets:
+//
+//	[4-byte message ID][2-byte fragment index][2-byte fragment count]
+//
+// It is sent in the clear and authenticated as part of the AAD, so fragments cannot be moved between messages.
+const messageHeaderLen = 8
+
+// maxPendingMessages is the number of partially received messages kept by ReadMessage. When another message
+// starts, the oldest one is dropped.
+const maxPendingMessages = 64
+
+// MessageConn is implemented by the conns returned by NewAESGCMConn, NewAESGCMConnRoled and NewAEADConnWith.
+// Messages are split into as many packets as needed and reassembled in any order, so they are not limited by
+// the packet size of the conn. Packets written with WriteMessage do not decrypt with Read and vice versa, so
+// both ends must stick to one of the APIs.
+type MessageConn interface {
+	// WriteMessage seals msg into fragments of at most a packet each and writes them. It fails for messages of
+	// more than 65535 fragments.
+	WriteMessage(msg []byte) error
+	// ReadMessage reads packets until a message is complete and returns it. Fragments may arrive in any order
+	// and interleaved with those of other messages, duplicates are ignored. A message whose fragments do not
+	// all arrive is dropped once 64 newer messages are in flight.
+	ReadMessage() ([]byte, error)
+}
+
+// partialMessage is a message whose fragments are being collected by ReadMessage.
+type partialMessage struct {
+	frags [][]byte // by index, nil until received
+	left  int      // fragments still missing
+}
+
+// messageFragSize returns the payload size of a message fragment.
+func (c *aesgcmConn) messageFragSize() int {
+	if mw := c.MaxWrite(); mw > 0 {
+		return int(mw) - messageHeaderLen
+	}
+	// ReadSeq rejects packets that fill its buffer
+	return netx.MaxPacketSize - 1 - c.overhead() - messageHeaderLen
+}
+
+// WriteMessage seals msg into as many packets as needed and writes them, see MessageConn.
+func (c *aesgcmConn) WriteMessage(msg []byte) error {
+	if err := c.ensureHandshake(); err != nil {
+		return err
+	}
+	c.io.RLock()
+	defer c.io.RUnlock()
+	size := c.messageFragSize()
+	if size <= 0 {
+		return errors.New("aesgcmConn: MaxWrite too small for message fragments")
+	}
+	count := max((len(msg)+size-1)/size, 1)
+	if count > 0xffff {
+		return fmt.Errorf("aesgcmConn: message of %d bytes exceeds 65535 fragments", len(msg))
+	}
+	id := c.msgID.Add(1)
+
+	bp := c.buf.Get().(*[]byte)
+	defer c.buf.Put(bp)
+	var hdr [messageHeaderLen]byte
+	binary.BigEndian.PutUint32(hdr[0:4], id)
+	binary.BigEndian.PutUint16(hdr[6:8], uint16(count))
+	for i := range count {
+		binary.BigEndian.PutUint16(hdr[4:6], uint16(i))
+		frag := msg[i*size : min((i+1)*size, len(msg))]
+		pkt, err := c.sealExtra(*bp, hdr[:], frag)
+		if err != nil {
+			return err
+		}
+		if err := writeFull(c.Conn, pkt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadMessage reads packets until a message is complete and returns it, see MessageConn.
+func (c *aesgcmConn) ReadMessage() ([]byte, error) {
+	if err := c.ensureHandshake(); err != nil {
+		return nil, err
+	}
+	bp := c.buf.Get().(*[]byte)
+	defer c.buf.Put(bp)
+	for {
+		id, index, count, frag, err := c.readFragment(*bp)
+		if err != nil {
+			return nil, err
+		}
+		if msg, ok, err := c.addFragment(id, index, count, frag); err != nil || ok {
+			return msg, err
+		}
+	}
+}
+
+// readFragment reads and opens the next message packet, returning its fragment header and a copy of its payload.
+// p is scratch space of netx.MaxPacketSize bytes.
+func (c *aesgcmConn) readFragment(p []byte) (id uint32, index, count uint16, frag []byte, err error) {
+	c.io.RLock()
+	defer c.io.RUnlock()
+	bp := c.buf.Get().(*[]byte)
+	buf := *bp
+	defer c.buf.Put(bp)
+
+	n, err := c.readPacket(buf)
+	if err != nil {
+		return 0, 0, 0, nil, err
+	}
+	m, _, _, err := c.openExtra(p, buf[:n], messageHeaderLen)
+	if err != nil {
+		return 0, 0, 0, nil, err
+	}
+	hdr := buf[c.headerLen() : c.headerLen()+messageHeaderLen]
+	id = binary.BigEndian.Uint32(hdr[0:4])
+	index = binary.BigEndian.Uint16(hdr[4:6])
+	count = binary.BigEndian.Uint16(hdr[6:8])
+	if count == 0 || index >= count {
+		return 0, 0, 0, nil, fmt.Errorf("aesgcmConn: invalid message fragment %d of %d", index, count)
+	}
+	return id, index, count, bytes.Clone(p[:m]), nil
+}
+
+// addFragment stores a fragment and returns the message once all its fragments are there.
+func (c *aesgcmConn) addFragment(id uint32, index, count uint16, frag []byte) ([]byte, bool, error) {
+	c.msgMu.Lock()
+	defer c.msgMu.Unlock()
+	pm, ok := c.pending[id]
+	if !ok {
+		if c.pending == nil {
+			c.pending = make(map[uint32]*partialMessage)
+		}
+		if len(c.pendingOrder) >= maxPendingMessages {
+			delete(c.pending, c.pendingOrder[0])
+			c.pendingOrder = c.pendingOrder[1:]
+		}
+		pm = &partialMessage{frags: make([][]byte, count), left: int(count)}
+		c.pending[id] = pm
+		c.pendingOrder = append(c.pendingOrder, id)
+	}
+	if len(pm.frags) != int(count) {
+		return nil, false, fmt.Errorf("aesgcmConn: fragment count %d does not match %d of message %d", count, len(pm.frags), id)
+	}
+	if pm.frags[index] != nil {
+		return nil, false, nil // duplicate
+	}
+	pm.frags[index] = frag
+	if pm.left--; pm.left > 0 {
+		return nil, false, nil
+	}
+
+	delete(c.pending, id)
+	for i, pid := range c.pendingOrder {
+		if pid == id {
+			c.pendingOrder = append(c.pendingOrder[:i], c.pendingOrder[i+1:]...)
+			break
+		}
+	}
+	return bytes.Join(pm.frags, nil), true, nil
+}
@@ -0,0 +1,160 @@
+package aesgcmproto
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/pedramktb/go-netx"
+)
+
+// defaultMaxPeers is the number of peers whose state an AESGCMPacketConn keeps by default.
+const defaultMaxPeers = 1024
+
+// WithMaxPeers sets the number of peers whose cipher state NewAESGCMPacketConn keeps, 1024 by default.
+// NewAESGCMPacketConn only.
+func WithMaxPeers(n int) Option {
+	return func(c *aesgcmConn) {
+		if n > 0 {
+			c.maxPeers = n
+		}
+	}
+}
+
+type aesgcmPacketConn struct {
+	net.PacketConn
+	keyFor   func(addr net.Addr) []byte
+	opts     []Option
+	maxPeers int
+	buf      sync.Pool
+
+	mu    sync.Mutex
+	peers map[string]*list.Element // of *aesgcmPeer, most recently used first
+	lru   list.List
+}
+
+type aesgcmPeer struct {
+	addr  string
+	state *aesgcmConn
+}
+
+// NewAESGCMPacketConn wraps an unconnected net.PacketConn, e.g. a UDP socket shared by many peers, so that
+// every datagram is sealed with the key of its peer, as returned by keyFor for the peer's address.
+// keyFor returns nil for unknown peers, whose datagrams are dropped and to which writes fail.
+//
+// Since there is no handshake to exchange IVs, every datagram carries a random nonce, as with WithExplicitNonce,
+// and the same limits apply: no more than 2^32 datagrams per key. The options of NewAESGCMConn apply, except
+// for those that need the handshake, like WithReplayWindow.
+//
+// The cipher state of a peer is set up on its first datagram and kept until it is evicted as the least recently
+// used one of more than WithMaxPeers peers, so keyFor is only called again after that. Each peer costs two AES
+// key schedules and GCM states, in the order of a kilobyte.
+// Datagrams that do not authenticate are dropped by ReadFrom rather than returned as errors, since one peer
+// must not be able to disturb the others.
+func NewAESGCMPacketConn(pc net.PacketConn, keyFor func(addr net.Addr) []byte, opts ...Option) (net.PacketConn, error) {
+	opts = append(opts[:len(opts):len(opts)], WithExplicitNonce(true))
+	// validate the options once up front, with a throwaway key
+	probe, err := newAESGCMState(make([]byte, 32), make([]byte, 32), opts...)
+	if err != nil {
+		return nil, err
+	}
+	c := &aesgcmPacketConn{
+		PacketConn: pc,
+		keyFor:     keyFor,
+		opts:       opts,
+		maxPeers:   defaultMaxPeers,
+		buf: sync.Pool{
+			New: func() any {
+				b := make([]byte, netx.MaxPacketSize)
+				return &b
+			},
+		},
+		peers: make(map[string]*list.Element),
+	}
+	if probe.maxPeers > 0 {
+		c.maxPeers = probe.maxPeers
+	}
+	return c, nil
+}
+
+// peer returns the state of the peer at addr, setting it up if needed.
+func (c *aesgcmPacketConn) peer(addr net.Addr) (*aesgcmConn, error) {
+	key := addr.Network() + "://" + addr.String()
+	c.mu.Lock()
+	if e, ok := c.peers[key]; ok {
+		c.lru.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*aesgcmPeer).state, nil
+	}
+	c.mu.Unlock()
+
+	k := c.keyFor(addr)
+	if k == nil {
+		return nil, errors.New("aesgcm: no key for peer " + addr.String())
+	}
+	state, err := newAESGCMState(k, k, c.opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.peers[key]; ok { // set up concurrently
+		c.lru.MoveToFront(e)
+		return e.Value.(*aesgcmPeer).state, nil
+	}
+	c.peers[key] = c.lru.PushFront(&aesgcmPeer{addr: key, state: state})
+	for c.lru.Len() > c.maxPeers {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.peers, oldest.Value.(*aesgcmPeer).addr)
+	}
+	return state, nil
+}
+
+// ReadFrom reads the next datagram that authenticates with the key of its sender and returns its payload.
+// If p is too small for the payload, io.ErrShortBuffer is returned.
+func (c *aesgcmPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	bp := c.buf.Get().(*[]byte)
+	buf := *bp
+	defer c.buf.Put(bp)
+
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(buf)
+		if err != nil {
+			return 0, addr, err
+		}
+		if n == netx.MaxPacketSize {
+			continue // truncated
+		}
+		state, err := c.peer(addr)
+		if err != nil {
+			continue
+		}
+		n, _, _, err = state.open(p, buf[:n])
+		if err != nil && !errors.Is(err, io.ErrShortBuffer) {
+			continue
+		}
+		return n, addr, err
+	}
+}
+
+// WriteTo seals p with the key of addr and writes it as a single datagram.
+func (c *aesgcmPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	state, err := c.peer(addr)
+	if err != nil {
+		return 0, err
+	}
+	bp := c.buf.Get().(*[]byte)
+	defer c.buf.Put(bp)
+	pkt, err := state.seal(*bp, p)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := c.PacketConn.WriteTo(pkt, addr); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
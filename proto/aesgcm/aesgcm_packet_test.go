@@ -0,0 +1,77 @@
+package aesgcmproto_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	aesgcmproto "github.com/pedramktb/go-netx/proto/aesgcm"
+)
+
+func TestAESGCMPacketConn_PerPeerKeys(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer pc.Close()
+
+	dial := func(key []byte) net.Conn {
+		t.Helper()
+		raw, err := net.Dial("udp", pc.LocalAddr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { _ = raw.Close() })
+		c, err := aesgcmproto.NewAESGCMConn(raw, key, aesgcmproto.WithExplicitNonce(true))
+		if err != nil {
+			t.Fatalf("client aesgcm: %v", err)
+		}
+		return c
+	}
+	keyA, keyB := bytes.Repeat([]byte{0xa}, 32), bytes.Repeat([]byte{0xb}, 16)
+	peerA, peerB, stranger := dial(keyA), dial(keyB), dial(bytes.Repeat([]byte{0xc}, 32))
+	keys := map[string][]byte{
+		peerA.LocalAddr().String(): keyA,
+		peerB.LocalAddr().String(): keyB,
+	}
+	server, err := aesgcmproto.NewAESGCMPacketConn(pc, func(addr net.Addr) []byte { return keys[addr.String()] })
+	if err != nil {
+		t.Fatalf("server aesgcm: %v", err)
+	}
+	_ = server.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// datagrams of an unknown peer are dropped without disturbing the others
+	if _, err := stranger.Write([]byte("let me in")); err != nil {
+		t.Fatalf("stranger write: %v", err)
+	}
+	buf := make([]byte, 64)
+	for _, peer := range []net.Conn{peerA, peerB} {
+		msg := "hello from " + peer.LocalAddr().String()
+		if _, err := peer.Write([]byte(msg)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		n, addr, err := server.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("server read: %v", err)
+		}
+		if addr.String() != peer.LocalAddr().String() || string(buf[:n]) != msg {
+			t.Fatalf("server read %q from %v, want %q from %v", buf[:n], addr, msg, peer.LocalAddr())
+		}
+		reply := "reply to " + addr.String()
+		if _, err := server.WriteTo([]byte(reply), addr); err != nil {
+			t.Fatalf("server write: %v", err)
+		}
+		_ = peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if n, err := peer.Read(buf); err != nil || string(buf[:n]) != reply {
+			t.Fatalf("peer read %q, %v; want %q", buf[:n], err, reply)
+		}
+	}
+
+	if _, err := server.WriteTo([]byte("x"), stranger.LocalAddr()); err == nil {
+		t.Fatal("expected an error writing to a peer without a key")
+	}
+	if _, err := aesgcmproto.NewAESGCMPacketConn(pc, nil, aesgcmproto.WithReplayWindow(64)); err == nil {
+		t.Fatal("expected an error for options needing a handshake")
+	}
+}
@@ -0,0 +1,87 @@
+package aesgcmproto
+
+import (
+	"errors"
+	"sync"
+)
+
+// Errors returned by Read and ReadSeq for packets rejected by the replay window, see WithReplayWindow.
+// They only reject a single packet, so reading can continue.
+var (
+	ErrReplayDuplicate = errors.New("aesgcm: duplicate packet")
+	ErrReplayTooOld    = errors.New("aesgcm: packet too old for replay window")
+)
+
+// SeqReader is implemented by the conns returned by NewAESGCMConn and NewAESGCMConnRoled.
+type SeqReader interface {
+	// ReadSeq is like Read, but also returns the sequence number of the packet and whether it arrived
+	// reordered, behind a packet with a higher sequence number. Reordering is only tracked with
+	// WithReplayWindow, and sequence numbers are always 0 with WithExplicitNonce.
+	ReadSeq(p []byte) (n int, seq uint64, reordered bool, err error)
+}
+
+// WithReplayWindow makes Read reject replayed packets. Packets up to size sequence numbers behind the highest
+// one accepted so far are still accepted once, so packets reordered by the network are not lost, while
+// duplicates fail with ErrReplayDuplicate and packets further behind with ErrReplayTooOld. The window is kept
+// as a ring of 64-bit blocks as described in RFC 6479, and size is rounded up to a multiple of 64.
+// Only packets that authenticate update the window. It requires derived nonces and cannot be combined with
+// WithExplicitNonce. A size of 0 disables the window, the default.
+func WithReplayWindow(size uint32) Option {
+	return func(c *aesgcmConn) {
+		c.replay = nil
+		if size > 0 {
+			c.replay = newReplayWindow(size)
+		}
+	}
+}
+
+// replayWindow is the RFC 6479 anti-replay window: a ring of blocks with a bit per sequence number.
+// One block more than the window needs is kept, so that advancing the window only clears whole blocks.
+type replayWindow struct {
+	mu      sync.Mutex
+	blocks  []uint64
+	size    uint64 // number of sequence numbers behind the highest one that are accepted
+	highest uint64
+	started bool // whether any packet was accepted
+}
+
+func newReplayWindow(size uint32) *replayWindow {
+	n := (uint64(size) + 63) / 64
+	return &replayWindow{
+		blocks: make([]uint64, n+1),
+		size:   n * 64,
+	}
+}
+
+// check accepts seq if it has not been seen and is within the window, and reports whether it is behind
+// the highest sequence number accepted before.
+func (w *replayWindow) check(seq uint64) (reordered bool, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	nblocks := uint64(len(w.blocks))
+	block := seq / 64
+	switch {
+	case !w.started || seq > w.highest:
+		if !w.started {
+			clear(w.blocks)
+		} else {
+			// clear the blocks the window moves over, at most all of them
+			cur := w.highest / 64
+			for i := range min(block-cur, nblocks) {
+				w.blocks[(cur+i+1)%nblocks] = 0
+			}
+		}
+		w.started = true
+		w.highest = seq
+	case w.highest-seq >= w.size:
+		return false, ErrReplayTooOld
+	default:
+		reordered = true
+	}
+	bit := uint64(1) << (seq % 64)
+	if w.blocks[block%nblocks]&bit != 0 {
+		return false, ErrReplayDuplicate
+	}
+	w.blocks[block%nblocks] |= bit
+	return reordered, nil
+}
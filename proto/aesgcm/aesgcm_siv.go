@@ -0,0 +1,245 @@
+package aesgcmproto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// AEAD selects the authenticated cipher of an AESGCMConn.
+type AEAD int
+
+const (
+	AEADAESGCM    AEAD = iota // AES-GCM, the default
+	AEADAESGCMSIV             // AES-GCM-SIV (RFC 8452), nonce-misuse resistant
+)
+
+func (a AEAD) String() string {
+	switch a {
+	case AEADAESGCM:
+		return "gcm"
+	case AEADAESGCMSIV:
+		return "gcmsiv"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseAEAD parses an AEAD name as used by the aesgcm driver.
+func ParseAEAD(s string) (AEAD, error) {
+	switch strings.ToLower(s) {
+	case "gcm":
+		return AEADAESGCM, nil
+	case "gcmsiv", "gcm-siv":
+		return AEADAESGCMSIV, nil
+	default:
+		return 0, fmt.Errorf("unknown aead %q", s)
+	}
+}
+
+// WithAEAD selects the authenticated cipher. AEADAESGCMSIV derives the keys of every packet from the
+// nonce and the plaintext, so a repeated nonce (e.g. a sequence reset with a reused IV after a restart)
+// only reveals whether two packets carry identical plaintexts, while with AES-GCM it leaks the XOR of
+// the plaintexts and allows forging packets. It only supports 16 and 32 byte keys, and is considerably
+// slower than AES-GCM: besides the key derivation per packet, its POLYVAL hash is implemented in portable
+// Go while AES-GCM uses hardware acceleration where available. Both peers must use the same AEAD.
+func WithAEAD(a AEAD) Option {
+	return func(c *aesgcmConn) {
+		c.aead = a
+	}
+}
+
+func (a AEAD) new(key []byte) (cipher.AEAD, error) {
+	switch a {
+	case AEADAESGCM:
+		return newGCM(key)
+	case AEADAESGCMSIV:
+		return newGCMSIV(key)
+	default:
+		return nil, fmt.Errorf("aesgcm: unknown aead %d", a)
+	}
+}
+
+var errOpen = errors.New("cipher: message authentication failed")
+
+// gcmSIV implements AES-GCM-SIV as specified in RFC 8452.
+type gcmSIV struct {
+	block  cipher.Block // keyed with the key-generating key
+	keyLen int
+}
+
+func newGCMSIV(key []byte) (cipher.AEAD, error) {
+	if len(key) != 16 && len(key) != 32 {
+		return nil, fmt.Errorf("aesgcm: invalid AES-GCM-SIV key size %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &gcmSIV{block: block, keyLen: len(key)}, nil
+}
+
+func (*gcmSIV) NonceSize() int { return 12 }
+func (*gcmSIV) Overhead() int  { return 16 }
+
+func (g *gcmSIV) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != 12 {
+		panic("aesgcm: incorrect nonce length given to AES-GCM-SIV")
+	}
+	authKey, enc := g.deriveKeys(nonce)
+	tag := gcmSIVTag(authKey, enc, nonce, plaintext, additionalData)
+	ret, out := sliceForAppend(dst, len(plaintext)+16)
+	gcmSIVCTR(enc, tag, out[:len(plaintext)], plaintext)
+	copy(out[len(plaintext):], tag[:])
+	return ret
+}
+
+func (g *gcmSIV) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != 12 {
+		panic("aesgcm: incorrect nonce length given to AES-GCM-SIV")
+	}
+	if len(ciphertext) < 16 {
+		return nil, errOpen
+	}
+	var tag [16]byte
+	copy(tag[:], ciphertext[len(ciphertext)-16:])
+	ciphertext = ciphertext[:len(ciphertext)-16]
+
+	authKey, enc := g.deriveKeys(nonce)
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	gcmSIVCTR(enc, tag, out, ciphertext)
+	expected := gcmSIVTag(authKey, enc, nonce, out, additionalData)
+	if subtle.ConstantTimeCompare(expected[:], tag[:]) != 1 {
+		clear(out)
+		return nil, errOpen
+	}
+	return ret, nil
+}
+
+// deriveKeys derives the per-nonce message authentication and encryption keys.
+func (g *gcmSIV) deriveKeys(nonce []byte) ([16]byte, cipher.Block) {
+	var in, out [16]byte
+	var keys [48]byte
+	copy(in[4:], nonce)
+	for i := range 2 + g.keyLen/8 {
+		binary.LittleEndian.PutUint32(in[:4], uint32(i))
+		g.block.Encrypt(out[:], in[:])
+		copy(keys[i*8:], out[:8])
+	}
+	var authKey [16]byte
+	copy(authKey[:], keys[:16])
+	enc, _ := aes.NewCipher(keys[16 : 16+g.keyLen]) // the length is valid
+	return authKey, enc
+}
+
+func gcmSIVTag(authKey [16]byte, enc cipher.Block, nonce, plaintext, additionalData []byte) [16]byte {
+	p := newPolyval(authKey)
+	p.update(additionalData)
+	p.update(plaintext)
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[:8], uint64(len(additionalData))*8)
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(plaintext))*8)
+	p.update(lengths[:])
+	s := p.sum()
+	for i := range 12 {
+		s[i] ^= nonce[i]
+	}
+	s[15] &= 0x7f
+	enc.Encrypt(s[:], s[:])
+	return s
+}
+
+// gcmSIVCTR is AES-CTR with the tag as initial counter block and a 32-bit little-endian counter.
+func gcmSIVCTR(enc cipher.Block, tag [16]byte, dst, src []byte) {
+	ctr := tag
+	ctr[15] |= 0x80
+	var ks [16]byte
+	for len(src) > 0 {
+		enc.Encrypt(ks[:], ctr[:])
+		n := subtle.XORBytes(dst, src, ks[:])
+		dst, src = dst[n:], src[n:]
+		binary.LittleEndian.PutUint32(ctr[:4], binary.LittleEndian.Uint32(ctr[:4])+1)
+	}
+}
+
+// fieldElement is an element of the GHASH field, see NIST SP 800-38D.
+type fieldElement struct{ hi, lo uint64 }
+
+// mul multiplies x and y in the GHASH field, bit by bit.
+func (x fieldElement) mul(y fieldElement) fieldElement {
+	var z fieldElement
+	v := y
+	for i := range 128 {
+		var bit uint64
+		if i < 64 {
+			bit = x.hi >> (63 - i) & 1
+		} else {
+			bit = x.lo >> (127 - i) & 1
+		}
+		mask := -bit
+		z.hi ^= v.hi & mask
+		z.lo ^= v.lo & mask
+		v = v.mulX()
+	}
+	return z
+}
+
+// mulX multiplies x by the polynomial x in the GHASH field.
+func (x fieldElement) mulX() fieldElement {
+	lsb := x.lo & 1
+	x.lo = x.lo>>1 | x.hi<<63
+	x.hi >>= 1
+	x.hi ^= (0xe1 << 56) & -lsb
+	return x
+}
+
+// polyval computes POLYVAL by way of GHASH, as described in RFC 8452, Appendix A:
+// POLYVAL(H, X_1, ..., X_n) = ByteReverse(GHASH(mulX_GHASH(ByteReverse(H)), ByteReverse(X_1), ..., ByteReverse(X_n))).
+// A byte-reversed block read big-endian is the block read little-endian.
+type polyval struct {
+	h, s fieldElement
+}
+
+func newPolyval(key [16]byte) *polyval {
+	return &polyval{h: reversedElement(key[:]).mulX()}
+}
+
+func reversedElement(b []byte) fieldElement {
+	return fieldElement{hi: binary.LittleEndian.Uint64(b[8:16]), lo: binary.LittleEndian.Uint64(b[:8])}
+}
+
+// update hashes b, zero-padded to a multiple of the block size.
+func (p *polyval) update(b []byte) {
+	for len(b) > 0 {
+		var block [16]byte
+		n := copy(block[:], b)
+		b = b[n:]
+		x := reversedElement(block[:])
+		p.s.hi ^= x.hi
+		p.s.lo ^= x.lo
+		p.s = p.s.mul(p.h)
+	}
+}
+
+func (p *polyval) sum() [16]byte {
+	var out [16]byte
+	binary.LittleEndian.PutUint64(out[:8], p.s.lo)
+	binary.LittleEndian.PutUint64(out[8:], p.s.hi)
+	return out
+}
+
+// sliceForAppend extends in by n bytes and returns the whole slice and the extension.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
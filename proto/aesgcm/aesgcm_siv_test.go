@@ -0,0 +1,246 @@
+package aesgcmproto
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("bad hex %q: %v", s, err)
+	}
+	return b
+}
+
+// gcmSIVVectors are test vectors from RFC 8452, Appendix C.
+var gcmSIVVectors = []struct {
+	key, nonce, plaintext, ad, result string
+}{
+	{"01000000000000000000000000000000", "030000000000000000000000", "", "", "dc20e2d83f25705bb49e439eca56de25"},
+	{"01000000000000000000000000000000", "030000000000000000000000", "0100000000000000", "", "b5d839330ac7b786578782fff6013b815b287c22493a364c"},
+	{"01000000000000000000000000000000", "030000000000000000000000", "010000000000000000000000", "", "7323ea61d05932260047d942a4978db357391a0bc4fdec8b0d106639"},
+	{"01000000000000000000000000000000", "030000000000000000000000", "01000000000000000000000000000000", "", "743f7c8077ab25f8624e2e948579cf77303aaf90f6fe21199c6068577437a0c4"},
+	{"01000000000000000000000000000000", "030000000000000000000000", "0100000000000000000000000000000002000000000000000000000000000000", "", "84e07e62ba83a6585417245d7ec413a9fe427d6315c09b57ce45f2e3936a94451a8e45dcd4578c667cd86847bf6155ff"},
+	{"01000000000000000000000000000000", "030000000000000000000000", "0200000000000000", "01", "1e6daba35669f4273b0a1a2560969cdf790d99759abd1508"},
+	{"0100000000000000000000000000000000000000000000000000000000000000", "030000000000000000000000", "", "", "07f5f4169bbf55a8400cd47ea6fd400f"},
+	{"0100000000000000000000000000000000000000000000000000000000000000", "030000000000000000000000", "0100000000000000", "", "c2ef328e5c71c83b843122130f7364b761e0b97427e3df28"},
+}
+
+func TestGCMSIV_RFC8452Vectors(t *testing.T) {
+	for _, v := range gcmSIVVectors {
+		a, err := newGCMSIV(unhex(t, v.key))
+		if err != nil {
+			t.Fatalf("new: %v", err)
+		}
+		nonce, pt, ad := unhex(t, v.nonce), unhex(t, v.plaintext), unhex(t, v.ad)
+		got := a.Seal(nil, nonce, pt, ad)
+		if hex.EncodeToString(got) != v.result {
+			t.Fatalf("seal(key=%s, pt=%s) = %x, want %s", v.key, v.plaintext, got, v.result)
+		}
+		opened, err := a.Open(nil, nonce, got, ad)
+		if err != nil || !bytes.Equal(opened, pt) {
+			t.Fatalf("open = %x, %v, want %x", opened, err, pt)
+		}
+	}
+}
+
+// With a reused IV and a reset sequence, two packets are sealed under the same nonce. AES-GCM then
+// leaks the XOR of the plaintexts, AES-GCM-SIV only whether they are identical.
+func TestGCMSIV_NonceReuse(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	var iv [12]byte
+	var header [8]byte // sequence 0 after the reset
+	nonce := (&aesgcmConn{}).nonce(&iv, header[:])
+
+	p1 := []byte("transfer 100 to alice....")
+	p2 := []byte("transfer 999 to mallory..")
+	xorPlain := make([]byte, len(p1))
+	for i := range p1 {
+		xorPlain[i] = p1[i] ^ p2[i]
+	}
+	leaks := func(a interface {
+		Seal(dst, nonce, plaintext, additionalData []byte) []byte
+	}) bool {
+		c1 := a.Seal(nil, nonce[:], p1, header[:])
+		c2 := a.Seal(nil, nonce[:], p2, header[:])
+		for i := range p1 {
+			if c1[i]^c2[i] != xorPlain[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	gcm, err := AEADAESGCM.new(key)
+	if err != nil {
+		t.Fatalf("gcm: %v", err)
+	}
+	if !leaks(gcm) {
+		t.Fatal("expected AES-GCM to leak the XOR of plaintexts under a reused nonce")
+	}
+	siv, err := AEADAESGCMSIV.new(key)
+	if err != nil {
+		t.Fatalf("gcm-siv: %v", err)
+	}
+	if leaks(siv) {
+		t.Fatal("AES-GCM-SIV leaked the XOR of plaintexts under a reused nonce")
+	}
+	// identical plaintexts are the only thing that shows
+	if !bytes.Equal(siv.Seal(nil, nonce[:], p1, header[:]), siv.Seal(nil, nonce[:], p1, header[:])) {
+		t.Fatal("AES-GCM-SIV is expected to be deterministic for the same nonce and plaintext")
+	}
+}
+
+// refPolyval computes POLYVAL straight from its definition in RFC 8452, Section 3, with dot(a, b) = a*b*x^-128
+// in the field with the polynomial x^128 + x^127 + x^126 + x^121 + 1, as a check on polyval, which goes
+// through GHASH instead.
+func refPolyval(h [16]byte, data []byte) [16]byte {
+	type elem struct{ lo, hi uint64 } // coefficients of x^0 to x^127, as a little-endian block
+	load := func(b []byte) elem {
+		return elem{binary.LittleEndian.Uint64(b[:8]), binary.LittleEndian.Uint64(b[8:16])}
+	}
+	const red = 0xc2 << 56 // x^127 + x^126 + x^121 in the high word; x^0 is set separately
+	mulX := func(a elem) elem {
+		carry := a.hi >> 63
+		a.hi = a.hi<<1 | a.lo>>63
+		a.lo <<= 1
+		a.hi ^= red & -carry
+		a.lo ^= carry
+		return a
+	}
+	divX := func(a elem) elem {
+		odd := a.lo & 1
+		a.hi ^= red & -odd // adding the polynomial clears x^0 and sets x^128
+		a.lo ^= odd
+		a.lo = a.lo>>1 | a.hi<<63
+		a.hi = a.hi>>1 | odd<<63
+		return a
+	}
+	dot := func(a, b elem) elem {
+		var z elem
+		for i := 127; i >= 0; i-- {
+			z = mulX(z)
+			word := b.lo
+			if i >= 64 {
+				word = b.hi
+			}
+			if word>>(i%64)&1 == 1 {
+				z.lo ^= a.lo
+				z.hi ^= a.hi
+			}
+		}
+		for range 128 {
+			z = divX(z)
+		}
+		return z
+	}
+	hk := load(h[:])
+	var acc elem
+	for len(data) > 0 {
+		var block [16]byte
+		n := copy(block[:], data)
+		data = data[n:]
+		x := load(block[:])
+		acc = dot(elem{acc.lo ^ x.lo, acc.hi ^ x.hi}, hk)
+	}
+	var out [16]byte
+	binary.LittleEndian.PutUint64(out[:8], acc.lo)
+	binary.LittleEndian.PutUint64(out[8:], acc.hi)
+	return out
+}
+
+// Test vector from RFC 8452, Appendix A.
+func TestGCMSIV_PolyvalVector(t *testing.T) {
+	h := [16]byte(unhex(t, "25629347589242761d31f826ba4b757b"))
+	data := unhex(t, "4f4f95668c83dfb6401762bb2d01a262d1a24ddd2721d006bbe45f20d3c9f362")
+	want := "f7a3b47b846119fae5b7866cf5e5b77e"
+	p := newPolyval(h)
+	p.update(data)
+	if got := p.sum(); hex.EncodeToString(got[:]) != want {
+		t.Fatalf("polyval = %x, want %s", got, want)
+	}
+	if got := refPolyval(h, data); hex.EncodeToString(got[:]) != want {
+		t.Fatalf("reference polyval = %x, want %s", got, want)
+	}
+}
+
+// FuzzGCMSIV checks polyval against the reference POLYVAL, and that Seal and Open round trip and reject
+// tampered packets, starting from the RFC 8452 vectors.
+func FuzzGCMSIV(f *testing.F) {
+	for _, v := range gcmSIVVectors {
+		key, _ := hex.DecodeString(v.key)
+		nonce, _ := hex.DecodeString(v.nonce)
+		pt, _ := hex.DecodeString(v.plaintext)
+		ad, _ := hex.DecodeString(v.ad)
+		f.Add(key, nonce, pt, ad)
+	}
+	f.Fuzz(func(t *testing.T, key, nonce, pt, ad []byte) {
+		if len(key) != 16 && len(key) != 32 {
+			return
+		}
+		if len(nonce) != 12 {
+			return
+		}
+		a, err := newGCMSIV(key)
+		if err != nil {
+			t.Fatalf("new: %v", err)
+		}
+
+		var h [16]byte
+		copy(h[:], key)
+		data := append(append([]byte{}, ad...), pt...)
+		p := newPolyval(h)
+		p.update(data)
+		if got, want := p.sum(), refPolyval(h, data); got != want {
+			t.Fatalf("polyval = %x, reference %x", got, want)
+		}
+
+		sealed := a.Seal(nil, nonce, pt, ad)
+		if len(sealed) != len(pt)+a.Overhead() {
+			t.Fatalf("sealed %d bytes into %d", len(pt), len(sealed))
+		}
+		opened, err := a.Open(nil, nonce, sealed, ad)
+		if err != nil || !bytes.Equal(opened, pt) {
+			t.Fatalf("open = %x, %v, want %x", opened, err, pt)
+		}
+		for _, i := range []int{0, len(sealed) / 2, len(sealed) - 1} {
+			tampered := bytes.Clone(sealed)
+			tampered[i] ^= 0x01
+			if _, err := a.Open(nil, nonce, tampered, ad); !errors.Is(err, errOpen) {
+				t.Fatalf("open with byte %d flipped: %v", i, err)
+			}
+		}
+	})
+}
+
+func BenchmarkGCMSIV_Seal(b *testing.B) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	nonce := make([]byte, 12)
+	for _, aead := range []AEAD{AEADAESGCM, AEADAESGCMSIV} {
+		a, err := aead.new(key)
+		if err != nil {
+			b.Fatalf("%s: %v", aead, err)
+		}
+		for _, size := range []int{64, 1350, 16384} {
+			b.Run(fmt.Sprintf("%s/%d", aead, size), func(b *testing.B) {
+				benchmarkSeal(b, a, nonce, size)
+			})
+		}
+	}
+}
+
+func benchmarkSeal(b *testing.B, a cipher.AEAD, nonce []byte, size int) {
+	pt := make([]byte, size)
+	out := make([]byte, 0, size+a.Overhead())
+	b.SetBytes(int64(size))
+	for b.Loop() {
+		out = a.Seal(out[:0], nonce, pt, nil)
+	}
+}
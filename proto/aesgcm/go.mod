@@ -2,7 +2,10 @@ module github.com/pedramktb/go-netx/proto/aesgcm
 
 go 1.25.7
 
-require github.com/pedramktb/go-netx v1.4.0
+require (
+	github.com/klauspost/compress v1.18.5
+	github.com/pedramktb/go-netx v1.4.0
+)
 
 require (
 	github.com/pion/transport/v3 v3.1.1 // indirect
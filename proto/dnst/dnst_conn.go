@@ -18,11 +18,16 @@ package netx
 import (
 	"context"
 	"encoding/base32"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
@@ -31,58 +36,760 @@ import (
 
 const serverMaxRead = 512
 
-type serverConnCore struct {
+// PayloadTooLargeError is returned by client and server writes whose payload does not fit in a single query
+// or response. Allowed is the largest payload that fits, the MaxWrite of the conn, so that upper layers can
+// split their packets accordingly.
+type PayloadTooLargeError struct {
+	Size    int
+	Allowed int
+}
+
+func (e *PayloadTooLargeError) Error() string {
+	return fmt.Sprintf("dnst: payload of %d bytes exceeds max write of %d bytes", e.Size, e.Allowed)
+}
+
+// connCore holds the configuration shared by DNST client and server conns.
+type connCore struct {
 	logger   netx.Logger
+	metrics  Metrics
 	encoding *base32.Encoding
-	domain   string
+	foldCase bool // decode case-insensitively, set for custom alphabets
+	domains  []string
 	maxWrite uint16
-	buf      sync.Pool
+	maxRead  int
+	buf      sync.Pool // read buffers of maxRead bytes
+	msgHook  func(*dns.Msg)
+	idFunc   func() uint16
+	respName func(reqName string) string // nil answers with the question name
+	// pushName is the question name of unsolicited responses, set if full duplex is enabled
+	pushName string
+	duplex   bool
+	push     *pushQueue  // nil unless WithPushQueue is set
+	dedup    *dedupCache // nil unless WithDedup is set
+	// keepalive is the idle interval after which the client sends keepalive queries, 0 disables them
+	keepalive time.Duration
+	// session is the token label the client puts in front of the data, see WithSessionToken
+	session string
+	// sessionLabel makes the server take the first label of every query as a session token
+	sessionLabel bool
+	// retransmit and retries configure the resolver client, see WithRetransmit
+	retransmit time.Duration
+	retries    int
+	// msgHeader prefixes every message in a payload with its length, see WithMessageHeader
+	msgHeader bool
+	// pending holds the messages of the last payload read that were not returned yet, with their tag
+	pendingMu  sync.Mutex
+	pending    [][]byte
+	pendingTag any
+	// answerIndex spreads response payloads over indexed TXT answers, see WithAnswerIndexing
+	answerIndex bool
+	// clientSubnet is the EDNS Client Subnet option added to queries, nil if none, see WithClientSubnet
+	clientSubnet *dns.EDNS0_SUBNET
+	// idChannel carries the control value of the client in the high byte of query IDs, see WithIDChannel
+	idChannel bool
 }
 
 type serverConn struct {
 	conn net.Conn
-	serverConnCore
+	connCore
 }
 
-type ServerOption func(*serverConnCore)
+// Option configures a DNST client or server conn. Options that only apply to one side are documented as such.
+type Option func(*connCore)
+
+// ServerOption is an alias of Option kept for compatibility.
+type ServerOption = Option
 
 // WithMaxWrite sets the maximum ciphertext packet size accepted on server writes.
 // Default is 765 bytes, which given a 255 byte QNAME is the maximum based on a UDP transport with an MTU of 1500.
-func WithMaxWrite(size uint16) ServerOption {
-	return func(c *serverConnCore) {
+// Server only; the client limit is computed from the domain.
+func WithMaxWrite(size uint16) Option {
+	return func(c *connCore) {
 		c.maxWrite = size
 	}
 }
 
+// WithMaxRead sets the size of the pooled buffers packets are read into, which bounds the size of a
+// single DNS message that can be read. Default is 512 bytes for servers, the classic UDP DNS limit,
+// and netx.MaxPacketSize for clients. Reads reuse the buffers, so steady-state reads do not allocate them.
+func WithMaxRead(size uint16) Option {
+	return func(c *connCore) {
+		if size > 0 {
+			c.maxRead = int(size)
+		}
+	}
+}
+
+// WithMsgHook sets a function that is called with every query before it is packed, after the question,
+// ID and flags have been set. It can be used to change the shape of the queries, e.g. to add decoy questions,
+// EDNS0 OPT records or tweak flags, so they blend in with regular DNS traffic. The first question carries
+// the payload and must be left intact; servers only look at it. Client only.
+func WithMsgHook(hook func(*dns.Msg)) Option {
+	return func(c *connCore) {
+		c.msgHook = hook
+	}
+}
+
+// WithIDFunc sets the function generating the IDs of the queries, e.g. to use sequential or fixed IDs.
+// Default is dns.Id, which returns random IDs. The client neither matches response IDs nor retransmits
+// queries, so repeated IDs do not confuse it, but resolvers in the path may drop or merge concurrent queries
+// sharing an ID. Client only.
+func WithIDFunc(f func() uint16) Option {
+	return func(c *connCore) {
+		if f != nil {
+			c.idFunc = f
+		}
+	}
+}
+
+// WithIDChannel makes the client carry a control value, set with SetIDControl, in the high byte of the ID of
+// every data query, e.g. a sequence number or flags, which servers read from the tag of the query with IDControl.
+// The low byte is still taken from the ID function, see WithIDFunc, and responses echo the whole ID, so matching
+// responses to queries is unaffected, but only 8 bits of it stay random: resolvers in the path and the resolver
+// client, see WithRetransmit, may see IDs repeat sooner among many concurrent queries. Servers need no option.
+// Client only.
+func WithIDChannel(enabled bool) Option {
+	return func(c *connCore) {
+		c.idChannel = enabled
+	}
+}
+
+// IDControl returns the control value carried in the ID of the query of a tag returned by ReadTagged of a server
+// conn or passed by DNSTHandler, see WithIDChannel, or false if the tag carries no query. It is only meaningful for clients using
+// WithIDChannel; for others it returns part of a random ID.
+func IDControl(tag any) (uint8, bool) {
+	m, ok := tag.(*dns.Msg)
+	if st, isTagged := tag.(serverConnTagged); isTagged {
+		m, ok = st.dnsMsg, true
+	}
+	if !ok || m == nil {
+		return 0, false
+	}
+	return uint8(m.Id >> 8), true
+}
+
+// WithResponseName sets a function that returns the owner name of the TXT answer for a query name, e.g. to answer
+// for a CNAME target. If the returned name differs from the query name, the response starts with a CNAME record
+// from the query name to it, so the answer forms a regular CNAME chain that resolvers accept. Clients decode the
+// first TXT answer regardless of its name. The CNAME record takes room in the response, so WithMaxWrite may need
+// to be lowered accordingly. Server only.
+func WithResponseName(f func(reqName string) string) Option {
+	return func(c *connCore) {
+		c.respName = f
+	}
+}
+
+// WithFullDuplex lets the server write responses without a preceding query, by passing a nil tag to WriteTagged,
+// so data can be pushed to the client at any time instead of in lock-step with its queries. The unsolicited
+// responses answer a synthetic TXT query for the server's domain. This is only useful over reliable transports
+// that deliver them to the client, like a TCP or TLS stream, where it avoids the overhead of polling.
+// Server only; clients read any response that arrives, whether they queried for it or not.
+func WithFullDuplex(enabled bool) Option {
+	return func(c *connCore) {
+		c.duplex = enabled
+	}
+}
+
+// ErrPushQueueFull is returned by server writes that would be queued, see WithPushQueue, while the queue is full.
+var ErrPushQueueFull = errors.New("dnst: push queue full")
+
+// WithPushQueue lets the server write without a query to answer, by passing a nil tag to WriteTagged, over
+// transports that cannot deliver unsolicited responses, like UDP or a resolver. Up to size such writes are
+// queued and each is sent as the response to the next incoming query, e.g. an empty poll of a PollConn, before
+// the query is returned by ReadTagged. Its tag is then marked as answered, so a write with it is queued in turn
+// rather than answering the query twice, which keeps the responses in order. Writes fail with ErrPushQueueFull
+// while the queue is full. Ignored for nil tags with WithFullDuplex, which writes them right away. Server only.
+func WithPushQueue(size int) Option {
+	return func(c *connCore) {
+		c.push = nil
+		if size > 0 {
+			c.push = &pushQueue{size: size}
+		}
+	}
+}
+
+// msgHeaderLen is the length of the header in front of every message with WithMessageHeader.
+const msgHeaderLen = 2
+
+// WithMessageHeader prefixes every message in a query or response payload with its 2-byte big-endian length,
+// so a payload can carry several messages and each read returns exactly one, keeping the boundaries of writes
+// without a framing layer on top. Writes still send a single message per payload, but the server answers a
+// query with as many WithPushQueue writes as fit in a response, so a burst of pushes reaches the client with
+// one poll while still being read one by one. The header takes 2 bytes of MaxWrite. Both ends must agree.
+func WithMessageHeader(enabled bool) Option {
+	return func(c *connCore) {
+		c.msgHeader = enabled
+	}
+}
+
+// WithAnswerIndexing makes the server spread a response payload over several TXT answers, one per 255-byte
+// string, each starting with a string holding its index, and the client put them back together in the order of
+// their indexes. Resolvers may reorder the records of a response, e.g. to rotate them, which reordering the strings
+// of a single TXT answer avoids but cannot be relied on for several. Every answer repeats the owner name, so
+// WithMaxWrite may need to be lowered to keep responses within the transport's limit. Both ends must agree.
+func WithAnswerIndexing(enabled bool) Option {
+	return func(c *connCore) {
+		c.answerIndex = enabled
+	}
+}
+
+// ValidateAlphabet checks that alphabet can be used with WithAlphabet: it must consist of 32 letters and digits,
+// which are valid in DNS labels, that are distinct regardless of case.
+func ValidateAlphabet(alphabet string) error {
+	if len(alphabet) != 32 {
+		return fmt.Errorf("dnst: alphabet must have 32 characters, got %d", len(alphabet))
+	}
+	seen := make(map[byte]bool, 32)
+	for i := range len(alphabet) {
+		ch := alphabet[i]
+		if 'A' <= ch && ch <= 'Z' {
+			ch += 'a' - 'A'
+		}
+		if (ch < 'a' || ch > 'z') && (ch < '0' || ch > '9') {
+			return fmt.Errorf("dnst: invalid alphabet character %q", alphabet[i])
+		}
+		if seen[ch] {
+			return fmt.Errorf("dnst: duplicate alphabet character %q", alphabet[i])
+		}
+		seen[ch] = true
+	}
+	return nil
+}
+
+// WithAlphabet replaces the standard base32 alphabet used to encode payloads, e.g. to evade signatures
+// matching it. Both ends must use the same alphabet. Custom alphabets are decoded case-insensitively,
+// so resolvers randomizing the case of QNAMEs do not break them.
+// It panics if the alphabet is invalid, see ValidateAlphabet.
+func WithAlphabet(alphabet string) Option {
+	if err := ValidateAlphabet(alphabet); err != nil {
+		panic(err)
+	}
+	encoding := base32.NewEncoding(strings.ToLower(alphabet)).WithPadding(base32.NoPadding)
+	return func(c *connCore) {
+		c.encoding = encoding
+		c.foldCase = true
+	}
+}
+
+// WithSessionToken makes the client put token as a label in front of the data of every query, so a server
+// using WithSessionLabel can tell the queries of several clients apart, e.g. behind a single UDP socket where
+// the remote address is the resolver's. token must be a valid DNS label, see ValidateSessionToken,
+// and is compared case-insensitively since resolvers may change the case of query names. The label takes
+// room in the QNAME, so MaxWrite shrinks accordingly. Client only.
+func WithSessionToken(token string) Option {
+	return func(c *connCore) {
+		c.session = token
+	}
+}
+
+// ValidateSessionToken checks that token can be used with WithSessionToken: it must be a DNS label of at most
+// 63 letters, digits and hyphens, not starting or ending with a hyphen.
+func ValidateSessionToken(token string) error {
+	if len(token) == 0 || len(token) > 63 {
+		return fmt.Errorf("dnst: session token must have 1 to 63 characters, got %d", len(token))
+	}
+	if token[0] == '-' || token[len(token)-1] == '-' {
+		return fmt.Errorf("dnst: session token %q must not start or end with a hyphen", token)
+	}
+	for i := range len(token) {
+		ch := token[i]
+		if (ch < 'a' || ch > 'z') && (ch < 'A' || ch > 'Z') && (ch < '0' || ch > '9') && ch != '-' {
+			return fmt.Errorf("dnst: invalid session token character %q", ch)
+		}
+	}
+	return nil
+}
+
+// WithSessionLabel makes the server take the first label of every query as the session token of the client,
+// see WithSessionToken. The token, in lower case, is carried by the tags of ReadTagged and can be read with
+// SessionToken. A query without a token loses its first label of data to it, so all clients must use one.
+// Server only.
+func WithSessionLabel(enabled bool) Option {
+	return func(c *connCore) {
+		c.sessionLabel = enabled
+	}
+}
+
+// SessionToken returns the session token carried by a tag returned by ReadTagged of a server conn using
+// WithSessionLabel, or false if the tag carries none.
+func SessionToken(tag any) (string, bool) {
+	t, ok := tag.(serverConnTagged)
+	if !ok || t.session == "" {
+		return "", false
+	}
+	return t.session, true
+}
+
+// WithKeepalive makes the client send a query for the SOA record of its domain whenever it has not written
+// for interval, to hold NAT mappings and resolver state of an idle conn. Servers skip queries that are not
+// for TXT records, so keepalives are never delivered as data, and the client skips responses to them.
+// Keepalives stop when the conn is closed. Client only.
+func WithKeepalive(interval time.Duration) Option {
+	return func(c *connCore) {
+		c.keepalive = max(interval, 0)
+	}
+}
+
+// WithClientSubnet makes the client add an EDNS0 OPT record with an EDNS Client Subnet option (RFC 7871) for
+// subnet to every query, keepalives included, so authoritative servers and resolvers honoring it see that subnet
+// instead of the resolver's, e.g. to steer queries to a given anycast site. The option is added before WithMsgHook
+// runs. Servers only look at the question, so they ignore it. A zero subnet adds nothing. Client only.
+func WithClientSubnet(subnet net.IPNet) Option {
+	return func(c *connCore) {
+		c.clientSubnet = nil
+		if subnet.IP == nil {
+			return
+		}
+		ones, bits := subnet.Mask.Size()
+		ecs := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 2, SourceNetmask: uint8(ones), Address: subnet.IP.To16()}
+		if ip4 := subnet.IP.To4(); ip4 != nil {
+			ecs.Family, ecs.Address = 1, ip4
+			if bits == 8*net.IPv6len {
+				ecs.SourceNetmask = uint8(max(ones-96, 0))
+			}
+		}
+		c.clientSubnet = ecs
+	}
+}
+
+// addClientSubnet adds the EDNS Client Subnet option of WithClientSubnet to the query m, if any.
+func (c *connCore) addClientSubnet(m *dns.Msg) {
+	if c.clientSubnet == nil {
+		return
+	}
+	opt := m.IsEdns0()
+	if opt == nil {
+		m.SetEdns0(1232, false) // the UDP size recommended by DNS Flag Day 2020
+		opt = m.IsEdns0()
+	}
+	ecs := *c.clientSubnet
+	opt.Option = append(opt.Option, &ecs)
+}
+
+// WithDedup makes the server remember queries by QNAME and ID for window. A repeated query within the
+// window, e.g. a retransmission or a duplicate made by the network, is not delivered again by ReadTagged;
+// instead the response written for the first one, if any, is sent again. Server only.
+func WithDedup(window time.Duration) Option {
+	return func(c *connCore) {
+		c.dedup = nil
+		if window > 0 {
+			c.dedup = &dedupCache{window: window}
+		}
+	}
+}
+
 // WithServerLogger sets a logger for the connection to use for internal logging (e.g. for logging invalid packets).
-func WithServerLogger(logger netx.Logger) ServerOption {
-	return func(c *serverConnCore) {
+// Despite its name, it applies to client conns as well.
+func WithServerLogger(logger netx.Logger) Option {
+	return func(c *connCore) {
 		c.logger = logger
 	}
 }
 
+// WithDomains lets the server answer queries for additional domains besides the one it was created with,
+// e.g. to rotate domains or split load across them. A domain of the form "*.example.com" matches clients
+// using any single label below example.com as their domain (e.g. "a.example.com"); that label is not
+// treated as payload. Do not register a wildcard together with its bare domain as they are ambiguous.
+// When domains overlap, the longest matching domain is used to extract the payload.
+// Server only.
+func WithDomains(domains ...string) Option {
+	return func(c *connCore) {
+		for _, d := range domains {
+			c.addDomain(d)
+		}
+	}
+}
+
+func (c *connCore) init(domain string, readSize int, opts ...Option) {
+	c.logger = slog.Default()
+	c.metrics = noopMetrics{}
+	c.encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+	c.maxWrite = 765
+	c.maxRead = readSize
+	c.idFunc = dns.Id
+	c.addDomain(domain)
+	c.pushName = strings.TrimPrefix(aceDomain(domain), "*.") + "."
+	for _, o := range opts {
+		o(c)
+	}
+	c.maxWrite = c.messageLimit(c.maxWrite)
+	maxRead := c.maxRead
+	c.buf.New = func() any {
+		b := make([]byte, maxRead)
+		return &b
+	}
+}
+
+func (c *connCore) addDomain(domain string) {
+	domain = aceDomain(domain) + "."
+	// Keep the longest domains first so that overlapping suffixes resolve to the most specific one.
+	i := 0
+	for i < len(c.domains) && len(c.domains[i]) >= len(domain) {
+		if c.domains[i] == domain {
+			return
+		}
+		i++
+	}
+	c.domains = slices.Insert(c.domains, i, domain)
+}
+
+// matchDomain returns the part of qName in front of the matching domain, or false if no domain matches.
+func (c *connCore) matchDomain(qName string) (string, bool) {
+	lqName := strings.ToLower(qName)
+	for _, domain := range c.domains {
+		if base, ok := strings.CutPrefix(domain, "*."); ok {
+			rest, ok := strings.CutSuffix(lqName, "."+base)
+			if !ok {
+				continue
+			}
+			// Drop the label matched by the wildcard.
+			idx := strings.LastIndexByte(rest, '.')
+			if idx <= 0 {
+				continue
+			}
+			return qName[:idx], true
+		}
+		if strings.HasSuffix(lqName, "."+domain) {
+			return qName[:len(qName)-len(domain)-1], true
+		}
+	}
+	return "", false
+}
+
+// decodeQuery extracts the payload and, with WithSessionLabel, the session token from a DNS query.
+// It returns false for queries that should be skipped (no question, unrelated domain, bad encoding).
+func (c *connCore) decodeQuery(m *dns.Msg, remoteAddr net.Addr) (data []byte, session string, ok bool) {
+	if len(m.Question) == 0 {
+		c.logger.DebugContext(context.Background(), "dnst: received DNS query with no question, skipping", "remoteAddr", remoteAddr.Network()+"://"+remoteAddr.String())
+		return nil, "", false
+	}
+	qName := m.Question[0].Name
+	if qType := m.Question[0].Qtype; qType != dns.TypeTXT {
+		c.logger.DebugContext(context.Background(), "dnst: received non-TXT DNS query, skipping", "qName", qName, "qType", dns.TypeToString[qType], "remoteAddr", remoteAddr.Network()+"://"+remoteAddr.String())
+		return nil, "", false
+	}
+	encoded, matched := c.matchDomain(qName)
+	if !matched {
+		c.logger.DebugContext(context.Background(), "dnst: received DNS query for unrelated domain, skipping", "qName", qName, "remoteAddr", remoteAddr.Network()+"://"+remoteAddr.String())
+		return nil, "", false
+	}
+	if c.sessionLabel {
+		session, encoded, _ = strings.Cut(encoded, ".")
+		if session == "" {
+			c.logger.DebugContext(context.Background(), "dnst: received DNS query without session label, skipping", "qName", qName, "remoteAddr", remoteAddr.Network()+"://"+remoteAddr.String())
+			return nil, "", false
+		}
+		session = strings.ToLower(session)
+	}
+	// Remove label-separator dots inserted by the client to form valid DNS labels.
+	encoded = strings.ReplaceAll(encoded, ".", "")
+
+	data, err := c.decodeString(encoded)
+	if err != nil {
+		c.metrics.DecodeError()
+		c.logger.DebugContext(context.Background(), "dnst: received DNS query with invalid encoding, skipping", "error", err, "remoteAddr", remoteAddr.Network()+"://"+remoteAddr.String())
+		return nil, "", false
+	}
+	return data, session, true
+}
+
+func (c *connCore) decodeString(s string) ([]byte, error) {
+	if c.foldCase {
+		s = strings.ToLower(s)
+	}
+	return c.encoding.DecodeString(s)
+}
+
+// dedupCache remembers recent queries and the responses written for them, see WithDedup.
+type dedupCache struct {
+	window  time.Duration
+	mu      sync.Mutex
+	entries map[string]*dedupEntry
+	swept   time.Time
+}
+
+type dedupEntry struct {
+	expires time.Time
+	resp    []byte // nil until a response is written
+}
+
+func dedupKey(m *dns.Msg) string {
+	return strings.ToLower(m.Question[0].Name) + "#" + strconv.Itoa(int(m.Id))
+}
+
+// seen reports whether m is a duplicate of a query within the window, returning the response to resend
+// if there is one. Otherwise it records m. Expired entries are swept about once per window.
+func (d *dedupCache) seen(m *dns.Msg) ([]byte, bool) {
+	key := dedupKey(m)
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.entries[key]; ok && now.Before(e.expires) {
+		return e.resp, true
+	}
+	if d.entries == nil {
+		d.entries = make(map[string]*dedupEntry)
+	}
+	d.entries[key] = &dedupEntry{expires: now.Add(d.window)}
+	if now.Sub(d.swept) >= d.window {
+		for k, e := range d.entries {
+			if now.After(e.expires) {
+				delete(d.entries, k)
+			}
+		}
+		d.swept = now
+	}
+	return nil, false
+}
+
+// store records resp as the response to the query m.
+func (d *dedupCache) store(m *dns.Msg, resp []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.entries[dedupKey(m)]; ok {
+		e.resp = resp
+	}
+}
+
+// pushQueue holds the data of server writes waiting for a query to answer, see WithPushQueue.
+type pushQueue struct {
+	mu   sync.Mutex
+	data [][]byte
+	size int
+}
+
+// add queues a copy of b.
+func (q *pushQueue) add(b []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.data) >= q.size {
+		return ErrPushQueueFull
+	}
+	q.data = append(q.data, append([]byte{}, b...))
+	return nil
+}
+
+// next returns the oldest queued data, or false if there is none. It is safe to call on a nil queue.
+func (q *pushQueue) next() ([]byte, bool) {
+	if q == nil {
+		return nil, false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.data) == 0 {
+		return nil, false
+	}
+	b := q.data[0]
+	q.data[0] = nil
+	q.data = q.data[1:]
+	return b, true
+}
+
+// batch removes the oldest queued data that fits in limit bytes with a message header each, at least one if
+// any is queued, and returns it framed as a payload. It is safe to call on a nil queue.
+func (q *pushQueue) batch(limit int) ([]byte, bool) {
+	if q == nil {
+		return nil, false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var out []byte
+	n := 0
+	for n < len(q.data) && (n == 0 || len(out)+msgHeaderLen+len(q.data[n]) <= limit) {
+		out = appendMessage(out, q.data[n])
+		q.data[n] = nil
+		n++
+	}
+	q.data = q.data[n:]
+	return out, n > 0
+}
+
+// nextPush returns the payload that answers the next query from the push queue, or false if there is none.
+func (c *connCore) nextPush() ([]byte, bool) {
+	if c.msgHeader {
+		return c.push.batch(int(c.maxWrite) + msgHeaderLen)
+	}
+	return c.push.next()
+}
+
+// queuePush queues b as a response to a later query, see WithPushQueue.
+func (c *connCore) queuePush(b []byte) (int, error) {
+	if len(b) > int(c.maxWrite) {
+		c.metrics.PayloadTooLarge()
+		return 0, &PayloadTooLargeError{Size: len(b), Allowed: int(c.maxWrite)}
+	}
+	if err := c.push.add(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// pushQuery returns the synthetic query answered by unsolicited responses in full duplex mode.
+func (c *connCore) pushQuery() *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(c.pushName, dns.TypeTXT)
+	m.Id = c.idFunc()
+	return m
+}
+
+// messageLimit returns the largest message that fits in a payload of size bytes.
+func (c *connCore) messageLimit(size uint16) uint16 {
+	if !c.msgHeader {
+		return size
+	}
+	if size < msgHeaderLen {
+		return 0
+	}
+	return size - msgHeaderLen
+}
+
+// appendMessage appends b to dst with its message header, see WithMessageHeader.
+func appendMessage(dst, b []byte) []byte {
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(b)))
+	return append(dst, b...)
+}
+
+// splitPayload returns the messages of a payload read: the payload itself, or with WithMessageHeader the
+// messages it carries, of which there may be none.
+func (c *connCore) splitPayload(data []byte) ([][]byte, error) {
+	if !c.msgHeader {
+		return [][]byte{data}, nil
+	}
+	var msgs [][]byte
+	for len(data) > 0 {
+		if len(data) < msgHeaderLen {
+			return nil, errors.New("dnst: truncated message header")
+		}
+		n := int(binary.BigEndian.Uint16(data))
+		if len(data) < msgHeaderLen+n {
+			return nil, fmt.Errorf("dnst: message of %d bytes exceeds the %d bytes left in the payload", n, len(data)-msgHeaderLen)
+		}
+		msgs = append(msgs, data[msgHeaderLen:msgHeaderLen+n])
+		data = data[msgHeaderLen+n:]
+	}
+	return msgs, nil
+}
+
+// deliver returns the first of msgs and keeps the others, along with their tag, for nextPending.
+func (c *connCore) deliver(msgs [][]byte, tag any) []byte {
+	if len(msgs) == 0 {
+		return nil
+	}
+	if len(msgs) > 1 {
+		c.pendingMu.Lock()
+		c.pending, c.pendingTag = msgs[1:], tag
+		c.pendingMu.Unlock()
+	}
+	return msgs[0]
+}
+
+// nextPending returns the next message kept by deliver and its tag, or false if there is none.
+func (c *connCore) nextPending() ([]byte, any, bool) {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	if len(c.pending) == 0 {
+		return nil, nil, false
+	}
+	msg := c.pending[0]
+	c.pending = c.pending[1:]
+	return msg, c.pendingTag, true
+}
+
+// encodeResponse packs the message b into a TXT response for the given query.
+func (c *connCore) encodeResponse(reqMsg *dns.Msg, b []byte) ([]byte, error) {
+	if len(b) > int(c.maxWrite) {
+		c.metrics.PayloadTooLarge()
+		return nil, &PayloadTooLargeError{Size: len(b), Allowed: int(c.maxWrite)}
+	}
+	if c.msgHeader {
+		b = appendMessage(nil, b)
+	}
+	return c.packResponse(reqMsg, b)
+}
+
+// packResponse packs the payload b into a TXT response for the given query.
+func (c *connCore) packResponse(reqMsg *dns.Msg, b []byte) ([]byte, error) {
+	resp := new(dns.Msg)
+	resp.SetReply(reqMsg)
+	resp.Compress = false
+
+	// Split encoded string into chunks of 255 bytes max, as required by DNS TXT record format.
+	encoded := c.encoding.EncodeToString(b)
+	name := reqMsg.Question[0].Name
+	if c.respName != nil {
+		if target := dns.Fqdn(c.respName(name)); !strings.EqualFold(target, name) {
+			resp.Answer = append(resp.Answer, &dns.CNAME{
+				Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 0},
+				Target: target,
+			})
+			name = target
+		}
+	}
+	hdr := dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0}
+	if !c.answerIndex {
+		resp.Answer = append(resp.Answer, &dns.TXT{Hdr: hdr, Txt: splitString(encoded, 255)})
+		return resp.Pack()
+	}
+	chunks := splitString(encoded, 255)
+	if len(chunks) == 0 {
+		chunks = []string{""} // an empty payload still gets an answer
+	}
+	for i, chunk := range chunks {
+		txt := &dns.TXT{Hdr: hdr, Txt: []string{strconv.Itoa(i)}}
+		if chunk != "" {
+			txt.Txt = append(txt.Txt, chunk)
+		}
+		resp.Answer = append(resp.Answer, txt)
+	}
+	return resp.Pack()
+}
+
+// answerData returns the encoded payload of a response: the strings of its first TXT answer, or with
+// WithAnswerIndexing those of all its TXT answers in the order of their indexes.
+func (c *connCore) answerData(m *dns.Msg) (string, error) {
+	var txts []*dns.TXT
+	// skip e.g. the CNAME records of a chain, whatever the owner name of the TXT answers
+	for _, rr := range m.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			txts = append(txts, txt)
+		}
+	}
+	if len(txts) == 0 {
+		return "", errors.New("invalid dns response type")
+	}
+	if !c.answerIndex {
+		return strings.Join(txts[0].Txt, ""), nil
+	}
+	chunks := make([][]string, len(txts))
+	for _, txt := range txts {
+		if len(txt.Txt) == 0 {
+			return "", errors.New("dnst: TXT answer without an index")
+		}
+		i, err := strconv.Atoi(txt.Txt[0])
+		if err != nil || i < 0 || i >= len(chunks) {
+			return "", fmt.Errorf("dnst: invalid TXT answer index %q of %d answers", txt.Txt[0], len(txts))
+		}
+		if chunks[i] != nil {
+			return "", fmt.Errorf("dnst: duplicate TXT answer index %d", i)
+		}
+		chunks[i] = txt.Txt[1:]
+	}
+	var b strings.Builder
+	for _, chunk := range chunks {
+		for _, str := range chunk {
+			b.WriteString(str)
+		}
+	}
+	return b.String(), nil
+}
+
 // NewServerConn creates a new DNST server connection.
+// Internationalized domains, including those of WithDomains, are matched in their punycode form.
 // See how to use a DNST Tagged Conn:
 // https://github.com/pedramktb/go-netx/blob/main/docs/mux-tag-poll.md
 func NewServerConn(conn net.Conn, domain string, opts ...ServerOption) netx.TaggedConn {
-	ds := &serverConn{
-		conn: conn,
-		serverConnCore: serverConnCore{
-			logger:   slog.Default(),
-			encoding: base32.StdEncoding.WithPadding(base32.NoPadding),
-			domain:   strings.TrimSuffix(domain, ".") + ".",
-			maxWrite: 765,
-			buf: sync.Pool{
-				New: func() any {
-					b := make([]byte, serverMaxRead)
-					return &b
-				},
-			},
-		},
-	}
-	for _, o := range opts {
-		o(&ds.serverConnCore)
-	}
+	ds := &serverConn{conn: conn}
+	ds.init(domain, serverMaxRead, opts...)
 	return ds
 }
 
@@ -93,7 +800,13 @@ func (c *serverConn) MaxWrite() uint16 { return c.maxWrite }
 // Invalid packets (DNS parse errors, wrong domain, bad encoding, no question)
 // are silently skipped so that spurious DNS traffic on port 53 does not
 // terminate the connection.
+// Errors from the underlying conn, including read deadline timeouts, are returned unchanged,
+// so a read can be retried after extending the deadline.
 func (c *serverConn) ReadTagged(b []byte, tag *any) (n int, err error) {
+	if msg, pendingTag, ok := c.nextPending(); ok {
+		*tag = pendingTag
+		return copy(b, msg), nil
+	}
 	for {
 		bp := c.buf.Get().(*[]byte)
 		buf := *bp
@@ -106,67 +819,97 @@ func (c *serverConn) ReadTagged(b []byte, tag *any) (n int, err error) {
 		}
 		m := new(dns.Msg)
 		if err := m.Unpack(buf[:n]); err != nil {
+			c.metrics.DecodeError()
 			c.logger.DebugContext(context.Background(), "dnst: received invalid DNS packet, skipping", "error", err, "remoteAddr", c.RemoteAddr().Network()+"://"+c.RemoteAddr().String())
 			c.buf.Put(bp)
 			continue // skip invalid DNS packet
 		}
 		c.buf.Put(bp)
+		c.metrics.Query()
 
 		*tag = m
 
-		if len(m.Question) == 0 {
-			c.logger.DebugContext(context.Background(), "dnst: received DNS query with no question, skipping", "remoteAddr", c.RemoteAddr().Network()+"://"+c.RemoteAddr().String())
-			continue // skip DNS messages with no question
+		data, session, ok := c.decodeQuery(m, c.RemoteAddr())
+		if !ok {
+			continue
 		}
-		qName := m.Question[0].Name
-		if !strings.HasSuffix(strings.ToLower(qName), c.domain) {
-			c.logger.DebugContext(context.Background(), "dnst: received DNS query for unrelated domain, skipping", "qName", qName, "remoteAddr", c.RemoteAddr().Network()+"://"+c.RemoteAddr().String())
-			continue // skip queries for unrelated domains
+		msgs, err := c.splitPayload(data)
+		if err != nil {
+			c.metrics.DecodeError()
+			c.logger.DebugContext(context.Background(), "dnst: received DNS query with invalid message header, skipping", "error", err, "remoteAddr", c.RemoteAddr().Network()+"://"+c.RemoteAddr().String())
+			continue
 		}
-		encoded := qName[:len(qName)-len(c.domain)-1]
-		// Remove label-separator dots inserted by the client to form valid DNS labels.
-		encoded = strings.ReplaceAll(encoded, ".", "")
-
-		data, decErr := c.encoding.DecodeString(encoded)
-		if decErr != nil {
-			c.logger.DebugContext(context.Background(), "dnst: received DNS query with invalid encoding, skipping", "error", decErr, "remoteAddr", c.RemoteAddr().Network()+"://"+c.RemoteAddr().String())
-			continue // skip packets with invalid encoding
+		if c.sessionLabel {
+			*tag = serverConnTagged{dnsMsg: m, session: session}
 		}
-
-		return copy(b, data), nil
+		if c.dedup != nil {
+			if resp, dup := c.dedup.seen(m); dup {
+				if resp != nil {
+					if _, err := c.conn.Write(resp); err != nil {
+						return 0, err
+					}
+					c.metrics.Response()
+				}
+				continue
+			}
+		}
+		if pushed, ok := c.nextPush(); ok {
+			if err := c.answerPush(m, pushed); err != nil {
+				return 0, err
+			}
+			*tag = serverConnTagged{dnsMsg: m, session: session, answered: true}
+		}
+		return copy(b, c.deliver(msgs, *tag)), nil
 	}
 }
 
 // WriteTagged writes a packet using the provided DNS query context to form a response.
+// With WithFullDuplex, a nil tag writes an unsolicited response.
 func (c *serverConn) WriteTagged(b []byte, tag any) (n int, err error) {
 	reqMsg, ok := tag.(*dns.Msg)
-	if !ok || reqMsg == nil {
-		return 0, errors.New("invalid context for dnst write")
+	st, isTagged := tag.(serverConnTagged)
+	if isTagged && st.dnsMsg != nil {
+		reqMsg, ok = st.dnsMsg, true // with a session label
 	}
-
-	// Create Response
-	resp := new(dns.Msg)
-	resp.SetReply(reqMsg)
-	resp.Compress = false
-
-	// Split encoded string into chunks of 255 bytes max, as required by DNS TXT record format.
-	encoded := c.encoding.EncodeToString(b)
-	txt := &dns.TXT{
-		Hdr: dns.RR_Header{Name: reqMsg.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0},
-		Txt: splitString(encoded, 255),
+	if c.push != nil && (st.answered || tag == nil && !c.duplex) {
+		return c.queuePush(b)
 	}
-	resp.Answer = append(resp.Answer, txt)
-
-	out, err := resp.Pack()
+	if !ok || reqMsg == nil {
+		if tag != nil || !c.duplex {
+			return 0, errors.New("invalid context for dnst write")
+		}
+		reqMsg = c.pushQuery()
+	}
+	out, err := c.encodeResponse(reqMsg, b)
 	if err != nil {
 		return 0, err
 	}
+	if c.dedup != nil && ok {
+		c.dedup.store(reqMsg, out)
+	}
 	if _, err := c.conn.Write(out); err != nil {
 		return 0, err
 	}
+	c.metrics.Response()
 	return len(b), nil
 }
 
+// answerPush answers the query m with the payload b from the push queue.
+func (c *serverConn) answerPush(m *dns.Msg, b []byte) error {
+	out, err := c.packResponse(m, b)
+	if err != nil {
+		return err
+	}
+	if c.dedup != nil {
+		c.dedup.store(m, out)
+	}
+	if _, err := c.conn.Write(out); err != nil {
+		return err
+	}
+	c.metrics.Response()
+	return nil
+}
+
 func (c *serverConn) Close() error                       { return c.conn.Close() }
 func (c *serverConn) LocalAddr() net.Addr                { return c.conn.LocalAddr() }
 func (c *serverConn) RemoteAddr() net.Addr               { return c.conn.RemoteAddr() }
@@ -174,13 +917,16 @@ func (c *serverConn) SetDeadline(t time.Time) error      { return c.conn.SetDead
 func (c *serverConn) SetReadDeadline(t time.Time) error  { return c.conn.SetReadDeadline(t) }
 func (c *serverConn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
 
-// serverConnTagged is the tag returned by taggedServerConn.ReadTagged.
+// serverConnTagged is the tag returned by taggedServerConn.ReadTagged, and by serverConn.ReadTagged
+// with WithSessionLabel.
 // It carries both the parsed DNS message (for forming the reply) and the
 // tag from the underlying TaggedConn (for routing the write back to the
 // correct underlying connection, e.g. a specific TCP conn inside a Mux).
 type serverConnTagged struct {
-	dnsMsg  *dns.Msg
-	connTag any
+	dnsMsg   *dns.Msg
+	connTag  any
+	session  string // see WithSessionLabel
+	answered bool   // the query was answered with queued data, see WithPushQueue
 }
 
 // taggedServerConn is like serverConn but operates on an underlying TaggedConn
@@ -188,7 +934,7 @@ type serverConnTagged struct {
 // preserved end-to-end through the DNST layer.
 type taggedServerConn struct {
 	conn netx.TaggedConn
-	serverConnCore
+	connCore
 }
 
 // NewTaggedServerConn creates a new DNST server connection that operates on an
@@ -197,24 +943,8 @@ type taggedServerConn struct {
 // the DNS message (to form the TXT response) and the forwarded tag from the
 // underlying TaggedConn (to route the write back to the correct connection).
 func NewTaggedServerConn(conn netx.TaggedConn, domain string, opts ...ServerOption) netx.TaggedConn {
-	ds := &taggedServerConn{
-		conn: conn,
-		serverConnCore: serverConnCore{
-			logger:   slog.Default(),
-			encoding: base32.StdEncoding.WithPadding(base32.NoPadding),
-			domain:   strings.TrimSuffix(domain, ".") + ".",
-			maxWrite: 765,
-			buf: sync.Pool{
-				New: func() any {
-					b := make([]byte, serverMaxRead)
-					return &b
-				},
-			},
-		},
-	}
-	for _, o := range opts {
-		o(&ds.serverConnCore)
-	}
+	ds := &taggedServerConn{conn: conn}
+	ds.init(domain, serverMaxRead, opts...)
 	return ds
 }
 
@@ -224,7 +954,15 @@ func (c *taggedServerConn) MaxWrite() uint16 { return c.maxWrite }
 // Invalid packets (DNS parse errors, wrong domain, bad encoding, no question)
 // are silently skipped so that spurious DNS traffic on port 53 does not
 // terminate the connection.
+// Errors from the underlying conn, including read deadline timeouts, are returned unchanged,
+// so a read can be retried after extending the deadline.
 func (c *taggedServerConn) ReadTagged(b []byte, tag *any) (n int, err error) {
+	if msg, pendingTag, ok := c.nextPending(); ok {
+		if tag != nil {
+			*tag = pendingTag
+		}
+		return copy(b, msg), nil
+	}
 	for {
 		bp := c.buf.Get().(*[]byte)
 		buf := *bp
@@ -238,65 +976,98 @@ func (c *taggedServerConn) ReadTagged(b []byte, tag *any) (n int, err error) {
 		}
 		m := new(dns.Msg)
 		if err := m.Unpack(buf[:n]); err != nil {
+			c.metrics.DecodeError()
 			c.logger.DebugContext(context.Background(), "dnst: received invalid DNS packet, skipping", "error", err, "remoteAddr", c.RemoteAddr().Network()+"://"+c.RemoteAddr().String())
 			c.buf.Put(bp)
 			continue // skip invalid DNS packet
 		}
 		c.buf.Put(bp)
+		c.metrics.Query()
 
-		if tag != nil {
-			*tag = serverConnTagged{dnsMsg: m, connTag: subTag}
+		data, session, ok := c.decodeQuery(m, c.RemoteAddr())
+		if !ok {
+			continue
 		}
-
-		if len(m.Question) == 0 {
-			c.logger.DebugContext(context.Background(), "dnst: received DNS query with no question, skipping", "remoteAddr", c.RemoteAddr().Network()+"://"+c.RemoteAddr().String())
-			continue // skip DNS messages with no question
+		msgs, err := c.splitPayload(data)
+		if err != nil {
+			c.metrics.DecodeError()
+			c.logger.DebugContext(context.Background(), "dnst: received DNS query with invalid message header, skipping", "error", err, "remoteAddr", c.RemoteAddr().Network()+"://"+c.RemoteAddr().String())
+			continue
 		}
-		qName := m.Question[0].Name
-		if !strings.HasSuffix(strings.ToLower(qName), c.domain) {
-			c.logger.DebugContext(context.Background(), "dnst: received DNS query for unrelated domain, skipping", "qName", qName, "remoteAddr", c.RemoteAddr().Network()+"://"+c.RemoteAddr().String())
-			continue // skip queries for unrelated domains
+		st := serverConnTagged{dnsMsg: m, connTag: subTag, session: session}
+		if c.dedup != nil {
+			if resp, dup := c.dedup.seen(m); dup {
+				if resp != nil {
+					if _, err := c.conn.WriteTagged(resp, subTag); err != nil {
+						return 0, err
+					}
+					c.metrics.Response()
+				}
+				continue
+			}
 		}
-		encoded := qName[:len(qName)-len(c.domain)-1]
-		encoded = strings.ReplaceAll(encoded, ".", "")
-
-		data, decErr := c.encoding.DecodeString(encoded)
-		if decErr != nil {
-			c.logger.DebugContext(context.Background(), "dnst: received DNS query with invalid encoding, skipping", "error", decErr, "remoteAddr", c.RemoteAddr().Network()+"://"+c.RemoteAddr().String())
-			continue // skip packets with invalid encoding
+		if pushed, ok := c.nextPush(); ok {
+			if err := c.answerPush(st, pushed); err != nil {
+				return 0, err
+			}
+			st.answered = true
 		}
-		return copy(b, data), nil
+		if tag != nil {
+			*tag = st
+		}
+		return copy(b, c.deliver(msgs, st)), nil
 	}
 }
 
+// WriteTagged writes a packet using the provided DNS query context to form a response.
+// With WithFullDuplex, a tag that is not from ReadTagged writes an unsolicited response and is passed on
+// to the underlying conn as is.
 func (c *taggedServerConn) WriteTagged(b []byte, tag any) (n int, err error) {
 	ct, ok := tag.(serverConnTagged)
-	if !ok || ct.dnsMsg == nil {
-		return 0, errors.New("invalid context for dnst tagged write")
+	if c.push != nil && (ct.answered || tag == nil && !c.duplex) {
+		return c.queuePush(b)
 	}
-	reqMsg := ct.dnsMsg
-
-	resp := new(dns.Msg)
-	resp.SetReply(reqMsg)
-	resp.Compress = false
-
-	encoded := c.encoding.EncodeToString(b)
-	txt := &dns.TXT{
-		Hdr: dns.RR_Header{Name: reqMsg.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0},
-		Txt: splitString(encoded, 255),
+	solicited := ok && ct.dnsMsg != nil
+	if !solicited {
+		if !c.duplex {
+			return 0, errors.New("invalid context for dnst tagged write")
+		}
+		// an unsolicited response, any other tag is meant for the underlying conn
+		if !ok {
+			ct.connTag = tag
+		}
+		ct.dnsMsg = c.pushQuery()
 	}
-	resp.Answer = append(resp.Answer, txt)
-
-	out, err := resp.Pack()
+	out, err := c.encodeResponse(ct.dnsMsg, b)
 	if err != nil {
 		return 0, err
 	}
+	if c.dedup != nil && solicited {
+		c.dedup.store(ct.dnsMsg, out)
+	}
 	if _, err := c.conn.WriteTagged(out, ct.connTag); err != nil {
 		return 0, err
 	}
+	c.metrics.Response()
 	return len(b), nil
 }
 
+// answerPush answers the query of st with the payload b from the push queue.
+func (c *taggedServerConn) answerPush(st serverConnTagged, b []byte) error {
+	out, err := c.packResponse(st.dnsMsg, b)
+	if err != nil {
+		return err
+	}
+	if c.dedup != nil {
+		c.dedup.store(st.dnsMsg, out)
+	}
+	if _, err := c.conn.WriteTagged(out, st.connTag); err != nil {
+		return err
+	}
+	c.metrics.Response()
+	return nil
+}
+
 func (c *taggedServerConn) Close() error                       { return c.conn.Close() }
 func (c *taggedServerConn) LocalAddr() net.Addr                { return c.conn.LocalAddr() }
 func (c *taggedServerConn) RemoteAddr() net.Addr               { return c.conn.RemoteAddr() }
@@ -306,87 +1077,187 @@ func (c *taggedServerConn) SetWriteDeadline(t time.Time) error { return c.conn.S
 
 type clientConn struct {
 	net.Conn
-	encoding *base32.Encoding
-	domain   string
-	maxWrite uint16
-	buf      sync.Pool
+	connCore
+	domain    string
+	lastWrite atomic.Int64 // unix nanoseconds of the last write, for keepalives
+	idControl atomic.Uint32
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 // NewClientConn creates a new DNST client connection.
 // MaxWrite is automatically computed from the domain length, accounting for
 // Base32 encoding overhead and DNS QNAME label splitting.
-func NewClientConn(conn net.Conn, domain string) net.Conn {
+// An internationalized domain is ACE-encoded as punycode first, so the budget is that of the encoded
+// name; see ValidateDomain for the domains that can be used.
+func NewClientConn(conn net.Conn, domain string, opts ...Option) net.Conn {
 	dt := &clientConn{
-		Conn:     conn,
-		encoding: base32.StdEncoding.WithPadding(base32.NoPadding),
-		domain:   strings.TrimSuffix(domain, "."),
-		maxWrite: maxQNAMEPayload(strings.TrimSuffix(domain, ".")),
-		buf: sync.Pool{
-			New: func() any {
-				b := make([]byte, netx.MaxPacketSize)
-				return &b
-			},
-		},
+		Conn:   conn,
+		domain: aceDomain(domain),
+	}
+	dt.init(domain, netx.MaxPacketSize, opts...)
+	dt.maxWrite = dt.clientMaxWrite(dt.domain)
+	dt.done = make(chan struct{})
+	dt.lastWrite.Store(time.Now().UnixNano())
+	if dt.keepalive > 0 {
+		go dt.keepaliveLoop()
 	}
 	return dt
 }
 
+// clientMaxWrite returns the MaxWrite of a client for the ACE-encoded domain.
+func (c *connCore) clientMaxWrite(domain string) uint16 {
+	if c.session != "" {
+		domain = c.session + "." + domain
+	}
+	return c.messageLimit(maxQNAMEPayload(domain))
+}
+
+// keepaliveLoop sends a keepalive query whenever the conn has not written for the keepalive interval.
+func (c *clientConn) keepaliveLoop() {
+	timer := time.NewTimer(c.keepalive)
+	defer timer.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-timer.C:
+		}
+		idle := time.Since(time.Unix(0, c.lastWrite.Load()))
+		if idle < c.keepalive {
+			timer.Reset(c.keepalive - idle)
+			continue
+		}
+		m := new(dns.Msg)
+		m.SetQuestion(c.domain+".", dns.TypeSOA)
+		m.Id = c.idFunc()
+		m.RecursionDesired = true
+		c.addClientSubnet(m)
+		if out, err := m.Pack(); err == nil {
+			if _, err := c.Conn.Write(out); err != nil {
+				c.logger.DebugContext(context.Background(), "dnst: error writing keepalive", "error", err)
+			}
+		}
+		c.lastWrite.Store(time.Now().UnixNano())
+		timer.Reset(c.keepalive)
+	}
+}
+
+// SetIDControl sets the control value carried by the IDs of the following queries of a client using
+// WithIDChannel, see there. It has no effect otherwise.
+func (c *clientConn) SetIDControl(control uint8) {
+	c.idControl.Store(uint32(control))
+}
+
+// queryID returns the ID of a data query, carrying the control value with WithIDChannel.
+func (c *clientConn) queryID() uint16 {
+	if !c.idChannel {
+		return c.idFunc()
+	}
+	return uint16(c.idControl.Load())<<8 | c.idFunc()&0xff
+}
+
+// Close stops keepalives and closes the underlying conn.
+func (c *clientConn) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
+
 // MaxWrite returns the maximum raw payload that a single Write can carry in a DNS query QNAME.
 func (c *clientConn) MaxWrite() uint16 { return c.maxWrite }
 
+// Read reads a single DNS response and returns its decoded payload.
+// A response without answers is returned as a zero-length read, as the server had nothing to send
+// (e.g. in reply to a PollConn poll). Errors are reserved for malformed responses.
+// Responses to keepalives (see WithKeepalive) are skipped.
+// Read deadline timeouts of the underlying conn are returned unchanged, before any DNS decoding.
 func (c *clientConn) Read(b []byte) (n int, err error) {
+	if msg, _, ok := c.nextPending(); ok {
+		return copy(b, msg), nil
+	}
 	bp := c.buf.Get().(*[]byte)
 	buf := *bp
 	defer c.buf.Put(bp)
 
-	n, err = c.Conn.Read(buf)
-	if err != nil {
-		return 0, err
-	}
 	m := new(dns.Msg)
-	if err := m.Unpack(buf[:n]); err != nil {
-		return 0, err
+	for {
+		n, err = c.Conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		if err := m.Unpack(buf[:n]); err != nil {
+			c.metrics.DecodeError()
+			return 0, err
+		}
+		// skip responses to keepalives
+		if len(m.Question) == 0 || m.Question[0].Qtype == dns.TypeTXT {
+			break
+		}
 	}
+	c.metrics.Response()
 	if len(m.Answer) == 0 {
 		return 0, nil
 	}
-	// Extract TXT
-	txtRR, ok := m.Answer[0].(*dns.TXT)
-	if !ok {
-		return 0, errors.New("invalid dns response type")
+	dataStr, err := c.answerData(m)
+	if err != nil {
+		c.metrics.DecodeError()
+		return 0, err
 	}
-	if len(txtRR.Txt) == 0 {
+	if dataStr == "" {
 		return 0, nil
 	}
-	dataStr := strings.Join(txtRR.Txt, "")
 
-	decoded, err := c.encoding.DecodeString(dataStr)
+	decoded, err := c.decodeString(dataStr)
 	if err != nil {
+		c.metrics.DecodeError()
 		return 0, err
 	}
-	return copy(b, decoded), nil
+	msgs, err := c.splitPayload(decoded)
+	if err != nil {
+		c.metrics.DecodeError()
+		return 0, err
+	}
+	return copy(b, c.deliver(msgs, nil)), nil
 }
 
 func (c *clientConn) Write(b []byte) (n int, err error) {
-	encoded := c.encoding.EncodeToString(b)
+	if len(b) > int(c.maxWrite) {
+		c.metrics.PayloadTooLarge()
+		return 0, &PayloadTooLargeError{Size: len(b), Allowed: int(c.maxWrite)}
+	}
+	payload := b
+	if c.msgHeader {
+		payload = appendMessage(nil, b)
+	}
+	encoded := c.encoding.EncodeToString(payload)
 	// Split encoded data into labels of max 63 bytes to comply with DNS label length limit.
 	qname := splitString63(encoded) + "." + c.domain + "."
-	if len(qname) > 253 {
-		return 0, errors.New("dns packet too long")
+	if c.session != "" {
+		if encoded == "" {
+			qname = c.session + "." + c.domain + "."
+		} else {
+			qname = c.session + "." + qname
+		}
 	}
 
 	m := new(dns.Msg)
 	m.SetQuestion(qname, dns.TypeTXT)
-	m.Id = dns.Id()
+	m.Id = c.queryID()
 	m.RecursionDesired = true
+	c.addClientSubnet(m)
+	if c.msgHook != nil {
+		c.msgHook(m)
+	}
 
 	out, err := m.Pack()
 	if err != nil {
 		return 0, err
 	}
+	c.lastWrite.Store(time.Now().UnixNano())
 	if _, err := c.Conn.Write(out); err != nil {
 		return 0, err
 	}
+	c.metrics.Query()
 	return len(b), nil
 }
 
@@ -397,10 +1268,10 @@ func maxQNAMEPayload(domain string) uint16 {
 	// Length: (E + ceil(E/63) - 1) + 1 + len(domain) + 1 = E + ceil(E/63) + len(domain) + 1
 	// Must be <= 253:
 	//   E + ceil(E/63) <= 252 - len(domain)
-	available := uint16(252 - len(domain))
-	if available <= 0 {
+	if len(domain) >= 252 {
 		return 0
 	}
+	available := uint16(252 - len(domain))
 	// Approximate: E * 64/63 ≈ available → E ≈ available * 63/64
 	maxE := available * 63 / 64
 	for maxE > 0 && maxE+(maxE+62)/63 > available {
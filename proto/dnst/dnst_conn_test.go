@@ -2,9 +2,18 @@ package netx
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pedramktb/go-netx"
 )
 
 func TestDNST_EndToEnd(t *testing.T) {
@@ -124,3 +133,1097 @@ func TestDNST_PacketSize(t *testing.T) {
 		t.Errorf("Packet content mismatch. Want %s, Got %s", data, buf[:n])
 	}
 }
+
+func TestDNST_MultipleDomains(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	serverConn := NewServerConn(p1, "a.example.com", WithDomains("*.b.example.org", "tunnel.net"))
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			var tag any
+			n, err := serverConn.ReadTagged(buf, &tag)
+			if err != nil {
+				return
+			}
+			if _, err := serverConn.WriteTagged(buf[:n], tag); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, domain := range []string{"a.example.com", "x.b.example.org", "tunnel.net", "deep.b.example.org"} {
+		clientConn := NewClientConn(p2, domain)
+		msg := []byte("hello " + domain)
+		if _, err := clientConn.Write(msg); err != nil {
+			t.Fatalf("%s: write: %v", domain, err)
+		}
+		_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		buf := make([]byte, 1024)
+		n, err := clientConn.Read(buf)
+		if err != nil {
+			t.Fatalf("%s: read: %v", domain, err)
+		}
+		if !bytes.Equal(buf[:n], msg) {
+			t.Fatalf("%s: got %q, want %q", domain, buf[:n], msg)
+		}
+	}
+}
+
+func TestDNST_MultipleDomainsSkipsUnrelated(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	serverConn := NewServerConn(p1, "a.example.com", WithDomains("b.example.org"))
+
+	got := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, 1024)
+		var tag any
+		n, err := serverConn.ReadTagged(buf, &tag)
+		if err != nil {
+			return
+		}
+		got <- append([]byte(nil), buf[:n]...)
+	}()
+
+	// A query for an unknown domain (sharing a suffix without a label boundary) is skipped.
+	if _, err := NewClientConn(p2, "xb.example.org").Write([]byte("ignored")); err != nil {
+		t.Fatalf("write unrelated: %v", err)
+	}
+	if _, err := NewClientConn(p2, "b.example.org").Write([]byte("delivered")); err != nil {
+		t.Fatalf("write related: %v", err)
+	}
+	select {
+	case b := <-got:
+		if string(b) != "delivered" {
+			t.Fatalf("got %q, want %q", b, "delivered")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for query")
+	}
+}
+
+func TestDNST_ReadDeadline(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	serverConn := NewServerConn(p1, "tunnel.com")
+	clientConn := NewClientConn(p2, "tunnel.com")
+
+	// server side: an expired deadline surfaces as a timeout, not a DNS decode error
+	_ = serverConn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	buf := make([]byte, 1024)
+	var tag any
+	if _, err := serverConn.ReadTagged(buf, &tag); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("server read: want deadline exceeded, got %v", err)
+	}
+
+	// client side
+	_ = clientConn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := clientConn.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("client read: want deadline exceeded, got %v", err)
+	}
+
+	// extending the deadlines makes both sides usable again
+	_ = serverConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	errCh := make(chan error, 1)
+	go func() {
+		sbuf := make([]byte, 1024)
+		var tag any
+		n, err := serverConn.ReadTagged(sbuf, &tag)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		_, err = serverConn.WriteTagged(sbuf[:n], tag)
+		errCh <- err
+	}()
+	data := []byte("after deadline")
+	if _, err := clientConn.Write(data); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	n, err := clientConn.Read(buf)
+	if err != nil {
+		t.Fatalf("read after extending deadline: %v", err)
+	}
+	if !bytes.Equal(data, buf[:n]) {
+		t.Fatalf("got %q, want %q", buf[:n], data)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("server: %v", err)
+	}
+}
+
+func TestDNST_MaxRead(t *testing.T) {
+	for _, size := range []uint16{512, 4096, 65535} {
+		p1, p2 := net.Pipe()
+		serverConn := NewServerConn(p1, "tunnel.com", WithMaxRead(size))
+		clientConn := NewClientConn(p2, "tunnel.com", WithMaxRead(size))
+
+		go func() {
+			buf := make([]byte, 1024)
+			for {
+				var tag any
+				n, err := serverConn.ReadTagged(buf, &tag)
+				if err != nil {
+					return
+				}
+				_, _ = serverConn.WriteTagged(buf[:n], tag)
+			}
+		}()
+
+		// several rounds so pooled buffers get reused
+		for i := range 3 {
+			data := bytes.Repeat([]byte{byte('a' + i)}, 40)
+			if _, err := clientConn.Write(data); err != nil {
+				t.Fatalf("size %d: write: %v", size, err)
+			}
+			_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			buf := make([]byte, 1024)
+			n, err := clientConn.Read(buf)
+			if err != nil {
+				t.Fatalf("size %d: read: %v", size, err)
+			}
+			if !bytes.Equal(data, buf[:n]) {
+				t.Fatalf("size %d: got %q, want %q", size, buf[:n], data)
+			}
+		}
+		_ = p1.Close()
+		_ = p2.Close()
+	}
+}
+
+// responseConn returns the same packed DNS response on every read.
+type responseConn struct {
+	net.Conn
+	resp []byte
+}
+
+func (c *responseConn) Read(b []byte) (int, error) { return copy(b, c.resp), nil }
+
+func BenchmarkDNST_ClientRead(b *testing.B) {
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+	serverConn := NewServerConn(p1, "tunnel.com")
+	clientConn := NewClientConn(p2, "tunnel.com")
+
+	// capture a real response by running one exchange
+	go func() {
+		buf := make([]byte, 1024)
+		var tag any
+		n, err := serverConn.ReadTagged(buf, &tag)
+		if err != nil {
+			return
+		}
+		_, _ = serverConn.WriteTagged(buf[:n], tag)
+	}()
+	if _, err := clientConn.Write([]byte("benchmark payload")); err != nil {
+		b.Fatalf("write: %v", err)
+	}
+	resp := make([]byte, 1024)
+	n, err := p2.Read(resp)
+	if err != nil {
+		b.Fatalf("read: %v", err)
+	}
+
+	for _, size := range []uint16{512, 65535} {
+		b.Run(fmt.Sprintf("maxread=%d", size), func(b *testing.B) {
+			c := NewClientConn(&responseConn{resp: resp[:n]}, "tunnel.com", WithMaxRead(size))
+			buf := make([]byte, 1024)
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := c.Read(buf); err != nil {
+					b.Fatalf("read: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestDNST_MsgHook(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	serverConn := NewServerConn(p1, "tunnel.com")
+	var hooked int
+	clientConn := NewClientConn(p2, "tunnel.com", WithMsgHook(func(m *dns.Msg) {
+		hooked++
+		m.Question = append(m.Question, dns.Question{Name: "www.example.org.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+		m.SetEdns0(1232, false)
+		m.RecursionDesired = false
+	}))
+
+	errCh := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1024)
+		var tag any
+		n, err := serverConn.ReadTagged(buf, &tag)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		if m := tag.(*dns.Msg); len(m.Question) != 2 || m.IsEdns0() == nil || m.RecursionDesired {
+			errCh <- errors.New("server did not receive the hooked query")
+			return
+		}
+		_, err = serverConn.WriteTagged(buf[:n], tag)
+		errCh <- err
+	}()
+
+	data := []byte("hooked payload")
+	if _, err := clientConn.Write(data); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1024)
+	n, err := clientConn.Read(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(data, buf[:n]) {
+		t.Fatalf("got %q, want %q", buf[:n], data)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("server: %v", err)
+	}
+	if hooked != 1 {
+		t.Fatalf("hook called %d times, want 1", hooked)
+	}
+}
+
+func TestDNST_ClientSubnet(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	_, subnet, err := net.ParseCIDR("203.0.113.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConn := NewClientConn(p2, "tunnel.com", WithClientSubnet(*subnet))
+	go func() {
+		_, _ = clientConn.Write([]byte("steered"))
+	}()
+	_ = p1.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1024)
+	n, err := p1.Read(buf)
+	if err != nil {
+		t.Fatalf("raw read: %v", err)
+	}
+	raw := append([]byte(nil), buf[:n]...)
+	m := new(dns.Msg)
+	if err := m.Unpack(raw); err != nil {
+		t.Fatalf("unpack: %v", err)
+	}
+	opt := m.IsEdns0()
+	if opt == nil || len(opt.Option) != 1 {
+		t.Fatalf("want an OPT record with one option, got %v", m.Extra)
+	}
+	ecs, ok := opt.Option[0].(*dns.EDNS0_SUBNET)
+	if !ok || ecs.Family != 1 || ecs.SourceNetmask != 24 || !ecs.Address.Equal(net.ParseIP("203.0.113.0")) {
+		t.Fatalf("unexpected option %v", opt.Option[0])
+	}
+
+	// the server ignores the option and reads the payload as usual
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	go func() {
+		_, _ = c.Write(raw)
+	}()
+	serverConn := NewServerConn(s, "tunnel.com")
+	_ = serverConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var tag any
+	n, err = serverConn.ReadTagged(buf, &tag)
+	if err != nil {
+		t.Fatalf("server read: %v", err)
+	}
+	if string(buf[:n]) != "steered" {
+		t.Fatalf("got %q, want %q", buf[:n], "steered")
+	}
+}
+
+func TestDNST_IDFunc(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	var next uint16 = 100
+	clientConn := NewClientConn(p2, "tunnel.com", WithIDFunc(func() uint16 {
+		next++
+		return next
+	}))
+
+	errCh := make(chan error, 1)
+	go func() {
+		for i := range 3 {
+			if _, err := fmt.Fprintf(clientConn, "query %d", i); err != nil {
+				errCh <- err
+				return
+			}
+		}
+		errCh <- nil
+	}()
+
+	buf := make([]byte, 1024)
+	for want := uint16(101); want <= 103; want++ {
+		n, err := p1.Read(buf)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		m := new(dns.Msg)
+		if err := m.Unpack(buf[:n]); err != nil {
+			t.Fatalf("unpack: %v", err)
+		}
+		if m.Id != want {
+			t.Fatalf("query ID = %d, want %d", m.Id, want)
+		}
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func TestDNST_IDChannel(t *testing.T) {
+	// a stub resolver that answers with the control value of the query in front of its data, after a stray
+	// response whose ID carries the same control value
+	echo := DNSTHandler("t.example.com", func(tag any, data []byte) []byte {
+		control, ok := IDControl(tag)
+		if !ok {
+			t.Error("no query in the handler tag")
+		}
+		return append([]byte{control}, data...)
+	})
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		stray := new(dns.Msg).SetReply(m)
+		stray.Id = m.Id ^ 1
+		_ = w.WriteMsg(stray)
+		echo(w, m)
+	})
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &dns.Server{PacketConn: pc, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	<-started
+
+	client, err := NewDNSTResolverClientConn(pc.LocalAddr().String(), "udp", "t.example.com", WithIDChannel(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	_ = client.SetDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64)
+	for _, control := range []uint8{0, 7, 0xff} {
+		client.(interface{ SetIDControl(uint8) }).SetIDControl(control)
+		for range 3 {
+			if _, err := client.Write([]byte("ping")); err != nil {
+				t.Fatal(err)
+			}
+			// the stray response is dropped, so the answer matched by ID is read
+			n, err := client.Read(buf)
+			if err != nil || n != 5 || buf[0] != control || string(buf[1:n]) != "ping" {
+				t.Fatalf("read %q, %v; want control %d and %q", buf[:n], err, control, "ping")
+			}
+		}
+	}
+}
+
+func TestDNST_FullDuplexPush(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	if _, err := NewServerConn(p1, "tunnel.com").WriteTagged([]byte("x"), nil); err == nil {
+		t.Fatal("expected an error for an untagged write without full duplex")
+	}
+
+	serverConn := NewServerConn(p1, "tunnel.com", WithFullDuplex(true))
+	clientConn := NewClientConn(p2, "tunnel.com")
+
+	data := []byte("pushed by the server")
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := serverConn.WriteTagged(data, nil)
+		errCh <- err
+	}()
+
+	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1024)
+	n, err := clientConn.Read(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(data, buf[:n]) {
+		t.Fatalf("got %q, want %q", buf[:n], data)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("server write: %v", err)
+	}
+}
+
+func TestDNST_PushQueue(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	serverConn := NewServerConn(p1, "tunnel.com", WithPushQueue(2))
+	clientConn := NewClientConn(p2, "tunnel.com")
+
+	// written before any query arrives
+	for _, msg := range []string{"early", "second"} {
+		if _, err := serverConn.WriteTagged([]byte(msg), nil); err != nil {
+			t.Fatalf("queue %q: %v", msg, err)
+		}
+	}
+	if _, err := serverConn.WriteTagged([]byte("third"), nil); !errors.Is(err, ErrPushQueueFull) {
+		t.Fatalf("write to a full queue: got %v, want ErrPushQueueFull", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1024)
+		for range 3 {
+			var tag any
+			n, err := serverConn.ReadTagged(buf, &tag)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			// the query was answered with queued data, so the reply goes behind it
+			if _, err := serverConn.WriteTagged(append([]byte("re:"), buf[:n]...), tag); err != nil {
+				errCh <- err
+				return
+			}
+		}
+		errCh <- nil
+	}()
+
+	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1024)
+	for i, want := range []string{"early", "second", "re:poll0"} {
+		if _, err := fmt.Fprintf(clientConn, "poll%d", i); err != nil {
+			t.Fatalf("poll %d: %v", i, err)
+		}
+		n, err := clientConn.Read(buf)
+		if err != nil {
+			t.Fatalf("read %d: %v", i, err)
+		}
+		if string(buf[:n]) != want {
+			t.Fatalf("response %d: got %q, want %q", i, buf[:n], want)
+		}
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("server: %v", err)
+	}
+}
+
+func TestDNST_MessageHeader(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	serverConn := NewServerConn(p1, "tunnel.com", WithPushQueue(4), WithMessageHeader(true))
+	clientConn := NewClientConn(p2, "tunnel.com", WithMessageHeader(true))
+
+	if got, want := serverConn.(interface{ MaxWrite() uint16 }).MaxWrite(), uint16(765-2); got != want {
+		t.Fatalf("server MaxWrite = %d, want %d", got, want)
+	}
+	if got, want := clientConn.(interface{ MaxWrite() uint16 }).MaxWrite(), maxQNAMEPayload("tunnel.com")-2; got != want {
+		t.Fatalf("client MaxWrite = %d, want %d", got, want)
+	}
+
+	// two messages in quick succession ride on the response to a single query
+	for _, msg := range []string{"first", "second"} {
+		if _, err := serverConn.WriteTagged([]byte(msg), nil); err != nil {
+			t.Fatalf("queue %q: %v", msg, err)
+		}
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1024)
+		var tag any
+		n, err := serverConn.ReadTagged(buf, &tag)
+		if err == nil && string(buf[:n]) != "poll" {
+			err = fmt.Errorf("server read %q, want %q", buf[:n], "poll")
+		}
+		errCh <- err
+	}()
+
+	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := clientConn.Write([]byte("poll")); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	buf := make([]byte, 1024)
+	for i, want := range []string{"first", "second"} {
+		n, err := clientConn.Read(buf)
+		if err != nil {
+			t.Fatalf("read %d: %v", i, err)
+		}
+		if string(buf[:n]) != want {
+			t.Fatalf("read %d: got %q, want %q", i, buf[:n], want)
+		}
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("server: %v", err)
+	}
+
+	// a message header claiming more than is left of the payload does not decode
+	if _, err := (&connCore{msgHeader: true}).splitPayload([]byte{0, 5, 'a'}); err == nil {
+		t.Fatal("expected an error for a message longer than its payload")
+	}
+}
+
+func TestDNST_Alphabet(t *testing.T) {
+	const alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUV"
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	serverConn := NewServerConn(p1, "tunnel.com", WithAlphabet(alphabet))
+	clientConn := NewClientConn(p2, "tunnel.com", WithAlphabet(alphabet))
+
+	errCh := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1024)
+		var tag any
+		n, err := serverConn.ReadTagged(buf, &tag)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		qName := tag.(*dns.Msg).Question[0].Name
+		if strings.ContainsAny(strings.TrimSuffix(qName, ".tunnel.com."), "wxyzWXYZ") {
+			errCh <- fmt.Errorf("query %q uses characters outside the alphabet", qName)
+			return
+		}
+		_, err = serverConn.WriteTagged(buf[:n], tag)
+		errCh <- err
+	}()
+
+	data := []byte("custom alphabet payload")
+	if _, err := clientConn.Write(data); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1024)
+	n, err := clientConn.Read(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(data, buf[:n]) {
+		t.Fatalf("got %q, want %q", buf[:n], data)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("server: %v", err)
+	}
+
+	for _, bad := range []string{"short", "0123456789abcdefghijklmnopqrstuU", "0123456789abcdefghijklmnopqrst-_"} {
+		if err := ValidateAlphabet(bad); err == nil {
+			t.Fatalf("ValidateAlphabet(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestDNST_AlphabetMismatch(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	var sm countingMetrics
+	// "hello" encodes to "nbswy3dp" in the client's alphabet; w and y are not part of the server's
+	serverConn := NewServerConn(p1, "tunnel.com", WithAlphabet("0123456789abcdefghijklmnopqrstuv"), WithMetrics(&sm))
+	clientConn := NewClientConn(p2, "tunnel.com", WithAlphabet("abcdefghijklmnopqrstuvwxyz234567"))
+
+	go func() { _, _ = clientConn.Write([]byte("hello")) }()
+	_ = serverConn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	var tag any
+	if n, err := serverConn.ReadTagged(make([]byte, 1024), &tag); err == nil {
+		t.Fatalf("server decoded %d bytes with a mismatched alphabet", n)
+	}
+	if sm.decodeErrors.Load() != 1 {
+		t.Fatalf("decode errors = %d, want 1", sm.decodeErrors.Load())
+	}
+}
+
+func TestDNST_DedupDeliversOnce(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	serverConn := NewServerConn(p1, "tunnel.com", WithDedup(time.Minute))
+	// a fixed ID makes the client send byte-identical queries for the same payload, like a retransmission
+	clientConn := NewClientConn(p2, "tunnel.com", WithIDFunc(func() uint16 { return 42 }))
+
+	delivered := make(chan string, 4)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			var tag any
+			n, err := serverConn.ReadTagged(buf, &tag)
+			if err != nil {
+				close(delivered)
+				return
+			}
+			delivered <- string(buf[:n])
+			if _, err := serverConn.WriteTagged(append([]byte("re: "), buf[:n]...), tag); err != nil {
+				close(delivered)
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 1024)
+	for i, payload := range []string{"first", "first", "second"} {
+		if _, err := clientConn.Write([]byte(payload)); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+		_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := clientConn.Read(buf)
+		if err != nil {
+			t.Fatalf("read %d: %v", i, err)
+		}
+		if got := string(buf[:n]); got != "re: "+payload {
+			t.Fatalf("response %d = %q, want %q", i, got, "re: "+payload)
+		}
+	}
+	for _, want := range []string{"first", "second"} {
+		if got := <-delivered; got != want {
+			t.Fatalf("delivered %q, want %q", got, want)
+		}
+	}
+}
+
+func TestDNST_MaxWriteReachesTopOfStack(t *testing.T) {
+	c, s := net.Pipe()
+	defer s.Close()
+	client := NewClientConn(c, "t.example.com")
+	want := client.(interface{ MaxWrite() uint16 }).MaxWrite()
+
+	top := netx.NewFrameConn(netx.NewPollConn(client))
+	defer top.Close()
+	mw, ok := top.(interface{ MaxWrite() uint16 })
+	if !ok {
+		t.Fatal("dnst+poll+frame does not expose MaxWrite")
+	}
+	if got := mw.MaxWrite(); got != want || got == 0 {
+		t.Fatalf("MaxWrite at the top is %d, want the dnst limit %d", got, want)
+	}
+}
+
+func TestDNST_ClientEmptyAnswerIsZeroRead(t *testing.T) {
+	c, s := net.Pipe()
+	defer s.Close()
+	client := NewClientConn(c, "t.example.com")
+	defer client.Close()
+
+	query := new(dns.Msg)
+	query.SetQuestion("poll.t.example.com.", dns.TypeTXT)
+	resp := new(dns.Msg)
+	resp.SetReply(query)
+	empty, err := resp.Pack()
+	if err != nil {
+		t.Fatalf("pack: %v", err)
+	}
+	go func() {
+		_, _ = s.Write(empty)
+		_, _ = s.Write(empty[:5]) // truncated header
+	}()
+
+	_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 64)
+	if n, err := client.Read(buf); n != 0 || err != nil {
+		t.Fatalf("want a clean zero-length read for an answer-less response, got n=%d err=%v", n, err)
+	}
+	if _, err := client.Read(buf); err == nil {
+		t.Fatal("want an error for a malformed response")
+	}
+}
+
+func TestDNST_ResponseName(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	serverConn := NewServerConn(p1, "tunnel.com", WithResponseName(func(reqName string) string {
+		return "cdn-" + reqName
+	}))
+	go func() {
+		_, _ = NewClientConn(p2, "tunnel.com").Write([]byte("query"))
+	}()
+	_ = serverConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1024)
+	var tag any
+	if _, err := serverConn.ReadTagged(buf, &tag); err != nil {
+		t.Fatalf("server read: %v", err)
+	}
+
+	data := []byte("answered under another name")
+	go func() {
+		_, _ = serverConn.WriteTagged(data, tag)
+	}()
+	_ = p2.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := p2.Read(buf)
+	if err != nil {
+		t.Fatalf("raw read: %v", err)
+	}
+	raw := append([]byte(nil), buf[:n]...)
+	m := new(dns.Msg)
+	if err := m.Unpack(raw); err != nil {
+		t.Fatalf("unpack: %v", err)
+	}
+	if len(m.Answer) != 2 {
+		t.Fatalf("want a CNAME and a TXT answer, got %v", m.Answer)
+	}
+	cname, ok := m.Answer[0].(*dns.CNAME)
+	if !ok || cname.Hdr.Name != m.Question[0].Name || cname.Target != "cdn-"+m.Question[0].Name {
+		t.Fatalf("unexpected first answer %v for question %s", m.Answer[0], m.Question[0].Name)
+	}
+	if m.Answer[1].Header().Name != cname.Target {
+		t.Fatalf("TXT owner %s, want %s", m.Answer[1].Header().Name, cname.Target)
+	}
+
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	go func() {
+		_, _ = s.Write(raw)
+	}()
+	clientConn := NewClientConn(c, "tunnel.com")
+	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err = clientConn.Read(buf)
+	if err != nil {
+		t.Fatalf("client read: %v", err)
+	}
+	if !bytes.Equal(data, buf[:n]) {
+		t.Fatalf("got %q, want %q", buf[:n], data)
+	}
+}
+
+func TestDNST_AnswerIndexing(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	serverConn := NewServerConn(p1, "tunnel.com", WithAnswerIndexing(true))
+	go func() {
+		_, _ = NewClientConn(p2, "tunnel.com", WithAnswerIndexing(true)).Write([]byte("query"))
+	}()
+	_ = serverConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 4096)
+	var tag any
+	if _, err := serverConn.ReadTagged(buf, &tag); err != nil {
+		t.Fatalf("server read: %v", err)
+	}
+
+	data := make([]byte, 600)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	go func() {
+		_, _ = serverConn.WriteTagged(data, tag)
+	}()
+	_ = p2.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := p2.Read(buf)
+	if err != nil {
+		t.Fatalf("raw read: %v", err)
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(buf[:n]); err != nil {
+		t.Fatalf("unpack: %v", err)
+	}
+	if len(m.Answer) < 3 {
+		t.Fatalf("want the payload spread over several answers, got %d", len(m.Answer))
+	}
+	// reverse the answers and swap the first two, as a resolver might shuffle them
+	slices.Reverse(m.Answer)
+	m.Answer[0], m.Answer[1] = m.Answer[1], m.Answer[0]
+	shuffled, err := m.Pack()
+	if err != nil {
+		t.Fatalf("pack: %v", err)
+	}
+
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	go func() {
+		_, _ = s.Write(shuffled)
+	}()
+	clientConn := NewClientConn(c, "tunnel.com", WithAnswerIndexing(true))
+	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err = clientConn.Read(buf)
+	if err != nil {
+		t.Fatalf("client read: %v", err)
+	}
+	if !bytes.Equal(data, buf[:n]) {
+		t.Fatalf("got %x, want %x", buf[:n], data)
+	}
+
+	// a duplicated answer is rejected rather than decoded into garbage
+	m.Answer = append(m.Answer, m.Answer[0])
+	dup, err := m.Pack()
+	if err != nil {
+		t.Fatalf("pack: %v", err)
+	}
+	go func() {
+		_, _ = s.Write(dup)
+	}()
+	if _, err := clientConn.Read(buf); err == nil {
+		t.Fatal("want an error for a duplicate answer index")
+	}
+}
+
+func TestDNST_Keepalive(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	var sm countingMetrics
+	serverConn := NewServerConn(p1, "tunnel.com", WithMetrics(&sm))
+	clientConn := NewClientConn(p2, "tunnel.com", WithKeepalive(30*time.Millisecond))
+	defer clientConn.Close()
+
+	// an idle client only sends keepalives, which the server does not deliver
+	buf := make([]byte, 1024)
+	var tag any
+	_ = serverConn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, err := serverConn.ReadTagged(buf, &tag); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("want the idle read to time out, got n=%d err=%v", n, err)
+	}
+	if q := sm.queries.Load(); q < 2 {
+		t.Fatalf("server saw %d keepalive queries, want at least 2", q)
+	}
+
+	// data still flows, and a response to a keepalive does not surface as a read
+	data := []byte("after the keepalives")
+	go func() { _, _ = clientConn.Write(data) }()
+	_ = serverConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := serverConn.ReadTagged(buf, &tag)
+	if err != nil || !bytes.Equal(buf[:n], data) {
+		t.Fatalf("server read %q, %v; want %q", buf[:n], err, data)
+	}
+	keepalive := new(dns.Msg)
+	keepalive.SetQuestion("tunnel.com.", dns.TypeSOA)
+	resp := new(dns.Msg)
+	resp.SetReply(keepalive)
+	out, err := resp.Pack()
+	if err != nil {
+		t.Fatalf("pack: %v", err)
+	}
+	go func() {
+		_, _ = p1.Write(out)
+		_, _ = serverConn.WriteTagged(data, tag)
+	}()
+	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err = clientConn.Read(buf)
+	if err != nil || !bytes.Equal(buf[:n], data) {
+		t.Fatalf("client read %q, %v; want %q", buf[:n], err, data)
+	}
+}
+
+func TestDNST_PayloadTooLargeError(t *testing.T) {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	client := NewClientConn(c, "t.example.com")
+	server := NewServerConn(s, "t.example.com", WithMaxWrite(100))
+
+	// 252-13 = 239 QNAME characters leave room for 235 base32 characters in labels, i.e. 146 bytes
+	const allowed = 146
+	var tooLarge *PayloadTooLargeError
+	_, err := client.Write(make([]byte, allowed+1))
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("client write err = %v, want *PayloadTooLargeError", err)
+	}
+	if tooLarge.Allowed != allowed || tooLarge.Size != allowed+1 {
+		t.Fatalf("client error has Allowed %d, Size %d, want %d, %d", tooLarge.Allowed, tooLarge.Size, allowed, allowed+1)
+	}
+
+	// a payload of the allowed size goes through
+	go func() { _, _ = client.Write(make([]byte, tooLarge.Allowed)) }()
+	buf := make([]byte, 1024)
+	var tag any
+	n, err := server.ReadTagged(buf, &tag)
+	if err != nil || n != allowed {
+		t.Fatalf("server read %d bytes, %v, want %d", n, err, allowed)
+	}
+
+	_, err = server.WriteTagged(make([]byte, 101), tag)
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("server write err = %v, want *PayloadTooLargeError", err)
+	}
+	if tooLarge.Allowed != 100 {
+		t.Fatalf("server error has Allowed %d, want 100", tooLarge.Allowed)
+	}
+}
+
+func TestDNST_SessionLabelSeparatesClients(t *testing.T) {
+	// two clients share the server conn, as queries relayed by a resolver to a single UDP socket would
+	srvRaw, mid := net.Pipe()
+	defer srvRaw.Close()
+	defer mid.Close()
+	server := NewServerConn(srvRaw, "t.example.com", WithSessionLabel(true))
+
+	clients := map[string]net.Conn{}
+	var writeMu sync.Mutex
+	for _, token := range []string{"alice", "bob"} {
+		c, s := net.Pipe()
+		defer c.Close()
+		clients[token] = NewClientConn(c, "t.example.com", WithSessionToken(token))
+		// forward queries to the shared server conn
+		go func() {
+			buf := make([]byte, 1024)
+			for {
+				n, err := s.Read(buf)
+				if err != nil {
+					return
+				}
+				writeMu.Lock()
+				_, err = mid.Write(buf[:n])
+				writeMu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	if mw := clients["alice"].(interface{ MaxWrite() uint16 }).MaxWrite(); mw >= maxQNAMEPayload("t.example.com") {
+		t.Fatalf("MaxWrite %d does not account for the session label", mw)
+	}
+
+	for _, msg := range []struct{ token, data string }{{"alice", "a1"}, {"bob", "b1"}, {"alice", "a2"}, {"bob", ""}} {
+		go func() { _, _ = clients[msg.token].Write([]byte(msg.data)) }()
+		buf := make([]byte, 1024)
+		var tag any
+		n, err := server.ReadTagged(buf, &tag)
+		if err != nil {
+			t.Fatalf("ReadTagged: %v", err)
+		}
+		token, ok := SessionToken(tag)
+		if !ok || token != msg.token || string(buf[:n]) != msg.data {
+			t.Fatalf("got %q from session %q (%v), want %q from %q", buf[:n], token, ok, msg.data, msg.token)
+		}
+	}
+
+}
+
+func TestDNST_Handler(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := dns.NewServeMux()
+	mux.Handle("t.example.com.", DNSTHandler("t.example.com", func(tag any, data []byte) []byte {
+		token, _ := SessionToken(tag)
+		return append([]byte(token+":"), data...)
+	}, WithSessionLabel(true)))
+	started := make(chan struct{})
+	srv := &dns.Server{PacketConn: pc, Handler: mux, NotifyStartedFunc: func() { close(started) }}
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	<-started
+
+	uc, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClientConn(uc, "t.example.com", WithSessionToken("alice"))
+	t.Cleanup(func() { _ = client.Close() })
+	_ = client.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := client.Read(buf)
+	if err != nil || string(buf[:n]) != "alice:ping" {
+		t.Fatalf("read %q, %v; want %q", buf[:n], err, "alice:ping")
+	}
+
+	// queries that a server conn would skip are refused
+	q := new(dns.Msg).SetQuestion("t.example.com.", dns.TypeA)
+	resp, err := dns.Exchange(q, pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Rcode != dns.RcodeRefused {
+		t.Fatalf("rcode %s, want REFUSED", dns.RcodeToString[resp.Rcode])
+	}
+}
+
+func TestDNST_ResolverClientConn(t *testing.T) {
+	for _, network := range []string{"udp", "tcp"} {
+		t.Run(network, func(t *testing.T) {
+			// a stub resolver that drops the first query and sends a stray response before each answer
+			echo := DNSTHandler("t.example.com", func(_ any, data []byte) []byte { return data })
+			var queries sync.Map
+			handler := dns.HandlerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+				if _, seen := queries.LoadOrStore(m.Question[0].Name, true); !seen && network == "udp" {
+					return
+				}
+				stray := new(dns.Msg).SetReply(m)
+				stray.Id = m.Id + 1
+				_ = w.WriteMsg(stray)
+				echo(w, m)
+			})
+			started := make(chan struct{})
+			srv := &dns.Server{Net: network, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+			if network == "udp" {
+				pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				srv.PacketConn = pc
+			} else {
+				ln, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				srv.Listener = ln
+			}
+			go func() { _ = srv.ActivateAndServe() }()
+			t.Cleanup(func() { _ = srv.Shutdown() })
+			<-started
+			addr := ""
+			if srv.PacketConn != nil {
+				addr = srv.PacketConn.LocalAddr().String()
+			} else {
+				addr = srv.Listener.Addr().String()
+			}
+
+			client, err := NewDNSTResolverClientConn(addr, network, "t.example.com", WithRetransmit(100*time.Millisecond, 2))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = client.Close() })
+			_ = client.SetDeadline(time.Now().Add(5 * time.Second))
+			buf := make([]byte, 64)
+			for _, msg := range []string{"first", "second"} {
+				if _, err := client.Write([]byte(msg)); err != nil {
+					t.Fatal(err)
+				}
+				n, err := client.Read(buf)
+				if err != nil || string(buf[:n]) != msg {
+					t.Fatalf("read %q, %v; want %q", buf[:n], err, msg)
+				}
+			}
+		})
+	}
+
+	if _, err := NewDNSTResolverClientConn("127.0.0.1:53", "unix", "t.example.com"); err == nil {
+		t.Fatal("unsupported network accepted")
+	}
+}
@@ -0,0 +1,66 @@
+package netx
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// aceDomain returns domain in the form it takes in queries: lower case, without the trailing dot, and with
+// internationalized labels ACE-encoded as punycode. Domains that do not convert are only lowered, see
+// ValidateDomain. A leading "*." wildcard label is kept.
+func aceDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	base, wildcard := strings.CutPrefix(domain, "*.")
+	if isASCII(base) {
+		return domain
+	}
+	ace, err := idna.Lookup.ToASCII(base)
+	if err != nil {
+		return domain
+	}
+	if wildcard {
+		return "*." + ace
+	}
+	return ace
+}
+
+// ValidateDomain checks that domain can be used as the domain of a DNST conn. An internationalized domain must
+// convert to punycode, as done by the conns, and the result must be a DNS name of labels of at most 63 bytes
+// that leaves room for payload in a query, which rules out names longer than 250 bytes. IP addresses are
+// rejected, since queries need a name to be routed to the server. Servers may use a leading "*." wildcard label.
+func ValidateDomain(domain string) error {
+	base := strings.TrimPrefix(strings.TrimSuffix(domain, "."), "*.")
+	if base == "" {
+		return fmt.Errorf("dnst: empty domain")
+	}
+	if net.ParseIP(strings.Trim(base, "[]")) != nil {
+		return fmt.Errorf("dnst: domain %q is an IP address, not a name", domain)
+	}
+	if !isASCII(base) {
+		if _, err := idna.Lookup.ToASCII(base); err != nil {
+			return fmt.Errorf("dnst: invalid internationalized domain %q: %w", domain, err)
+		}
+	}
+	ace := aceDomain(domain)
+	for label := range strings.SplitSeq(strings.TrimPrefix(ace, "*."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return fmt.Errorf("dnst: domain %q has a label of %d bytes, want 1 to 63", ace, len(label))
+		}
+	}
+	if maxQNAMEPayload(ace) == 0 {
+		return fmt.Errorf("dnst: domain %q of %d bytes leaves no room for payload", ace, len(ace))
+	}
+	return nil
+}
+
+func isASCII(s string) bool {
+	for i := range len(s) {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
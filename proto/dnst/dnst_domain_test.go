@@ -0,0 +1,78 @@
+package netx
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestDNST_InternationalizedDomain(t *testing.T) {
+	const domain, ace = "Bücher.example", "xn--bcher-kva.example"
+	if got := aceDomain(domain + "."); got != ace {
+		t.Fatalf("aceDomain(%q) = %q, want %q", domain, got, ace)
+	}
+	if got := aceDomain("*." + domain); got != "*."+ace {
+		t.Fatalf("aceDomain of the wildcard = %q, want %q", got, "*."+ace)
+	}
+
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+	serverConn := NewServerConn(p1, domain)
+	clientConn := NewClientConn(p2, domain)
+
+	// the budget is that of the punycode name, which is longer than the Unicode one
+	want := maxQNAMEPayload(ace)
+	if got := clientConn.(interface{ MaxWrite() uint16 }).MaxWrite(); got != want {
+		t.Fatalf("MaxWrite = %d, want %d for %q", got, want, ace)
+	}
+
+	payload := []byte(strings.Repeat("x", int(want)))
+	go func() { _, _ = clientConn.Write(payload) }()
+	_ = p1.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1024)
+	var tag any
+	n, err := serverConn.ReadTagged(buf, &tag)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(buf[:n]) != string(payload) {
+		t.Fatalf("got %q, want %q", buf[:n], payload)
+	}
+	if qName := tag.(*dns.Msg).Question[0].Name; !strings.HasSuffix(qName, "."+ace+".") || len(qName) > 254 {
+		t.Fatalf("query name %q is not a valid name below %q", qName, ace)
+	}
+}
+
+func TestDNST_ValidateDomain(t *testing.T) {
+	for _, tc := range []struct {
+		domain string
+		want   string // empty for a valid domain
+	}{
+		{"tunnel.example.com.", ""},
+		{"*.tunnel.example.com", ""},
+		{"bücher.example", ""},
+		{"", "empty domain"},
+		{"192.0.2.1", "IP address"},
+		{"[2001:db8::1]", "IP address"},
+		{"a..example", "label of 0 bytes"},
+		{strings.Repeat("a", 64) + ".example", "label of 64 bytes"},
+		{strings.Repeat("abcdefghi.", 25) + "example", "no room for payload"},
+		{"xn--a.example", ""},                                        // ASCII names are taken as is
+		{"bü\u200dcher.example", "invalid internationalized domain"}, // a joiner out of context
+	} {
+		err := ValidateDomain(tc.domain)
+		if tc.want == "" {
+			if err != nil {
+				t.Errorf("ValidateDomain(%q): %v", tc.domain, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("ValidateDomain(%q) = %v, want an error containing %q", tc.domain, err, tc.want)
+		}
+	}
+}
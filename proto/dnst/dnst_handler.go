@@ -0,0 +1,57 @@
+package netx
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+)
+
+// DNSTHandler returns a handler that serves DNST for domain within an existing dns.Server, e.g. registered
+// with dns.HandleFunc for the domain, as an alternative to a server conn. The payload of every query is passed
+// to onData and the payload it returns is sent back in the TXT response, so there is one reply per query and
+// no full duplex.
+//
+// sessionTag is the tag ReadTagged of a server conn would return for the query, so SessionToken works on it with
+// WithSessionLabel. The server options apply, except for WithFullDuplex, WithPushQueue, WithMessageHeader and WithMaxRead. Queries that a server
+// conn would skip are refused, and replies exceeding the max write are answered with a server failure.
+func DNSTHandler(domain string, onData func(sessionTag any, data []byte) (reply []byte), opts ...Option) dns.HandlerFunc {
+	c := &connCore{}
+	c.init(domain, serverMaxRead, opts...)
+	return func(w dns.ResponseWriter, m *dns.Msg) {
+		c.metrics.Query()
+		data, session, ok := c.decodeQuery(m, w.RemoteAddr())
+		if !ok {
+			_ = w.WriteMsg(new(dns.Msg).SetRcode(m, dns.RcodeRefused))
+			return
+		}
+		var tag any = m
+		if c.sessionLabel {
+			tag = serverConnTagged{dnsMsg: m, session: session}
+		}
+		if c.dedup != nil {
+			if resp, dup := c.dedup.seen(m); dup {
+				if resp != nil {
+					if _, err := w.Write(resp); err == nil {
+						c.metrics.Response()
+					}
+				}
+				return
+			}
+		}
+
+		out, err := c.encodeResponse(m, onData(tag, data))
+		if err != nil {
+			c.logger.DebugContext(context.Background(), "dnst: failed to encode response", "error", err, "remoteAddr", w.RemoteAddr().Network()+"://"+w.RemoteAddr().String())
+			_ = w.WriteMsg(new(dns.Msg).SetRcode(m, dns.RcodeServerFailure))
+			return
+		}
+		if c.dedup != nil {
+			c.dedup.store(m, out)
+		}
+		if _, err := w.Write(out); err != nil {
+			c.logger.DebugContext(context.Background(), "dnst: error writing response", "error", err, "remoteAddr", w.RemoteAddr().Network()+"://"+w.RemoteAddr().String())
+			return
+		}
+		c.metrics.Response()
+	}
+}
@@ -0,0 +1,31 @@
+package netx
+
+// Metrics receives counter increments from DNST conns, e.g. to feed a metrics library.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	// Query counts DNS queries received by a server or sent by a client.
+	Query()
+	// Response counts DNS responses sent by a server or received by a client.
+	Response()
+	// DecodeError counts packets that could not be decoded (invalid DNS messages, bad encoding, unexpected records).
+	DecodeError()
+	// PayloadTooLarge counts writes rejected for exceeding the conn's MaxWrite.
+	PayloadTooLarge()
+}
+
+// WithMetrics sets a Metrics implementation to report to. A nil Metrics disables reporting.
+func WithMetrics(m Metrics) Option {
+	return func(c *connCore) {
+		if m == nil {
+			m = noopMetrics{}
+		}
+		c.metrics = m
+	}
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) Query()           {}
+func (noopMetrics) Response()        {}
+func (noopMetrics) DecodeError()     {}
+func (noopMetrics) PayloadTooLarge() {}
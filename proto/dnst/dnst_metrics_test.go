@@ -0,0 +1,98 @@
+package netx
+
+import (
+	"bytes"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingMetrics struct {
+	queries, responses, decodeErrors, tooLarge atomic.Int64
+}
+
+func (m *countingMetrics) Query()           { m.queries.Add(1) }
+func (m *countingMetrics) Response()        { m.responses.Add(1) }
+func (m *countingMetrics) DecodeError()     { m.decodeErrors.Add(1) }
+func (m *countingMetrics) PayloadTooLarge() { m.tooLarge.Add(1) }
+
+func TestDNST_Metrics(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	var sm, cm countingMetrics
+	serverConn := NewServerConn(p1, "example.com", WithMetrics(&sm), WithMaxWrite(16))
+	clientConn := NewClientConn(p2, "example.com", WithMetrics(&cm))
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			var tag any
+			n, err := serverConn.ReadTagged(buf, &tag)
+			if err != nil {
+				return
+			}
+			_, _ = serverConn.WriteTagged(buf[:n], tag)
+		}
+	}()
+
+	const rounds = 3
+	for i := range rounds {
+		msg := []byte{'m', byte('0' + i)}
+		if _, err := clientConn.Write(msg); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+		_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		buf := make([]byte, 64)
+		n, err := clientConn.Read(buf)
+		if err != nil {
+			t.Fatalf("read %d: %v", i, err)
+		}
+		if !bytes.Equal(buf[:n], msg) {
+			t.Fatalf("round %d: got %q, want %q", i, buf[:n], msg)
+		}
+	}
+
+	// Garbage on the wire is a decode error on the server.
+	if _, err := p2.Write([]byte("not a dns message")); err != nil {
+		t.Fatalf("write garbage: %v", err)
+	}
+
+	// A payload larger than the server's max write is rejected; the client gets no answer for it.
+	if _, err := clientConn.Write(bytes.Repeat([]byte("x"), 32)); err != nil {
+		t.Fatalf("write oversized echo: %v", err)
+	}
+	// An oversized client write never hits the wire.
+	if _, err := clientConn.Write(bytes.Repeat([]byte("y"), int(clientConn.(interface{ MaxWrite() uint16 }).MaxWrite())+1)); err == nil {
+		t.Fatalf("expected oversized client write to fail")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for sm.tooLarge.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := sm.queries.Load(); got != rounds+1 {
+		t.Errorf("server queries = %d, want %d", got, rounds+1)
+	}
+	if got := sm.responses.Load(); got != rounds {
+		t.Errorf("server responses = %d, want %d", got, rounds)
+	}
+	if got := sm.decodeErrors.Load(); got != 1 {
+		t.Errorf("server decode errors = %d, want 1", got)
+	}
+	if got := sm.tooLarge.Load(); got != 1 {
+		t.Errorf("server oversized = %d, want 1", got)
+	}
+	if got := cm.queries.Load(); got != rounds+1 {
+		t.Errorf("client queries = %d, want %d", got, rounds+1)
+	}
+	if got := cm.responses.Load(); got != rounds {
+		t.Errorf("client responses = %d, want %d", got, rounds)
+	}
+	if got := cm.tooLarge.Load(); got != 1 {
+		t.Errorf("client oversized = %d, want 1", got)
+	}
+}
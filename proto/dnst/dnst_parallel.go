@@ -0,0 +1,197 @@
+package netx
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pedramktb/go-netx"
+)
+
+// ErrNoDomains is returned by NewParallelClientConn without domains.
+var ErrNoDomains = errors.New("dnst: parallel client conn without domains")
+
+type parallelClientConn struct {
+	channels []net.Conn
+	maxWrite uint16
+	next     atomic.Uint32
+
+	reads   chan []byte
+	alive   atomic.Int32 // running channel readers, the last one closes reads
+	readErr error        // first channel read error, set before reads is closed
+	errOnce sync.Once
+	done    chan struct{}
+	closing atomic.Bool
+
+	mu           sync.Mutex
+	readDeadline time.Time
+	readDlNotify chan struct{}
+}
+
+// NewParallelClientConn spreads one logical conn over a DNST client channel per domain, so that
+// several queries can be in flight at once. Every channel is a MuxClient over conns obtained from
+// dial, so a failed channel is redialled independently. Writes are assigned to the channels
+// round-robin and responses of all channels are merged into Read.
+// The domains should be distinct subdomains of a wildcard domain served by the server,
+// e.g. c0.t.example.com and c1.t.example.com for a server on *.t.example.com.
+// Packets are not kept in order across channels, so this is only suitable for datagram-like upper
+// layers that tolerate reordering. At least one domain is required, see ErrNoDomains.
+func NewParallelClientConn(dial netx.Dialer, domains []string, opts ...Option) (net.Conn, error) {
+	if len(domains) == 0 {
+		return nil, ErrNoDomains
+	}
+	c := &parallelClientConn{
+		reads:        make(chan []byte, len(domains)),
+		done:         make(chan struct{}),
+		readDlNotify: make(chan struct{}),
+	}
+	// the options as applied to every channel, for their MaxWrite
+	core := &connCore{}
+	core.init("", 0, opts...)
+	for _, domain := range domains {
+		ch := netx.NewMuxClient(func() (net.Conn, error) {
+			conn, err := dial()
+			if err != nil {
+				return nil, err
+			}
+			return NewClientConn(conn, domain, opts...), nil
+		})
+		c.channels = append(c.channels, ch)
+		if mw := core.clientMaxWrite(aceDomain(domain)); c.maxWrite == 0 || mw < c.maxWrite {
+			c.maxWrite = mw
+		}
+	}
+	c.alive.Store(int32(len(c.channels)))
+	for _, ch := range c.channels {
+		go c.readChannel(ch)
+	}
+	return c, nil
+}
+
+// readChannel forwards the responses of a channel to reads until it fails.
+func (c *parallelClientConn) readChannel(ch net.Conn) {
+	defer func() {
+		if c.alive.Add(-1) == 0 {
+			close(c.reads)
+		}
+	}()
+	buf := make([]byte, netx.MaxPacketSize)
+	for {
+		n, err := ch.Read(buf)
+		if err != nil {
+			c.errOnce.Do(func() { c.readErr = err })
+			return
+		}
+		select {
+		case c.reads <- append([]byte(nil), buf[:n]...):
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// MaxWrite returns the maximum raw payload a single Write can carry on every channel.
+func (c *parallelClientConn) MaxWrite() uint16 { return c.maxWrite }
+
+// Read returns the next response of any channel.
+func (c *parallelClientConn) Read(b []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		deadline := c.readDeadline
+		notify := c.readDlNotify
+		c.mu.Unlock()
+
+		var timer *time.Timer
+		var timeoutCh <-chan time.Time
+		if !deadline.IsZero() {
+			dur := time.Until(deadline)
+			if dur <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(dur)
+			timeoutCh = timer.C
+		}
+
+		select {
+		case data, ok := <-c.reads:
+			if timer != nil {
+				timer.Stop()
+			}
+			if !ok {
+				if c.closing.Load() {
+					return 0, net.ErrClosed
+				}
+				if c.readErr != nil {
+					return 0, c.readErr
+				}
+				return 0, io.EOF
+			}
+			return copy(b, data), nil
+		case <-c.done:
+			if timer != nil {
+				timer.Stop()
+			}
+			return 0, net.ErrClosed
+		case <-timeoutCh:
+			return 0, os.ErrDeadlineExceeded
+		case <-notify:
+			if timer != nil {
+				timer.Stop()
+			}
+			// Deadline changed, loop to pick up new deadline
+		}
+	}
+}
+
+// Write sends b as a single query on the next channel.
+func (c *parallelClientConn) Write(b []byte) (int, error) {
+	if c.closing.Load() {
+		return 0, net.ErrClosed
+	}
+	i := (c.next.Add(1) - 1) % uint32(len(c.channels))
+	return c.channels[i].Write(b)
+}
+
+func (c *parallelClientConn) Close() error {
+	if !c.closing.CompareAndSwap(false, true) {
+		return nil
+	}
+	close(c.done)
+	var err error
+	for _, ch := range c.channels {
+		err = errors.Join(err, ch.Close())
+	}
+	return err
+}
+
+func (c *parallelClientConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline only applies to Read, the channels keep reading in the background.
+func (c *parallelClientConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	close(c.readDlNotify)
+	c.readDlNotify = make(chan struct{})
+	return nil
+}
+
+func (c *parallelClientConn) SetWriteDeadline(t time.Time) error {
+	var err error
+	for _, ch := range c.channels {
+		err = errors.Join(err, ch.SetWriteDeadline(t))
+	}
+	return err
+}
+
+func (c *parallelClientConn) LocalAddr() net.Addr  { return c.channels[0].LocalAddr() }
+func (c *parallelClientConn) RemoteAddr() net.Addr { return c.channels[0].RemoteAddr() }
@@ -0,0 +1,79 @@
+package netx
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDNST_ParallelClientThroughput(t *testing.T) {
+	const delay = 30 * time.Millisecond
+	const packets = 8
+
+	// Every dial gets its own serial resolver that answers each query after delay.
+	dial := func() (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			srv := NewServerConn(server, "*.t.example.com")
+			buf := make([]byte, 512)
+			for {
+				var tag any
+				n, err := srv.ReadTagged(buf, &tag)
+				if err != nil {
+					return
+				}
+				time.Sleep(delay)
+				if _, err := srv.WriteTagged(buf[:n], tag); err != nil {
+					return
+				}
+			}
+		}()
+		return client, nil
+	}
+
+	measure := func(domains ...string) time.Duration {
+		conn, err := NewParallelClientConn(dial, domains)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		start := time.Now()
+		go func() {
+			for i := range packets {
+				if _, err := conn.Write([]byte{byte(i)}); err != nil {
+					return
+				}
+			}
+		}()
+		seen := make(map[byte]bool)
+		buf := make([]byte, 64)
+		for range packets {
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if n != 1 || seen[buf[0]] {
+				t.Fatalf("unexpected response %x", buf[:n])
+			}
+			seen[buf[0]] = true
+		}
+		return time.Since(start)
+	}
+
+	single := measure("c0.t.example.com")
+	parallel := measure("c0.t.example.com", "c1.t.example.com")
+	if parallel >= single*3/4 {
+		t.Fatalf("expected two channels to be faster than one, took %v vs %v", parallel, single)
+	}
+}
+
+func TestDNST_ParallelClientConnRequiresDomains(t *testing.T) {
+	dial := func() (net.Conn, error) { return nil, errors.New("not dialed") }
+	if _, err := NewParallelClientConn(dial, nil); !errors.Is(err, ErrNoDomains) {
+		t.Fatalf("got %v, want ErrNoDomains", err)
+	}
+}
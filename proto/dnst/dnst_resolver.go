@@ -0,0 +1,151 @@
+package netx
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// WithRetransmit sets how long to wait for the response to a query before sending it again, and how many times
+// to send it again before giving up on it. Default is 2s and 2 retries. Retransmissions only apply to UDP, and
+// a retransmitted query reaches the server again, so WithDedup should be used there.
+// Resolver client only, see NewDNSTResolverClientConn.
+func WithRetransmit(interval time.Duration, retries int) Option {
+	return func(c *connCore) {
+		c.retransmit = interval
+		c.retries = retries
+	}
+}
+
+// NewDNSTResolverClientConn dials the resolver at resolverAddr over network, "udp" or "tcp", and returns a
+// DNST client conn for domain that sends its queries through it. Unlike NewClientConn over a raw conn, it
+// frames the messages for TCP, only returns responses whose ID matches an outstanding query, dropping stray or
+// late ones, and retransmits unanswered queries over UDP, see WithRetransmit. Closing the conn closes the
+// connection to the resolver.
+func NewDNSTResolverClientConn(resolverAddr, network, domain string, opts ...Option) (net.Conn, error) {
+	switch network {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("dnst: unsupported resolver network %q", network)
+	}
+	if err := ValidateDomain(domain); err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial(network, resolverAddr)
+	if err != nil {
+		return nil, err
+	}
+	core := connCore{retransmit: 2 * time.Second, retries: 2}
+	for _, o := range opts {
+		o(&core)
+	}
+	rc := &resolverConn{
+		Conn:    &dns.Conn{Conn: conn},
+		pending: make(map[uint16]*pendingQuery),
+	}
+	if _, ok := conn.(net.PacketConn); ok && core.retransmit > 0 {
+		rc.retransmit, rc.retries = core.retransmit, core.retries
+	}
+	return NewClientConn(rc, domain, opts...), nil
+}
+
+// resolverConn carries DNS messages to and from a resolver, matching responses to queries by ID.
+type resolverConn struct {
+	net.Conn                 // a *dns.Conn, which frames messages over TCP
+	retransmit time.Duration // 0 disables retransmissions
+	retries    int
+
+	mu      sync.Mutex
+	pending map[uint16]*pendingQuery
+	closed  bool
+}
+
+type pendingQuery struct {
+	msg   []byte
+	sent  int // retransmissions so far
+	timer *time.Timer
+}
+
+// Write sends the query in b and remembers it until its response is read.
+func (c *resolverConn) Write(b []byte) (int, error) {
+	if len(b) < 2 {
+		return 0, dns.ErrShortRead
+	}
+	id := binary.BigEndian.Uint16(b)
+	q := &pendingQuery{msg: append([]byte(nil), b...)}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	if old, ok := c.pending[id]; ok && old.timer != nil {
+		old.timer.Stop()
+	}
+	c.pending[id] = q
+	if c.retransmit > 0 {
+		q.timer = time.AfterFunc(c.retransmit, func() { c.resend(id, q) })
+	}
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+// resend sends q again if it is still unanswered, or forgets it once it ran out of retries.
+func (c *resolverConn) resend(id uint16, q *pendingQuery) {
+	c.mu.Lock()
+	if c.closed || c.pending[id] != q {
+		c.mu.Unlock()
+		return
+	}
+	if q.sent >= c.retries {
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return
+	}
+	q.sent++
+	q.timer.Reset(c.retransmit)
+	c.mu.Unlock()
+	_, _ = c.Conn.Write(q.msg)
+}
+
+// Read returns the next response that answers an outstanding query.
+func (c *resolverConn) Read(b []byte) (int, error) {
+	for {
+		n, err := c.Conn.Read(b)
+		if err != nil {
+			return 0, err
+		}
+		if n < 2 {
+			continue
+		}
+		id := binary.BigEndian.Uint16(b)
+		c.mu.Lock()
+		q, ok := c.pending[id]
+		if ok {
+			delete(c.pending, id)
+			if q.timer != nil {
+				q.timer.Stop()
+			}
+		}
+		c.mu.Unlock()
+		if ok {
+			return n, nil
+		}
+	}
+}
+
+func (c *resolverConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	for id, q := range c.pending {
+		if q.timer != nil {
+			q.timer.Stop()
+		}
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
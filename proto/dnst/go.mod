@@ -5,13 +5,14 @@ go 1.25.7
 require (
 	github.com/miekg/dns v1.1.72
 	github.com/pedramktb/go-netx v1.4.0
+	golang.org/x/net v0.52.0
 )
 
 require (
 	github.com/pion/transport/v3 v3.1.1 // indirect
 	golang.org/x/mod v0.34.0 // indirect
-	golang.org/x/net v0.52.0 // indirect
 	golang.org/x/sync v0.20.0 // indirect
 	golang.org/x/sys v0.42.0 // indirect
+	golang.org/x/text v0.35.0 // indirect
 	golang.org/x/tools v0.43.0 // indirect
 )
@@ -0,0 +1,201 @@
+/*
+RateLimitConn is a network layer that caps the bandwidth of a connection with a token bucket per direction.
+It can be placed anywhere in a chain, e.g. after an encryption layer to only throttle the inner stream.
+Reads and writes wait for tokens before touching the underlying conn and are then charged for the bytes
+they moved, so packet boundaries are kept intact. A single read or write larger than the burst may exceed
+it once and is paid off by the following operations. Waiting honors the read and write deadlines.
+*/
+
+package netx
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	Register("ratelimit", func(params map[string]string, listener bool) (Wrapper, error) {
+		var up, down, burst uint64
+		for key, value := range params {
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return Wrapper{}, fmt.Errorf("ratelimit: invalid %s parameter %q: %w", key, value, err)
+			}
+			switch key {
+			case "up":
+				up = n
+			case "down":
+				down = n
+			case "burst":
+				burst = n
+			default:
+				return Wrapper{}, fmt.Errorf("uri: unknown ratelimit parameter %q", key)
+			}
+		}
+		connToConn := func(c net.Conn) (net.Conn, error) {
+			return NewRateLimitConn(c, up, down, burst), nil
+		}
+		return Wrapper{
+			Name:   "ratelimit",
+			Params: params,
+			ListenerToListener: func(l net.Listener) (net.Listener, error) {
+				return ConnWrapListener(l, connToConn)
+			},
+			DialerToDialer: func(f Dialer) (Dialer, error) {
+				return ConnWrapDialer(f, connToConn)
+			},
+			ConnToConn: connToConn,
+		}, nil
+	})
+}
+
+type rateLimitConn struct {
+	net.Conn
+	up, down      *tokenBucket // nil if unlimited
+	readDeadline  atomic.Int64 // unix nanos, 0 for none
+	writeDeadline atomic.Int64
+	done          chan struct{}
+	closeOnce     sync.Once
+}
+
+// NewRateLimitConn limits writes to up and reads to down bytes per second, with burst as the bucket size.
+// A rate of zero means unlimited. A burst of zero defaults to one second worth of the rate.
+func NewRateLimitConn(c net.Conn, up, down, burst uint64) net.Conn {
+	return &rateLimitConn{
+		Conn: c,
+		up:   newTokenBucket(up, burst),
+		down: newTokenBucket(down, burst),
+		done: make(chan struct{}),
+	}
+}
+
+// MaxWrite forwards the underlying connection's MaxWrite limit, if any.
+func (c *rateLimitConn) MaxWrite() uint16 {
+	if mw, ok := c.Conn.(interface{ MaxWrite() uint16 }); ok {
+		return mw.MaxWrite()
+	}
+	return 0
+}
+
+func (c *rateLimitConn) Read(p []byte) (int, error) {
+	if c.down == nil {
+		return c.Conn.Read(p)
+	}
+	if err := c.down.wait(1, c.readDeadline.Load(), c.done); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Read(p)
+	c.down.charge(n)
+	return n, err
+}
+
+func (c *rateLimitConn) Write(p []byte) (int, error) {
+	if c.up == nil {
+		return c.Conn.Write(p)
+	}
+	if err := c.up.wait(len(p), c.writeDeadline.Load(), c.done); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Write(p)
+	c.up.charge(n)
+	return n, err
+}
+
+func (c *rateLimitConn) SetDeadline(t time.Time) error {
+	storeDeadline(&c.readDeadline, t)
+	storeDeadline(&c.writeDeadline, t)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *rateLimitConn) SetReadDeadline(t time.Time) error {
+	storeDeadline(&c.readDeadline, t)
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *rateLimitConn) SetWriteDeadline(t time.Time) error {
+	storeDeadline(&c.writeDeadline, t)
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// Close wakes up reads and writes waiting for tokens and closes the underlying conn.
+func (c *rateLimitConn) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
+
+func storeDeadline(d *atomic.Int64, t time.Time) {
+	if t.IsZero() {
+		d.Store(0)
+		return
+	}
+	d.Store(t.UnixNano())
+}
+
+// tokenBucket refills at rate tokens (bytes) per second up to burst. Tokens may go negative when an
+// operation is charged more than was available, which delays later operations until the debt is paid off.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst uint64) *tokenBucket {
+	if rate == 0 {
+		return nil
+	}
+	if burst == 0 {
+		burst = rate
+	}
+	return &tokenBucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// refill adds the tokens accumulated since the last call. Caller must hold mu.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// wait blocks until need tokens, capped at the burst, are available, the deadline passes or done is closed.
+func (b *tokenBucket) wait(need int, deadline int64, done <-chan struct{}) error {
+	for {
+		now := time.Now()
+		b.mu.Lock()
+		b.refill(now)
+		missing := min(float64(need), b.burst) - b.tokens
+		b.mu.Unlock()
+		if missing <= 0 {
+			return nil
+		}
+		delay := time.Duration(missing / b.rate * float64(time.Second))
+		expired := false
+		if deadline != 0 {
+			if left := time.Unix(0, deadline).Sub(now); left < delay {
+				delay, expired = left, true
+			}
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-done:
+			timer.Stop()
+			return net.ErrClosed
+		}
+		if expired {
+			return os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func (b *tokenBucket) charge(n int) {
+	b.mu.Lock()
+	b.refill(time.Now())
+	b.tokens -= float64(n)
+	b.mu.Unlock()
+}
@@ -0,0 +1,61 @@
+package netx_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	netx "github.com/pedramktb/go-netx"
+)
+
+func TestRateLimitConnThrottlesWrites(t *testing.T) {
+	t.Parallel()
+	var w netx.Wrapper
+	if err := w.UnmarshalText([]byte("ratelimit{up=40000,burst=4000}"), false); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	clientRaw, serverRaw := net.Pipe()
+	t.Cleanup(func() { _ = clientRaw.Close(); _ = serverRaw.Close() })
+	v, err := w.Apply(net.Conn(clientRaw))
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	client := v.(net.Conn)
+	go func() { _, _ = io.Copy(io.Discard, serverRaw) }()
+
+	// the first 4000 bytes are covered by the burst, the remaining 36000 take 0.9s at 40000 B/s
+	chunk := make([]byte, 4000)
+	start := time.Now()
+	for i := range 10 {
+		if _, err := client.Write(chunk); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 850*time.Millisecond {
+		t.Fatalf("wrote 40000 bytes in %v, want about 900ms", elapsed)
+	}
+}
+
+func TestRateLimitConnReadHonorsDeadline(t *testing.T) {
+	t.Parallel()
+	clientRaw, serverRaw := net.Pipe()
+	t.Cleanup(func() { _ = clientRaw.Close(); _ = serverRaw.Close() })
+	client := netx.NewRateLimitConn(clientRaw, 0, 100, 1000)
+	go func() { _, _ = serverRaw.Write(make([]byte, 5000)) }()
+
+	// a single large read is let through and puts the bucket 4000 bytes, i.e. 40s, into debt
+	if _, err := io.ReadFull(client, make([]byte, 5000)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	_ = client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	start := time.Now()
+	if _, err := client.Read(make([]byte, 10)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("throttled read returned %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("throttled read took %v to time out", elapsed)
+	}
+}
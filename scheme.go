@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"strings"
 )
@@ -17,11 +18,17 @@ func (s ListenerScheme) Listen(ctx context.Context, addr string, opts ...ListenO
 	}
 	wl, err := s.Wrappers.Apply(l)
 	if err != nil {
+		_ = l.Close()
 		return nil, fmt.Errorf("error upgrading to %s://%s: %w", s.String(), addr, err)
 	}
 	if l, ok := wl.(net.Listener); ok {
 		return l, nil
 	}
+	if c, ok := wl.(io.Closer); ok {
+		_ = c.Close()
+	} else {
+		_ = l.Close()
+	}
 	return nil, fmt.Errorf("error upgrading to %s://%s: %w", s.String(), addr, errors.New("wrapper(s) did not produce net.Listener"))
 }
 
@@ -35,7 +42,7 @@ func (c DialerScheme) Dial(ctx context.Context, addr string, opts ...DialOption)
 	dial := func() (net.Conn, error) {
 		return Dial(ctx, c.Transport.String(), addr, opts...)
 	}
-	wdial, err := c.Wrappers.Apply(dial)
+	wdial, err := c.Wrappers.applyDialer(ctx, dial, opts)
 	if err != nil {
 		return nil, fmt.Errorf("error upgrading to %s://%s: %w", c.String(), addr, err)
 	}
@@ -2,6 +2,7 @@ package netx
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"io"
 	"log/slog"
@@ -13,10 +14,19 @@ import (
 
 var (
 	ErrServerClosed = errors.New("server is shutting down")
+	// ErrStoppedAccepting is returned by Serve and ServeAccept after StopAccepting.
+	ErrStoppedAccepting = errors.New("server stopped accepting")
+	// ErrConnByteLimit is returned by Read and Write of conns closed for exceeding MaxConnBytesIn or MaxConnBytesOut.
+	ErrConnByteLimit = errors.New("connection byte limit exceeded")
+	// errConnForceClosed is the context cause for conns closed by CloseConn or the idle timeout.
+	errConnForceClosed = errors.New("connection closed by server")
 )
 
-// Handler is a function that takes a base context, a net.Conn representing the incoming connection,
+// Handler is a function that takes a context, a net.Conn representing the incoming connection,
 // and a closed function that should be called when the user is done with the connection.
+// The context is derived from the server's base context per connection. It is canceled once closed is called,
+// and when the server force-closes the connection (Close, a Shutdown timeout, CloseConn or the idle timeout),
+// so goroutines tied to the connection can stop. On Close and Shutdown, context.Cause reports ErrServerClosed.
 // It returns a boolean indicating whether the connection matches the handler
 // and a wrappedConn server can continue using for closing them.
 //
@@ -32,18 +42,86 @@ type Handler func(ctx context.Context, conn net.Conn, closed func()) (matched bo
 type Server[ID comparable] struct {
 	Logger Logger
 
+	// IdleConnTimeout closes tracked connections that have seen no reads or writes for the given duration.
+	// The server does not sit in the data path, so when set, the conn passed to handlers is wrapped
+	// in an activity-tracking conn. Zero disables the reaper.
+	IdleConnTimeout time.Duration
+
+	// MinReadRate and MinReadRateWindow, if both set, close connections that have sent less than MinReadRate
+	// bytes per second on average over their first MinReadRateWindow, counting from accept, e.g. slowloris
+	// clients that dribble their request to tie up handlers while they match or read it. Like IdleConnTimeout,
+	// this wraps the conn passed to handlers in an activity-tracking conn. Only the first window is checked,
+	// so it suits protocols where the client sends its request right away, like HTTP.
+	MinReadRate       int
+	MinReadRateWindow time.Duration
+
+	// MaxConnBytesIn and MaxConnBytesOut close connections once they have read or written more than that many
+	// bytes in total, to cap abusive sessions. Reads and writes of a closed connection fail with ErrConnByteLimit,
+	// and writes that would exceed the limit are not sent. Like IdleConnTimeout, this wraps the conn passed to
+	// handlers in a counting conn. Zero means unlimited.
+	MaxConnBytesIn  int64
+	MaxConnBytesOut int64
+
+	// ConnPreWrap, if set, wraps every accepted connection before any handler sees it.
+	// It is meant for pre-processing shared by all routes, e.g. PROXY protocol parsing.
+	// If it fails, the connection is closed and dropped.
+	ConnPreWrap func(net.Conn) (net.Conn, error)
+
+	// TLSConfig, if set, terminates TLS once for all routes: every connection is wrapped with tls.Server after
+	// ConnPreWrap, and its handshake is completed, within 10 seconds, before any route is tried, so handlers
+	// get the plaintext *tls.Conn. Connections failing the handshake are logged and dropped. Handlers can route
+	// on the negotiated state, e.g. the ALPN protocol or SNI server name, with TLSState on their context.
+	// ServeTLS overrides it for the connections of a single listener.
+	TLSConfig *tls.Config
+
+	// MatchCacheTTL, if set, remembers which route matched a connection per remote host for the given duration.
+	// Later connections from the same host try that route first and fall back to trying all routes
+	// if it does not match. This is an optimization for expensive matching, not a security boundary.
+	MatchCacheTTL time.Duration
+
+	// AccessLog, if set, is called with an entry for every tracked connection once its handler calls closed.
+	// To fill in the byte counts, accepted connections are wrapped in a counting conn below ConnPreWrap.
+	AccessLog func(AccessLogEntry[ID])
+
+	// MatchTrace, if set, is called for every route a connection is offered to, with whether the route took
+	// it, so the decision path of a connection can be logged. Routes declining through their match predicate
+	// or skipped at their connection limit count as not matched. It is called from the goroutine handling the
+	// connection, so concurrently for different connections.
+	MatchTrace func(conn net.Conn, id ID, matched bool)
+
+	// PrefixFirstByteTimeout bounds how long connections wait for their first byte to be matched against the
+	// routes set with SetPrefixRoute, before they are offered to the plain routes. It only applies while prefix
+	// routes are set. Zero means 250 milliseconds.
+	PrefixFirstByteTimeout time.Duration
+
 	// We use a copy-on-write pattern to allow fast handler lookup.
-	routes   atomic.Value
-	routesMu sync.Mutex
+	// Removed routes are only marked and compacted away lazily, see RouteHandle.
+	routes     atomic.Value // []*route[ID]
+	routesMu   sync.Mutex
+	routeIndex map[ID]*route[ID]
+	routesDead int
+	prefixes   map[*route[ID]][]byte // see SetPrefixRoute
+	prefixTrie atomic.Pointer[prefixNode[ID]]
 
-	closing atomic.Bool
+	matchCacheMu    sync.Mutex
+	matchCache      map[string]matchCacheEntry[ID]
+	matchCacheSwept time.Time
+
+	closing  atomic.Bool
+	stopping atomic.Bool   // see StopAccepting
+	closed   chan struct{} // closed once closing is set, guarded by mu, see closingSignal
 
 	mu sync.Mutex
 
 	listeners     map[net.Listener]struct{}
 	listenerGroup sync.WaitGroup
+	ready         chan struct{} // closed once the first listener is added, see Ready
+	readyClosed   bool
+	startGroup    sync.WaitGroup // accept loops of Start, see Wait
+	startErr      error
 
-	conns map[*io.Closer]struct{}
+	conns    map[*io.Closer]context.CancelCauseFunc // cancels the context of the conn's handler
+	connKeys map[any]*io.Closer                     // see SetConnKey
 }
 
 func (s *Server[ID]) Serve(ctx context.Context, listener net.Listener) error {
@@ -51,9 +129,50 @@ func (s *Server[ID]) Serve(ctx context.Context, listener net.Listener) error {
 		s.Logger = slog.Default()
 	}
 
-	if !s.addListener(listener) {
-		return ErrServerClosed
+	if err := s.addListener(listener); err != nil {
+		return err
 	}
+	return s.acceptLoop(ctx, listener, nil)
+}
+
+// Start is like Serve, but accepts in the background and returns as soon as the listener is registered, so
+// the server is accepting from it once Start returns. It fails like Serve if the server is closed or stopped
+// accepting. Use Wait to block until the accept loops of all Start calls have ended.
+func (s *Server[ID]) Start(ctx context.Context, listener net.Listener) error {
+	if s.Logger == nil {
+		s.Logger = slog.Default()
+	}
+
+	if err := s.addListener(listener); err != nil {
+		return err
+	}
+	s.startGroup.Add(1)
+	go func() {
+		defer s.startGroup.Done()
+		if err := s.acceptLoop(ctx, listener, nil); err != nil {
+			s.mu.Lock()
+			if s.startErr == nil {
+				s.startErr = err
+			}
+			s.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+// Wait blocks until the accept loops of all Start calls have ended, e.g. after Close, Shutdown or
+// StopAccepting, and returns the error the first of them ended with, as Serve would have returned it.
+// It returns nil right away if Start was not called.
+func (s *Server[ID]) Wait() error {
+	s.startGroup.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.startErr
+}
+
+// acceptLoop accepts from the registered listener until the server closes or stops accepting.
+// tlsConfig terminates TLS for its connections instead of TLSConfig, if set.
+func (s *Server[ID]) acceptLoop(ctx context.Context, listener net.Listener, tlsConfig *tls.Config) error {
 	defer s.removeListener(listener)
 
 	for {
@@ -62,40 +181,193 @@ func (s *Server[ID]) Serve(ctx context.Context, listener net.Listener) error {
 			if s.closing.Load() {
 				return ErrServerClosed
 			}
+			if s.stopping.Load() {
+				return ErrStoppedAccepting
+			}
 			s.Logger.WarnContext(ctx, "error accepting connection", "error", err)
 			continue
 		}
-		go s.route(ctx, conn)
+		go s.route(ctx, conn, tlsConfig)
+		if s.stopping.Load() {
+			return ErrStoppedAccepting
+		}
 	}
 }
 
+// ServeConn routes a single already accepted connection, e.g. one handed over by inetd or systemd
+// socket activation, through the server's handlers. Matched connections are tracked like connections
+// accepted by Serve, so Close and Shutdown apply to them. It returns once the connection has been routed.
+// If the server is closing, the connection is closed and ErrServerClosed is returned.
+func (s *Server[ID]) ServeConn(ctx context.Context, conn net.Conn) error {
+	if s.Logger == nil {
+		s.Logger = slog.Default()
+	}
+	if s.closing.Load() {
+		_ = conn.Close()
+		return ErrServerClosed
+	}
+	s.route(ctx, conn, nil)
+	return nil
+}
+
+// ServeAccept is like Serve, but takes connections from accept instead of a net.Listener, e.g. streams of
+// a QUIC session or conns handed over on a channel. Accepted connections are routed and tracked like those
+// of Serve. Errors from accept are logged and accept is called again, except for errors wrapping
+// net.ErrClosed, which signal that no more connections will come and are returned.
+// Close and Shutdown stop ServeAccept without waiting for a blocked accept call to return;
+// a connection it returns afterwards is closed.
+func (s *Server[ID]) ServeAccept(ctx context.Context, accept func() (net.Conn, error)) error {
+	if s.Logger == nil {
+		s.Logger = slog.Default()
+	}
+
+	l := newFuncListener(accept)
+	if err := s.addListener(l); err != nil {
+		_ = l.Close()
+		return err
+	}
+	defer s.removeListener(l)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.closing.Load() {
+				return ErrServerClosed
+			}
+			if s.stopping.Load() {
+				_ = l.Close()
+				return ErrStoppedAccepting
+			}
+			if errors.Is(err, net.ErrClosed) {
+				_ = l.Close()
+				return err
+			}
+			s.Logger.WarnContext(ctx, "error accepting connection", "error", err)
+			continue
+		}
+		go s.route(ctx, conn, nil)
+	}
+}
+
+// funcListener adapts an accept function to a net.Listener, so it can be closed like one.
+// Since Close cannot interrupt a blocked accept call, the calls are made by a separate goroutine.
+type funcListener struct {
+	accept  func() (net.Conn, error)
+	results chan funcAccept
+	next    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+type funcAccept struct {
+	conn net.Conn
+	err  error
+}
+
+func newFuncListener(accept func() (net.Conn, error)) *funcListener {
+	l := &funcListener{
+		accept:  accept,
+		results: make(chan funcAccept),
+		next:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// run calls accept once per Accept, so no connection is taken from the source without a caller for it.
+func (l *funcListener) run() {
+	for {
+		select {
+		case <-l.next:
+		case <-l.done:
+			return
+		}
+		conn, err := l.accept()
+		select {
+		case l.results <- funcAccept{conn, err}:
+		case <-l.done:
+			if conn != nil {
+				_ = conn.Close()
+			}
+			return
+		}
+	}
+}
+
+func (l *funcListener) Accept() (net.Conn, error) {
+	select {
+	case l.next <- struct{}{}:
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+	select {
+	case r := <-l.results:
+		return r.conn, r.err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *funcListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *funcListener) Addr() net.Addr { return funcAddr{} }
+
+type funcAddr struct{}
+
+func (funcAddr) Network() string { return "func" }
+func (funcAddr) String() string  { return "accept" }
+
 // SetRoute sets a handler for a specific ID.
 // If a handler already exists for this ID, it will be replaced.
 // It does not close any existing connections that were created by the previous handler, but new connections will use the new handler.
-func (s *Server[ID]) SetRoute(id ID, handler Handler) {
+// The returned handle can be used to replace or remove this route without looking it up again.
+func (s *Server[ID]) SetRoute(id ID, handler Handler) RouteHandle[ID] {
 	s.routesMu.Lock()
 	defer s.routesMu.Unlock()
-	old, _ := s.routes.Load().([]route[ID])
-	// if there are no routes yet, initialize with the first one
-	if old == nil {
-		s.routes.Store([]route[ID]{{id: id, handler: handler}})
-		return
+	return s.setRoute(id, nil, &routeHandler{handle: handler})
+}
+
+// SetMatchedRoute sets a route for a specific ID that separates matching from handling: match decides whether
+// a connection belongs to the route and handle serves the connections it matched, as a Handler that matched
+// would, returning the wrappedConn. match is called before anything else of the route, including waiting for
+// a slot of its limit, so it should be cheap and must not read from or write to the connection; routes matching
+// on the first bytes use SetPrefixRoute instead. With MatchCacheTTL, match is still called for cached routes.
+// Otherwise it works as SetRoute, and setting the route again with SetRoute or Replace turns it into a plain route.
+func (s *Server[ID]) SetMatchedRoute(id ID, match func(conn net.Conn) bool, handle func(ctx context.Context, conn net.Conn, closed func()) io.Closer) RouteHandle[ID] {
+	s.routesMu.Lock()
+	defer s.routesMu.Unlock()
+	return s.setRoute(id, nil, &routeHandler{
+		match: func(_ context.Context, conn net.Conn) bool { return match(conn) },
+		handle: func(ctx context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+			return true, handle(ctx, conn, closed)
+		},
+	})
+}
+
+// setRoute is SetRoute with routesMu held, for a prefix route if prefix is not nil.
+func (s *Server[ID]) setRoute(id ID, prefix []byte, handler *routeHandler) RouteHandle[ID] {
+	if s.routeIndex == nil {
+		s.routeIndex = make(map[ID]*route[ID])
 	}
-	// check if we need to replace an existing route; always copy-on-write
-	for i, r := range old {
-		if r.id == id {
-			newRoutes := make([]route[ID], len(old))
-			copy(newRoutes, old)
-			newRoutes[i] = route[ID]{id: id, handler: handler}
-			s.routes.Store(newRoutes)
-			return
-		}
+	// replacing an existing route only swaps its handler, no copy needed
+	if r, ok := s.routeIndex[id]; ok {
+		r.handler.Store(handler)
+		s.setRoutePrefix(r, prefix)
+		return RouteHandle[ID]{s: s, r: r}
 	}
-	// append a new route by creating a new slice to avoid modifying shared backing array
-	newRoutes := make([]route[ID], len(old)+1)
-	copy(newRoutes, old)
-	newRoutes[len(old)] = route[ID]{id: id, handler: handler}
-	s.routes.Store(newRoutes)
+	r := &route[ID]{id: id}
+	r.handler.Store(handler)
+	s.setRoutePrefix(r, prefix) // before the route is published
+	s.routeIndex[id] = r
+	// Readers only ever look at the slice up to the length they loaded, so appending into spare
+	// capacity of the shared backing array is safe and keeps additions amortized O(1).
+	old, _ := s.routes.Load().([]*route[ID])
+	s.routes.Store(append(old, r))
+	return RouteHandle[ID]{s: s, r: r}
 }
 
 // RemoveRoute removes a handler by its ID.
@@ -103,88 +375,556 @@ func (s *Server[ID]) SetRoute(id ID, handler Handler) {
 func (s *Server[ID]) RemoveRoute(id ID) {
 	s.routesMu.Lock()
 	defer s.routesMu.Unlock()
-	routes, _ := s.routes.Load().([]route[ID])
-	if len(routes) == 0 {
+	if r, ok := s.routeIndex[id]; ok {
+		s.removeRoute(r)
+	}
+}
+
+// removeRoute marks r as removed and compacts the routes once more than half of them are dead.
+// Caller must hold routesMu.
+func (s *Server[ID]) removeRoute(r *route[ID]) {
+	if !r.removed.CompareAndSwap(false, true) {
+		return
+	}
+	if s.routeIndex[r.id] == r {
+		delete(s.routeIndex, r.id)
+	}
+	if _, ok := s.prefixes[r]; ok {
+		delete(s.prefixes, r)
+		s.buildPrefixTrie()
+	}
+	routes, _ := s.routes.Load().([]*route[ID])
+	s.routesDead++
+	if s.routesDead*2 <= len(routes) {
 		return
 	}
-	// build a new slice excluding the id
-	newRoutes := make([]route[ID], 0, len(routes))
+	// build a new slice excluding removed routes; the old backing array may still be in use by readers
+	newRoutes := make([]*route[ID], 0, len(routes)-s.routesDead)
 	for _, r := range routes {
-		if r.id != id {
+		if !r.removed.Load() {
 			newRoutes = append(newRoutes, r)
 		}
 	}
 	s.routes.Store(newRoutes)
+	s.routesDead = 0
+}
+
+// AccessLogEntry describes a connection handled by a Server, see Server.AccessLog.
+type AccessLogEntry[ID comparable] struct {
+	Route      ID
+	RemoteAddr net.Addr
+	// Start is when the connection was accepted and End when its handler called closed.
+	Start, End time.Time
+	// BytesIn and BytesOut are the bytes read from and written to the accepted connection.
+	BytesIn, BytesOut uint64
+}
+
+// acceptedConn holds the per-connection state shared by the routes a connection is offered to.
+type acceptedConn struct {
+	idle    *idleConn    // nil unless IdleConnTimeout is set
+	slow    *slowConn    // nil unless MinReadRate is set
+	counter *ByteCounter // nil unless AccessLog is set
+	limit   *limitConn   // nil unless MaxConnBytesIn or MaxConnBytesOut is set
+	remote  net.Addr
+	start   time.Time
 }
 
 type route[ID comparable] struct {
-	id      ID
-	handler Handler
+	id       ID
+	handler  atomic.Pointer[routeHandler]
+	removed  atomic.Bool
+	prefixed atomic.Bool // only tried through the prefix trie, see SetPrefixRoute
+	limit    atomic.Pointer[routeLimiter]
+}
+
+// routeHandler is the handler of a route, with the matcher of a route set by SetMatchedRoute.
+type routeHandler struct {
+	match  func(ctx context.Context, conn net.Conn) bool // nil for plain routes
+	handle Handler
+}
+
+// RouteLimit bounds the number of concurrent connections of a route, see RouteHandle.SetLimit.
+type RouteLimit struct {
+	// Max is the maximum number of tracked connections of the route. Zero removes the limit.
+	Max int
+	// Queue is the number of connections that may wait up to Wait for a free slot once Max is reached.
+	// If either is zero, connections are not queued.
+	Queue int
+	Wait  time.Duration
+}
+
+type routeLimiter struct {
+	RouteLimit
+	sem     chan struct{}
+	waiting atomic.Int32
+}
+
+// acquire takes a slot, queueing for one if configured. It reports whether a slot was taken.
+func (l *routeLimiter) acquire(ctx context.Context) bool {
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	default:
+	}
+	if l.Queue <= 0 || l.Wait <= 0 {
+		return false
+	}
+	if l.waiting.Add(1) > int32(l.Queue) {
+		l.waiting.Add(-1)
+		return false
+	}
+	defer l.waiting.Add(-1)
+	timer := time.NewTimer(l.Wait)
+	defer timer.Stop()
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l *routeLimiter) release() { <-l.sem }
+
+// RouteHandle refers to a route added by SetRoute.
+// Removing or replacing a route through its handle is O(1) (amortized for removal),
+// as opposed to rebuilding the routes on every change.
+type RouteHandle[ID comparable] struct {
+	s *Server[ID]
+	r *route[ID]
 }
 
-func (s *Server[ID]) route(ctx context.Context, conn net.Conn) {
-	routes, ok := s.routes.Load().([]route[ID])
+// ID returns the ID of the route.
+func (h RouteHandle[ID]) ID() ID {
+	var zero ID
+	if h.r == nil {
+		return zero
+	}
+	return h.r.id
+}
+
+// Replace swaps the handler of the route, and drops the matcher of a route set by SetMatchedRoute.
+// It is a no-op if the route has been removed.
+// Existing connections created by the previous handler are not affected.
+func (h RouteHandle[ID]) Replace(handler Handler) {
+	if h.r == nil {
+		return
+	}
+	h.s.routesMu.Lock()
+	defer h.s.routesMu.Unlock()
+	if !h.r.removed.Load() {
+		h.r.handler.Store(&routeHandler{handle: handler})
+	}
+}
+
+// SetLimit limits the number of concurrent connections of the route. A slot is taken before the handler is
+// offered a connection and released once the handler calls closed, or right away if it does not match.
+// When no slot is free and none frees up in the queue, the route is skipped and the connection is offered
+// to the remaining routes. Queued connections delay all later routes, including for connections this route
+// would not match. Connections holding a slot of a previous limit release it to that limit.
+func (h RouteHandle[ID]) SetLimit(limit RouteLimit) {
+	if h.r == nil {
+		return
+	}
+	if limit.Max <= 0 {
+		h.r.limit.Store(nil)
+		return
+	}
+	h.r.limit.Store(&routeLimiter{RouteLimit: limit, sem: make(chan struct{}, limit.Max)})
+}
+
+// Remove removes the route. It is a no-op if the route has already been removed,
+// including when it was removed by ID or replaced by a new route for the same ID after removal.
+// It does not close any existing connections that were created by this route.
+func (h RouteHandle[ID]) Remove() {
+	if h.r == nil {
+		return
+	}
+	h.s.routesMu.Lock()
+	defer h.s.routesMu.Unlock()
+	h.s.removeRoute(h.r)
+}
+
+// route routes conn, terminating TLS with tlsConfig, or TLSConfig if it is nil.
+func (s *Server[ID]) route(ctx context.Context, conn net.Conn, tlsConfig *tls.Config) {
+	routes, ok := s.routes.Load().([]*route[ID])
 	if !ok {
 		_ = conn.Close()
 		s.Logger.DebugContext(ctx, "no routes configured, dropping connection", "addr", conn.RemoteAddr().String())
 		return
 	}
-	for _, r := range routes {
-		connCloser := io.Closer(conn)
-		var wConn *io.Closer = &connCloser
+	ac := &acceptedConn{remote: conn.RemoteAddr(), start: time.Now()}
+	// the trackers sit below the pre-wrap, so the conn handlers see is the pre-wrapped one
+	if s.MinReadRate > 0 && s.MinReadRateWindow > 0 {
+		ac.slow = newSlowConn(conn, s.MinReadRate, s.MinReadRateWindow)
+		conn = ac.slow
+	}
+	if s.MaxConnBytesIn > 0 || s.MaxConnBytesOut > 0 {
+		ac.limit = newLimitConn(conn, s.MaxConnBytesIn, s.MaxConnBytesOut)
+		conn = ac.limit
+	}
+	if s.AccessLog != nil {
+		ac.counter = new(ByteCounter)
+		conn = NewCountConn(conn, ac.counter)
+	}
+	if s.IdleConnTimeout > 0 {
+		ac.idle = newIdleConn(conn, s.IdleConnTimeout)
+		conn = ac.idle
+	}
+	if s.ConnPreWrap != nil {
+		wrapped, err := s.ConnPreWrap(conn)
+		if err != nil {
+			_ = conn.Close()
+			s.Logger.WarnContext(ctx, "error wrapping connection, dropping connection", "addr", conn.RemoteAddr().String(), "error", err)
+			return
+		}
+		conn = wrapped
+	}
+	if tlsConfig == nil {
+		tlsConfig = s.TLSConfig
+	}
+	if tlsConfig != nil {
 		var ok bool
-		closeCooldown := make(chan struct{}, 1)
-		ok, connCloser = r.handler(ctx, conn, func() {
-			<-closeCooldown
-			s.mu.Lock()
-			delete(s.conns, wConn)
-			s.mu.Unlock()
-		})
-		if !ok {
-			continue
+		if ctx, conn, ok = s.terminateTLS(ctx, conn, tlsConfig); !ok {
+			return
+		}
+	}
+	if root := s.prefixTrie.Load(); root != nil {
+		pc := NewPeekConn(conn)
+		conn = pc
+		firstByteTimeout := s.PrefixFirstByteTimeout
+		if firstByteTimeout <= 0 {
+			firstByteTimeout = defaultPrefixFirstByteTimeout
+		}
+		if r := matchPrefix(root, pc, firstByteTimeout); r != nil && s.tryRoute(ctx, r, conn, ac) {
+			return
 		}
-		// Fallback to original conn if handler returned nil closer
-		if connCloser == nil {
-			connCloser = conn
+	}
+	var cached *route[ID]
+	var cacheKey string
+	if s.MatchCacheTTL > 0 {
+		cacheKey = matchCacheKey(conn.RemoteAddr())
+		if cached = s.cachedRoute(cacheKey); cached != nil && !cached.prefixed.Load() && s.tryRoute(ctx, cached, conn, ac) {
+			s.cacheRoute(cacheKey, cached)
+			return
 		}
+	}
+	for _, r := range routes {
+		if r == cached || r.prefixed.Load() {
+			continue // already tried, or only for matching prefixes
+		}
+		if s.tryRoute(ctx, r, conn, ac) {
+			if s.MatchCacheTTL > 0 {
+				s.cacheRoute(cacheKey, r)
+			}
+			return
+		}
+	}
+	_ = conn.Close() // make sure to close the connection if not already closed by the handler
+	s.Logger.DebugContext(ctx, "unhandled connection, dropping connection", "addr", conn.RemoteAddr().String())
+}
+
+// tryRoute offers conn to the handler of r and starts tracking it if the handler matches.
+func (s *Server[ID]) tryRoute(ctx context.Context, r *route[ID], conn net.Conn, ac *acceptedConn) bool {
+	if r.removed.Load() {
+		return false
+	}
+	matched := s.offerRoute(ctx, r, conn, ac)
+	if s.MatchTrace != nil {
+		s.MatchTrace(conn, r.id, matched)
+	}
+	return matched
+}
+
+// offerRoute offers conn to the handler of r, see tryRoute.
+func (s *Server[ID]) offerRoute(ctx context.Context, r *route[ID], conn net.Conn, ac *acceptedConn) bool {
+	rh := r.handler.Load()
+	if rh.match != nil && !rh.match(ctx, conn) {
+		return false
+	}
+	release := func() {}
+	if lim := r.limit.Load(); lim != nil {
+		if !lim.acquire(ctx) {
+			s.Logger.DebugContext(ctx, "route at connection limit, skipping route", "addr", conn.RemoteAddr().String())
+			return false
+		}
+		release = sync.OnceFunc(lim.release)
+	}
+	handler := rh.handle
+	connCloser := io.Closer(conn)
+	var wConn *io.Closer = &connCloser
+	var ok bool
+	var key any // guarded by s.mu
+	setKey := connKeySetter(func(k any) {
 		s.mu.Lock()
-		if s.conns == nil {
-			s.conns = make(map[*io.Closer]struct{})
+		defer s.mu.Unlock()
+		s.untrackKey(key, wConn)
+		key = k
+		if _, tracked := s.conns[wConn]; tracked {
+			s.trackKey(key, wConn)
 		}
-		s.conns[wConn] = struct{}{}
+	})
+	connCtx, cancel := context.WithCancelCause(context.WithValue(ctx, connKeyCtxKey{}, setKey))
+	closeCooldown := make(chan struct{}, 1)
+	ok, connCloser = handler(connCtx, conn, func() {
+		<-closeCooldown
+		cancel(nil)
+		s.mu.Lock()
+		delete(s.conns, wConn)
+		s.untrackKey(key, wConn)
 		s.mu.Unlock()
-		closeCooldown <- struct{}{}
+		release()
+		if s.AccessLog != nil && ac.counter != nil {
+			s.AccessLog(AccessLogEntry[ID]{
+				Route:      r.id,
+				RemoteAddr: ac.remote,
+				Start:      ac.start,
+				End:        time.Now(),
+				BytesIn:    ac.counter.Read(),
+				BytesOut:   ac.counter.Written(),
+			})
+		}
+	})
+	if !ok {
+		cancel(nil)
+		release()
+		return false
+	}
+	// Fallback to original conn if handler returned nil closer
+	if connCloser == nil {
+		connCloser = conn
+	}
+	s.mu.Lock()
+	if s.conns == nil {
+		s.conns = make(map[*io.Closer]context.CancelCauseFunc)
+	}
+	s.conns[wConn] = cancel
+	s.trackKey(key, wConn)
+	s.mu.Unlock()
+	closeCooldown <- struct{}{}
+	forceClose := func(msg string) func() {
+		return func() {
+			s.mu.Lock()
+			_, tracked := s.conns[wConn]
+			delete(s.conns, wConn)
+			s.untrackKey(key, wConn)
+			s.mu.Unlock()
+			if tracked {
+				s.Logger.DebugContext(ctx, msg, "addr", conn.RemoteAddr().String())
+				cancel(errConnForceClosed)
+				_ = (*wConn).Close()
+			}
+		}
+	}
+	if ac.idle != nil {
+		ac.idle.onIdle(forceClose("closing idle connection"))
+	}
+	if ac.slow != nil {
+		ac.slow.onSlow(forceClose("closing slow connection"))
+	}
+	if ac.limit != nil {
+		ac.limit.onLimit(forceClose("closing connection over byte limit"))
+	}
+	return true
+}
+
+type connKeyCtxKey struct{}
+
+type connKeySetter func(key any)
+
+// SetConnKey associates key with the connection being handled, so it can be closed later with
+// Server.CloseConn. ctx must be the context passed to the Handler; it can be called during or after
+// handling. A key identifies one connection, setting it on another connection moves it there.
+// The key must be comparable, nil removes the key. It returns false if ctx does not come from a Server.
+func SetConnKey(ctx context.Context, key any) bool {
+	set, ok := ctx.Value(connKeyCtxKey{}).(connKeySetter)
+	if ok {
+		set(key)
+	}
+	return ok
+}
+
+// trackKey and untrackKey maintain connKeys. Caller must hold s.mu.
+func (s *Server[ID]) trackKey(key any, c *io.Closer) {
+	if key == nil {
 		return
 	}
-	_ = conn.Close() // make sure to close the connection if not already closed by the handler
-	s.Logger.DebugContext(ctx, "unhandled connection, dropping connection", "addr", conn.RemoteAddr().String())
+	if s.connKeys == nil {
+		s.connKeys = make(map[any]*io.Closer)
+	}
+	s.connKeys[key] = c
 }
 
-func (s *Server[ID]) addListener(l net.Listener) bool {
+func (s *Server[ID]) untrackKey(key any, c *io.Closer) {
+	if key != nil && s.connKeys[key] == c {
+		delete(s.connKeys, key)
+	}
+}
+
+// CloseConn closes the tracked connection associated with key by SetConnKey, leaving other
+// connections and the server running. It reports whether such a connection was found.
+func (s *Server[ID]) CloseConn(key any) bool {
+	s.mu.Lock()
+	c, ok := s.connKeys[key]
+	cancel := s.conns[c]
+	if ok {
+		delete(s.connKeys, key)
+		delete(s.conns, c)
+	}
+	s.mu.Unlock()
+	if ok {
+		if cancel != nil {
+			cancel(errConnForceClosed)
+		}
+		_ = (*c).Close()
+	}
+	return ok
+}
+
+type matchCacheEntry[ID comparable] struct {
+	r       *route[ID]
+	expires time.Time
+}
+
+// matchCacheKey returns the host part of addr, so reconnects from another port share an entry.
+func matchCacheKey(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
+
+// cachedRoute returns the route that last matched a conn from key, if it has not expired.
+func (s *Server[ID]) cachedRoute(key string) *route[ID] {
+	s.matchCacheMu.Lock()
+	defer s.matchCacheMu.Unlock()
+	e, ok := s.matchCache[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(e.expires) || e.r.removed.Load() {
+		delete(s.matchCache, key)
+		return nil
+	}
+	return e.r
+}
+
+// cacheRoute records r as the route matching conns from key and sweeps expired entries about once per TTL.
+func (s *Server[ID]) cacheRoute(key string, r *route[ID]) {
+	now := time.Now()
+	s.matchCacheMu.Lock()
+	defer s.matchCacheMu.Unlock()
+	if s.matchCache == nil {
+		s.matchCache = make(map[string]matchCacheEntry[ID])
+	}
+	s.matchCache[key] = matchCacheEntry[ID]{r: r, expires: now.Add(s.MatchCacheTTL)}
+	if now.Sub(s.matchCacheSwept) < s.MatchCacheTTL {
+		return
+	}
+	for k, e := range s.matchCache {
+		if now.After(e.expires) {
+			delete(s.matchCache, k)
+		}
+	}
+	s.matchCacheSwept = now
+}
+
+func (s *Server[ID]) addListener(l net.Listener) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.listeners == nil {
 		s.listeners = make(map[net.Listener]struct{})
 	}
 	if s.closing.Load() {
-		return false
+		return ErrServerClosed
+	}
+	if s.stopping.Load() {
+		return ErrStoppedAccepting
 	}
 	s.listeners[l] = struct{}{}
 	s.listenerGroup.Add(1)
-	return true
+	if !s.readyClosed {
+		if s.ready == nil {
+			s.ready = make(chan struct{})
+		}
+		close(s.ready)
+		s.readyClosed = true
+	}
+	return nil
+}
+
+// Ready returns a channel that is closed once Serve, ServeAccept or Start has registered its first listener
+// and is about to accept, so callers can wait for the server instead of sleeping before dialing.
+// It stays open if the server is closed before serving.
+func (s *Server[ID]) Ready() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ready == nil {
+		s.ready = make(chan struct{})
+	}
+	return s.ready
 }
 
 func (s *Server[ID]) removeListener(l net.Listener) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	delete(s.listeners, l)
+	if d, ok := l.(interface{ SetDeadline(time.Time) error }); ok && s.stopping.Load() {
+		// undo the deadline set by StopAccepting, so the listener can be accepted from again
+		_ = d.SetDeadline(time.Time{})
+	}
 	s.listenerGroup.Done()
 }
 
+// Listeners returns the listeners Serve is currently accepting from, e.g. to pass their file descriptors
+// to a new process with (*net.TCPListener).File for a zero-downtime restart.
+func (s *Server[ID]) Listeners() []net.Listener {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ls []net.Listener
+	for l := range s.listeners {
+		if _, ok := l.(*funcListener); !ok {
+			ls = append(ls, l)
+		}
+	}
+	return ls
+}
+
+// StopAccepting makes Serve and ServeAccept stop accepting new connections and return ErrStoppedAccepting,
+// without closing the listeners of Serve, so their sockets can be handed over to another process while
+// existing connections continue to be served. It returns those listeners. Once Serve has returned, the server
+// no longer tracks them, so Close and Shutdown leave them open.
+// Accept calls are interrupted with a deadline on listeners that support one, like *net.TCPListener.
+// On other listeners, the loop stops after the next Accept returns, and a connection it accepted is still routed.
+// Later calls to Serve and ServeAccept return ErrStoppedAccepting right away.
+func (s *Server[ID]) StopAccepting() []net.Listener {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopping.Store(true)
+	var ls []net.Listener
+	for l := range s.listeners {
+		if fl, ok := l.(*funcListener); ok {
+			_ = fl.Close()
+			continue
+		}
+		if d, ok := l.(interface{ SetDeadline(time.Time) error }); ok {
+			_ = d.SetDeadline(time.Unix(1, 0)) // in the past, so Accept returns right away
+		}
+		ls = append(ls, l)
+	}
+	return ls
+}
+
 func (s *Server[ID]) Close() error {
 	if !s.closing.CompareAndSwap(false, true) {
 		return nil
 	}
+	close(s.closingSignal())
 
 	// First close listeners under lock
 	s.mu.Lock()
@@ -201,10 +941,12 @@ func (s *Server[ID]) Close() error {
 
 	// Now close all active connections under lock
 	s.mu.Lock()
-	for c := range s.conns {
+	for c, cancel := range s.conns {
+		cancel(ErrServerClosed)
 		_ = (*c).Close()
 		delete(s.conns, c)
 	}
+	clear(s.connKeys)
 	s.mu.Unlock()
 
 	return err
@@ -219,6 +961,7 @@ func (s *Server[ID]) Shutdown(ctx context.Context) error {
 	if !s.closing.CompareAndSwap(false, true) {
 		return nil
 	}
+	close(s.closingSignal())
 
 	// Close listeners to stop accepting new connections
 	s.mu.Lock()
@@ -248,10 +991,12 @@ func (s *Server[ID]) Shutdown(ctx context.Context) error {
 		case <-ctx.Done():
 			// Timeout/cancellation: force close remaining connections
 			s.mu.Lock()
-			for c := range s.conns {
+			for c, cancel := range s.conns {
+				cancel(ErrServerClosed)
 				_ = (*c).Close()
 				delete(s.conns, c)
 			}
+			clear(s.connKeys)
 			s.mu.Unlock()
 			return errors.Join(err, ctx.Err())
 		case <-ticker.C:
@@ -259,3 +1004,160 @@ func (s *Server[ID]) Shutdown(ctx context.Context) error {
 		}
 	}
 }
+
+// idleConn tracks the last activity on a conn and fires a callback once it has been idle for timeout.
+// Until a callback is set, an idle conn is simply closed.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+	last    atomic.Int64
+	timer   *time.Timer
+	idle    atomic.Pointer[func()]
+}
+
+func newIdleConn(conn net.Conn, timeout time.Duration) *idleConn {
+	c := &idleConn{Conn: conn, timeout: timeout}
+	c.touch()
+	c.timer = time.AfterFunc(timeout, c.check)
+	return c
+}
+
+func (c *idleConn) touch() {
+	c.last.Store(time.Now().UnixNano())
+}
+
+func (c *idleConn) check() {
+	if since := time.Since(time.Unix(0, c.last.Load())); since < c.timeout {
+		c.timer.Reset(c.timeout - since)
+		return
+	}
+	if f := c.idle.Load(); f != nil {
+		(*f)()
+		return
+	}
+	_ = c.Close()
+}
+
+func (c *idleConn) onIdle(f func()) {
+	c.idle.Store(&f)
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.touch()
+	return n, err
+}
+
+func (c *idleConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.touch()
+	return n, err
+}
+
+func (c *idleConn) Close() error {
+	c.timer.Stop()
+	return c.Conn.Close()
+}
+
+// slowConn counts the bytes read from a conn and fires a callback if fewer than rate bytes per second were
+// read by the end of window. Until a callback is set, a slow conn is simply closed.
+type slowConn struct {
+	net.Conn
+	min   int64 // bytes to read within the window
+	read  atomic.Int64
+	timer *time.Timer
+	slow  atomic.Pointer[func()]
+}
+
+func newSlowConn(conn net.Conn, rate int, window time.Duration) *slowConn {
+	c := &slowConn{Conn: conn, min: int64(float64(rate) * window.Seconds())}
+	c.timer = time.AfterFunc(window, c.check)
+	return c
+}
+
+func (c *slowConn) check() {
+	if c.read.Load() >= c.min {
+		return
+	}
+	if f := c.slow.Load(); f != nil {
+		(*f)()
+		return
+	}
+	_ = c.Close()
+}
+
+func (c *slowConn) onSlow(f func()) {
+	c.slow.Store(&f)
+}
+
+func (c *slowConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func (c *slowConn) Close() error {
+	c.timer.Stop()
+	return c.Conn.Close()
+}
+
+// limitConn counts the bytes read from and written to a conn and fires a callback once either exceeds its limit,
+// after which reads and writes fail with ErrConnByteLimit. Until a callback is set, the conn is simply closed.
+type limitConn struct {
+	net.Conn
+	maxIn, maxOut int64 // 0 is unlimited
+	in, out       atomic.Int64
+	exceeded      atomic.Bool
+	limited       atomic.Pointer[func()]
+}
+
+func newLimitConn(conn net.Conn, maxIn, maxOut int64) *limitConn {
+	return &limitConn{Conn: conn, maxIn: maxIn, maxOut: maxOut}
+}
+
+func (c *limitConn) onLimit(f func()) {
+	c.limited.Store(&f)
+}
+
+// exceed marks the conn as over its limit and fires the callback the first time.
+func (c *limitConn) exceed() {
+	if c.exceeded.Swap(true) {
+		return
+	}
+	if f := c.limited.Load(); f != nil {
+		(*f)()
+		return
+	}
+	_ = c.Close()
+}
+
+// Read drops the bytes of the read that exceeds the limit, since the conn is closed anyway.
+func (c *limitConn) Read(b []byte) (int, error) {
+	if c.exceeded.Load() {
+		return 0, ErrConnByteLimit
+	}
+	n, err := c.Conn.Read(b)
+	if c.maxIn > 0 && c.in.Add(int64(n)) > c.maxIn {
+		c.exceed()
+		return 0, ErrConnByteLimit
+	}
+	if err != nil && c.exceeded.Load() {
+		return n, ErrConnByteLimit
+	}
+	return n, err
+}
+
+func (c *limitConn) Write(b []byte) (int, error) {
+	if c.exceeded.Load() {
+		return 0, ErrConnByteLimit
+	}
+	if c.maxOut > 0 && c.out.Add(int64(len(b))) > c.maxOut {
+		c.exceed()
+		return 0, ErrConnByteLimit
+	}
+	n, err := c.Conn.Write(b)
+	if err != nil && c.exceeded.Load() {
+		return n, ErrConnByteLimit
+	}
+	return n, err
+}
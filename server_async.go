@@ -0,0 +1,73 @@
+package netx
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+)
+
+// PendingMatch is the decision of an asynchronous match, see SetAsyncMatchedRoute. The route matches if true is
+// sent on it; false, or closing it without sending, declines the connection.
+type PendingMatch <-chan bool
+
+// SetAsyncMatchedRoute is like SetMatchedRoute, for match decisions that take a round trip, e.g. to an auth
+// service. match starts the decision and returns right away with a PendingMatch, which the server waits on for up
+// to timeout while the connection is parked. Every connection is routed on a goroutine of its own, so a parked
+// connection holds up neither the accept loop nor other connections, only the routes after this one; ServeConn
+// returns once the decision is made. A decision that does not arrive in time counts as no match, and the
+// connection is offered to the next routes. A timeout of zero waits as long as it takes.
+//
+// The context passed to match is canceled once the decision is no longer waited for, because it arrived, timed
+// out or the server is closing, so match can give up its work; a decision sent afterwards is ignored. Parked
+// connections have no handler yet, so they are not tracked and Shutdown does not wait for them: Close and
+// Shutdown stop waiting on their decisions right away and count them as no match.
+func (s *Server[ID]) SetAsyncMatchedRoute(id ID, match func(ctx context.Context, conn net.Conn) PendingMatch, timeout time.Duration, handle func(ctx context.Context, conn net.Conn, closed func()) io.Closer) RouteHandle[ID] {
+	s.routesMu.Lock()
+	defer s.routesMu.Unlock()
+	return s.setRoute(id, nil, &routeHandler{
+		match: func(ctx context.Context, conn net.Conn) bool {
+			return s.awaitMatch(ctx, conn, match, timeout)
+		},
+		handle: func(ctx context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+			return true, handle(ctx, conn, closed)
+		},
+	})
+}
+
+// awaitMatch starts an asynchronous match for conn and waits for its decision, see SetAsyncMatchedRoute.
+func (s *Server[ID]) awaitMatch(ctx context.Context, conn net.Conn, match func(context.Context, net.Conn) PendingMatch, timeout time.Duration) bool {
+	matchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pending := match(matchCtx, conn)
+	if pending == nil {
+		return false
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case matched := <-pending:
+		return matched && !s.closing.Load()
+	case <-expired:
+		s.Logger.DebugContext(ctx, "async match timed out, skipping route", "addr", conn.RemoteAddr().String())
+		return false
+	case <-s.closingSignal():
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// closingSignal returns a channel that is closed once Close or Shutdown is called.
+func (s *Server[ID]) closingSignal() chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed == nil {
+		s.closed = make(chan struct{})
+	}
+	return s.closed
+}
@@ -0,0 +1,141 @@
+package netx_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pedramktb/go-netx"
+)
+
+// asyncMatch resolves to matched after delay, unless its context is canceled first.
+func asyncMatch(delay time.Duration, matched bool, canceled chan<- struct{}) func(context.Context, net.Conn) netx.PendingMatch {
+	return func(ctx context.Context, _ net.Conn) netx.PendingMatch {
+		ch := make(chan bool, 1)
+		go func() {
+			select {
+			case <-time.After(delay):
+				ch <- matched
+			case <-ctx.Done():
+				if canceled != nil {
+					close(canceled)
+				}
+			}
+		}()
+		return ch
+	}
+}
+
+// replyWith returns a handle that writes reply and closes the connection.
+func replyWith(reply string) func(context.Context, net.Conn, func()) io.Closer {
+	return func(_ context.Context, conn net.Conn, closed func()) io.Closer {
+		go func() {
+			defer closed()
+			defer conn.Close()
+			_, _ = conn.Write([]byte(reply))
+		}()
+		return conn
+	}
+}
+
+// dialAndRead dials addr and reads until the server closes the connection.
+func dialAndRead(addr string) (string, error) {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	_ = c.SetReadDeadline(time.Now().Add(3 * time.Second))
+	b, err := io.ReadAll(c)
+	return string(b), err
+}
+
+func TestServerAsyncMatchedRoute(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var s netx.Server[string]
+	s.Logger = &memLogger{}
+	defer s.Close()
+
+	s.SetAsyncMatchedRoute("auth", asyncMatch(100*time.Millisecond, true, nil), time.Second, replyWith("auth"))
+	s.SetRoute("fallback", func(_ context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+		return true, replyWith("fallback")(ctx, conn, closed)
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go func() { _ = s.Serve(ctx, ln) }()
+
+	// two connections are parked at once, neither waits for the other's decision
+	start := time.Now()
+	results := make(chan string, 2)
+	for range 2 {
+		go func() {
+			got, _ := dialAndRead(ln.Addr().String())
+			results <- got
+		}()
+	}
+	for range 2 {
+		if got := <-results; got != "auth" {
+			t.Fatalf("got %q, want the async route to match", got)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Fatalf("decisions took %v, want them made concurrently after 100ms", elapsed)
+	}
+
+	// a decision that misses the timeout counts as no match, and its context is canceled
+	canceled := make(chan struct{})
+	s.SetAsyncMatchedRoute("auth", asyncMatch(time.Minute, true, canceled), 50*time.Millisecond, replyWith("auth"))
+	if got, _ := dialAndRead(ln.Addr().String()); got != "fallback" {
+		t.Fatalf("got %q, want the timed out connection to fall through", got)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("match context not canceled after the timeout")
+	}
+}
+
+func TestServerAsyncMatchedRouteClose(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var s netx.Server[string]
+	s.Logger = &memLogger{}
+
+	canceled := make(chan struct{})
+	s.SetAsyncMatchedRoute("auth", asyncMatch(time.Minute, true, canceled), 0, replyWith("auth"))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go func() { _ = s.Serve(ctx, ln) }()
+
+	done := make(chan string, 1)
+	go func() {
+		got, _ := dialAndRead(ln.Addr().String())
+		done <- got
+	}()
+	time.Sleep(50 * time.Millisecond) // let the connection park
+	if err := s.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("parked decision not abandoned on Close")
+	}
+	select {
+	case got := <-done:
+		if got != "" {
+			t.Fatalf("got %q, want the parked connection dropped", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("parked connection not dropped on Close")
+	}
+}
@@ -0,0 +1,113 @@
+package netx
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// prefixPeekTimeout bounds how long the server waits for the bytes of a longer prefix, once the
+	// connection has sent its first byte.
+	prefixPeekTimeout = 5 * time.Second
+	// defaultPrefixFirstByteTimeout is the default of Server.PrefixFirstByteTimeout.
+	defaultPrefixFirstByteTimeout = 250 * time.Millisecond
+)
+
+// prefixNode is a node of the trie of prefix routes, keyed by the next byte of the prefix.
+type prefixNode[ID comparable] struct {
+	children map[byte]*prefixNode[ID]
+	route    *route[ID] // route whose prefix ends here, if any
+}
+
+// SetPrefixRoute sets a handler for a specific ID that is only offered connections starting with prefix.
+// Connections are matched against all prefix routes by peeking at their first bytes, and the route with the
+// longest matching prefix is tried first. Its handler gets a *PeekConn, so the peeked bytes are still there to
+// be read. If it does not match, or no prefix does, the connection is offered to the routes added by SetRoute,
+// again as a *PeekConn. Prefix routes are never tried for connections their prefix does not match.
+//
+// The server waits for the first byte up to PrefixFirstByteTimeout, so connections of protocols where the server
+// speaks first reach the plain routes after that short wait. Once bytes have arrived and a longer prefix could
+// still match, it waits for more, up to 5 seconds, so a client that sends a short prefix and waits for a reply
+// is delayed when a longer prefix starts with it. Prefixes must not
+// exceed 8192 bytes. An existing route for id is replaced, and the returned handle works as for SetRoute.
+// Setting the route again with SetRoute turns it into a plain route.
+func (s *Server[ID]) SetPrefixRoute(id ID, prefix []byte, handler Handler) RouteHandle[ID] {
+	if len(prefix) > peekConnSize {
+		panic(fmt.Sprintf("netx: SetPrefixRoute prefix of %d bytes exceeds %d bytes", len(prefix), peekConnSize))
+	}
+	s.routesMu.Lock()
+	defer s.routesMu.Unlock()
+	return s.setRoute(id, append([]byte{}, prefix...), &routeHandler{handle: handler})
+}
+
+// setRoutePrefix makes r a prefix route for prefix, or a plain route if prefix is nil.
+// Caller must hold routesMu.
+func (s *Server[ID]) setRoutePrefix(r *route[ID], prefix []byte) {
+	if _, ok := s.prefixes[r]; !ok && prefix == nil {
+		return
+	}
+	if prefix == nil {
+		delete(s.prefixes, r)
+	} else {
+		if s.prefixes == nil {
+			s.prefixes = make(map[*route[ID]][]byte)
+		}
+		s.prefixes[r] = prefix
+	}
+	r.prefixed.Store(prefix != nil)
+	s.buildPrefixTrie()
+}
+
+// buildPrefixTrie rebuilds the trie from the prefix routes. The trie is replaced as a whole, since
+// prefix routes are expected to change rarely. Caller must hold routesMu.
+func (s *Server[ID]) buildPrefixTrie() {
+	if len(s.prefixes) == 0 {
+		s.prefixTrie.Store(nil)
+		return
+	}
+	root := &prefixNode[ID]{}
+	for r, prefix := range s.prefixes {
+		node := root
+		for _, b := range prefix {
+			next, ok := node.children[b]
+			if !ok {
+				if node.children == nil {
+					node.children = make(map[byte]*prefixNode[ID])
+				}
+				next = &prefixNode[ID]{}
+				node.children[b] = next
+			}
+			node = next
+		}
+		node.route = r
+	}
+	s.prefixTrie.Store(root)
+}
+
+// matchPrefix returns the prefix route with the longest prefix that conn starts with, or nil if none does.
+// It peeks one byte further as long as a longer prefix could still match, waiting up to firstByteTimeout for
+// the first byte.
+func matchPrefix[ID comparable](root *prefixNode[ID], conn *PeekConn, firstByteTimeout time.Duration) *route[ID] {
+	_ = conn.SetReadDeadline(time.Now().Add(firstByteTimeout))
+	defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+	var best *route[ID]
+	node := root
+	for depth := 0; ; depth++ {
+		if node.route != nil && !node.route.removed.Load() {
+			best = node.route
+		}
+		if len(node.children) == 0 {
+			return best
+		}
+		b, err := conn.Peek(depth + 1)
+		if err != nil {
+			return best
+		}
+		if depth == 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(prefixPeekTimeout))
+		}
+		if node = node.children[b[depth]]; node == nil {
+			return best
+		}
+	}
+}
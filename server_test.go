@@ -6,6 +6,7 @@ import (
 	"errors"
 	"io"
 	"net"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -151,6 +152,66 @@ func TestRemoveRouteThenUnhandled(t *testing.T) {
 	}
 }
 
+func TestServerMatchTrace(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := &memLogger{}
+	var s netx.Server[string]
+	s.Logger = logger
+	// trace into the logger, so attempts and the drop are recorded in order
+	s.MatchTrace = func(_ net.Conn, id string, matched bool) {
+		if matched {
+			logger.append("TRACE", id+" matched")
+		} else {
+			logger.append("TRACE", id+" declined")
+		}
+	}
+	decline := func(_ context.Context, _ net.Conn, _ func()) (bool, io.Closer) { return false, nil }
+	s.SetRoute("a", decline)
+	s.SetRoute("b", decline)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() { _ = s.Serve(ctx, ln) }()
+	defer s.Close()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the unhandled connection to be dropped")
+	}
+
+	want := []string{"TRACE: a declined", "TRACE: b declined", "DEBUG: unhandled connection, dropping connection"}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		logger.mu.Lock()
+		var got []string
+		for _, e := range logger.entries {
+			if strings.HasPrefix(e, "TRACE: ") || strings.HasPrefix(e, "DEBUG: unhandled") {
+				got = append(got, e)
+			}
+		}
+		logger.mu.Unlock()
+		if len(got) >= len(want) {
+			if strings.Join(got, "|") != strings.Join(want, "|") {
+				t.Fatalf("got %q, want %q", got, want)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func TestCloseClosesActiveConnections(t *testing.T) {
 	t.Parallel()
 	ctx := context.Background()
@@ -393,3 +454,1199 @@ func TestShutdownTimeoutForcesClose(t *testing.T) {
 		t.Fatal("serve did not exit after forced Shutdown()")
 	}
 }
+
+func TestIdleConnTimeoutClosesSilentConn(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var s netx.Server[string]
+	s.Logger = &memLogger{}
+	s.IdleConnTimeout = 100 * time.Millisecond
+	defer s.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() { _ = s.Serve(ctx, ln) }()
+
+	// Handler that matches and leaves the connection to the client
+	s.SetRoute("id", func(_ context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+		return true, conn
+	})
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+
+	// The client stays silent; the server should close the conn after the idle timeout
+	start := time.Now()
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1)
+	_, err = c.Read(buf)
+	if err == nil {
+		t.Fatalf("expected read error after idle timeout")
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		t.Fatalf("conn was not closed by idle reaper: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("conn closed too early after %v", elapsed)
+	}
+
+	// A reaped conn is no longer tracked, so shutdown completes immediately
+	shutdownCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil && !errors.Is(err, netx.ErrServerClosed) {
+		t.Fatalf("shutdown: %v", err)
+	}
+}
+
+func TestRouteHandleRemoveAndReplace(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var s netx.Server[string]
+	s.Logger = &memLogger{}
+	defer s.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() { _ = s.Serve(ctx, ln) }()
+
+	hits := make(chan string, 4)
+	handler := func(name string) netx.Handler {
+		return func(_ context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+			hits <- name
+			_ = conn.Close()
+			closed()
+			return true, conn
+		}
+	}
+	dial := func() string {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer c.Close()
+		select {
+		case h := <-hits:
+			return h
+		case <-time.After(300 * time.Millisecond):
+			return ""
+		}
+	}
+
+	h := s.SetRoute("id", handler("first"))
+	if h.ID() != "id" {
+		t.Fatalf("handle id = %q", h.ID())
+	}
+	if got := dial(); got != "first" {
+		t.Fatalf("got %q, want first", got)
+	}
+
+	h.Replace(handler("second"))
+	if got := dial(); got != "second" {
+		t.Fatalf("got %q, want second", got)
+	}
+
+	h.Remove()
+	if got := dial(); got != "" {
+		t.Fatalf("removed handle still matched with %q", got)
+	}
+
+	// A stale handle must not affect a new route registered under the same ID.
+	s.SetRoute("id", handler("third"))
+	h.Replace(handler("stale"))
+	h.Remove()
+	if got := dial(); got != "third" {
+		t.Fatalf("got %q, want third", got)
+	}
+}
+
+func BenchmarkRouteHandleAddRemove(b *testing.B) {
+	handler := func(context.Context, net.Conn, func()) (bool, io.Closer) { return false, nil }
+	handles := make([]netx.RouteHandle[int], 1000)
+	for b.Loop() {
+		var s netx.Server[int]
+		for i := range handles {
+			handles[i] = s.SetRoute(i, handler)
+		}
+		for _, h := range handles {
+			h.Remove()
+		}
+	}
+}
+
+// markerConn prepends a marker to everything read from the underlying conn.
+type markerConn struct {
+	net.Conn
+	pending []byte
+}
+
+func (c *markerConn) Read(p []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+func TestConnPreWrap(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := &memLogger{}
+	var s netx.Server[string]
+	s.Logger = logger
+	s.ConnPreWrap = func(c net.Conn) (net.Conn, error) {
+		if c.RemoteAddr() == nil {
+			return nil, errors.New("no remote addr")
+		}
+		return &markerConn{Conn: c, pending: []byte("pre:")}, nil
+	}
+	defer s.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() { _ = s.Serve(ctx, ln) }()
+
+	// Echo back the first 8 bytes the handler sees
+	s.SetRoute("id", func(_ context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+		if _, ok := conn.(*markerConn); !ok {
+			return false, nil
+		}
+		go func() {
+			defer closed()
+			defer conn.Close()
+			buf := make([]byte, 8)
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				return
+			}
+			_, _ = conn.Write(buf)
+		}()
+		return true, conn
+	})
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c.Write([]byte("data")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(buf) != "pre:data" {
+		t.Fatalf("handler saw %q, want %q", buf, "pre:data")
+	}
+}
+
+func TestConnPreWrapErrorDropsConn(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := &memLogger{}
+	var s netx.Server[string]
+	s.Logger = logger
+	s.ConnPreWrap = func(c net.Conn) (net.Conn, error) {
+		return nil, errors.New("bad preamble")
+	}
+	defer s.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() { _ = s.Serve(ctx, ln) }()
+
+	called := make(chan struct{}, 1)
+	s.SetRoute("id", func(_ context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+		called <- struct{}{}
+		return true, conn
+	})
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("expected conn to be closed after failed pre-wrap")
+	}
+	select {
+	case <-called:
+		t.Fatalf("handler must not see a conn whose pre-wrap failed")
+	default:
+	}
+	// the conn is closed right before logging, so allow the log a moment to land
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		logger.mu.Lock()
+		entries := append([]string(nil), logger.entries...)
+		logger.mu.Unlock()
+		for _, e := range entries {
+			if e == "WARN: error wrapping connection, dropping connection" {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected pre-wrap failure to be logged")
+}
+
+func TestServeConn(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var s netx.Server[string]
+	s.Logger = &memLogger{}
+
+	s.SetRoute("other", func(_ context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+		return false, nil
+	})
+	handled := make(chan struct{})
+	s.SetRoute("id", func(_ context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+		close(handled)
+		return true, conn
+	})
+
+	conn, remote := net.Pipe()
+	defer remote.Close()
+	if err := s.ServeConn(ctx, conn); err != nil {
+		t.Fatalf("serve conn: %v", err)
+	}
+	select {
+	case <-handled:
+	default:
+		t.Fatal("matching handler did not run")
+	}
+
+	// the conn is tracked, so closing the server closes it
+	if err := s.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	_ = remote.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := remote.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected conn closed by server Close, got %v", err)
+	}
+
+	// a closed server rejects further conns
+	conn2, remote2 := net.Pipe()
+	defer remote2.Close()
+	if err := s.ServeConn(ctx, conn2); !errors.Is(err, netx.ErrServerClosed) {
+		t.Fatalf("want ErrServerClosed, got %v", err)
+	}
+}
+
+func TestMatchCacheTriesCachedRouteFirst(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var s netx.Server[string]
+	s.Logger = &memLogger{}
+	s.MatchCacheTTL = 100 * time.Millisecond
+	defer s.Close()
+
+	var mu sync.Mutex
+	var calls []string
+	record := func(id string, match bool) netx.Handler {
+		return func(_ context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+			mu.Lock()
+			calls = append(calls, id)
+			mu.Unlock()
+			return match, conn
+		}
+	}
+	s.SetRoute("expensive", record("expensive", false))
+	s.SetRoute("winner", record("winner", true))
+
+	serve := func() []string {
+		mu.Lock()
+		calls = nil
+		mu.Unlock()
+		conn, remote := net.Pipe() // all pipes share the remote addr "pipe"
+		t.Cleanup(func() { _ = remote.Close() })
+		if err := s.ServeConn(ctx, conn); err != nil {
+			t.Fatalf("serve conn: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), calls...)
+	}
+
+	if got := serve(); len(got) != 2 || got[0] != "expensive" || got[1] != "winner" {
+		t.Fatalf("first conn calls = %v, want full matching", got)
+	}
+	if got := serve(); len(got) != 1 || got[0] != "winner" {
+		t.Fatalf("second conn calls = %v, want cached route only", got)
+	}
+
+	// once the entry expires, matching starts over
+	time.Sleep(150 * time.Millisecond)
+	if got := serve(); len(got) != 2 {
+		t.Fatalf("conn after expiry calls = %v, want full matching", got)
+	}
+
+	// a cached route that no longer matches falls back to the others
+	s.SetRoute("winner", record("winner", false))
+	s.SetRoute("fallback", record("fallback", true))
+	if got := serve(); len(got) != 3 || got[0] != "winner" || got[2] != "fallback" {
+		t.Fatalf("stale cache calls = %v, want cached route then full matching", got)
+	}
+}
+
+func TestMatchedRoute(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var s netx.Server[string]
+	s.Logger = &memLogger{}
+	defer s.Close()
+
+	var mu sync.Mutex
+	var calls []string
+	route := func(id, network string) {
+		s.SetMatchedRoute(id, func(conn net.Conn) bool {
+			mu.Lock()
+			calls = append(calls, "match "+id)
+			mu.Unlock()
+			return conn.LocalAddr().Network() == network
+		}, func(_ context.Context, conn net.Conn, closed func()) io.Closer {
+			mu.Lock()
+			calls = append(calls, "handle "+id)
+			mu.Unlock()
+			go func() {
+				defer closed()
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+			return conn
+		})
+	}
+	route("tcp", "tcp")
+	route("pipe", "pipe")
+
+	conn, remote := net.Pipe()
+	defer remote.Close()
+	if err := s.ServeConn(ctx, conn); err != nil {
+		t.Fatalf("serve conn: %v", err)
+	}
+	mu.Lock()
+	got := strings.Join(calls, ", ")
+	mu.Unlock()
+	if want := "match tcp, match pipe, handle pipe"; got != want {
+		t.Fatalf("calls = %q, want %q", got, want)
+	}
+}
+
+func TestRouteLimitRefusesExcessMatches(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var s netx.Server[string]
+	s.Logger = &memLogger{}
+	defer s.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() { _ = s.Serve(ctx, ln) }()
+
+	// Both routes match everything and hold the conn until the client closes it
+	matched := make(chan string, 8)
+	hold := func(name string) netx.Handler {
+		return func(_ context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+			matched <- name
+			go func() {
+				defer closed()
+				defer conn.Close()
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+			return true, conn
+		}
+	}
+	s.SetRoute("limited", hold("limited")).SetLimit(netx.RouteLimit{Max: 2})
+	s.SetRoute("other", hold("other"))
+
+	dial := func() (net.Conn, string) {
+		t.Helper()
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		select {
+		case got := <-matched:
+			return c, got
+		case <-time.After(2 * time.Second):
+			t.Fatal("conn was not matched")
+			return nil, ""
+		}
+	}
+	var conns []net.Conn
+	defer func() {
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	}()
+	for i, want := range []string{"limited", "limited", "other"} {
+		c, got := dial()
+		conns = append(conns, c)
+		if got != want {
+			t.Fatalf("conn %d matched route %q, want %q", i, got, want)
+		}
+	}
+
+	// closing a conn of the limited route frees its slot
+	_ = conns[0].Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c, got := dial()
+		conns = append(conns, c)
+		if got == "limited" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("limited route slot was not released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServeAcceptFromChannel(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var s netx.Server[string]
+	s.Logger = &memLogger{}
+
+	s.SetRoute("echo", func(_ context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+		go func() {
+			defer closed()
+			defer conn.Close()
+			_, _ = io.Copy(conn, conn)
+		}()
+		return true, conn
+	})
+
+	conns := make(chan net.Conn)
+	accept := func() (net.Conn, error) {
+		c, ok := <-conns
+		if !ok {
+			return nil, net.ErrClosed
+		}
+		return c, nil
+	}
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.ServeAccept(ctx, accept) }()
+
+	conn, remote := net.Pipe()
+	defer remote.Close()
+	conns <- conn
+	_ = remote.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := remote.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(remote, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo = %q, %v", buf, err)
+	}
+
+	// Close stops ServeAccept even though accept is blocked on the channel, and closes tracked conns
+	if err := s.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, netx.ErrServerClosed) {
+			t.Fatalf("serve accept returned %v, want ErrServerClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("serve accept did not exit after Close")
+	}
+	if _, err := remote.Read(buf); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected conn closed by server Close, got %v", err)
+	}
+
+	// an exhausted source ends ServeAccept with net.ErrClosed
+	var s2 netx.Server[string]
+	s2.Logger = &memLogger{}
+	defer s2.Close()
+	done := make(chan net.Conn)
+	close(done)
+	if err := s2.ServeAccept(ctx, func() (net.Conn, error) {
+		if _, ok := <-done; !ok {
+			return nil, net.ErrClosed
+		}
+		return nil, nil
+	}); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("serve accept returned %v, want net.ErrClosed", err)
+	}
+}
+
+func TestReadySignal(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var s netx.Server[string]
+	s.Logger = &memLogger{}
+	defer s.Close()
+
+	ready := s.Ready()
+	select {
+	case <-ready:
+		t.Fatal("server ready before serving")
+	default:
+	}
+
+	handled := make(chan struct{})
+	s.SetRoute("id", func(_ context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+		close(handled)
+		_ = conn.Close()
+		go closed()
+		return true, conn
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() { _ = s.Serve(ctx, ln) }()
+
+	select {
+	case <-ready:
+	case <-time.After(2 * time.Second):
+		t.Fatal("server did not become ready")
+	}
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	select {
+	case <-handled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("conn was not handled")
+	}
+}
+
+func TestCloseConnClosesOnlyKeyedConn(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var s netx.Server[string]
+	s.Logger = &memLogger{}
+	defer s.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() { _ = s.Serve(ctx, ln) }()
+
+	// the first byte sent by a client is its key, the rest is echoed
+	keyed := make(chan string, 2)
+	s.SetRoute("echo", func(ctx context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+		b := make([]byte, 1)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return false, nil
+		}
+		if !netx.SetConnKey(ctx, string(b)) {
+			t.Error("handler context does not accept a conn key")
+		}
+		keyed <- string(b)
+		go func() {
+			defer closed()
+			defer conn.Close()
+			_, _ = io.Copy(conn, conn)
+		}()
+		return true, conn
+	})
+
+	dial := func(key string) net.Conn {
+		t.Helper()
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		if _, err := c.Write([]byte(key)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		select {
+		case <-keyed:
+		case <-time.After(2 * time.Second):
+			t.Fatal("conn was not keyed")
+		}
+		return c
+	}
+	a, b := dial("a"), dial("b")
+	defer a.Close()
+	defer b.Close()
+
+	if !s.CloseConn("a") {
+		t.Fatal("CloseConn did not find conn a")
+	}
+	if s.CloseConn("a") {
+		t.Fatal("CloseConn found conn a twice")
+	}
+	_ = a.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := a.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected conn a to be closed, got %v", err)
+	}
+
+	if _, err := b.Write([]byte("x")); err != nil {
+		t.Fatalf("write b: %v", err)
+	}
+	_ = b.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1)
+	if _, err := io.ReadFull(b, buf); err != nil || buf[0] != 'x' {
+		t.Fatalf("expected conn b to keep working, got %q, %v", buf, err)
+	}
+}
+
+func TestAccessLogRecordsRouteAndBytes(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	entries := make(chan netx.AccessLogEntry[string], 1)
+	s := netx.Server[string]{
+		Logger:    &memLogger{},
+		AccessLog: func(e netx.AccessLogEntry[string]) { entries <- e },
+	}
+	defer s.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() { _ = s.Serve(ctx, ln) }()
+
+	const hold = 50 * time.Millisecond
+	s.SetRoute("echo", func(_ context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+		go func() {
+			defer closed()
+			defer conn.Close()
+			buf := make([]byte, 5)
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				return
+			}
+			time.Sleep(hold)
+			_, _ = conn.Write(append(buf, buf...))
+		}()
+		return true, conn
+	})
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := io.ReadFull(c, make([]byte, 10)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	select {
+	case e := <-entries:
+		if e.Route != "echo" {
+			t.Fatalf("route %q, want echo", e.Route)
+		}
+		if e.RemoteAddr.String() != c.LocalAddr().String() {
+			t.Fatalf("remote addr %v, want %v", e.RemoteAddr, c.LocalAddr())
+		}
+		if d := e.End.Sub(e.Start); d < hold || d > 5*time.Second {
+			t.Fatalf("implausible duration %v", d)
+		}
+		if e.BytesIn != 5 || e.BytesOut != 10 {
+			t.Fatalf("counted %d bytes in and %d out, want 5 and 10", e.BytesIn, e.BytesOut)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no access log entry")
+	}
+}
+
+func TestHandlerContextCanceledOnForceClose(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var s netx.Server[string]
+	s.Logger = &memLogger{}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() { _ = s.Serve(ctx, ln) }()
+
+	connCtxs := make(chan context.Context, 1)
+	s.SetRoute("hold", func(connCtx context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+		connCtxs <- connCtx
+		go func() {
+			defer closed()
+			<-connCtx.Done() // a goroutine tied to the conn that only stops on cancellation
+		}()
+		return true, conn
+	})
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	var connCtx context.Context
+	select {
+	case connCtx = <-connCtxs:
+	case <-time.After(2 * time.Second):
+		t.Fatal("conn was not handled")
+	}
+	if connCtx.Err() != nil {
+		t.Fatal("handler context canceled before the conn was closed")
+	}
+
+	_ = s.Close()
+	select {
+	case <-connCtx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler context not canceled on force-close")
+	}
+	if !errors.Is(context.Cause(connCtx), netx.ErrServerClosed) {
+		t.Fatalf("cause %v, want ErrServerClosed", context.Cause(connCtx))
+	}
+}
+
+func TestStopAcceptingKeepsListenerAndConns(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var s netx.Server[string]
+	s.Logger = &memLogger{}
+	defer s.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ctx, ln) }()
+	<-s.Ready()
+
+	s.SetRoute("echo", func(_ context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+		go func() {
+			defer closed()
+			_, _ = io.Copy(conn, conn)
+		}()
+		return true, conn
+	})
+	echo := func(c net.Conn, msg string) {
+		t.Helper()
+		_ = c.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := c.Write([]byte(msg)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		buf := make([]byte, len(msg))
+		if _, err := io.ReadFull(c, buf); err != nil || string(buf) != msg {
+			t.Fatalf("echo got %q, %v; want %q", buf, err, msg)
+		}
+	}
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	echo(c, "before")
+
+	if ls := s.Listeners(); len(ls) != 1 || ls[0] != ln {
+		t.Fatalf("Listeners() = %v, want the served listener", ls)
+	}
+	if ls := s.StopAccepting(); len(ls) != 1 || ls[0] != ln {
+		t.Fatalf("StopAccepting() = %v, want the served listener", ls)
+	}
+	select {
+	case err := <-served:
+		if !errors.Is(err, netx.ErrStoppedAccepting) {
+			t.Fatalf("Serve returned %v, want ErrStoppedAccepting", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after StopAccepting")
+	}
+	if err := s.Serve(ctx, ln); !errors.Is(err, netx.ErrStoppedAccepting) {
+		t.Fatalf("Serve after StopAccepting returned %v, want ErrStoppedAccepting", err)
+	}
+	echo(c, "after")
+
+	// the socket stays open and can be inherited through its file descriptor
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("file: %v", err)
+	}
+	defer f.Close()
+	inherited, err := net.FileListener(f)
+	if err != nil {
+		t.Fatalf("file listener: %v", err)
+	}
+	defer inherited.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := inherited.Accept()
+		if err != nil {
+			t.Errorf("accept on inherited listener: %v", err)
+		}
+		accepted <- conn
+	}()
+	c2, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial after handover: %v", err)
+	}
+	defer c2.Close()
+	select {
+	case conn := <-accepted:
+		if conn != nil {
+			_ = conn.Close()
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("inherited listener did not accept")
+	}
+	echo(c, "still served")
+}
+
+func TestMinReadRateCutsOffDribblingClient(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var s netx.Server[string]
+	s.Logger = &memLogger{}
+	s.MinReadRate = 100 // bytes per second
+	s.MinReadRateWindow = 300 * time.Millisecond
+	defer s.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() { _ = s.Serve(ctx, ln) }()
+
+	// an HTTP-ish handler reading a request terminated by an empty line
+	handlerDone := make(chan context.Context, 2)
+	s.SetRoute("req", func(connCtx context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+		go func() {
+			defer closed()
+			defer func() { handlerDone <- connCtx }()
+			r := bufio.NewReader(conn)
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == "\n" {
+					_, _ = conn.Write([]byte("ok"))
+					return
+				}
+			}
+		}()
+		return true, conn
+	})
+
+	// a client sending its request at once is served
+	fast, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer fast.Close()
+	_ = fast.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := fast.Write([]byte("GET / HTTP/1.1\nHost: example.com\n\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(fast, buf); err != nil || string(buf) != "ok" {
+		t.Fatalf("fast client got %q, %v", buf, err)
+	}
+	<-handlerDone
+	// and stays open past the window, as only the first window is checked
+	time.Sleep(400 * time.Millisecond)
+
+	// a client dribbling a byte every 50ms stays below the rate and is cut off
+	slow, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer slow.Close()
+	go func() {
+		for _, b := range []byte("GET / HTTP/1.1\nHost: example.com\n\n") {
+			if _, err := slow.Write([]byte{b}); err != nil {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+	start := time.Now()
+	_ = slow.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = slow.Read(buf)
+	var ne net.Error
+	if err == nil || (errors.As(err, &ne) && ne.Timeout()) {
+		t.Fatalf("want the slow conn closed by the server, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Fatalf("slow conn closed too early after %v", elapsed)
+	}
+	select {
+	case connCtx := <-handlerDone:
+		if connCtx.Err() == nil {
+			t.Fatal("handler context not canceled for the cut off conn")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler did not stop after the conn was cut off")
+	}
+}
+
+func TestPrefixRouteLongestMatch(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var s netx.Server[string]
+	s.Logger = &memLogger{}
+	defer s.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() { _ = s.Serve(ctx, ln) }()
+
+	// every handler answers with its name and the first 4 bytes it reads
+	reply := func(name string) netx.Handler {
+		return func(_ context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+			go func() {
+				defer closed()
+				defer conn.Close()
+				buf := make([]byte, 4)
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					return
+				}
+				_, _ = conn.Write(append([]byte(name+":"), buf...))
+			}()
+			return true, conn
+		}
+	}
+	s.SetPrefixRoute("short", []byte{0x16, 0x03}, reply("short"))
+	s.SetPrefixRoute("long", []byte{0x16, 0x03, 0x01}, reply("long"))
+	s.SetPrefixRoute("declines", []byte("ab"), func(context.Context, net.Conn, func()) (bool, io.Closer) {
+		return false, nil
+	})
+	s.SetRoute("other", reply("other"))
+
+	for _, tc := range []struct {
+		send string
+		want string
+	}{
+		{"\x16\x03\x01X", "long:\x16\x03\x01X"},
+		{"\x16\x03\x02X", "short:\x16\x03\x02X"},
+		{"\x16\x04YZ", "other:\x16\x04YZ"},
+		{"abcd", "other:abcd"}, // the prefix route declines, the peeked bytes reach the fallback
+	} {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		_ = c.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := io.WriteString(c, tc.send); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		got, err := io.ReadAll(c)
+		_ = c.Close()
+		if err != nil {
+			t.Fatalf("read %q: %v", tc.send, err)
+		}
+		if string(got) != tc.want {
+			t.Fatalf("sent %q, got %q, want %q", tc.send, got, tc.want)
+		}
+	}
+}
+
+func TestPrefixRouteServerSpeaksFirst(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var s netx.Server[string]
+	s.Logger = &memLogger{}
+	defer s.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() { _ = s.Serve(ctx, ln) }()
+
+	s.SetPrefixRoute("tls", []byte{0x16, 0x03}, func(context.Context, net.Conn, func()) (bool, io.Closer) {
+		return false, nil
+	})
+	// the plain route greets first, like an SSH or SMTP server
+	s.SetRoute("banner", func(_ context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+		go func() {
+			defer closed()
+			defer conn.Close()
+			_, _ = io.WriteString(conn, "SSH-2.0-test\r\n")
+		}()
+		return true, conn
+	})
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	start := time.Now()
+	_ = c.SetDeadline(start.Add(2 * time.Second))
+	got, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatalf("read banner: %v", err)
+	}
+	if string(got) != "SSH-2.0-test\r\n" {
+		t.Fatalf("got %q, want the banner", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("banner took %v", elapsed)
+	}
+}
+
+func TestConnByteLimitClosesConn(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var s netx.Server[string]
+	s.Logger = &memLogger{}
+	s.MaxConnBytesIn = 16
+	defer s.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() { _ = s.Serve(ctx, ln) }()
+
+	handlerErr := make(chan error, 1)
+	s.SetRoute("echo", func(_ context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+		go func() {
+			defer closed()
+			buf := make([]byte, 64)
+			for {
+				n, err := conn.Read(buf)
+				if err != nil {
+					handlerErr <- err
+					return
+				}
+				if _, err := conn.Write(buf[:n]); err != nil {
+					handlerErr <- err
+					return
+				}
+			}
+		}()
+		return true, conn
+	})
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 10)
+	if _, err := c.Write([]byte("0123456789")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "0123456789" {
+		t.Fatalf("echo within the limit: %q, %v", buf, err)
+	}
+
+	// the next write takes the conn past 16 bytes
+	if _, err := c.Write([]byte("0123456789")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	select {
+	case err := <-handlerErr:
+		if !errors.Is(err, netx.ErrConnByteLimit) {
+			t.Fatalf("handler got %v, want ErrConnByteLimit", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("conn over the limit was not closed")
+	}
+	var ne net.Error
+	if _, err := c.Read(buf); err == nil || (errors.As(err, &ne) && ne.Timeout()) {
+		t.Fatalf("want the conn closed by the server, got %v", err)
+	}
+}
+
+func TestStartAndWait(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var s netx.Server[string]
+	s.Logger = &memLogger{}
+	defer s.Close()
+
+	handled := make(chan struct{})
+	s.SetRoute("id", func(_ context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+		close(handled)
+		_ = conn.Close()
+		go closed()
+		return true, conn
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	start := time.Now()
+	if err := s.Start(ctx, ln); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Start took %v", elapsed)
+	}
+	select {
+	case <-s.Ready():
+	default:
+		t.Fatal("server not ready after Start returned")
+	}
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	select {
+	case <-handled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection not handled")
+	}
+
+	waited := make(chan error, 1)
+	go func() { waited <- s.Wait() }()
+	select {
+	case err := <-waited:
+		t.Fatalf("Wait returned %v while serving", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	_ = s.Close()
+	select {
+	case err := <-waited:
+		if !errors.Is(err, netx.ErrServerClosed) {
+			t.Fatalf("Wait = %v, want ErrServerClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Wait did not return after Close")
+	}
+	if err := s.Start(ctx, ln); !errors.Is(err, netx.ErrServerClosed) {
+		t.Fatalf("Start on a closed server = %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package netx
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// tlsHandshakeTimeout bounds the handshake of connections the server terminates TLS for.
+const tlsHandshakeTimeout = 10 * time.Second
+
+type tlsStateCtxKey struct{}
+
+// TLSState returns the state of the TLS connection the server terminated, e.g. the negotiated ALPN protocol
+// and the SNI server name, see Server.TLSConfig. ctx must be the context passed to the Handler.
+// It returns false if the server does not terminate TLS.
+func TLSState(ctx context.Context) (tls.ConnectionState, bool) {
+	state, ok := ctx.Value(tlsStateCtxKey{}).(tls.ConnectionState)
+	return state, ok
+}
+
+// ServeTLS is like Serve, but terminates TLS for the connections of listener with config instead of
+// TLSConfig, e.g. to present a different certificate per port. Connections are handled as described for
+// TLSConfig, and other listeners keep using TLSConfig, if any.
+func (s *Server[ID]) ServeTLS(ctx context.Context, listener net.Listener, config *tls.Config) error {
+	if config == nil {
+		return errors.New("netx: ServeTLS requires a TLS config")
+	}
+	if s.Logger == nil {
+		s.Logger = slog.Default()
+	}
+
+	if err := s.addListener(listener); err != nil {
+		return err
+	}
+	return s.acceptLoop(ctx, listener, config)
+}
+
+// terminateTLS completes the TLS handshake of conn with config and returns the plaintext conn along with a
+// context carrying its state. It closes conn and returns false if the handshake fails.
+func (s *Server[ID]) terminateTLS(ctx context.Context, conn net.Conn, config *tls.Config) (context.Context, net.Conn, bool) {
+	tc := tls.Server(conn, config)
+	if err := Handshake(conn, tlsHandshakeTimeout, tc.HandshakeContext); err != nil {
+		_ = conn.Close()
+		s.Logger.WarnContext(ctx, "tls handshake failed, dropping connection", "addr", conn.RemoteAddr().String(), "error", err)
+		return ctx, nil, false
+	}
+	return context.WithValue(ctx, tlsStateCtxKey{}, tc.ConnectionState()), tc, true
+}
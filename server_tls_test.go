@@ -0,0 +1,153 @@
+package netx_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pedramktb/go-netx"
+)
+
+func TestServerTLSTerminationRoutesByALPN(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	logger := &memLogger{}
+	var s netx.Server[string]
+	s.Logger = logger
+	s.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{mustSelfSignedCert(t)},
+		NextProtos:   []string{"upper", "echo"},
+	}
+	defer s.Close()
+
+	route := func(proto string, reply func(string, tls.ConnectionState) string) {
+		s.SetRoute(proto, func(ctx context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+			state, ok := netx.TLSState(ctx)
+			if !ok || state.NegotiatedProtocol != proto {
+				return false, nil
+			}
+			if _, isTLS := conn.(*tls.Conn); !isTLS {
+				t.Errorf("handler got a %T, want the terminated *tls.Conn", conn)
+			}
+			go func() {
+				defer closed()
+				defer conn.Close()
+				buf := make([]byte, 64)
+				n, err := conn.Read(buf)
+				if err != nil {
+					return
+				}
+				_, _ = conn.Write([]byte(reply(string(buf[:n]), state)))
+			}()
+			return true, conn
+		})
+	}
+	route("upper", func(msg string, _ tls.ConnectionState) string { return "UPPER " + msg })
+	route("echo", func(msg string, state tls.ConnectionState) string { return state.ServerName + " " + msg })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	if err := s.Start(ctx, ln); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	for _, tc := range []struct{ proto, want string }{
+		{"echo", "example.com hi"},
+		{"upper", "UPPER hi"},
+	} {
+		c, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+			InsecureSkipVerify: true,
+			ServerName:         "example.com",
+			NextProtos:         []string{tc.proto},
+		})
+		if err != nil {
+			t.Fatalf("dial %s: %v", tc.proto, err)
+		}
+		_ = c.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := c.Write([]byte("hi")); err != nil {
+			t.Fatalf("write %s: %v", tc.proto, err)
+		}
+		got, err := io.ReadAll(c)
+		_ = c.Close()
+		if err != nil || string(got) != tc.want {
+			t.Fatalf("%s route replied %q, %v, want %q", tc.proto, got, err, tc.want)
+		}
+	}
+
+	// a client that does not speak TLS is dropped before any route sees it
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	_, _ = c.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		logger.mu.Lock()
+		entries := append([]string(nil), logger.entries...)
+		logger.mu.Unlock()
+		for _, e := range entries {
+			if e == "WARN: tls handshake failed, dropping connection" {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected the failed handshake to be logged")
+}
+
+func TestServerServeTLSPerListener(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var s netx.Server[string]
+	s.Logger = &memLogger{}
+	defer s.Close()
+	s.SetRoute("echo", func(_ context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+		go func() {
+			defer closed()
+			defer conn.Close()
+			_, _ = io.Copy(conn, conn)
+		}()
+		return true, conn
+	})
+
+	if err := s.ServeTLS(ctx, nil, nil); err == nil {
+		t.Fatal("expected ServeTLS to fail without a config")
+	}
+
+	certs := []tls.Certificate{mustSelfSignedCert(t), mustSelfSignedCert(t)}
+	addrs := make([]string, len(certs))
+	for i, cert := range certs {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		addrs[i] = ln.Addr().String()
+		go func() { _ = s.ServeTLS(ctx, ln, &tls.Config{Certificates: []tls.Certificate{cert}}) }()
+	}
+
+	for i, addr := range addrs {
+		c, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("dial listener %d: %v", i, err)
+		}
+		_ = c.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := c.Write([]byte("hi")); err != nil {
+			t.Fatalf("write listener %d: %v", i, err)
+		}
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "hi" {
+			t.Fatalf("listener %d echoed %q, %v", i, buf, err)
+		}
+		if got := c.ConnectionState().PeerCertificates[0].Raw; !bytes.Equal(got, certs[i].Certificate[0]) {
+			t.Fatalf("listener %d presented the wrong certificate", i)
+		}
+		_ = c.Close()
+	}
+}
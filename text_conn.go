@@ -0,0 +1,246 @@
+/*
+TextConn is a network layer for channels that only pass printable text, like chat bot relays or mail.
+Every write is encoded as base64 or hex and followed by a delimiter, so packet boundaries are preserved
+without an additional frame layer. Reads buffer the incoming text up to the next delimiter and decode it.
+Both peers must use the same encoding and delimiter.
+*/
+
+package netx
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+func init() {
+	Register("textenc", func(params map[string]string, listener bool) (Wrapper, error) {
+		enc := TextBase64
+		delim := "\n"
+		for key, value := range params {
+			switch key {
+			case "encoding":
+				var err error
+				if enc, err = ParseTextEncoding(value); err != nil {
+					return Wrapper{}, fmt.Errorf("textenc: %w", err)
+				}
+			case "delim":
+				// allow escapes like \n or \r\n, which cannot be written literally in a URI
+				d, err := strconv.Unquote(`"` + value + `"`)
+				if err != nil {
+					return Wrapper{}, fmt.Errorf("textenc: invalid delim parameter %q: %w", value, err)
+				}
+				delim = d
+			default:
+				return Wrapper{}, fmt.Errorf("uri: unknown textenc parameter %q", key)
+			}
+		}
+		if err := enc.checkDelim(delim); err != nil {
+			return Wrapper{}, fmt.Errorf("textenc: %w", err)
+		}
+		connToConn := func(c net.Conn) (net.Conn, error) {
+			return NewTextConn(c, enc, delim)
+		}
+		return Wrapper{
+			Name:   "textenc",
+			Params: params,
+			ListenerToListener: func(l net.Listener) (net.Listener, error) {
+				return ConnWrapListener(l, connToConn)
+			},
+			DialerToDialer: func(f Dialer) (Dialer, error) {
+				return ConnWrapDialer(f, connToConn)
+			},
+			ConnToConn: connToConn,
+		}, nil
+	})
+}
+
+// TextEncoding is the encoding a TextConn uses for the bytes of every packet.
+type TextEncoding int
+
+const (
+	TextBase64 TextEncoding = iota // standard base64 with padding
+	TextHex                        // lowercase hex
+)
+
+func (e TextEncoding) String() string {
+	switch e {
+	case TextBase64:
+		return "base64"
+	case TextHex:
+		return "hex"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseTextEncoding parses an encoding name as used by the textenc driver.
+func ParseTextEncoding(s string) (TextEncoding, error) {
+	switch strings.ToLower(s) {
+	case "base64":
+		return TextBase64, nil
+	case "hex":
+		return TextHex, nil
+	default:
+		return 0, fmt.Errorf("unknown encoding %q", s)
+	}
+}
+
+func (e TextEncoding) alphabet() string {
+	switch e {
+	case TextHex:
+		return "0123456789abcdefABCDEF"
+	default:
+		return "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/="
+	}
+}
+
+// checkDelim rejects delimiters that could appear inside encoded data.
+func (e TextEncoding) checkDelim(delim string) error {
+	if e != TextBase64 && e != TextHex {
+		return fmt.Errorf("unknown encoding %d", e)
+	}
+	if delim == "" {
+		return errors.New("empty delimiter")
+	}
+	if strings.ContainsAny(delim, e.alphabet()) {
+		return fmt.Errorf("delimiter %q overlaps the %s alphabet", delim, e)
+	}
+	return nil
+}
+
+// encodedLen returns the length of the encoding of n bytes.
+func (e TextEncoding) encodedLen(n int) int {
+	if e == TextHex {
+		return hex.EncodedLen(n)
+	}
+	return base64.StdEncoding.EncodedLen(n)
+}
+
+// maxDecodedLen returns the largest packet size whose encoding fits in n bytes.
+func (e TextEncoding) maxDecodedLen(n int) uint16 {
+	if n <= 0 {
+		return 0
+	}
+	if e == TextHex {
+		return uint16(n / 2)
+	}
+	return uint16(n / 4 * 3)
+}
+
+func (e TextEncoding) encode(dst, p []byte) []byte {
+	if e == TextHex {
+		return hex.AppendEncode(dst, p)
+	}
+	return base64.StdEncoding.AppendEncode(dst, p)
+}
+
+func (e TextEncoding) decode(dst, p []byte) ([]byte, error) {
+	if e == TextHex {
+		return hex.AppendDecode(dst, p)
+	}
+	return base64.StdEncoding.AppendDecode(dst, p)
+}
+
+// ErrTextLineTooLong is returned by the reads of a TextConn when a line is longer than the encoding of a
+// MaxPacketSize packet and its delimiter, so a peer cannot make it buffer without bound.
+var ErrTextLineTooLong = errors.New("textenc: line exceeds the encoded length of MaxPacketSize")
+
+type textConn struct {
+	net.Conn
+	enc      TextEncoding
+	delim    []byte
+	br       *bufio.Reader
+	line     []byte // encoded packet being read
+	maxLine  int    // longest accepted line, delimiter included
+	decoded  []byte
+	pending  []byte
+	wbuf     []byte
+	maxWrite uint16
+	rmu, wmu sync.Mutex
+}
+
+// NewTextConn wraps c so every write is sent as one line of text in the given encoding, terminated by delim.
+// The delimiter must not contain characters of the encoding's alphabet.
+func NewTextConn(c net.Conn, enc TextEncoding, delim string) (net.Conn, error) {
+	if err := enc.checkDelim(delim); err != nil {
+		return nil, err
+	}
+	tc := &textConn{
+		Conn:    c,
+		enc:     enc,
+		delim:   []byte(delim),
+		br:      bufio.NewReader(c),
+		maxLine: enc.encodedLen(MaxPacketSize) + len(delim),
+	}
+	if mw, ok := c.(interface{ MaxWrite() uint16 }); ok && mw.MaxWrite() != 0 {
+		tc.maxWrite = enc.maxDecodedLen(int(mw.MaxWrite()) - len(delim))
+		if tc.maxWrite == 0 {
+			return nil, errors.New("textenc: underlying connection's MaxWrite is too small")
+		}
+	}
+	return tc, nil
+}
+
+// MaxWrite returns the largest packet whose encoded line fits the underlying connection's MaxWrite,
+// or 0 if it has no limit.
+func (c *textConn) MaxWrite() uint16 { return c.maxWrite }
+
+// Read returns at most one packet's bytes; large packets are delivered across multiple Reads.
+// Whitespace around an encoded packet is ignored, so channels adding line breaks are tolerated.
+// Lines longer than the encoding of MaxPacketSize bytes and the delimiter fail with ErrTextLineTooLong.
+func (c *textConn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+
+	last := c.delim[len(c.delim)-1]
+	c.line = c.line[:0]
+	for !bytes.HasSuffix(c.line, c.delim) {
+		chunk, err := c.br.ReadSlice(last)
+		c.line = append(c.line, chunk...)
+		if len(c.line) > c.maxLine {
+			return 0, ErrTextLineTooLong
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return 0, err
+		}
+	}
+	var err error
+	c.decoded, err = c.enc.decode(c.decoded[:0], bytes.TrimSpace(c.line[:len(c.line)-len(c.delim)]))
+	if err != nil {
+		return 0, fmt.Errorf("textenc: %w", err)
+	}
+	n := copy(p, c.decoded)
+	c.pending = c.decoded[n:]
+	return n, nil
+}
+
+// Write sends p as a single encoded packet followed by the delimiter.
+func (c *textConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.wbuf = append(c.enc.encode(c.wbuf[:0], p), c.delim...)
+	if _, err := c.Conn.Write(c.wbuf); err != nil {
+		return 0, err
+	}
+	if fw, ok := c.Conn.(BufConn); ok {
+		if err := fw.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
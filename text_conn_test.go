@@ -0,0 +1,179 @@
+package netx_test
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	netx "github.com/pedramktb/go-netx"
+)
+
+func TestTextConnRoundTripBinary(t *testing.T) {
+	t.Parallel()
+	packets := [][]byte{make([]byte, 256), []byte("second packet"), {0}}
+	for i := range packets[0] {
+		packets[0][i] = byte(i)
+	}
+	for _, tc := range []struct {
+		enc   netx.TextEncoding
+		delim string
+	}{
+		{netx.TextBase64, "\n"},
+		{netx.TextHex, "\r\n"},
+		{netx.TextBase64, "|~|"},
+	} {
+		t.Run(tc.enc.String(), func(t *testing.T) {
+			t.Parallel()
+			clientRaw, serverRaw := net.Pipe()
+			t.Cleanup(func() { _ = clientRaw.Close(); _ = serverRaw.Close() })
+			client, err := netx.NewTextConn(clientRaw, tc.enc, tc.delim)
+			if err != nil {
+				t.Fatalf("new client: %v", err)
+			}
+			// tee the wire text to check it is printable
+			tapRaw, tap := net.Pipe()
+			t.Cleanup(func() { _ = tapRaw.Close(); _ = tap.Close() })
+			wire := make(chan []byte, len(packets))
+			go func() {
+				buf := make([]byte, 4096)
+				for {
+					n, err := serverRaw.Read(buf)
+					if err != nil {
+						_ = tapRaw.Close()
+						return
+					}
+					wire <- bytes.Clone(buf[:n])
+					if _, err := tapRaw.Write(buf[:n]); err != nil {
+						return
+					}
+				}
+			}()
+			server, err := netx.NewTextConn(tap, tc.enc, tc.delim)
+			if err != nil {
+				t.Fatalf("new server: %v", err)
+			}
+
+			go func() {
+				for _, p := range packets {
+					if _, err := client.Write(p); err != nil {
+						return
+					}
+				}
+			}()
+			_ = server.SetReadDeadline(time.Now().Add(2 * time.Second))
+			buf := make([]byte, 1024)
+			for i, want := range packets {
+				n, err := server.Read(buf)
+				if err != nil {
+					t.Fatalf("read packet %d: %v", i, err)
+				}
+				if !bytes.Equal(buf[:n], want) {
+					t.Fatalf("packet %d = %x, want %x", i, buf[:n], want)
+				}
+				text := <-wire
+				for _, b := range text {
+					if (b < 0x20 || b > 0x7e) && !bytes.ContainsRune([]byte(tc.delim), rune(b)) {
+						t.Fatalf("non-printable byte %#x on the wire: %q", b, text)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTextConnRejectsAmbiguousDelim(t *testing.T) {
+	t.Parallel()
+	c, _ := net.Pipe()
+	defer c.Close()
+	if _, err := netx.NewTextConn(c, netx.TextHex, "a"); err == nil {
+		t.Fatalf("expected error for a delimiter inside the hex alphabet")
+	}
+	var w netx.Wrapper
+	if err := w.UnmarshalText([]byte(`textenc{encoding=hex,delim=\r\n}`), false); err != nil {
+		t.Fatalf("unmarshal escaped delimiter: %v", err)
+	}
+	if err := w.UnmarshalText([]byte("textenc{encoding=base64,delim==}"), false); err == nil {
+		t.Fatalf("expected error for a delimiter inside the base64 alphabet")
+	}
+}
+
+type limitedConn struct {
+	net.Conn
+	maxWrite uint16
+}
+
+func (c limitedConn) MaxWrite() uint16 { return c.maxWrite }
+
+func TestTextConnMaxWriteFitsEncodedLine(t *testing.T) {
+	t.Parallel()
+	c, _ := net.Pipe()
+	defer c.Close()
+	for _, tc := range []struct {
+		enc  netx.TextEncoding
+		want uint16
+	}{
+		{netx.TextBase64, 765}, // 1023 bytes hold 255 base64 blocks
+		{netx.TextHex, 511},
+	} {
+		tconn, err := netx.NewTextConn(limitedConn{c, 1024}, tc.enc, "\n")
+		if err != nil {
+			t.Fatalf("%s: %v", tc.enc, err)
+		}
+		if got := tconn.(interface{ MaxWrite() uint16 }).MaxWrite(); got != tc.want {
+			t.Fatalf("%s: MaxWrite %d, want %d", tc.enc, got, tc.want)
+		}
+	}
+	if _, err := netx.NewTextConn(limitedConn{c, 2}, netx.TextBase64, "\n"); err == nil {
+		t.Fatalf("expected error for a MaxWrite too small for one encoded byte")
+	}
+}
+
+func TestTextConnRejectsOverlongLine(t *testing.T) {
+	t.Parallel()
+	clientRaw, serverRaw := net.Pipe()
+	t.Cleanup(func() { _ = clientRaw.Close(); _ = serverRaw.Close() })
+	server, err := netx.NewTextConn(serverRaw, netx.TextHex, "\n")
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	// a peer that never sends the delimiter
+	go func() {
+		chunk := bytes.Repeat([]byte("ab"), 4096)
+		for {
+			if _, err := clientRaw.Write(chunk); err != nil {
+				return
+			}
+		}
+	}()
+	_ = server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := server.Read(make([]byte, 1024)); !errors.Is(err, netx.ErrTextLineTooLong) {
+		t.Fatalf("read: %v, want ErrTextLineTooLong", err)
+	}
+}
+
+func TestTextConnReadsMaxPacket(t *testing.T) {
+	t.Parallel()
+	clientRaw, serverRaw := net.Pipe()
+	t.Cleanup(func() { _ = clientRaw.Close(); _ = serverRaw.Close() })
+	client, err := netx.NewTextConn(clientRaw, netx.TextBase64, "\n")
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	server, err := netx.NewTextConn(serverRaw, netx.TextBase64, "\n")
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	want := bytes.Repeat([]byte{0xa5}, netx.MaxPacketSize)
+	go func() { _, _ = client.Write(want) }()
+	_ = server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	got := make([]byte, netx.MaxPacketSize)
+	n, err := server.Read(got)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(got[:n], want) {
+		t.Fatalf("read %d bytes, want the %d byte packet", n, len(want))
+	}
+}
@@ -6,51 +6,293 @@ import (
 	"io"
 	"log/slog"
 	"net"
+	"os"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // Tun is an endpoint of a tunnel connection between two net.Conns.
 // Conn is the underlying connection of the tunnel and Peer is the client/server communicating with the tunnel.
+// A Tun must not be copied after first use.
 type Tun struct {
-	Logger     Logger
-	Conn       net.Conn
-	Peer       net.Conn
+	Logger Logger
+	Conn   net.Conn
+	Peer   net.Conn
+	// PeerDial is an alternative to Peer. If Peer is nil, Relay calls PeerDial lazily once the first data
+	// arrives on Conn, so no peer connection is made for tunnels that close without sending anything.
+	PeerDial   func(ctx context.Context) (net.Conn, error)
 	BufferSize uint // BufferSize for io.Copy, default 32KB
-	closing    atomic.Bool
+	// StreamSide, if set, marks the side that is a byte stream, like TCP, while the other side is packet
+	// oriented, like UDP. Relay then frames every datagram read from the packet side on the stream side, as
+	// NewFrameConn does, and writes every frame read from the stream side as a single datagram, so datagram
+	// boundaries survive the stream. The buffers grow to MaxPacketSize, so datagrams are never truncated.
+	StreamSide TunSide
+	// ReadTimeout bounds every single read on either side; the deadline is refreshed before each read.
+	// WriteTimeout does the same for writes. Exceeding either tears the tunnel down. Zero means no timeout.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// MaxLifetime, if set, closes the tunnel once Relay has been running for that long, regardless of
+	// activity, e.g. to force clients to authenticate again. Zero means no limit.
+	MaxLifetime time.Duration
+	// OnClose, if set, is called once when Relay finishes. The error is nil for a clean close
+	// (EOF on either side or Close, including a forced close during server shutdown),
+	// the context error if ctx was canceled, ErrTunMaxLifetime if MaxLifetime was reached,
+	// or a *TunError telling which side failed.
+	OnClose func(error)
+	// ObserveConn and ObservePeer, if set, receive a copy of the data relayed from Conn and from Peer
+	// respectively, e.g. for debugging or an inline IDS. They are written by a goroutine each through a queue
+	// of tunObserveQueue chunks, so a slow observer does not slow the relay; chunks that do not fit in the
+	// queue are dropped. Once Relay finishes and the queued chunks are written, observers that implement
+	// io.Closer are closed, so they should be distinct.
+	ObserveConn io.Writer
+	ObservePeer io.Writer
+	closing     atomic.Bool
+	peerMu      sync.Mutex                    // guards Peer while it is dialed lazily
+	observers   [3]*tunObserver               // indexed by the TunSide the data is read from
+	relayed     [3]atomic.Uint64              // bytes relayed, indexed by the TunSide they are read from
+	pauseMu     sync.Mutex                    // serializes Pause and Resume
+	resume      atomic.Pointer[chan struct{}] // non-nil while paused, closed by Resume
+	pauses      atomic.Uint64                 // incremented by Pause, so timeouts of reads it spans can be ignored
 }
 
+// tunObserveQueue is the number of chunks queued for a Tun observer before further chunks are dropped.
+const tunObserveQueue = 64
+
+// tunObserver hands copies of relayed chunks to an observer without blocking the relay.
+type tunObserver struct {
+	w     io.Writer
+	queue chan []byte
+}
+
+func newTunObserver(w io.Writer) *tunObserver {
+	o := &tunObserver{w: w, queue: make(chan []byte, tunObserveQueue)}
+	go o.run()
+	return o
+}
+
+func (o *tunObserver) run() {
+	failed := false
+	for b := range o.queue {
+		if !failed {
+			_, err := o.w.Write(b)
+			failed = err != nil
+		}
+	}
+	if c, ok := o.w.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
+
+// observe queues a copy of b, or drops it if the queue is full. It is a no-op on a nil observer.
+func (o *tunObserver) observe(b []byte) {
+	if o == nil {
+		return
+	}
+	select {
+	case o.queue <- append([]byte(nil), b...):
+	default:
+	}
+}
+
+func (o *tunObserver) close() {
+	if o != nil {
+		close(o.queue)
+	}
+}
+
+// ErrTunMaxLifetime is passed to Tun.OnClose when a tunnel was closed for reaching its MaxLifetime.
+var ErrTunMaxLifetime = errors.New("tun: max lifetime reached")
+
+// TunSide identifies a side of a Tun.
+type TunSide int
+
+const (
+	TunSideConn TunSide = iota + 1 // the Conn side
+	TunSidePeer                    // the Peer side
+)
+
+func (s TunSide) String() string {
+	switch s {
+	case TunSideConn:
+		return "conn"
+	case TunSidePeer:
+		return "peer"
+	default:
+		return "unknown"
+	}
+}
+
+// TunError is an error that terminated a tunnel, attributed to the side it occurred on.
+type TunError struct {
+	Side TunSide
+	Err  error
+}
+
+func (e *TunError) Error() string {
+	return "tun: " + e.Side.String() + " side: " + e.Err.Error()
+}
+
+func (e *TunError) Unwrap() error { return e.Err }
+
 // Relay copies data between the two connections until either side encounters an error or is closed.
+// Canceling ctx closes the tunnel. When the relay finishes, OnClose is called with the reason.
 func (t *Tun) Relay(ctx context.Context) {
-	if t.Conn == nil || t.Peer == nil {
+	if t.Conn == nil || (t.Peer == nil && t.PeerDial == nil) {
 		return
 	}
 	if t.Logger == nil {
 		t.Logger = slog.Default()
 	}
+	stop := context.AfterFunc(ctx, func() { _ = t.Close() })
+	var expired atomic.Bool
+	if t.MaxLifetime > 0 {
+		timer := time.AfterFunc(t.MaxLifetime, func() {
+			if !t.closing.Load() {
+				expired.Store(true)
+				_ = t.Close()
+			}
+		})
+		defer timer.Stop()
+	}
+	if t.ObserveConn != nil {
+		t.observers[TunSideConn] = newTunObserver(t.ObserveConn)
+	}
+	if t.ObservePeer != nil {
+		t.observers[TunSidePeer] = newTunObserver(t.ObservePeer)
+	}
+	var err error
+	defer func() {
+		for _, o := range t.observers {
+			o.close()
+		}
+		// a force close by the server counts as a clean close, see Server.Close
+		if !stop() && err == nil && !errors.Is(context.Cause(ctx), ErrServerClosed) && !errors.Is(context.Cause(ctx), errConnForceClosed) {
+			err = ctx.Err()
+		}
+		if expired.Load() && err == nil {
+			err = ErrTunMaxLifetime
+		}
+		if t.OnClose != nil {
+			t.OnClose(err)
+		}
+	}()
+
+	conn, peer := t.framed(t.Conn, TunSideConn), t.Peer
+	if peer == nil {
+		if peer, err = t.dialPeer(ctx, conn); err != nil {
+			t.Logger.ErrorContext(ctx, "error dialing peer", "error", err)
+			return
+		}
+		if peer == nil {
+			return
+		}
+	} else {
+		peer = t.framed(peer, TunSidePeer)
+	}
 
 	sendErrCh := make(chan error, 1)
 	recvErrCh := make(chan error, 1)
 
-	go t.halfCopy(t.Peer, t.Conn, sendErrCh)
-	go t.halfCopy(t.Conn, t.Peer, recvErrCh)
+	go t.halfCopy(peer, conn, TunSidePeer, TunSideConn, sendErrCh)
+	go t.halfCopy(conn, peer, TunSideConn, TunSidePeer, recvErrCh)
 
 	sendErr := <-sendErrCh
 	recvErr := <-recvErrCh
 	if sendErr != nil {
 		t.Logger.ErrorContext(ctx, "error copying data from peer to tun", "error", sendErr)
+		err = sendErr
 	}
 	if recvErr != nil {
 		t.Logger.ErrorContext(ctx, "error copying data from tun to peer", "error", recvErr)
+		if err == nil {
+			err = recvErr
+		}
 	}
 }
 
-func (t *Tun) halfCopy(src io.ReadCloser, dst io.WriteCloser, errCh chan<- error) {
-	var buf []byte
-	if t.BufferSize != 0 {
-		buf = make([]byte, t.BufferSize)
+// framed returns c wrapped in a FrameConn if side is the StreamSide, see Tun.StreamSide.
+func (t *Tun) framed(c net.Conn, side TunSide) net.Conn {
+	if t.StreamSide != side {
+		return c
+	}
+	return NewFrameConn(c)
+}
+
+// bufferSize returns the size of the copy buffers, see Tun.BufferSize and Tun.StreamSide.
+func (t *Tun) bufferSize() uint {
+	size := t.BufferSize
+	if size == 0 {
+		size = 32 * 1024
+	}
+	if t.StreamSide != 0 {
+		size = max(size, MaxPacketSize)
+	}
+	return size
+}
+
+// dialPeer waits for the first data on conn, dials the peer and forwards that data to it, returning the peer
+// as it is relayed to. If conn is closed before sending anything, the tunnel is closed without dialing and the
+// peer is nil.
+func (t *Tun) dialPeer(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	buf := make([]byte, t.bufferSize())
+	if t.ReadTimeout > 0 {
+		if err := conn.SetReadDeadline(time.Now().Add(t.ReadTimeout)); err != nil {
+			_ = t.Close()
+			return nil, &TunError{Side: TunSideConn, Err: err}
+		}
+	}
+	n, err := conn.Read(buf)
+	if n == 0 {
+		closing := t.closing.Load()
+		_ = t.Close()
+		if err == io.EOF || closing {
+			return nil, nil
+		}
+		return nil, &TunError{Side: TunSideConn, Err: err}
 	}
+	peer, dErr := t.PeerDial(ctx)
+	if dErr != nil {
+		_ = t.Close()
+		return nil, &TunError{Side: TunSidePeer, Err: dErr}
+	}
+	t.peerMu.Lock()
+	if t.closing.Load() {
+		t.peerMu.Unlock()
+		_ = peer.Close()
+		return nil, nil
+	}
+	t.Peer = peer
+	t.peerMu.Unlock()
+	peer = t.framed(peer, TunSidePeer)
+	if t.WriteTimeout > 0 {
+		if wErr := peer.SetWriteDeadline(time.Now().Add(t.WriteTimeout)); wErr != nil {
+			_ = t.Close()
+			return nil, &TunError{Side: TunSidePeer, Err: wErr}
+		}
+	}
+	if _, wErr := peer.Write(buf[:n]); wErr != nil {
+		_ = t.Close()
+		return nil, &TunError{Side: TunSidePeer, Err: wErr}
+	}
+	t.relayed[TunSideConn].Add(uint64(n))
+	t.observers[TunSideConn].observe(buf[:n])
+	// a read error that came with the first data ends the tunnel after forwarding it
+	if err != nil {
+		closing := t.closing.Load()
+		_ = t.Close()
+		if err == io.EOF || closing {
+			return nil, nil
+		}
+		return nil, &TunError{Side: TunSideConn, Err: err}
+	}
+	return peer, nil
+}
+
+func (t *Tun) halfCopy(src net.Conn, dst net.Conn, srcSide, dstSide TunSide, errCh chan<- error) {
+	buf := make([]byte, t.bufferSize())
 	defer t.Close()
-	_, err := io.CopyBuffer(dst, src, buf)
+	err := t.copyConn(dst, src, buf, srcSide, dstSide)
 	if t.closing.Load() {
 		errCh <- nil
 		return
@@ -58,52 +300,227 @@ func (t *Tun) halfCopy(src io.ReadCloser, dst io.WriteCloser, errCh chan<- error
 	errCh <- err
 }
 
+// copyConn is like io.CopyBuffer but refreshes the read and write deadlines around each operation if set,
+// and attributes errors to the side they occurred on.
+func (t *Tun) copyConn(dst net.Conn, src net.Conn, buf []byte, srcSide, dstSide TunSide) error {
+	for {
+		t.waitResumed()
+		if t.ReadTimeout > 0 {
+			if err := src.SetReadDeadline(time.Now().Add(t.ReadTimeout)); err != nil {
+				return &TunError{Side: srcSide, Err: err}
+			}
+		}
+		pauses := t.pauses.Load()
+		n, rErr := src.Read(buf)
+		if n > 0 {
+			// data read while paused is held back until Resume
+			t.waitResumed()
+			if t.WriteTimeout > 0 {
+				if err := dst.SetWriteDeadline(time.Now().Add(t.WriteTimeout)); err != nil {
+					return &TunError{Side: dstSide, Err: err}
+				}
+			}
+			if _, wErr := dst.Write(buf[:n]); wErr != nil {
+				return &TunError{Side: dstSide, Err: wErr}
+			}
+			t.relayed[srcSide].Add(uint64(n))
+			t.observers[srcSide].observe(buf[:n])
+		}
+		if rErr != nil {
+			if rErr == io.EOF {
+				return nil
+			}
+			if errors.Is(rErr, os.ErrDeadlineExceeded) && (t.resume.Load() != nil || t.pauses.Load() != pauses) {
+				continue // ReadTimeout does not apply while paused
+			}
+			return &TunError{Side: srcSide, Err: rErr}
+		}
+	}
+}
+
+// Pause stops relaying in both directions without closing either side, until Resume is called. Reads in
+// progress are not interrupted, as that could cut a message of a layered conn in half; whatever they return is
+// held back and written on Resume. No further reads are started while paused, so incoming data backs up in the
+// transports: stream transports like TCP apply backpressure to the senders once their buffers are full, while
+// datagram transports drop what does not fit. ReadTimeout does not apply while paused. Closing the tunnel,
+// including by canceling the context of Relay or by a server shutdown, ends a pause. Pausing a paused tunnel
+// does nothing.
+func (t *Tun) Pause() {
+	t.pauseMu.Lock()
+	defer t.pauseMu.Unlock()
+	if t.resume.Load() != nil || t.closing.Load() {
+		return
+	}
+	resume := make(chan struct{})
+	t.resume.Store(&resume)
+	t.pauses.Add(1)
+}
+
+// Resume continues relaying after Pause. Resuming a tunnel that is not paused does nothing.
+func (t *Tun) Resume() {
+	t.pauseMu.Lock()
+	defer t.pauseMu.Unlock()
+	resume := t.resume.Load()
+	if resume == nil {
+		return
+	}
+	t.resume.Store(nil)
+	close(*resume)
+}
+
+// waitResumed blocks while the tunnel is paused.
+func (t *Tun) waitResumed() {
+	if resume := t.resume.Load(); resume != nil {
+		<-*resume
+	}
+}
+
 func (t *Tun) Close() error {
 	if !t.closing.CompareAndSwap(false, true) {
 		return nil
 	}
+	defer t.Resume() // let paused copy loops see the closed conns
 	connErr := t.Conn.Close()
 	if errors.Is(connErr, net.ErrClosed) {
 		connErr = nil
 	}
-	peerErr := t.Peer.Close()
+	t.peerMu.Lock()
+	peer := t.Peer
+	t.peerMu.Unlock()
+	if peer == nil {
+		return connErr
+	}
+	peerErr := peer.Close()
 	if errors.Is(peerErr, net.ErrClosed) {
 		peerErr = nil
 	}
 	return errors.Join(connErr, peerErr)
 }
 
-type TunHandler func(ctx context.Context, conn net.Conn) (matched bool, connCtx context.Context, tunnel Tun)
+// ActiveTunnels returns a snapshot of the tunnels that are currently relaying.
+func (m *TunMaster[ID]) ActiveTunnels() []TunInfo[ID] {
+	m.tunMu.Lock()
+	defer m.tunMu.Unlock()
+	infos := make([]TunInfo[ID], 0, len(m.tunnels))
+	for t, e := range m.tunnels {
+		infos = append(infos, tunInfo(t, e))
+	}
+	return infos
+}
+
+// CloseTunnel closes the active tunnels for which match returns true and returns how many it closed.
+// The tunnels finish as if closed by their peers.
+func (m *TunMaster[ID]) CloseTunnel(match func(TunInfo[ID]) bool) int {
+	m.tunMu.Lock()
+	tunnels := make(map[*Tun]TunInfo[ID], len(m.tunnels))
+	for t, e := range m.tunnels {
+		tunnels[t] = tunInfo(t, e)
+	}
+	m.tunMu.Unlock()
+	// match runs without the lock, so it may call back into m
+	n := 0
+	for t, info := range tunnels {
+		if match(info) {
+			_ = t.Close()
+			n++
+		}
+	}
+	return n
+}
+
+func tunInfo[ID comparable](t *Tun, e tunEntry[ID]) TunInfo[ID] {
+	t.peerMu.Lock()
+	peer := t.Peer
+	t.peerMu.Unlock()
+	info := TunInfo[ID]{
+		Route:      e.route,
+		RemoteAddr: e.remote,
+		Start:      e.start,
+		BytesIn:    t.relayed[TunSideConn].Load(),
+		BytesOut:   t.relayed[TunSidePeer].Load(),
+	}
+	if peer != nil {
+		info.PeerAddr = peer.RemoteAddr()
+	}
+	return info
+}
+
+// connAddr formats the remote address of c for logging, tolerating a peer that has not been dialed yet.
+func connAddr(c net.Conn) string {
+	if c == nil {
+		return "pending"
+	}
+	return c.RemoteAddr().Network() + "://" + c.RemoteAddr().String()
+}
+
+// TunHandler decides whether a connection is tunneled and returns the tunnel for it, which must not be nil if it
+// matched. Tunnels hold their pause state and counters, so they are handed over as pointers and must not be copied.
+type TunHandler func(ctx context.Context, conn net.Conn) (matched bool, connCtx context.Context, tunnel *Tun)
 
 // TunMaster initially accepts no connections, since there are no known tunnel handlers.
 // It's the duty of the caller to add tunnel handlers via SetHandler.
 // The generic ID type is used to identify different tunnel handlers, e.g. by a client ID or username.
-type TunMaster[ID comparable] struct{ Server[ID] }
+type TunMaster[ID comparable] struct {
+	Server[ID]
+
+	tunMu   sync.Mutex
+	tunnels map[*Tun]tunEntry[ID] // relaying tunnels, see ActiveTunnels
+}
+
+type tunEntry[ID comparable] struct {
+	route  ID
+	remote net.Addr
+	start  time.Time
+}
+
+// TunInfo describes an active tunnel of a TunMaster, see TunMaster.ActiveTunnels.
+type TunInfo[ID comparable] struct {
+	Route      ID
+	RemoteAddr net.Addr // of the accepted conn
+	PeerAddr   net.Addr // nil while the peer is not dialed yet, see Tun.PeerDial
+	// Start is when the tunnel started relaying.
+	Start time.Time
+	// BytesIn and BytesOut are the bytes relayed from the conn to the peer and from the peer to the conn.
+	BytesIn, BytesOut uint64
+}
+
+// Age returns how long the tunnel has been relaying.
+func (i TunInfo[ID]) Age() time.Duration { return time.Since(i.Start) }
 
 // SetRoute sets a tunnel handler for a specific ID.
 // If a handler already exists for this ID, it will be replaced.
 // It does not close any existing tunnels that were created by the previous handler, but new tunnels will use the new handler.
-func (m *TunMaster[ID]) SetRoute(id ID, handler TunHandler) {
-	m.Server.SetRoute(id, func(connCtx context.Context, conn net.Conn, closed func()) (matched bool, tun io.Closer) {
+func (m *TunMaster[ID]) SetRoute(id ID, handler TunHandler) RouteHandle[ID] {
+	return m.Server.SetRoute(id, func(connCtx context.Context, conn net.Conn, closed func()) (matched bool, tun io.Closer) {
 		matched, connCtx, tunnel := handler(connCtx, conn)
-		if !matched {
+		if !matched || tunnel == nil {
 			return false, conn
 		}
 
 		m.Logger.InfoContext(connCtx, "starting new tunnel",
-			"tun", tunnel.Conn.RemoteAddr().Network()+"://"+tunnel.Conn.RemoteAddr().String(),
-			"peer", tunnel.Peer.RemoteAddr().Network()+"://"+tunnel.Peer.RemoteAddr().String(),
+			"tun", connAddr(tunnel.Conn),
+			"peer", connAddr(tunnel.Peer),
 		)
 
+		m.tunMu.Lock()
+		if m.tunnels == nil {
+			m.tunnels = make(map[*Tun]tunEntry[ID])
+		}
+		m.tunnels[tunnel] = tunEntry[ID]{route: id, remote: conn.RemoteAddr(), start: time.Now()}
+		m.tunMu.Unlock()
+
 		go func() {
 			tunnel.Relay(connCtx)
+			m.tunMu.Lock()
+			delete(m.tunnels, tunnel)
+			m.tunMu.Unlock()
 			closed()
 			m.Logger.InfoContext(connCtx, "tunnel closed",
-				"tun", tunnel.Conn.RemoteAddr().Network()+"://"+tunnel.Conn.RemoteAddr().String(),
-				"peer", tunnel.Peer.RemoteAddr().Network()+"://"+tunnel.Peer.RemoteAddr().String(),
+				"tun", connAddr(tunnel.Conn),
+				"peer", connAddr(tunnel.Peer),
 			)
 		}()
 
-		return true, &tunnel
+		return true, tunnel
 	})
 }
@@ -0,0 +1,154 @@
+package netx
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// PeerSelector picks one of the peers of a TunSplit.
+type PeerSelector uint8
+
+const (
+	PeerData    PeerSelector = iota // the payload peer, Peers[0]
+	PeerControl                     // the out-of-band control peer, Peers[1]
+)
+
+// TunSplitHeaderSize is the size of the header prepended to every chunk TunSplit writes to Conn:
+// the PeerSelector of the peer the chunk was read from, followed by the 4-byte big-endian payload length.
+const TunSplitHeaderSize = 5
+
+// TunSplit is a tunnel endpoint that splits the stream coming from Conn between two peers,
+// e.g. to send payload to one backend and control messages to another.
+// Every chunk read from Conn is passed to Classifier, which selects the peer it is forwarded to.
+// Chunks read from either peer are merged back into Conn, each prefixed with a header naming
+// the peer it came from, see TunSplitHeaderSize.
+//
+// Ordering caveats: Classifier sees the data as returned by a single Read on Conn, so a message split across
+// reads or several messages coalesced into one read are classified as a whole; Conn should preserve message
+// boundaries (e.g. a FrameConn) unless the classification can be made per chunk. The order of chunks is kept
+// per peer, but there is no ordering between the two peers in either direction.
+type TunSplit struct {
+	Logger     Logger
+	Conn       net.Conn
+	Peers      [2]net.Conn // indexed by PeerSelector
+	Classifier func([]byte) PeerSelector
+	BufferSize uint // BufferSize for reads, default 32KB
+	// OnClose, if set, is called once when Relay finishes, like Tun.OnClose.
+	OnClose func(error)
+	closing atomic.Bool
+	wmu     sync.Mutex // serializes the merged writes to Conn
+}
+
+// Relay routes data between Conn and the peers until any of them encounters an error or is closed.
+// Canceling ctx closes the tunnel. When the relay finishes, OnClose is called with the reason.
+func (t *TunSplit) Relay(ctx context.Context) {
+	if t.Conn == nil || t.Peers[PeerData] == nil || t.Peers[PeerControl] == nil || t.Classifier == nil {
+		return
+	}
+	if t.Logger == nil {
+		t.Logger = slog.Default()
+	}
+	stop := context.AfterFunc(ctx, func() { _ = t.Close() })
+
+	errCh := make(chan error, 3)
+	go t.runCopy(errCh, t.splitConn)
+	for sel := range t.Peers {
+		go t.runCopy(errCh, func(buf []byte) error { return t.mergePeer(PeerSelector(sel), buf) })
+	}
+	var err error
+	for range 3 {
+		if cErr := <-errCh; cErr != nil {
+			t.Logger.ErrorContext(ctx, "error relaying split tunnel", "error", cErr)
+			if err == nil {
+				err = cErr
+			}
+		}
+	}
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+	if t.OnClose != nil {
+		t.OnClose(err)
+	}
+}
+
+func (t *TunSplit) runCopy(errCh chan<- error, copyFn func(buf []byte) error) {
+	size := t.BufferSize
+	if size == 0 {
+		size = 32 * 1024
+	}
+	defer t.Close()
+	err := copyFn(make([]byte, size))
+	if t.closing.Load() {
+		errCh <- nil
+		return
+	}
+	errCh <- err
+}
+
+// splitConn forwards every chunk read from Conn to the peer selected by the classifier.
+func (t *TunSplit) splitConn(buf []byte) error {
+	for {
+		n, rErr := t.Conn.Read(buf)
+		if n > 0 {
+			sel := t.Classifier(buf[:n])
+			if int(sel) >= len(t.Peers) {
+				return &TunError{Side: TunSideConn, Err: fmt.Errorf("invalid peer selector %d", sel)}
+			}
+			if _, wErr := t.Peers[sel].Write(buf[:n]); wErr != nil {
+				return &TunError{Side: TunSidePeer, Err: wErr}
+			}
+		}
+		if rErr != nil {
+			if rErr == io.EOF {
+				return nil
+			}
+			return &TunError{Side: TunSideConn, Err: rErr}
+		}
+	}
+}
+
+// mergePeer writes every chunk read from the selected peer to Conn, prefixed with its header.
+func (t *TunSplit) mergePeer(sel PeerSelector, buf []byte) error {
+	frame := make([]byte, TunSplitHeaderSize+len(buf))
+	frame[0] = byte(sel)
+	for {
+		n, rErr := t.Peers[sel].Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(frame[1:TunSplitHeaderSize], uint32(n))
+			copy(frame[TunSplitHeaderSize:], buf[:n])
+			t.wmu.Lock()
+			_, wErr := t.Conn.Write(frame[:TunSplitHeaderSize+n])
+			t.wmu.Unlock()
+			if wErr != nil {
+				return &TunError{Side: TunSideConn, Err: wErr}
+			}
+		}
+		if rErr != nil {
+			if rErr == io.EOF {
+				return nil
+			}
+			return &TunError{Side: TunSidePeer, Err: rErr}
+		}
+	}
+}
+
+func (t *TunSplit) Close() error {
+	if !t.closing.CompareAndSwap(false, true) {
+		return nil
+	}
+	var errs error
+	for _, c := range []net.Conn{t.Conn, t.Peers[PeerData], t.Peers[PeerControl]} {
+		if err := c.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = errors.Join(errs, err)
+		}
+	}
+	return errs
+}
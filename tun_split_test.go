@@ -0,0 +1,88 @@
+package netx_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pedramktb/go-netx"
+)
+
+// taggingEcho echoes every chunk read from c prefixed with tag, so tests can tell which backend answered.
+func taggingEcho(c net.Conn, tag string) {
+	defer c.Close()
+	buf := make([]byte, 1024)
+	for {
+		n, err := c.Read(buf)
+		if err != nil {
+			return
+		}
+		if _, err := c.Write(append([]byte(tag), buf[:n]...)); err != nil {
+			return
+		}
+	}
+}
+
+func TestTunSplitRoutesByPrefix(t *testing.T) {
+	t.Parallel()
+	client, conn := net.Pipe()
+	dataPeer, dataBackend := net.Pipe()
+	ctrlPeer, ctrlBackend := net.Pipe()
+	go taggingEcho(dataBackend, "data:")
+	go taggingEcho(ctrlBackend, "ctrl:")
+
+	done := make(chan error, 1)
+	tun := netx.TunSplit{
+		Logger: &memLogger{},
+		Conn:   conn,
+		Peers:  [2]net.Conn{dataPeer, ctrlPeer},
+		Classifier: func(b []byte) netx.PeerSelector {
+			if b[0] == '!' {
+				return netx.PeerControl
+			}
+			return netx.PeerData
+		},
+		OnClose: func(err error) { done <- err },
+	}
+	go tun.Relay(context.Background())
+	_ = client.SetDeadline(time.Now().Add(2 * time.Second))
+
+	cases := []struct {
+		send string
+		sel  netx.PeerSelector
+		want string
+	}{
+		{"hello", netx.PeerData, "data:hello"},
+		{"!ping", netx.PeerControl, "ctrl:!ping"},
+		{"world", netx.PeerData, "data:world"},
+	}
+	for _, tc := range cases {
+		if _, err := client.Write([]byte(tc.send)); err != nil {
+			t.Fatalf("write %q: %v", tc.send, err)
+		}
+		hdr := make([]byte, netx.TunSplitHeaderSize)
+		if _, err := io.ReadFull(client, hdr); err != nil {
+			t.Fatalf("read header: %v", err)
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(hdr[1:]))
+		if _, err := io.ReadFull(client, payload); err != nil {
+			t.Fatalf("read payload: %v", err)
+		}
+		if netx.PeerSelector(hdr[0]) != tc.sel || string(payload) != tc.want {
+			t.Fatalf("got peer %d %q, want peer %d %q", hdr[0], payload, tc.sel, tc.want)
+		}
+	}
+
+	_ = client.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("OnClose error = %v, want nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("relay did not finish after client close")
+	}
+}
@@ -1,10 +1,14 @@
 package netx_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"net"
+	"sync"
 	"testing"
 	"time"
 
@@ -28,10 +32,10 @@ func TestTunRelayBidirectional(t *testing.T) {
 	go func() { errCh <- m.Serve(ctx, ln) }()
 
 	peerCh := make(chan net.Conn, 1)
-	m.SetRoute("id", func(connCtx context.Context, conn net.Conn) (bool, context.Context, netx.Tun) {
+	m.SetRoute("id", func(connCtx context.Context, conn net.Conn) (bool, context.Context, *netx.Tun) {
 		a, b := net.Pipe() // a is server-side peer, b is test-side peer
 		peerCh <- b
-		return true, connCtx, netx.Tun{Logger: logger, Conn: conn, Peer: a}
+		return true, connCtx, &netx.Tun{Logger: logger, Conn: conn, Peer: a}
 	})
 
 	// Connect client
@@ -111,11 +115,11 @@ func TestTunShutdownTimeoutForcesClose(t *testing.T) {
 
 	peerCh := make(chan net.Conn, 1)
 	ready := make(chan struct{})
-	m.SetRoute("id", func(connCtx context.Context, conn net.Conn) (bool, context.Context, netx.Tun) {
+	m.SetRoute("id", func(connCtx context.Context, conn net.Conn) (bool, context.Context, *netx.Tun) {
 		a, b := net.Pipe()
 		close(ready)
 		peerCh <- b
-		return true, connCtx, netx.Tun{Logger: logger, Conn: conn, Peer: a}
+		return true, connCtx, &netx.Tun{Logger: logger, Conn: conn, Peer: a}
 	})
 
 	c, err := net.Dial("tcp", ln.Addr().String())
@@ -172,3 +176,706 @@ func TestTunShutdownTimeoutForcesClose(t *testing.T) {
 		t.Fatal("serve did not exit after Shutdown()")
 	}
 }
+
+func TestTunWriteTimeoutTearsDown(t *testing.T) {
+	t.Parallel()
+	logger := &memLogger{}
+
+	conn, connRemote := net.Pipe() // connRemote never reads
+	peer, peerRemote := net.Pipe()
+	t.Cleanup(func() { _ = connRemote.Close(); _ = peerRemote.Close() })
+
+	tun := &netx.Tun{Logger: logger, Conn: conn, Peer: peer, WriteTimeout: 100 * time.Millisecond}
+	done := make(chan struct{})
+	go func() {
+		tun.Relay(context.Background())
+		close(done)
+	}()
+
+	// The relay reads this from the peer but can never deliver it to the stalled conn side.
+	go func() { _, _ = peerRemote.Write([]byte("stalled")) }()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("relay did not tear down after write timeout")
+	}
+
+	var timeoutLogged bool
+	logger.mu.Lock()
+	for _, e := range logger.entries {
+		if e == "ERROR: error copying data from peer to tun" {
+			timeoutLogged = true
+		}
+	}
+	logger.mu.Unlock()
+	if !timeoutLogged {
+		t.Fatalf("expected write timeout to be logged, got %v", logger.entries)
+	}
+
+	// Both sides are closed by the teardown.
+	if _, err := connRemote.Write([]byte("x")); err == nil {
+		t.Fatalf("expected conn side to be closed")
+	}
+}
+
+func TestTunReadTimeoutTearsDown(t *testing.T) {
+	t.Parallel()
+
+	conn, connRemote := net.Pipe()
+	peer, peerRemote := net.Pipe()
+	t.Cleanup(func() { _ = connRemote.Close(); _ = peerRemote.Close() })
+
+	tun := &netx.Tun{Logger: &memLogger{}, Conn: conn, Peer: peer, ReadTimeout: 100 * time.Millisecond}
+	done := make(chan struct{})
+	go func() {
+		tun.Relay(context.Background())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("relay did not tear down after read timeout")
+	}
+}
+
+func TestTunMaxLifetimeEndsBusyRelay(t *testing.T) {
+	t.Parallel()
+
+	conn, connRemote := net.Pipe()
+	peer, peerRemote := net.Pipe()
+	t.Cleanup(func() { _ = connRemote.Close(); _ = peerRemote.Close() })
+
+	const lifetime = 150 * time.Millisecond
+	closeErr := make(chan error, 1)
+	tun := &netx.Tun{
+		Logger:      &memLogger{},
+		Conn:        conn,
+		Peer:        peer,
+		MaxLifetime: lifetime,
+		OnClose:     func(err error) { closeErr <- err },
+	}
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		tun.Relay(context.Background())
+		close(done)
+	}()
+
+	// keep data flowing through the tunnel until it is torn down
+	go func() {
+		for {
+			if _, err := connRemote.Write([]byte("tick")); err != nil {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	go func() { _, _ = io.Copy(io.Discard, peerRemote) }()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("relay did not end after its max lifetime")
+	}
+	if elapsed := time.Since(start); elapsed < lifetime {
+		t.Fatalf("relay ended after %v, before its max lifetime", elapsed)
+	}
+	if err := <-closeErr; !errors.Is(err, netx.ErrTunMaxLifetime) {
+		t.Fatalf("OnClose error = %v, want ErrTunMaxLifetime", err)
+	}
+}
+
+func TestTunPeerDialIsLazy(t *testing.T) {
+	t.Parallel()
+
+	conn, connRemote := net.Pipe()
+	peer, peerRemote := net.Pipe()
+	t.Cleanup(func() { _ = connRemote.Close(); _ = peerRemote.Close() })
+
+	dialed := make(chan struct{})
+	tun := &netx.Tun{
+		Logger: &memLogger{},
+		Conn:   conn,
+		PeerDial: func(ctx context.Context) (net.Conn, error) {
+			close(dialed)
+			return peer, nil
+		},
+	}
+	done := make(chan struct{})
+	go func() {
+		tun.Relay(context.Background())
+		close(done)
+	}()
+
+	select {
+	case <-dialed:
+		t.Fatal("peer dialed before any data was sent")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	go func() { _, _ = connRemote.Write([]byte("hello")) }()
+	select {
+	case <-dialed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("peer not dialed after data was sent")
+	}
+
+	// the first data is forwarded to the freshly dialed peer, and the relay continues both ways
+	buf := make([]byte, 5)
+	_ = peerRemote.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(peerRemote, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("peer read %q: %v", buf, err)
+	}
+	go func() { _, _ = peerRemote.Write([]byte("world")) }()
+	_ = connRemote.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(connRemote, buf); err != nil || string(buf) != "world" {
+		t.Fatalf("conn read %q: %v", buf, err)
+	}
+
+	_ = connRemote.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("relay did not finish after conn closed")
+	}
+}
+
+func TestTunPeerDialSkippedWhenConnClosesEarly(t *testing.T) {
+	t.Parallel()
+
+	conn, connRemote := net.Pipe()
+	var dials int
+	tun := &netx.Tun{
+		Logger: &memLogger{},
+		Conn:   conn,
+		PeerDial: func(ctx context.Context) (net.Conn, error) {
+			dials++
+			return nil, errors.New("unexpected dial")
+		},
+	}
+	done := make(chan struct{})
+	go func() {
+		tun.Relay(context.Background())
+		close(done)
+	}()
+
+	_ = connRemote.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("relay did not finish after conn closed")
+	}
+	if dials != 0 {
+		t.Fatalf("peer dialed %d times for a conn that sent nothing", dials)
+	}
+	if err := tun.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+}
+
+// failingConn fails every read with err.
+type failingConn struct {
+	net.Conn
+	err error
+}
+
+func (c *failingConn) Read([]byte) (int, error) { return 0, c.err }
+
+// relayOnClose runs tun.Relay with ctx and returns the errors passed to OnClose once the relay finished.
+func relayOnClose(t *testing.T, ctx context.Context, tun *netx.Tun, trigger func()) []error {
+	t.Helper()
+	var errs []error
+	tun.OnClose = func(err error) { errs = append(errs, err) }
+	done := make(chan struct{})
+	go func() {
+		tun.Relay(ctx)
+		close(done)
+	}()
+	trigger()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("relay did not finish")
+	}
+	return errs
+}
+
+func TestTunOnClose(t *testing.T) {
+	t.Parallel()
+
+	t.Run("clean", func(t *testing.T) {
+		conn, connRemote := net.Pipe()
+		peer, peerRemote := net.Pipe()
+		t.Cleanup(func() { _ = connRemote.Close() })
+		tun := &netx.Tun{Logger: &memLogger{}, Conn: conn, Peer: peer}
+		errs := relayOnClose(t, context.Background(), tun, func() { _ = peerRemote.Close() })
+		if len(errs) != 1 || errs[0] != nil {
+			t.Fatalf("OnClose calls = %v, want one nil", errs)
+		}
+	})
+
+	t.Run("peer side", func(t *testing.T) {
+		conn, connRemote := net.Pipe()
+		peer, peerRemote := net.Pipe()
+		t.Cleanup(func() { _ = connRemote.Close(); _ = peerRemote.Close() })
+		boom := errors.New("boom")
+		tun := &netx.Tun{Logger: &memLogger{}, Conn: conn, Peer: &failingConn{Conn: peer, err: boom}}
+		errs := relayOnClose(t, context.Background(), tun, func() {})
+		if len(errs) != 1 {
+			t.Fatalf("OnClose called %d times, want 1", len(errs))
+		}
+		var tunErr *netx.TunError
+		if !errors.As(errs[0], &tunErr) || tunErr.Side != netx.TunSidePeer || !errors.Is(errs[0], boom) {
+			t.Fatalf("OnClose error = %v, want peer side boom", errs[0])
+		}
+	})
+
+	t.Run("conn side", func(t *testing.T) {
+		conn, connRemote := net.Pipe()
+		peer, peerRemote := net.Pipe()
+		t.Cleanup(func() { _ = connRemote.Close(); _ = peerRemote.Close() })
+		boom := errors.New("boom")
+		tun := &netx.Tun{Logger: &memLogger{}, Conn: &failingConn{Conn: conn, err: boom}, Peer: peer}
+		errs := relayOnClose(t, context.Background(), tun, func() {})
+		var tunErr *netx.TunError
+		if len(errs) != 1 || !errors.As(errs[0], &tunErr) || tunErr.Side != netx.TunSideConn {
+			t.Fatalf("OnClose calls = %v, want one conn side error", errs)
+		}
+	})
+
+	t.Run("context", func(t *testing.T) {
+		conn, connRemote := net.Pipe()
+		peer, peerRemote := net.Pipe()
+		t.Cleanup(func() { _ = connRemote.Close(); _ = peerRemote.Close() })
+		ctx, cancel := context.WithCancel(context.Background())
+		tun := &netx.Tun{Logger: &memLogger{}, Conn: conn, Peer: peer}
+		errs := relayOnClose(t, ctx, tun, cancel)
+		if len(errs) != 1 || !errors.Is(errs[0], context.Canceled) {
+			t.Fatalf("OnClose calls = %v, want one context.Canceled", errs)
+		}
+	})
+}
+
+func TestTunOnCloseOnceOnForcedShutdown(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var m netx.TunMaster[string]
+	m.Logger = &memLogger{}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() { _ = m.Serve(ctx, ln) }()
+
+	closeErrs := make(chan error, 2)
+	ready := make(chan struct{})
+	m.SetRoute("id", func(connCtx context.Context, conn net.Conn) (bool, context.Context, *netx.Tun) {
+		a, _ := net.Pipe()
+		close(ready)
+		return true, connCtx, &netx.Tun{Logger: m.Logger, Conn: conn, Peer: a, OnClose: func(err error) { closeErrs <- err }}
+	})
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	<-ready
+
+	sdCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := m.Shutdown(sdCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown error = %v, want DeadlineExceeded", err)
+	}
+
+	select {
+	case err := <-closeErrs:
+		if err != nil {
+			t.Fatalf("OnClose error = %v, want nil for forced close", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnClose not called after forced shutdown")
+	}
+	select {
+	case err := <-closeErrs:
+		t.Fatalf("OnClose called twice, second error %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// observerBuf collects observed bytes and signals when it is closed.
+type observerBuf struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	closed chan struct{}
+}
+
+func (o *observerBuf) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.Write(p)
+}
+
+func (o *observerBuf) Close() error {
+	close(o.closed)
+	return nil
+}
+
+func TestTunObserversReceiveRelayedBytes(t *testing.T) {
+	t.Parallel()
+
+	conn, connRemote := net.Pipe()
+	peer, peerRemote := net.Pipe()
+	t.Cleanup(func() { _ = connRemote.Close(); _ = peerRemote.Close() })
+
+	fromConn := &observerBuf{closed: make(chan struct{})}
+	fromPeer := &observerBuf{closed: make(chan struct{})}
+	tun := &netx.Tun{
+		Logger:      &memLogger{},
+		Conn:        conn,
+		Peer:        peer,
+		ObserveConn: fromConn,
+		ObservePeer: fromPeer,
+	}
+	go tun.Relay(context.Background())
+
+	var sentByConn, sentByPeer bytes.Buffer
+	buf := make([]byte, 64)
+	for i := range 5 {
+		req := fmt.Sprintf("request %d", i)
+		if _, err := connRemote.Write([]byte(req)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		n, err := peerRemote.Read(buf)
+		if err != nil || string(buf[:n]) != req {
+			t.Fatalf("peer got %q, %v", buf[:n], err)
+		}
+		resp := fmt.Sprintf("response %d", i)
+		if _, err := peerRemote.Write([]byte(resp)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if n, err = connRemote.Read(buf); err != nil || string(buf[:n]) != resp {
+			t.Fatalf("conn got %q, %v", buf[:n], err)
+		}
+		sentByConn.WriteString(req)
+		sentByPeer.WriteString(resp)
+	}
+	_ = connRemote.Close()
+
+	for _, o := range []*observerBuf{fromConn, fromPeer} {
+		select {
+		case <-o.closed:
+		case <-time.After(2 * time.Second):
+			t.Fatal("observer not closed after the relay finished")
+		}
+	}
+	if got := fromConn.buf.String(); got != sentByConn.String() {
+		t.Fatalf("conn observer got %q, want %q", got, sentByConn.String())
+	}
+	if got := fromPeer.buf.String(); got != sentByPeer.String() {
+		t.Fatalf("peer observer got %q, want %q", got, sentByPeer.String())
+	}
+}
+
+// stuckWriter blocks every write until released.
+type stuckWriter struct{ release chan struct{} }
+
+func (w stuckWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+func TestTunSlowObserverDoesNotBlockRelay(t *testing.T) {
+	t.Parallel()
+
+	conn, connRemote := net.Pipe()
+	peer, peerRemote := net.Pipe()
+	t.Cleanup(func() { _ = connRemote.Close(); _ = peerRemote.Close() })
+
+	stuck := stuckWriter{release: make(chan struct{})}
+	defer close(stuck.release)
+	tun := &netx.Tun{Logger: &memLogger{}, Conn: conn, Peer: peer, ObserveConn: stuck}
+	go tun.Relay(context.Background())
+	defer tun.Close()
+
+	// far more chunks than the observer queue holds still cross the relay
+	buf := make([]byte, 16)
+	for range 500 {
+		go func() { _, _ = connRemote.Write([]byte("chunk")) }()
+		_ = peerRemote.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if n, err := peerRemote.Read(buf); err != nil || string(buf[:n]) != "chunk" {
+			t.Fatalf("peer got %q, %v", buf[:n], err)
+		}
+	}
+}
+
+func TestTunMasterActiveTunnelsAndCloseTunnel(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var m netx.TunMaster[string]
+	m.Logger = &memLogger{}
+	defer m.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() { _ = m.Serve(ctx, ln) }()
+
+	// every tunnel relays to an echoing peer
+	m.SetRoute("echo", func(connCtx context.Context, conn net.Conn) (bool, context.Context, *netx.Tun) {
+		a, b := net.Pipe()
+		go func() { _, _ = io.Copy(b, b) }()
+		return true, connCtx, &netx.Tun{Logger: &memLogger{}, Conn: conn, Peer: a}
+	})
+
+	var clients []net.Conn
+	for i := range 3 {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { _ = c.Close() })
+		_ = c.SetDeadline(time.Now().Add(2 * time.Second))
+		msg := bytes.Repeat([]byte{'x'}, i+1)
+		if _, err := c.Write(msg); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if _, err := io.ReadFull(c, msg); err != nil {
+			t.Fatalf("read echo: %v", err)
+		}
+		clients = append(clients, c)
+	}
+
+	// the counters are updated right after the writes, so wait for them to settle
+	byRemote := map[string]netx.TunInfo[string]{}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		clear(byRemote)
+		settled := true
+		for _, info := range m.ActiveTunnels() {
+			byRemote[info.RemoteAddr.String()] = info
+			settled = settled && info.BytesOut == info.BytesIn
+		}
+		if settled && len(byRemote) == len(clients) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("active tunnels did not settle: %+v", byRemote)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	for i, c := range clients {
+		info, ok := byRemote[c.LocalAddr().String()]
+		if !ok {
+			t.Fatalf("tunnel of client %d not listed", i)
+		}
+		if info.Route != "echo" || info.BytesIn != uint64(i+1) || info.PeerAddr == nil || info.Age() <= 0 {
+			t.Fatalf("tunnel of client %d: %+v", i, info)
+		}
+	}
+
+	target := clients[1].LocalAddr().String()
+	if n := m.CloseTunnel(func(info netx.TunInfo[string]) bool { return info.RemoteAddr.String() == target }); n != 1 {
+		t.Fatalf("CloseTunnel closed %d tunnels, want 1", n)
+	}
+	if _, err := clients[1].Read(make([]byte, 1)); err == nil {
+		t.Fatal("closed tunnel still delivers data")
+	}
+	for time.Now().Before(deadline) && len(m.ActiveTunnels()) != 2 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	for _, info := range m.ActiveTunnels() {
+		if info.RemoteAddr.String() == target {
+			t.Fatal("closed tunnel still listed")
+		}
+	}
+	if n := len(m.ActiveTunnels()); n != 2 {
+		t.Fatalf("%d active tunnels, want 2", n)
+	}
+	// the others keep relaying
+	if _, err := clients[0].Write([]byte("y")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := io.ReadFull(clients[0], make([]byte, 1)); err != nil {
+		t.Fatalf("read echo: %v", err)
+	}
+}
+
+func TestTunPauseResume(t *testing.T) {
+	t.Parallel()
+	client, tunConn := net.Pipe()
+	peerConn, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	closed := make(chan error, 1)
+	tun := &netx.Tun{Logger: &memLogger{}, Conn: tunConn, Peer: peerConn, OnClose: func(err error) { closed <- err }}
+	go tun.Relay(context.Background())
+
+	buf := make([]byte, 16)
+	send := func(msg string) {
+		go func() { _, _ = client.Write([]byte(msg)) }()
+	}
+	send("one")
+	_ = server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if n, err := server.Read(buf); err != nil || string(buf[:n]) != "one" {
+		t.Fatalf("read %q, %v", buf[:n], err)
+	}
+
+	tun.Pause()
+	send("two")
+	_ = server.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, err := server.Read(buf); err == nil {
+		t.Fatalf("relayed %q while paused", buf[:n])
+	}
+
+	tun.Resume()
+	_ = server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if n, err := server.Read(buf); err != nil || string(buf[:n]) != "two" {
+		t.Fatalf("read after resume %q, %v", buf[:n], err)
+	}
+
+	// closing a paused tunnel ends the relay
+	tun.Pause()
+	_ = tun.Close()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("OnClose(%v), want a clean close", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("paused relay did not end on Close")
+	}
+}
+
+func TestTunPauseKeepsFramesIntact(t *testing.T) {
+	t.Parallel()
+
+	// framed TCP stream | tun | pipe, with the tunnel paused and resumed while frames are in flight; a pipe
+	// keeps the boundaries of writes like UDP, without dropping any
+	peer, tunPeer := net.Pipe()
+	t.Cleanup(func() { _ = peer.Close(); _ = tunPeer.Close() })
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	stream, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer stream.Close()
+	tunStream, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tun := &netx.Tun{Logger: &memLogger{}, Conn: tunStream, Peer: tunPeer, StreamSide: netx.TunSideConn}
+	go tun.Relay(ctx)
+
+	// every frame arrives in two halves, so pauses land in the middle of frames
+	const frames, size = 30, 4000
+	go func() {
+		for i := range frames {
+			frame := binary.BigEndian.AppendUint16(nil, size)
+			frame = append(frame, bytes.Repeat([]byte{byte(i)}, size)...)
+			for _, half := range [][]byte{frame[:size/2], frame[size/2:]} {
+				if _, err := stream.Write(half); err != nil {
+					return
+				}
+				time.Sleep(3 * time.Millisecond)
+			}
+		}
+	}()
+	stopToggling := make(chan struct{})
+	defer close(stopToggling)
+	go func() {
+		for {
+			select {
+			case <-stopToggling:
+				tun.Resume()
+				return
+			default:
+			}
+			tun.Pause()
+			time.Sleep(time.Millisecond)
+			tun.Resume()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	buf := make([]byte, netx.MaxPacketSize)
+	for i := range frames {
+		_ = peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := peer.Read(buf)
+		if err != nil {
+			t.Fatalf("datagram %d: %v", i, err)
+		}
+		if !bytes.Equal(buf[:n], bytes.Repeat([]byte{byte(i)}, size)) {
+			t.Fatalf("datagram %d: got %d bytes starting with %v, want %d bytes of %d", i, n, buf[:min(n, 8)], size, byte(i))
+		}
+	}
+}
+
+func TestTunStreamSideCarriesDatagrams(t *testing.T) {
+	t.Parallel()
+
+	// clientPeer <-> clientUDP | client tun | TCP stream | server tun | serverUDP <-> serverPeer
+	clientPeer, clientUDP := newUDPPair(t)
+	serverUDP, serverPeer := newUDPPair(t)
+	t.Cleanup(func() {
+		_ = clientPeer.Close()
+		_ = clientUDP.Close()
+		_ = serverUDP.Close()
+		_ = serverPeer.Close()
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	streamClient, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	streamServer, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &netx.Tun{Logger: &memLogger{}, Conn: streamClient, Peer: clientUDP, StreamSide: netx.TunSideConn}
+	server := &netx.Tun{Logger: &memLogger{}, Conn: serverUDP, Peer: streamServer, StreamSide: netx.TunSidePeer}
+	go client.Relay(ctx)
+	go server.Relay(ctx)
+
+	buf := make([]byte, netx.MaxPacketSize)
+	for _, size := range []int{1, 512, 1500, 9000, 40000, 65507} {
+		msg := bytes.Repeat([]byte{byte(size)}, size)
+		for _, dir := range []struct {
+			name     string
+			from, to *net.UDPConn
+		}{{"client to server", clientPeer, serverPeer}, {"server to client", serverPeer, clientPeer}} {
+			if _, err := dir.from.Write(msg); err != nil {
+				t.Fatalf("%s: write %d bytes: %v", dir.name, size, err)
+			}
+			_ = dir.to.SetReadDeadline(time.Now().Add(2 * time.Second))
+			n, err := dir.to.Read(buf)
+			if err != nil {
+				t.Fatalf("%s: read %d bytes: %v", dir.name, size, err)
+			}
+			if !bytes.Equal(buf[:n], msg) {
+				t.Fatalf("%s: got a datagram of %d bytes, want %d", dir.name, n, size)
+			}
+		}
+	}
+}
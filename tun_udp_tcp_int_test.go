@@ -99,10 +99,10 @@ func TestInt_UDP_over_TCP_TunMasters(t *testing.T) {
 	// Client TunMaster: bridge UDP<->framed stream (towards server)
 	var tmClient netx.TunMaster[string]
 	tmClient.Logger = logger
-	tmClient.SetRoute("route", func(connCtx context.Context, conn net.Conn) (bool, context.Context, netx.Tun) {
+	tmClient.SetRoute("route", func(connCtx context.Context, conn net.Conn) (bool, context.Context, *netx.Tun) {
 		// Wrap the stream side with framing to preserve UDP datagrams
 		fc := netx.NewFrameConn(conn)
-		return true, connCtx, netx.Tun{
+		return true, connCtx, &netx.Tun{
 			Logger:     logger,
 			Conn:       fc,
 			Peer:       clientTunUDP,
@@ -113,9 +113,9 @@ func TestInt_UDP_over_TCP_TunMasters(t *testing.T) {
 	// Server TunMaster: bridge framed stream<->UDP (towards server UDP peer)
 	var tmServer netx.TunMaster[string]
 	tmServer.Logger = logger
-	tmServer.SetRoute("route", func(connCtx context.Context, conn net.Conn) (bool, context.Context, netx.Tun) {
+	tmServer.SetRoute("route", func(connCtx context.Context, conn net.Conn) (bool, context.Context, *netx.Tun) {
 		fc := netx.NewFrameConn(conn)
-		return true, connCtx, netx.Tun{
+		return true, connCtx, &netx.Tun{
 			Logger:     logger,
 			Conn:       fc,
 			Peer:       serverTunUDP,
@@ -199,23 +199,23 @@ func TestInt_TunMasterRouting_PlainAndTLS(t *testing.T) {
 	tmServer.Logger = logger
 
 	// Route 1: TLS first
-	tmServer.SetRoute("tls", func(connCtx context.Context, conn net.Conn) (bool, context.Context, netx.Tun) {
+	tmServer.SetRoute("tls", func(connCtx context.Context, conn net.Conn) (bool, context.Context, *netx.Tun) {
 		// Match if the connection looks like a tls.Conn
 		if _, ok := conn.(interface{ ConnectionState() tls.ConnectionState }); !ok {
-			return false, connCtx, netx.Tun{}
+			return false, connCtx, nil
 		}
 		fc := netx.NewFrameConn(conn)
-		return true, connCtx, netx.Tun{Logger: logger, Conn: fc, Peer: serverTunUDPTLS, BufferSize: 64 << 10}
+		return true, connCtx, &netx.Tun{Logger: logger, Conn: fc, Peer: serverTunUDPTLS, BufferSize: 64 << 10}
 	})
 
 	// Route 2: Plain fallback
-	tmServer.SetRoute("plain", func(connCtx context.Context, conn net.Conn) (bool, context.Context, netx.Tun) {
+	tmServer.SetRoute("plain", func(connCtx context.Context, conn net.Conn) (bool, context.Context, *netx.Tun) {
 		// If it wasn't TLS, handle as plain framed
 		if _, ok := conn.(interface{ ConnectionState() tls.ConnectionState }); ok {
-			return false, connCtx, netx.Tun{}
+			return false, connCtx, nil
 		}
 		fc := netx.NewFrameConn(conn)
-		return true, connCtx, netx.Tun{Logger: logger, Conn: fc, Peer: serverTunUDPPlain, BufferSize: 64 << 10}
+		return true, connCtx, &netx.Tun{Logger: logger, Conn: fc, Peer: serverTunUDPPlain, BufferSize: 64 << 10}
 	})
 
 	// Start server
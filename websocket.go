@@ -0,0 +1,271 @@
+/*
+WebSocketHandler lets a Server route WebSocket connections next to other protocols on the same listener.
+It peeks at the beginning of a connection and, if it is an HTTP WebSocket upgrade request (RFC 6455) for the
+configured path, completes the handshake and hands the inner handler a net.Conn carrying the payload of
+the WebSocket data frames. Anything else is left unconsumed for the next route, provided the conn can be
+peeked at; set Server.ConnPreWrap to a function returning NewPeekConn to make every conn peekable.
+
+Written data is sent as one binary frame per Write. Fragmented and text frames are read as a byte stream.
+Pings are answered and a close frame ends the stream with io.EOF.
+*/
+
+package netx
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	webSocketGUID        = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	webSocketPeekTimeout = 5 * time.Second
+	peekConnSize         = 8192 // also the maximum size of a peeked HTTP request header
+)
+
+// PeekConn is a net.Conn whose incoming data can be inspected before it is read.
+type PeekConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+// NewPeekConn wraps c so its data can be peeked at. A conn that already is a *PeekConn is returned as is.
+func NewPeekConn(c net.Conn) *PeekConn {
+	if pc, ok := c.(*PeekConn); ok {
+		return pc
+	}
+	return &PeekConn{Conn: c, br: bufio.NewReaderSize(c, peekConnSize)}
+}
+
+// Peek returns the next n bytes without consuming them, blocking until they are available.
+// n must not exceed 8192 bytes.
+func (c *PeekConn) Peek(n int) ([]byte, error) { return c.br.Peek(n) }
+
+func (c *PeekConn) Read(p []byte) (int, error) { return c.br.Read(p) }
+
+// peekHTTPHeader returns the header of an HTTP GET request at the start of c without consuming it,
+// or false if the data does not look like one.
+func (c *PeekConn) peekHTTPHeader() ([]byte, bool) {
+	if b, err := c.br.Peek(4); err != nil || string(b) != "GET " {
+		return nil, false
+	}
+	for {
+		n := c.br.Buffered()
+		b, _ := c.br.Peek(n)
+		if i := bytes.Index(b, []byte("\r\n\r\n")); i >= 0 {
+			return b[:i+4], true
+		}
+		if n >= c.br.Size() {
+			return nil, false
+		}
+		if _, err := c.br.Peek(n + 1); err != nil {
+			return nil, false
+		}
+	}
+}
+
+// WebSocketHandler returns a Handler matching WebSocket upgrade requests for path, or any path if empty.
+// On a match it completes the handshake and delegates to inner with a conn over the WebSocket data frames.
+// Conns that are not such requests are not matched. If conn is not a *PeekConn, the peeked bytes are lost
+// for later routes, see the package documentation. Peeking gives up after 5 seconds without a request.
+func WebSocketHandler(path string, inner Handler) Handler {
+	return func(ctx context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+		pc := NewPeekConn(conn)
+		_ = pc.SetReadDeadline(time.Now().Add(webSocketPeekTimeout))
+		header, ok := pc.peekHTTPHeader()
+		_ = pc.SetReadDeadline(time.Time{})
+		if !ok {
+			return false, nil
+		}
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(header)))
+		if err != nil || (path != "" && req.URL.Path != path) || !isWebSocketUpgrade(req) {
+			return false, nil
+		}
+		if _, err := pc.br.Discard(len(header)); err != nil {
+			return false, nil
+		}
+		accept := webSocketAccept(req.Header.Get("Sec-WebSocket-Key"))
+		resp := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + accept + "\r\n\r\n"
+		if _, err := pc.Write([]byte(resp)); err != nil {
+			_ = pc.Close()
+			go closed()
+			return true, pc
+		}
+		ws := &webSocketConn{Conn: pc}
+		matched, wrapped := inner(ctx, ws, closed)
+		if !matched {
+			// the conn has been upgraded already, so nobody else can take it
+			_ = ws.Close()
+			go closed()
+			return true, ws
+		}
+		return true, wrapped
+	}
+}
+
+func isWebSocketUpgrade(req *http.Request) bool {
+	return req.Method == http.MethodGet &&
+		headerContainsToken(req.Header, "Connection", "upgrade") &&
+		headerContainsToken(req.Header, "Upgrade", "websocket") &&
+		req.Header.Get("Sec-WebSocket-Version") == "13" &&
+		req.Header.Get("Sec-WebSocket-Key") != ""
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for t := range strings.SplitSeq(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func webSocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// webSocketConn is the server side of a WebSocket connection.
+type webSocketConn struct {
+	net.Conn // *PeekConn, so buffered data is not lost
+	// read state, only used by Read
+	remaining uint64
+	mask      [4]byte
+	maskPos   int
+	// wmu serializes frames written by Write, Close and ping replies
+	wmu       sync.Mutex
+	closeOnce sync.Once
+}
+
+func (c *webSocketConn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.Conn.Read(p)
+	for i := range n {
+		p[i] ^= c.mask[c.maskPos&3]
+		c.maskPos++
+	}
+	c.remaining -= uint64(n)
+	return n, err
+}
+
+// nextFrame reads frame headers until a data frame starts, handling control frames in between.
+func (c *webSocketConn) nextFrame() error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.Conn, hdr[:]); err != nil {
+		return err
+	}
+	opcode := hdr[0] & 0x0F
+	if hdr[1]&0x80 == 0 {
+		return errors.New("websocket: unmasked client frame")
+	}
+	length := uint64(hdr[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.Conn, ext[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.Conn, ext[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if _, err := io.ReadFull(c.Conn, c.mask[:]); err != nil {
+		return err
+	}
+	c.maskPos = 0
+	switch opcode {
+	case wsOpContinuation, wsOpText, wsOpBinary:
+		c.remaining = length
+		return nil
+	case wsOpClose, wsOpPing, wsOpPong:
+		if length > 125 {
+			return errors.New("websocket: control frame too large")
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.Conn, payload); err != nil {
+			return err
+		}
+		for i := range payload {
+			payload[i] ^= c.mask[i&3]
+		}
+		switch opcode {
+		case wsOpPing:
+			return c.writeFrame(wsOpPong, payload)
+		case wsOpClose:
+			c.closeOnce.Do(func() { _ = c.writeFrame(wsOpClose, payload) })
+			return io.EOF
+		}
+		return nil
+	default:
+		return fmt.Errorf("websocket: unknown opcode %d", opcode)
+	}
+}
+
+func (c *webSocketConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(wsOpBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *webSocketConn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, 10+len(payload))
+	frame = append(frame, 0x80|opcode)
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+// Close sends a normal closure frame, unless one has been exchanged already, and closes the conn.
+func (c *webSocketConn) Close() error {
+	c.closeOnce.Do(func() {
+		_ = c.SetWriteDeadline(time.Now().Add(time.Second))
+		_ = c.writeFrame(wsOpClose, []byte{0x03, 0xE8}) // 1000, normal closure
+	})
+	return c.Conn.Close()
+}
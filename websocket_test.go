@@ -0,0 +1,107 @@
+package netx_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	netx "github.com/pedramktb/go-netx"
+)
+
+func TestWebSocketHandlerSharesListenerWithHTTP(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var s netx.Server[string]
+	s.Logger = &memLogger{}
+	s.ConnPreWrap = func(c net.Conn) (net.Conn, error) { return netx.NewPeekConn(c), nil }
+	defer s.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() { _ = s.Serve(ctx, ln) }()
+
+	// Echo WebSocket payloads
+	s.SetRoute("ws", netx.WebSocketHandler("/tunnel", func(_ context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+		go func() {
+			defer closed()
+			defer conn.Close()
+			_, _ = io.Copy(conn, conn)
+		}()
+		return true, conn
+	}))
+	// Answer everything else as plain HTTP
+	s.SetRoute("http", func(_ context.Context, conn net.Conn, closed func()) (bool, io.Closer) {
+		go func() {
+			defer closed()
+			defer conn.Close()
+			req, err := http.ReadRequest(bufio.NewReader(conn))
+			if err != nil {
+				return
+			}
+			body := "plain " + req.URL.Path
+			_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: "+
+				strconv.Itoa(len(body))+"\r\n\r\n"+body)
+		}()
+		return true, conn
+	})
+
+	// WebSocket upgrade
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(2 * time.Second))
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	if _, err := io.WriteString(c, "GET /tunnel HTTP/1.1\r\nHost: example\r\nUpgrade: websocket\r\n"+
+		"Connection: Upgrade\r\nSec-WebSocket-Key: "+key+"\r\nSec-WebSocket-Version: 13\r\n\r\n"); err != nil {
+		t.Fatalf("write upgrade: %v", err)
+	}
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("read upgrade response: %v", err)
+	}
+	sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected upgrade response: %d %v", resp.StatusCode, resp.Header)
+	}
+
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x82, 0x80 | 5, mask[0], mask[1], mask[2], mask[3]}
+	for i, b := range []byte("hello") {
+		frame = append(frame, b^mask[i&3])
+	}
+	if _, err := c.Write(frame); err != nil {
+		t.Fatalf("write frame: %v", err)
+	}
+	got := make([]byte, 7)
+	if _, err := io.ReadFull(br, got); err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	if got[0] != 0x82 || got[1] != 5 || string(got[2:]) != "hello" {
+		t.Fatalf("unexpected echoed frame %x", got)
+	}
+
+	// Plain HTTP on the same listener reaches the next route untouched
+	hc := http.Client{Timeout: 2 * time.Second}
+	hresp, err := hc.Get("http://" + ln.Addr().String() + "/tunnel")
+	if err != nil {
+		t.Fatalf("http get: %v", err)
+	}
+	defer hresp.Body.Close()
+	body, _ := io.ReadAll(hresp.Body)
+	if hresp.StatusCode != http.StatusOK || string(body) != "plain /tunnel" {
+		t.Fatalf("unexpected http response: %d %q", hresp.StatusCode, body)
+	}
+}
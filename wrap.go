@@ -1,6 +1,7 @@
 package netx
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strings"
@@ -56,6 +57,37 @@ func (ws Wrappers) Apply(conn any) (any, error) {
 	return conn, nil
 }
 
+// applyDialer applies the wrappers to dial like Apply, but hands ctx and opts to the layers that dial more
+// targets on their own, so those are dialed like the base dialer.
+func (ws Wrappers) applyDialer(ctx context.Context, dial Dialer, opts []DialOption) (any, error) {
+	var v any = dial
+	var err error
+	for _, w := range ws {
+		if d, ok := v.(Dialer); ok && w.dialerToDialerCtx != nil {
+			v, err = w.dialerToDialerCtx(ctx, d, opts)
+		} else {
+			v, err = w.Apply(v)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("wrap %q: %w", w.String(), err)
+		}
+	}
+	return v, nil
+}
+
+// OutputFor returns the output PipeType of the whole chain when it receives the given input type.
+// Returns (outputType, true) if every wrapper accepts the output of the previous one, or (0, false) otherwise.
+func (ws Wrappers) OutputFor(input PipeType) (PipeType, bool) {
+	current := input
+	for _, w := range ws {
+		var ok bool
+		if current, ok = w.OutputFor(current); !ok {
+			return 0, false
+		}
+	}
+	return current, true
+}
+
 func (ws Wrappers) String() string {
 	strs := make([]string, len(ws))
 	for i, w := range ws {
@@ -68,8 +100,13 @@ func (ws Wrappers) MarshalText() ([]byte, error) {
 	return []byte(ws.String()), nil
 }
 
+// UnmarshalText parses a +-separated chain of layers, expanding the chain layers that reference a chain
+// registered with RegisterChain.
 func (ws *Wrappers) UnmarshalText(text []byte, server bool) error {
-	parts := strings.Split(string(text), "+")
+	parts, err := expandChains(strings.Split(string(text), "+"), 0)
+	if err != nil {
+		return err
+	}
 	*ws = make([]Wrapper, len(parts))
 	for i := range parts {
 		if err := (*ws)[i].UnmarshalText([]byte(parts[i]), server); err != nil {
@@ -90,17 +127,47 @@ func (ws *Wrappers) UnmarshalText(text []byte, server bool) error {
 		currentType = outputType
 	}
 
-	if server && currentType != PipeTypeListener {
-		return fmt.Errorf("invalid wrapper chain: final output type %s is not a Listener for server scheme", currentType.String())
+	want := PipeTypeDialer
+	if server {
+		want = PipeTypeListener
 	}
-
-	if !server && currentType != PipeTypeDialer {
-		return fmt.Errorf("invalid wrapper chain: final output type %s is not a Dialer for client scheme", currentType.String())
+	if currentType != want {
+		return &ChainTypeError{
+			Chain:   ws.String(),
+			Server:  server,
+			Got:     currentType,
+			Want:    want,
+			Bridges: bridgingDrivers(currentType, want, server),
+		}
 	}
 
 	return nil
 }
 
+// ChainTypeError is returned by Wrappers.UnmarshalText when a chain does not end in the type its scheme needs,
+// a Listener for servers and a Dialer for clients.
+type ChainTypeError struct {
+	Chain  string
+	Server bool
+	Got    PipeType
+	Want   PipeType
+	// Bridges lists the registered drivers that turn Got into Want without params, any of which
+	// could be appended to the chain to fix it.
+	Bridges []string
+}
+
+func (e *ChainTypeError) Error() string {
+	scheme := "client"
+	if e.Server {
+		scheme = "server"
+	}
+	msg := fmt.Sprintf("invalid wrapper chain %q: final output type %s is not a %s for %s scheme", e.Chain, e.Got, e.Want, scheme)
+	if len(e.Bridges) > 0 {
+		msg += fmt.Sprintf("; append one of %s to convert %s to %s", quoteParams(e.Bridges), e.Got, e.Want)
+	}
+	return msg
+}
+
 type ListenerWrapper struct{ Wrapper }
 
 func (ls *ListenerWrapper) UnmarshalText(text []byte) error {
@@ -128,6 +195,9 @@ type Wrapper struct {
 	DialerToDialer func(Dialer) (Dialer, error)
 	DialerToConn   func(Dialer) (net.Conn, error)
 	DialerToTagged func(Dialer) (TaggedConn, error)
+	// dialerToDialerCtx, if set, is used instead of DialerToDialer when the context and options of the dial
+	// are known, see Wrappers.applyDialer.
+	dialerToDialerCtx func(ctx context.Context, d Dialer, opts []DialOption) (Dialer, error)
 
 	ConnToConn     func(net.Conn) (net.Conn, error)
 	ConnToTagged   func(net.Conn) (TaggedConn, error)
@@ -270,39 +340,49 @@ func (w Wrapper) MarshalText() ([]byte, error) {
 }
 
 func (w *Wrapper) UnmarshalText(text []byte, listener bool) error {
-	str := string(text)
+	var err error
+	if w.Name, w.Params, err = parseLayer(string(text)); err != nil {
+		return err
+	}
+	if w.Name == chainLayer {
+		return fmt.Errorf("uri: %s layers can only be used in a chain of layers", chainLayer)
+	}
 
-	w.Name = strings.ToLower(strings.TrimSpace(str))
-	w.Params = map[string]string{}
+	driver, err := GetDriver(w.Name)
+	if err != nil {
+		return fmt.Errorf("uri: %w", err)
+	}
+	*w, err = driver(w.Params, listener)
+	if err != nil {
+		return fmt.Errorf("uri: setup driver %s: %w", w.Name, err)
+	}
+
+	return nil
+}
+
+// parseLayer splits a layer of the form name{key=value,...} into its lower-cased name and params.
+func parseLayer(str string) (name string, params map[string]string, err error) {
+	name = strings.ToLower(strings.TrimSpace(str))
+	params = map[string]string{}
 	if idx := strings.Index(str, "{"); idx != -1 {
 		if !strings.HasSuffix(str, "}") {
-			return fmt.Errorf("uri: missing '}' in layer %q", str)
+			return "", nil, fmt.Errorf("uri: missing '}' in layer %q", str)
 		}
-		w.Name = strings.ToLower(strings.TrimSpace(str[:idx]))
+		name = strings.ToLower(strings.TrimSpace(str[:idx]))
 		for pair := range strings.SplitSeq(str[idx+1:len(str)-1], ",") {
 			kv := strings.SplitN(pair, "=", 2)
 			if len(kv) != 2 {
-				return fmt.Errorf("uri: invalid parameter %q", pair)
+				return "", nil, fmt.Errorf("uri: invalid parameter %q", pair)
 			}
 			key := strings.ToLower(strings.TrimSpace(kv[0]))
 			value := strings.TrimSpace(kv[1])
 			if key == "" {
-				return fmt.Errorf("uri: empty parameter key")
+				return "", nil, fmt.Errorf("uri: empty parameter key")
 			}
-			w.Params[key] = value
+			params[key] = value
 		}
 	}
-
-	driver, err := GetDriver(w.Name)
-	if err != nil {
-		return fmt.Errorf("uri: %w", err)
-	}
-	*w, err = driver(w.Params, listener)
-	if err != nil {
-		return fmt.Errorf("uri: setup driver %s: %w", w.Name, err)
-	}
-
-	return nil
+	return name, params, nil
 }
 
 type connWrappedListener struct {